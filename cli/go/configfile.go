@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configSearchNames are looked for in the working directory when --config is
+// not given.
+var configSearchNames = []string{"geoip.yaml", "geoip.yml", "geoip.json", "geoip.toml"}
+
+// configPrecedence is appended to config file errors so it is clear which
+// source wins when the same setting appears in several places.
+const configPrecedence = "precedence: command-line flags > config file > environment variables > defaults"
+
+// configKeyAliases maps config file keys (shared with the Python client's
+// config.yaml) to the flag they set. Any other key is tried as a flag name
+// with underscores turned into dashes.
+var configKeyAliases = map[string]string{
+	"api_endpoint":   "endpoint",
+	"api_endpoints":  "endpoint",
+	"target_dir":     "directory",
+	"max_retries":    "retries",
+	"max_concurrent": "concurrent",
+}
+
+// configKeysIgnored are understood by other GeoIP clients but have no
+// equivalent here yet; they are skipped with a warning instead of failing.
+var configKeysIgnored = map[string]bool{
+	"verify_ssl": true,
+}
+
+// findConfigFile returns the config file to load: the --config value, else
+// the first geoip.{yaml,yml,json,toml} in the working directory, else "".
+func findConfigFile(explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	for _, name := range configSearchNames {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// applyConfigFile loads path and sets every flag it mentions that was not
+// given on the command line. Flag defaults already hold the environment
+// values, so setting them here makes the file override the environment while
+// explicit flags still win. It returns the flags the file set.
+func applyConfigFile(fs *flag.FlagSet, path string) (map[string]bool, error) {
+	values, err := parseConfigFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	// A setting counts as given if either its long or short flag was used;
+	// both share the same destination variable.
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+		if long, ok := flagShortNames[f.Name]; ok {
+			given[long] = true
+		}
+		if long, ok := flagLongAliases[f.Name]; ok {
+			given[long] = true
+		}
+	})
+
+	set := map[string]bool{}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name, ok := configKeyAliases[key]
+		if !ok {
+			name = strings.ReplaceAll(key, "_", "-")
+		}
+		if long, ok := flagLongAliases[name]; ok {
+			name = long
+		}
+		if configKeysIgnored[key] {
+			fmt.Fprintf(os.Stderr, "Warning: config file %s: %q is not supported by this client, ignoring\n", path, key)
+			continue
+		}
+		if _, short := flagShortNames[name]; short || name == "config" || fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("config file %s: unknown setting %q", path, key)
+		}
+		if given[name] {
+			continue
+		}
+		if err := fs.Set(name, values[key]); err != nil {
+			return nil, fmt.Errorf("config file %s: invalid %s %q: %v (%s)", path, key, values[key], err, configPrecedence)
+		}
+		set[name] = true
+	}
+	return set, nil
+}
+
+// flagGiven reports whether the setting name was given on the command line,
+// under its long name or any alias, or by the config file merged into fs.
+func flagGiven(fs *flag.FlagSet, name string) bool {
+	given := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name || flagShortNames[f.Name] == name || flagLongAliases[f.Name] == name {
+			given = true
+		}
+	})
+	return given
+}
+
+// settingSource describes where the value of flag name came from, for
+// validation errors raised after the config file has been merged.
+func settingSource(name, configPath string, fromFile map[string]bool) string {
+	if fromFile[name] {
+		return fmt.Sprintf("set in config file %s; %s", configPath, configPrecedence)
+	}
+	return configPrecedence
+}
+
+// flagShortNames maps each short flag to the long flag sharing its
+// destination, so "-k" on the command line also shields api-key.
+var flagShortNames = map[string]string{
+	"k": "api-key",
+	"e": "endpoint",
+	"d": "directory",
+	"b": "databases",
+	"l": "log-file",
+	"r": "retries",
+	"t": "timeout",
+	"q": "quiet",
+	"v": "verbose",
+	"n": "no-lock",
+}
+
+// flagLongAliases maps long flags kept for compatibility to the flag they
+// duplicate.
+var flagLongAliases = map[string]string{
+	"backup":           "keep-backup",
+	"insecure":         "insecure-skip-verify",
+	"metrics-textfile": "metrics-file",
+	"split":            "chunks-per-file",
+	"watch":            "daemon",
+	"webhook":          "webhook-url",
+}
+
+// parseConfigFile reads a flat YAML, JSON or TOML file, chosen by extension,
+// into setting -> string value. Lists become comma-separated values.
+func parseConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return parseJSONConfig(data)
+	case ".toml":
+		return parseFlatConfig(data, "=")
+	default:
+		return parseFlatConfig(data, ":")
+	}
+}
+
+func parseJSONConfig(data []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(raw))
+	for key, v := range raw {
+		s, err := configScalar(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		values[normalizeConfigKey(key)] = s
+	}
+	return values, nil
+}
+
+func configScalar(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configScalar(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("nested values are not supported")
+}
+
+// parseFlatConfig parses the flat subset of YAML (sep ":") and TOML
+// (sep "=") that a settings file needs: "key: value" pairs, quoted strings,
+// inline [a, b] lists, YAML "- item" block lists and # comments.
+func parseFlatConfig(data []byte, sep string) (map[string]string, error) {
+	values := map[string]string{}
+	var listKey string
+	var list []string
+	flush := func() {
+		if listKey != "" {
+			values[listKey] = strings.Join(list, ",")
+		}
+		listKey, list = "", nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(stripConfigComment(scanner.Text()))
+		if line == "" || line == "---" {
+			continue
+		}
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", lineNo)
+			}
+			list = append(list, unquoteConfig(strings.TrimSpace(strings.TrimPrefix(line, "-"))))
+			continue
+		}
+		flush()
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: sections are not supported", lineNo)
+		}
+
+		i := strings.Index(line, sep)
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected key%svalue", lineNo, sep)
+		}
+		key := normalizeConfigKey(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+		switch {
+		case value == "":
+			listKey = key // a block list may follow
+			values[key] = ""
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			var items []string
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, unquoteConfig(item))
+				}
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			values[key] = unquoteConfig(value)
+		}
+	}
+	flush()
+	return values, scanner.Err()
+}
+
+// stripConfigComment removes a # comment that is not inside quotes.
+func stripConfigComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquoteConfig(s string) string {
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'') {
+		if s[0] == '"' {
+			if u, err := strconv.Unquote(s); err == nil {
+				return u
+			}
+		}
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// normalizeConfigKey accepts api_key, api-key and apiKey style keys.
+func normalizeConfigKey(key string) string {
+	key = strings.Trim(key, `"'`)
+	var b strings.Builder
+	for i, r := range key {
+		switch {
+		case r == '-':
+			b.WriteByte('_')
+		case r >= 'A' && r <= 'Z':
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r + ('a' - 'A'))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestApplyConfigFile verifies each supported format is merged with
+// command-line flags taking precedence over the file and the file over the
+// environment-derived defaults.
+func TestApplyConfigFile(t *testing.T) {
+	files := map[string]string{
+		"geoip.yaml": `# comment
+api_key: "file-key"  # trailing comment
+target_dir: /srv/geoip
+databases:
+  - GeoIP2-City.mmdb
+  # - skipped
+  - GeoIP2-Country.mmdb
+max_retries: 7
+timeout: 5m
+`,
+		"geoip.json": `{"api-key": "file-key", "directory": "/srv/geoip",
+			"databases": ["GeoIP2-City.mmdb", "GeoIP2-Country.mmdb"], "retries": 7, "timeout": "5m"}`,
+		"geoip.toml": `apiKey = "file-key"
+target_dir = '/srv/geoip'
+databases = ["GeoIP2-City.mmdb", "GeoIP2-Country.mmdb"]
+max_retries = 7
+timeout = "5m"
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		var apiKey, dir, databases string
+		var retries int
+		timeout := &timeoutValue{d: time.Minute}
+		fs.StringVar(&apiKey, "api-key", "env-key", "")
+		fs.StringVar(&apiKey, "k", "env-key", "")
+		fs.StringVar(&dir, "directory", "/env/dir", "")
+		fs.StringVar(&databases, "databases", "all", "")
+		fs.IntVar(&retries, "retries", 3, "")
+		fs.Var(timeout, "timeout", "")
+		if err := fs.Parse([]string{"-k", "flag-key"}); err != nil {
+			t.Fatal(err)
+		}
+
+		fromFile, err := applyConfigFile(fs, path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if apiKey != "flag-key" {
+			t.Errorf("%s: api-key = %q, want the command-line value", name, apiKey)
+		}
+		if fromFile["api-key"] {
+			t.Errorf("%s: api-key reported as set by the file", name)
+		}
+		if dir != "/srv/geoip" || retries != 7 || timeout.d != 5*time.Minute {
+			t.Errorf("%s: dir=%q retries=%d timeout=%v", name, dir, retries, timeout.d)
+		}
+		if databases != "GeoIP2-City.mmdb,GeoIP2-Country.mmdb" {
+			t.Errorf("%s: databases = %q", name, databases)
+		}
+	}
+}
+
+func TestApplyConfigFileErrors(t *testing.T) {
+	for content, want := range map[string]string{
+		"retries: lots\n":   "precedence: command-line flags > config file",
+		"no_such_key: 1\n":  `unknown setting "no_such_key"`,
+		"[section]\n":       "sections are not supported",
+		"  - orphan item\n": "list item without a key",
+	} {
+		path := filepath.Join(t.TempDir(), "geoip.yaml")
+		os.WriteFile(path, []byte(content), 0644)
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Int("retries", 3, "")
+		_, err := applyConfigFile(fs, path)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: err = %v, want it to mention %q", content, err, want)
+		}
+	}
+}
+
+// TestAPIKeyPrecedence verifies an api_key from the config file counts as
+// explicit and beats GEOIP_API_KEY_FILE, while an api_key_file from the
+// config file beats GEOIP_API_KEY.
+func TestAPIKeyPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	os.WriteFile(keyFile, []byte("file-key-123\n"), 0600)
+
+	for content, want := range map[string]string{
+		"api_key: config-key-123\n":       "config-key-123",
+		"api_key_file: " + keyFile + "\n": "file-key-123",
+		"max_retries: 3\n":                "env-key-123",
+	} {
+		path := filepath.Join(t.TempDir(), "geoip.yaml")
+		os.WriteFile(path, []byte(content), 0644)
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		var apiKey, apiKeyFile string
+		var retries int
+		fs.StringVar(&apiKey, "api-key", "env-key-123", "")
+		fs.StringVar(&apiKey, "k", "env-key-123", "")
+		// GEOIP_API_KEY_FILE is only a default; an explicit key still wins.
+		defaultFile := ""
+		if strings.HasPrefix(content, "api_key:") {
+			defaultFile = keyFile
+		}
+		fs.StringVar(&apiKeyFile, "api-key-file", defaultFile, "")
+		fs.IntVar(&retries, "retries", 3, "")
+		fs.Parse(nil)
+		if _, err := applyConfigFile(fs, path); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := resolveSecret("API key", apiKey, apiKeyFile, flagGiven(fs, "api-key"), nil)
+		if err != nil || got != want {
+			t.Errorf("%q: key %q, %v; want %q", content, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// Wednesday 2024-01-10 12:34 UTC.
+	from := time.Date(2024, 1, 10, 12, 34, 20, 0, time.UTC)
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"0 3 * * *", time.Date(2024, 1, 11, 3, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 10, 13, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 10, 12, 45, 0, 0, time.UTC)},
+		{"30 */6 * * mon-fri", time.Date(2024, 1, 10, 18, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 feb,mar *", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either matches (the 15th or a Friday).
+		{"0 0 15 * fri", time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC)},
+		{"34 12 * * *", time.Date(2024, 1, 11, 12, 34, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		s, err := parseCron(c.expr)
+		if err != nil {
+			t.Errorf("%q: %v", c.expr, err)
+			continue
+		}
+		if got := s.next(from); !got.Equal(c.want) {
+			t.Errorf("%q: next = %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "5-1 * * * *", "*/0 * * * *", "0 0 * foo *", "0 0 30 2 *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%q accepted", expr)
+		}
+	}
+}
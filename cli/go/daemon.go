@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+// daemonLoop runs updateOnce every config.Interval, or at the times
+// config.Cron selects, until ctx is cancelled. With an interval the first
+// run is delayed by a random fraction of it so a fleet started together
+// doesn't hit the API at once; a value on trigger (SIGHUP) starts a run
+// immediately. Runs never overlap: the next one is scheduled only after
+// the last has finished, so a cron time passed during a long run is
+// skipped. Failed runs are logged and the loop carries on.
+func daemonLoop(ctx context.Context, config *geoip.Config, logger *geoip.ConsoleLogger, active *activeRun, trigger <-chan struct{}) int {
+	var schedule *cronSchedule
+	if config.Cron != "" {
+		var err error
+		if schedule, err = parseCron(config.Cron); err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+	}
+	nextWait := func() time.Duration {
+		if schedule != nil {
+			return time.Until(schedule.next(time.Now()))
+		}
+		return config.Interval
+	}
+
+	var wait time.Duration
+	if schedule != nil {
+		wait = nextWait()
+		logger.Info("Daemon mode: updating on schedule %q", config.Cron)
+	} else {
+		wait = firstRunDelay(config.Interval)
+		logger.Info("Daemon mode: updating every %v", config.Interval)
+	}
+	for {
+		next := time.Now().Add(wait)
+		logger.Info("Next update at %s, in %v (send SIGHUP to update now)", next.Format("2006-01-02 15:04:05 MST"), wait.Round(time.Second))
+		active.status.idle(next)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Info("Daemon stopped")
+			return 0
+		case <-trigger:
+			timer.Stop()
+			logger.Info("Received SIGHUP, updating now")
+		case <-timer.C:
+		}
+
+		if code := updateOnce(ctx, config, logger, active); code == exitInterrupted {
+			return code
+		} else if code != 0 {
+			logger.Warn("Update failed; retrying at the next scheduled update")
+		}
+		// A SIGHUP that arrived during the run has been served by it.
+		select {
+		case <-trigger:
+		default:
+		}
+		wait = nextWait()
+	}
+}
+
+// firstRunDelay returns a random delay in [0, interval).
+func firstRunDelay(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(interval)))
+}
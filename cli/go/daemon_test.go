@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+// TestDaemonLoop verifies SIGHUP-style triggers start a run, a failed run
+// does not stop the daemon, and cancellation ends it cleanly.
+func TestDaemonLoop(t *testing.T) {
+	var runs int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&runs, 1)
+		http.Error(w, "unavailable", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	config := &geoip.Config{
+		APIEndpoints: []string{srv.URL + "/auth"},
+		TargetDir:    t.TempDir(),
+		MaxRetries:   1,
+		Timeout:      5 * time.Second,
+		NoLock:       true,
+		Quiet:        true,
+		Interval:     time.Hour,
+	}
+	logger, err := geoip.NewLogger(config, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	trigger := make(chan struct{}, 1)
+	exit := make(chan int)
+	go func() { exit <- daemonLoop(ctx, config, logger, &activeRun{}, trigger) }()
+
+	for want := int32(1); want <= 2; want++ {
+		trigger <- struct{}{}
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadInt32(&runs) < want && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := atomic.LoadInt32(&runs); got != want {
+			t.Fatalf("after trigger %d: %d runs", want, got)
+		}
+	}
+
+	cancel()
+	select {
+	case code := <-exit:
+		if code != 0 {
+			t.Errorf("exit code %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not stop after cancellation")
+	}
+}
+
+func TestFirstRunDelay(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := firstRunDelay(time.Minute); d < 0 || d >= time.Minute {
+			t.Fatalf("delay %v outside [0, 1m)", d)
+		}
+	}
+	if d := firstRunDelay(0); d != 0 {
+		t.Errorf("zero interval: %v", d)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+// TestByteRateValueSet verifies --max-rate accepts plain byte counts and
+// K/M/G binary units in the usual spellings.
+func TestByteRateValueSet(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"1048576", 1 << 20, false},
+		{"500KB", 500 << 10, false},
+		{"5MB", 5 << 20, false},
+		{"5mb", 5 << 20, false},
+		{"1.5M", 3 << 19, false},
+		{"2G", 2 << 30, false},
+		{"10MB/s", 10 << 20, false},
+		{"", 0, true},
+		{"fast", 0, true},
+		{"-1MB", 0, true},
+	}
+	for _, c := range cases {
+		var v byteRateValue
+		err := v.Set(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("Set(%q): expected error, got %d", c.in, v.n)
+			}
+			continue
+		}
+		if err != nil || v.n != c.want {
+			t.Errorf("Set(%q) = %d, %v; want %d", c.in, v.n, err, c.want)
+		}
+	}
+}
+
+// TestByteSizeValueSet verifies --space-estimate takes the same units as
+// --max-rate without the per-second suffix.
+func TestByteSizeValueSet(t *testing.T) {
+	for in, want := range map[string]int64{"0": 0, "500MB": 500 << 20, "2g": 2 << 30, "4096": 4096} {
+		var v byteSizeValue
+		if err := v.Set(in); err != nil || v.n != want {
+			t.Errorf("Set(%q) = %d, %v; want %d", in, v.n, err, want)
+		}
+	}
+	var v byteSizeValue
+	if err := v.Set("-5MB"); err == nil {
+		t.Error("Set(-5MB): expected error")
+	}
+}
+
+func TestConcurrencyValueSet(t *testing.T) {
+	v := concurrencyValue{n: 2}
+	if err := v.Set("auto"); err != nil || !v.auto || v.String() != "auto" {
+		t.Errorf("Set(auto) = %+v, %v", v, err)
+	}
+	if err := v.Set("6"); err != nil || v.auto || v.n != 6 {
+		t.Errorf("Set(6) = %+v, %v", v, err)
+	}
+	for _, in := range []string{"0", "-1", "many"} {
+		if err := v.Set(in); err == nil {
+			t.Errorf("Set(%q): expected error", in)
+		}
+	}
+}
+
+func TestSpaceMarginValueSet(t *testing.T) {
+	cases := []struct {
+		in      string
+		percent int
+		bytes   int64
+	}{
+		{"10%", 10, 0},
+		{"0%", 0, 0},
+		{"500MB", 0, 500 << 20},
+		{"1G", 0, 1 << 30},
+	}
+	for _, c := range cases {
+		v := spaceMarginValue{percent: 10}
+		if err := v.Set(c.in); err != nil || v.percent != c.percent || v.bytes != c.bytes {
+			t.Errorf("Set(%q) = %d%%/%d, %v", c.in, v.percent, v.bytes, err)
+		}
+	}
+	for _, in := range []string{"-5%", "lots", "x%"} {
+		var v spaceMarginValue
+		if err := v.Set(in); err == nil {
+			t.Errorf("Set(%q): expected error", in)
+		}
+	}
+}
+
+// TestAgeValueSet verifies --max-age takes days, weeks and Go durations.
+func TestAgeValueSet(t *testing.T) {
+	day := 24 * time.Hour
+	cases := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"30d", 30 * day, false},
+		{"30", 30 * day, false},
+		{"2w", 14 * day, false},
+		{"36h", 36 * time.Hour, false},
+		{"", 0, true},
+		{"-1d", 0, true},
+		{"soon", 0, true},
+	}
+	for _, c := range cases {
+		var v ageValue
+		err := v.Set(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("Set(%q): expected error, got %v", c.in, v.d)
+			}
+			continue
+		}
+		if err != nil || v.d != c.want {
+			t.Errorf("Set(%q) = %v, %v; want %v", c.in, v.d, err, c.want)
+		}
+	}
+}
+
+func TestSinceValueSet(t *testing.T) {
+	var v sinceValue
+	if err := v.Set("2024-03-01T12:00:00Z"); err != nil || !v.t.Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("RFC3339: %v, %v", v.t, err)
+	}
+	if err := v.Set("2024-03-01"); err != nil || !v.t.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("date: %v, %v", v.t, err)
+	}
+	if err := v.Set("7d"); err != nil || time.Since(v.t).Round(time.Hour) != 7*24*time.Hour {
+		t.Errorf("7d: %v, %v", v.t, err)
+	}
+	if err := v.Set("last tuesday"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// TestStatusCodesValueSet verifies --retry-on takes a list of 4xx/5xx codes
+// and refuses the authentication failures that are never retried.
+func TestStatusCodesValueSet(t *testing.T) {
+	var v statusCodesValue
+	if err := v.Set("429, 502,503"); err != nil || v.String() != "429,502,503" {
+		t.Errorf("Set = %v, %v", v.codes, err)
+	}
+	for _, in := range []string{"200", "abc", "600", "429,401", "403"} {
+		if err := v.Set(in); err == nil {
+			t.Errorf("Set(%q): expected error", in)
+		}
+	}
+}
+
+// TestResolveSecret covers the precedence of the API key sources.
+func TestResolveSecret(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(file, []byte("  file-key-123\n"), 0600)
+
+	cases := []struct {
+		key, file string
+		given     bool
+		want      string
+	}{
+		{"env-key-123", "", false, "env-key-123"},
+		{"env-key-123", file, false, "file-key-123"}, // file beats GEOIP_API_KEY
+		{"flag-key-123", file, true, "flag-key-123"}, // explicit --api-key beats the file
+		{"-", file, true, "stdin-key-123"},
+	}
+	for _, c := range cases {
+		got, err := resolveSecret("API key", c.key, c.file, c.given, strings.NewReader("stdin-key-123\n"))
+		if err != nil || got != c.want {
+			t.Errorf("resolveSecret(%q, %q, %v) = %q, %v; want %q", c.key, c.file, c.given, got, err, c.want)
+		}
+	}
+	if _, err := resolveSecret("API key", "", filepath.Join(t.TempDir(), "missing"), false, nil); err == nil {
+		t.Error("missing key file accepted")
+	}
+}
+
+// TestDatabasesFile verifies --databases-file lines are read without
+// comments and blanks, and merged with --databases.
+func TestDatabasesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	os.WriteFile(path, []byte("# shared selection\ncity\n\n  GeoIP2-ISP.mmdb  # for fraud\nasn\n"), 0644)
+	names, err := readDatabasesFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"city", "GeoIP2-ISP.mmdb", "asn"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("readDatabasesFile = %q, want %q", names, want)
+	}
+
+	cases := []struct {
+		inline string
+		given  bool
+		want   []string
+	}{
+		{"all", false, []string{"city", "GeoIP2-ISP.mmdb", "asn"}},
+		{"country, city", true, []string{"country", "city", "GeoIP2-ISP.mmdb", "asn"}},
+		{"all", true, []string{"all"}},
+	}
+	for _, c := range cases {
+		if got := mergeDatabases(c.inline, c.given, names); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("mergeDatabases(%q, %v) = %q, want %q", c.inline, c.given, got, c.want)
+		}
+	}
+	if got := mergeDatabases("all", false, nil); !reflect.DeepEqual(got, []string{"all"}) {
+		t.Errorf("default selection = %q", got)
+	}
+
+	os.WriteFile(path, []byte("# nothing yet\n"), 0644)
+	if _, err := readDatabasesFile(path); err == nil {
+		t.Error("empty databases file accepted")
+	}
+}
+
+// TestFindDatabaseFiles verifies --validate-only finds database files
+// whatever the case of their extension.
+func TestFindDatabaseFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.mmdb", "b.MMDB", "c.BIN", "d.bin", "e.Bin", "README.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "versions.mmdb"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mmdb, bin, err := findDatabaseFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := func(paths []string) []string {
+		var names []string
+		for _, p := range paths {
+			names = append(names, filepath.Base(p))
+		}
+		return names
+	}
+	if got, want := base(mmdb), []string{"a.mmdb", "b.MMDB"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("mmdb = %v, want %v", got, want)
+	}
+	if got, want := base(bin), []string{"c.BIN", "d.bin", "e.Bin"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bin = %v, want %v", got, want)
+	}
+}
+
+// TestFetchDatabasesInfo verifies discovery sends the User-Agent and API
+// key, retries a transient failure, fails over to the next endpoint unless
+// the key was rejected and reports a non-200 status rather than decoding it.
+func TestFetchDatabasesInfo(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/databases" {
+			http.NotFound(w, r)
+			return
+		}
+		if ua := r.Header.Get("User-Agent"); ua != "test-agent/1.0" {
+			t.Errorf("User-Agent = %q", ua)
+		}
+		if key := r.Header.Get("X-API-Key"); key != "geoip_test_key" {
+			t.Errorf("X-API-Key = %q", key)
+		}
+		if r.URL.Query().Get("status") == "304" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"total": 7}`))
+	}))
+	defer srv.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+
+	config := &geoip.Config{UserAgent: "test-agent/1.0", APIKey: "geoip_test_key", MaxRetries: 3, Quiet: true}
+	logger, _ := geoip.NewLogger(config, io.Discard)
+	info, err := fetchDatabasesInfo(config, logger, []string{down.URL + "/auth", srv.URL + "/auth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Total != 7 || attempts != 2 {
+		t.Errorf("total = %d after %d attempts, want 7 after 2", info.Total, attempts)
+	}
+
+	// A success other than 200 carries no listing.
+	if _, err := fetchDatabasesInfo(config, logger, []string{srv.URL + "/auth?status=304"}); err == nil || err.Error() != "database discovery not available (HTTP 304)" {
+		t.Errorf("304: err = %v, want HTTP 304", err)
+	}
+
+	// A rejected key is rejected by every mirror.
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer rejecting.Close()
+	attempts = 0
+	if _, err := fetchDatabasesInfo(config, logger, []string{rejecting.URL + "/auth", srv.URL + "/auth"}); !geoip.IsRejected(err) {
+		t.Errorf("401: err = %v, want a rejection", err)
+	}
+	if attempts != 0 {
+		t.Errorf("failed over to the next endpoint after a 401 (%d requests)", attempts)
+	}
+}
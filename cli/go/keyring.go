@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The API key can live in the OS credential store instead of the
+// environment or a file: the macOS Keychain, the Windows Credential Manager,
+// or the Secret Service (libsecret) on Linux and other Unixes. Entries are
+// named by a service and an account, given as "service/account".
+
+// errKeyringNotFound is returned by keyringGet when no entry exists.
+var errKeyringNotFound = errors.New("no such keyring entry")
+
+// parseKeyringRef splits a "service/account" reference at its last slash.
+func parseKeyringRef(ref string) (service, account string, err error) {
+	i := strings.LastIndex(ref, "/")
+	if i <= 0 || i == len(ref)-1 {
+		return "", "", fmt.Errorf("invalid keyring entry %q: use service/account", ref)
+	}
+	service, account = ref[:i], ref[i+1:]
+	if strings.ContainsAny(ref, "\"\\\n\r") {
+		return "", "", fmt.Errorf("invalid keyring entry %q: quotes, backslashes and newlines are not allowed", ref)
+	}
+	return service, account, nil
+}
+
+// lookupKeyringKey reads the API key stored under ref.
+func lookupKeyringKey(ref string) (string, error) {
+	service, account, err := parseKeyringRef(ref)
+	if err != nil {
+		return "", err
+	}
+	key, err := keyringGet(service, account)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(key), nil
+}
+
+// saveKeyringKey stores key under ref, replacing any existing entry.
+func saveKeyringKey(ref, key string) error {
+	service, account, err := parseKeyringRef(ref)
+	if err != nil {
+		return err
+	}
+	return keyringSet(service, account, key)
+}
@@ -0,0 +1,42 @@
+//go:build darwin
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keyringGet reads a generic password from the login Keychain.
+func keyringGet(service, account string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		// 44 is errSecItemNotFound.
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 44 {
+			return "", errKeyringNotFound
+		}
+		return "", fmt.Errorf("keychain lookup failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// keyringSet stores a generic password in the login Keychain. The command
+// goes to "security -i" on stdin so the key never appears in a process
+// listing.
+func keyringSet(service, account, key string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %q -a %q -w %q\n", service, account, key))
+	cmd.Stderr = &stderr
+	if out, err := cmd.Output(); err != nil || strings.Contains(string(out)+stderr.String(), "error") {
+		return fmt.Errorf("keychain update failed: %v: %s", err, strings.TrimSpace(string(out)+stderr.String()))
+	}
+	return nil
+}
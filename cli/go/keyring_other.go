@@ -0,0 +1,48 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keyringGet looks the entry up in the Secret Service through libsecret's
+// secret-tool.
+func keyringGet(service, account string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", errors.New("keyring lookup needs secret-tool (libsecret-tools)")
+	}
+	// secret-tool exits 1 without output when nothing matches.
+	if len(out) == 0 && stderr.Len() == 0 {
+		return "", errKeyringNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("keyring lookup failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// keyringSet stores the entry with secret-tool, which reads the secret
+// from stdin.
+func keyringSet(service, account, key string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "store", "--label", "GeoIP API key ("+service+"/"+account+")",
+		"service", service, "account", account)
+	cmd.Stdin = strings.NewReader(key)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return errors.New("keyring storage needs secret-tool (libsecret-tools)")
+		}
+		return fmt.Errorf("keyring update failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestParseKeyringRef(t *testing.T) {
+	for ref, want := range map[string][2]string{
+		"geoip/alice":          {"geoip", "alice"},
+		"geoipdb.net/ci/robot": {"geoipdb.net/ci", "robot"},
+	} {
+		service, account, err := parseKeyringRef(ref)
+		if err != nil || service != want[0] || account != want[1] {
+			t.Errorf("parseKeyringRef(%q) = %q, %q, %v; want %q, %q", ref, service, account, err, want[0], want[1])
+		}
+	}
+	for _, ref := range []string{"", "geoip", "/alice", "geoip/", `geo"ip/alice`} {
+		if _, _, err := parseKeyringRef(ref); err == nil {
+			t.Errorf("parseKeyringRef(%q) accepted", ref)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockPollInterval is how often Acquire retries a held lock while waiting
+// out --lock-timeout.
+const lockPollInterval = 250 * time.Millisecond
+
+// errLockHeld is returned by tryLockFile when another process holds the lock.
+var errLockHeld = errors.New("lock held by another process")
+
+// LockFile manages process locking with an OS advisory lock (flock on Unix,
+// LockFileEx on Windows). The OS drops the lock when the process exits, so a
+// crashed or killed run never leaves a stale lock behind. The file itself is
+// left in place and only carries the holder's PID for diagnostics.
+type LockFile struct {
+	path   string
+	noLock bool
+	file   *os.File
+}
+
+func newLockFile(noLock bool) *LockFile {
+	lockPath := filepath.Join(os.TempDir(), "geoip-update.lock")
+	return &LockFile{
+		path:   lockPath,
+		noLock: noLock,
+	}
+}
+
+// Acquire takes the lock, waiting up to timeout for another instance to
+// release it. A zero timeout fails immediately if the lock is held.
+func (l *LockFile) Acquire(timeout time.Duration) error {
+	if l.noLock {
+		return nil
+	}
+
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err = tryLockFile(f)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errLockHeld) || !time.Now().Before(deadline) {
+			f.Close()
+			if errors.Is(err, errLockHeld) {
+				if pid := readLockPID(l.path); pid > 0 {
+					return fmt.Errorf("another instance is already running (PID: %d)", pid)
+				}
+				return fmt.Errorf("another instance is already running")
+			}
+			return fmt.Errorf("failed to lock %s: %w", l.path, err)
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	// Record our PID for whoever finds the lock held.
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	l.file = f
+	return nil
+}
+
+// Release clears the PID and drops the lock. The file is not removed:
+// unlinking it would let a waiting process lock the old inode while a new
+// one creates and locks a fresh file.
+func (l *LockFile) Release() {
+	if l.noLock || l.file == nil {
+		return
+	}
+	l.file.Truncate(0)
+	unlockFile(l.file)
+	l.file.Close()
+	l.file = nil
+}
+
+// readLockPID returns the PID recorded in the lock file, or 0 if there is
+// none.
+func readLockPID(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, 32))
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
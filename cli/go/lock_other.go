@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// tryLockFile is a no-op on platforms without advisory file locks.
+func tryLockFile(f *os.File) error { return nil }
+
+func unlockFile(f *os.File) error { return nil }
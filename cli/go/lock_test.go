@@ -0,0 +1,50 @@
+//go:build unix || windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLockFileContention verifies a held lock blocks a second holder,
+// reports the holder's PID, is taken over once released within
+// --lock-timeout, and is dropped by the OS when the handle is closed without
+// an explicit release (as on a crash).
+func TestLockFileContention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geoip-update.lock")
+	first := &LockFile{path: path}
+	second := &LockFile{path: path}
+
+	if err := first.Acquire(0); err != nil {
+		t.Fatal(err)
+	}
+	err := second.Acquire(0)
+	if err == nil {
+		t.Fatal("second Acquire succeeded while the lock was held")
+	}
+	if want := fmt.Sprintf("PID: %d", os.Getpid()); !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q does not mention %s", err, want)
+	}
+
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		first.Release()
+	}()
+	if err := second.Acquire(5 * time.Second); err != nil {
+		t.Fatalf("Acquire with timeout: %v", err)
+	}
+
+	// Simulate a crash: the handle goes away without Release.
+	second.file.Close()
+	second.file = nil
+	third := &LockFile{path: path}
+	if err := third.Acquire(0); err != nil {
+		t.Fatalf("lock not released by closing the handle: %v", err)
+	}
+	third.Release()
+}
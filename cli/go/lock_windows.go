@@ -0,0 +1,58 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// lockRange returns the byte range that is locked. It lies far beyond the
+// PID text so other processes can still read it while the lock is held.
+func lockRange() *syscall.Overlapped {
+	return &syscall.Overlapped{Offset: 0, OffsetHigh: 0x7fffffff}
+}
+
+// tryLockFile takes a non-blocking exclusive LockFileEx lock on f.
+func tryLockFile(f *os.File) error {
+	r, _, err := procLockFileEx.Call(
+		f.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately,
+		0,
+		1, 0,
+		uintptr(unsafe.Pointer(lockRange())),
+	)
+	if r != 0 {
+		return nil
+	}
+	if err == errorLockViolation || err == syscall.ERROR_IO_PENDING {
+		return errLockHeld
+	}
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	r, _, err := procUnlockFileEx.Call(
+		f.Fd(),
+		0,
+		1, 0,
+		uintptr(unsafe.Pointer(lockRange())),
+	)
+	if r != 0 {
+		return nil
+	}
+	return err
+}
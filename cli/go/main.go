@@ -3,23 +3,24 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
 )
 
 // Version is injected at build time via -ldflags -X. The Makefile injects a
@@ -34,697 +35,463 @@ func displayVersion() string {
 }
 
 const (
-	defaultEndpoint   = "https://geoipdb.net/auth"
-	defaultTargetDir  = "./geoip"
-	defaultRetries    = 3
-	defaultTimeout    = 1800 // overall ceiling; downloadIdleTimeout is the stall guard
-	defaultConcurrent = 2    // bandwidth-bound: fewer streams finish large files sooner
+	defaultEndpoint       = "https://geoipdb.net/auth"
+	defaultTargetDir      = "./geoip"
+	defaultRetries        = 3
+	defaultTimeout        = 1800 // per-database deadline; --stall-timeout is the stall guard
+	defaultConnectTimeout = 30   // seconds to connect, and to complete the TLS handshake
+	defaultStallTimeout   = 120  // seconds without data before a download is resumed
+	defaultConcurrent     = 2    // bandwidth-bound: fewer streams finish large files sooner
 )
 
-// Config holds the application configuration
-type Config struct {
-	APIKey        string
-	APIEndpoint   string
-	TargetDir     string
-	Databases     []string
-	LogFile       string
-	MaxRetries    int
-	Timeout       time.Duration
-	MaxConcurrent int
-	Quiet         bool
-	Verbose       bool
-	NoLock        bool
-}
-
-// DownloadResult represents the result of a database download
-type DownloadResult struct {
-	Database string
-	Size     int64
-	Error    error
-}
-
-// Logger handles logging with different levels
-type Logger struct {
-	quiet   bool
-	verbose bool
-	file    *os.File
-	mu      sync.Mutex
+// timeoutValue is a flag.Value for --timeout/-t that accepts either a bare
+// integer interpreted as seconds ("1800") or a Go duration string ("5m",
+// "300s", "90s"). Plain integers keep existing callers working; duration
+// strings let the GitHub Action forward its documented "5m / 300s" format
+// straight through to the binary.
+type timeoutValue struct {
+	d time.Duration
 }
 
-func newLogger(config *Config) (*Logger, error) {
-	l := &Logger{
-		quiet:   config.Quiet,
-		verbose: config.Verbose,
-	}
-
-	if config.LogFile != "" {
-		// Create log directory if needed
-		logDir := filepath.Dir(config.LogFile)
-		if err := os.MkdirAll(logDir, 0755); err != nil {
-			return nil, fmt.Errorf("failed to create log directory: %w", err)
-		}
-
-		// Open log file
-		file, err := os.OpenFile(config.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open log file: %w", err)
-		}
-		l.file = file
+func (t *timeoutValue) String() string {
+	if t == nil {
+		return ""
 	}
-
-	return l, nil
+	return t.d.String()
 }
 
-func (l *Logger) log(level, message string) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	logLine := fmt.Sprintf("[%s] [%s] %s", timestamp, level, message)
-
-	// Write to file if configured
-	if l.file != nil {
-		fmt.Fprintln(l.file, logLine)
-	}
-
-	// Write to console based on level and settings
-	if !l.quiet {
-		switch level {
-		case "ERROR":
-			fmt.Fprintf(os.Stderr, "\033[0;31m[%s]\033[0m %s\n", level, message)
-		case "WARN":
-			fmt.Fprintf(os.Stderr, "\033[1;33m[%s]\033[0m %s\n", level, message)
-		case "SUCCESS":
-			fmt.Printf("\033[0;32m[%s]\033[0m %s\n", level, message)
-		case "INFO":
-			if l.verbose {
-				fmt.Printf("\033[0;34m[%s]\033[0m %s\n", level, message)
-			}
-		default:
-			fmt.Printf("[%s] %s\n", level, message)
-		}
-	} else if level == "ERROR" {
-		// Always output errors
-		fmt.Fprintf(os.Stderr, "[%s] %s\n", timestamp, message)
+func (t *timeoutValue) Set(s string) error {
+	s = strings.TrimSpace(s)
+	// A bare integer means seconds (backward compatible with the old flag.Int).
+	if n, err := strconv.Atoi(s); err == nil {
+		t.d = time.Duration(n) * time.Second
+		return nil
 	}
+	// Otherwise accept a Go duration string ("5m", "300s", "90s").
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid value %q: want seconds (e.g. 1800) or a duration (e.g. 5m, 300s)", s)
+	}
+	t.d = d
+	return nil
 }
 
-func (l *Logger) Info(format string, args ...interface{}) {
-	l.log("INFO", fmt.Sprintf(format, args...))
-}
-
-func (l *Logger) Warn(format string, args ...interface{}) {
-	l.log("WARN", fmt.Sprintf(format, args...))
-}
-
-func (l *Logger) Error(format string, args ...interface{}) {
-	l.log("ERROR", fmt.Sprintf(format, args...))
+// byteRateValue is a flag.Value for --max-rate: a byte count per second with
+// an optional binary unit ("500KB", "5MB", "1.5M", "1048576"). 0 means
+// unlimited.
+type byteRateValue struct {
+	n int64
 }
 
-func (l *Logger) Success(format string, args ...interface{}) {
-	l.log("SUCCESS", fmt.Sprintf(format, args...))
+func (b *byteRateValue) String() string {
+	if b == nil {
+		return ""
+	}
+	return strconv.FormatInt(b.n, 10)
 }
 
-func (l *Logger) Close() {
-	if l.file != nil {
-		l.file.Close()
+func (b *byteRateValue) Set(s string) error {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/S"), "PS")
+	n, err := parseByteSize(s)
+	if err != nil {
+		return fmt.Errorf("invalid rate: want bytes per second with an optional unit (e.g. 500KB, 5MB)")
 	}
+	b.n = n
+	return nil
 }
 
-// LockFile manages process locking
-type LockFile struct {
-	path   string
-	noLock bool
+// concurrencyValue is a flag.Value for --concurrent: a number of parallel
+// downloads, or "auto" to size it from the CPU count.
+type concurrencyValue struct {
+	n    int
+	auto bool
 }
 
-func newLockFile(noLock bool) *LockFile {
-	lockPath := filepath.Join(os.TempDir(), "geoip-update.lock")
-	return &LockFile{
-		path:   lockPath,
-		noLock: noLock,
+func (c *concurrencyValue) String() string {
+	if c == nil {
+		return ""
+	}
+	if c.auto {
+		return "auto"
 	}
+	return strconv.Itoa(c.n)
 }
 
-func (l *LockFile) Acquire() error {
-	if l.noLock {
+func (c *concurrencyValue) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "auto") {
+		c.auto = true
 		return nil
 	}
-
-	// Check if lock file exists
-	if data, err := os.ReadFile(l.path); err == nil {
-		// Parse PID
-		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
-			// Check if process is running
-			if isProcessRunning(pid) {
-				return fmt.Errorf("another instance is already running (PID: %d)", pid)
-			}
-		}
-		// Remove stale lock
-		os.Remove(l.path)
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid concurrency %q: want a positive number or auto", s)
 	}
-
-	// Create new lock
-	pid := os.Getpid()
-	return os.WriteFile(l.path, []byte(strconv.Itoa(pid)), 0644)
+	c.n, c.auto = n, false
+	return nil
 }
 
-func (l *LockFile) Release() {
-	if l.noLock {
-		return
-	}
-
-	// Only remove if it's our lock
-	if data, err := os.ReadFile(l.path); err == nil {
-		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
-			if pid == os.Getpid() {
-				os.Remove(l.path)
-			}
-		}
-	}
+// byteSizeValue is a flag.Value for a byte count with an optional binary
+// unit ("500MB", "1.5G", "1048576").
+type byteSizeValue struct {
+	n int64
 }
 
-func isProcessRunning(pid int) bool {
-	// Platform-specific process checking
-	switch runtime.GOOS {
-	case "windows":
-		// On Windows, we can't easily check, so assume it's running
-		return true
-	default:
-		// Unix-like systems
-		process, err := os.FindProcess(pid)
-		if err != nil {
-			return false
-		}
-		// Send signal 0 to check if process exists
-		err = process.Signal(os.Signal(nil))
-		return err == nil
+func (b *byteSizeValue) String() string {
+	if b == nil {
+		return ""
 	}
+	return strconv.FormatInt(b.n, 10)
 }
 
-// downloadIdleTimeout aborts a download whose body read stalls for this long.
-// This is a stall timeout, not an absolute deadline, so a slow-but-progressing
-// download of a large database is not killed mid-transfer.
-const downloadIdleTimeout = 120 * time.Second
-
-// idleTimeoutReader wraps a response body and cancels the request (via cancel)
-// if no data is read for idle. The timer is reset on every read that returns
-// bytes, so only a genuine stall trips it.
-type idleTimeoutReader struct {
-	rc    io.Reader
-	timer *time.Timer
-	idle  time.Duration
+func (b *byteSizeValue) Set(s string) error {
+	n, err := parseByteSize(s)
+	if err != nil {
+		return fmt.Errorf("invalid size: want bytes with an optional unit (e.g. 500MB, 2G)")
+	}
+	b.n = n
+	return nil
 }
 
-func newIdleTimeoutReader(rc io.Reader, idle time.Duration, cancel context.CancelFunc) *idleTimeoutReader {
-	return &idleTimeoutReader{rc: rc, idle: idle, timer: time.AfterFunc(idle, cancel)}
+// endpointsValue is a flag.Value for --endpoint/-e: a comma-separated list
+// of API endpoints, which may also be given by repeating the flag. The first
+// explicit value replaces the environment/default list.
+type endpointsValue struct {
+	list []string
+	set  bool
 }
 
-func (r *idleTimeoutReader) Read(p []byte) (int, error) {
-	n, err := r.rc.Read(p)
-	if n > 0 {
-		r.timer.Reset(r.idle)
+func (e *endpointsValue) String() string {
+	if e == nil {
+		return ""
 	}
-	return n, err
+	return strings.Join(e.list, ",")
 }
 
-func (r *idleTimeoutReader) Stop() { r.timer.Stop() }
-
-// HTTPClient wraps http.Client with retry logic
-type HTTPClient struct {
-	client     *http.Client
-	maxRetries int
-	logger     *Logger
-}
-
-func newHTTPClient(timeout time.Duration, maxRetries int, logger *Logger) *HTTPClient {
-	return &HTTPClient{
-		client: &http.Client{
-			// Generous overall ceiling. The per-read stall guard
-			// (downloadIdleTimeout) is what aborts a dead transfer; this just
-			// bounds a pathologically slow one. Connect/TLS/header are bounded
-			// explicitly below so removing a tight total timeout can't hang.
-			Timeout: timeout,
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					MinVersion: tls.VersionTLS12,
-				},
-				DialContext:           (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
-				TLSHandshakeTimeout:   15 * time.Second,
-				ResponseHeaderTimeout: 30 * time.Second,
-				MaxIdleConns:          100,
-				IdleConnTimeout:       90 * time.Second,
-				DisableCompression:    false,
-			},
-		},
-		maxRetries: maxRetries,
-		logger:     logger,
+func (e *endpointsValue) Set(s string) error {
+	if !e.set {
+		e.list, e.set = nil, true
 	}
+	e.list = append(e.list, splitEndpoints(s)...)
+	return nil
 }
 
-func (h *HTTPClient) doWithRetry(req *http.Request) (*http.Response, error) {
-	var lastErr error
-	retryDelay := time.Second
-
-	for attempt := 0; attempt < h.maxRetries; attempt++ {
-		if attempt > 0 {
-			h.logger.Info("Retrying in %v... (attempt %d/%d)", retryDelay, attempt+1, h.maxRetries)
-			time.Sleep(retryDelay)
-			retryDelay = minDuration(retryDelay*2, 60*time.Second)
-		}
-
-		resp, err := h.client.Do(req)
-		if err != nil {
-			lastErr = err
-			h.logger.Warn("Request failed: %v", err)
-			continue
-		}
-
-		// Check status code
-		switch resp.StatusCode {
-		case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
-			// 200 full, 206 resumed range, 416 range-not-satisfiable (already complete)
-			return resp, nil
-		case http.StatusTooManyRequests:
-			resp.Body.Close()
-			if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
-				if seconds, err := strconv.Atoi(retryAfter); err == nil {
-					retryDelay = time.Duration(seconds) * time.Second
-				}
-			}
-			h.logger.Warn("Rate limited (429)")
-			lastErr = fmt.Errorf("rate limited")
-		case http.StatusUnauthorized:
-			resp.Body.Close()
-			return nil, fmt.Errorf("authentication failed (401) - check your API key")
-		case http.StatusForbidden:
-			resp.Body.Close()
-			return nil, fmt.Errorf("access forbidden (403) - check your permissions")
-		default:
-			body, _ := io.ReadAll(resp.Body)
-			resp.Body.Close()
-			lastErr = fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
-			h.logger.Warn("HTTP error %d", resp.StatusCode)
+// splitEndpoints splits a comma-separated list (endpoints, --no-proxy
+// hosts), dropping blanks.
+func splitEndpoints(s string) []string {
+	var list []string
+	for _, endpoint := range strings.Split(s, ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			list = append(list, endpoint)
 		}
 	}
-
-	return nil, fmt.Errorf("failed after %d attempts: %w", h.maxRetries, lastErr)
+	return list
 }
 
-// GeoIPUpdater handles the database update process
-type GeoIPUpdater struct {
-	config     *Config
-	httpClient *HTTPClient
-	logger     *Logger
-	tempDir    string
+// statusCodesValue is a flag.Value for --retry-on: a comma-separated list
+// of HTTP status codes.
+type statusCodesValue struct {
+	codes []int
 }
 
-func newGeoIPUpdater(config *Config, logger *Logger) (*GeoIPUpdater, error) {
-	// Create temp directory
-	tempDir, err := os.MkdirTemp("", "geoip-update-*")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp directory: %w", err)
-	}
-
-	return &GeoIPUpdater{
-		config:     config,
-		httpClient: newHTTPClient(config.Timeout, config.MaxRetries, logger),
-		logger:     logger,
-		tempDir:    tempDir,
-	}, nil
-}
-
-func (g *GeoIPUpdater) authenticate() (map[string]string, error) {
-	g.logger.Info("Authenticating with API endpoint")
-
-	// Prepare request body
-	body := map[string]interface{}{
-		"databases": "all",
-	}
-	if len(g.config.Databases) > 0 && g.config.Databases[0] != "all" {
-		body["databases"] = g.config.Databases
-	}
-
-	jsonBody, err := json.Marshal(body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	// Create request
-	req, err := http.NewRequest("POST", g.config.APIEndpoint, bytes.NewReader(jsonBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", g.config.APIKey)
-	req.Header.Set("User-Agent", fmt.Sprintf("GeoIP-Update-Go/%s", version))
-
-	// Make request
-	resp, err := g.httpClient.doWithRetry(req)
-	if err != nil {
-		return nil, err
+func (v *statusCodesValue) String() string {
+	if v == nil {
+		return ""
 	}
-	defer resp.Body.Close()
-
-	// Parse response
-	var urls map[string]string
-	if err := json.NewDecoder(resp.Body).Decode(&urls); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	parts := make([]string, len(v.codes))
+	for i, code := range v.codes {
+		parts[i] = strconv.Itoa(code)
 	}
-
-	g.logger.Info("Received URLs for %d databases", len(urls))
-	return urls, nil
+	return strings.Join(parts, ",")
 }
 
-func (g *GeoIPUpdater) downloadDatabase(ctx context.Context, name, url string) DownloadResult {
-	g.logger.Info("Downloading: %s", name)
-
-	tempFile := filepath.Join(g.tempDir, name)
-	targetFile := filepath.Join(g.config.TargetDir, name)
-
-	// Resume on interruption/stall (HTTP Range) rather than restarting from
-	// byte 0, so large databases complete on flaky links. Retry while the
-	// transfer keeps making progress; give up only after a few consecutive
-	// no-progress attempts.
-	os.Remove(tempFile) // fresh start for this database
-	const maxNoProgress = 3
-	const hardCap = 50
-	noProgress := 0
-	var lastErr error
-
-	for attempt := 1; ; attempt++ {
-		if attempt > hardCap {
-			return DownloadResult{Database: name, Error: fmt.Errorf("giving up after %d attempts: %w", hardCap, lastErr)}
-		}
-
-		var offset int64
-		if fi, statErr := os.Stat(tempFile); statErr == nil {
-			offset = fi.Size()
-		}
-
-		reqCtx, cancel := context.WithCancel(ctx)
-		req, err := http.NewRequestWithContext(reqCtx, "GET", url, nil)
-		if err != nil {
-			cancel()
-			return DownloadResult{Database: name, Error: fmt.Errorf("failed to create request: %w", err)}
-		}
-		if offset > 0 {
-			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
-			g.logger.Info("Resuming %s from %d bytes (attempt %d)", name, offset, attempt)
-		}
-
-		// doWithRetry handles transient/429 retries and fails fast on 401/403.
-		resp, err := g.httpClient.doWithRetry(req)
-		if err != nil {
-			cancel()
-			lastErr = err
-			noProgress++
-			if noProgress >= maxNoProgress {
-				return DownloadResult{Database: name, Error: err}
-			}
-			time.Sleep(5 * time.Second)
+func (v *statusCodesValue) Set(s string) error {
+	v.codes = nil
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
 			continue
 		}
-
-		// 416 => the byte range is past EOF, i.e. we already have the whole file.
-		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
-			resp.Body.Close()
-			cancel()
-			break
+		code, err := strconv.Atoi(field)
+		if err != nil || code < 400 || code > 599 {
+			return fmt.Errorf("invalid status code %q: want 400-599", field)
 		}
-
-		// 206 resumes (append); 200 means the server sent the whole body, so
-		// start the file fresh.
-		var out *os.File
-		if resp.StatusCode == http.StatusPartialContent && offset > 0 {
-			out, err = os.OpenFile(tempFile, os.O_APPEND|os.O_WRONLY, 0o644)
-		} else {
-			out, err = os.Create(tempFile)
-		}
-		if err != nil {
-			resp.Body.Close()
-			cancel()
-			return DownloadResult{Database: name, Error: fmt.Errorf("failed to open temp file: %w", err)}
-		}
-
-		// Copy through a stall guard: abort if no bytes arrive for
-		// downloadIdleTimeout (slow-but-progressing transfers are unaffected).
-		body := newIdleTimeoutReader(resp.Body, downloadIdleTimeout, cancel)
-		_, copyErr := io.Copy(out, body)
-		body.Stop()
-		out.Close()
-		resp.Body.Close()
-		cancel()
-
-		if copyErr == nil {
-			break // read through to EOF => complete
-		}
-
-		lastErr = copyErr
-		var cur int64
-		if fi, statErr := os.Stat(tempFile); statErr == nil {
-			cur = fi.Size()
-		}
-		if cur > offset {
-			noProgress = 0
-			g.logger.Warn("%s: transfer interrupted at %d bytes - resuming (%v)", name, cur, copyErr)
-		} else {
-			noProgress++
-			g.logger.Warn("%s: no progress (attempt %d/%d): %v", name, noProgress, maxNoProgress, copyErr)
-			if noProgress >= maxNoProgress {
-				return DownloadResult{Database: name, Error: fmt.Errorf("failed to download: %w", copyErr)}
-			}
-			time.Sleep(5 * time.Second)
+		if code == 401 || code == 403 {
+			return fmt.Errorf("%d is never retried: a bad API key or missing permission won't fix itself", code)
 		}
+		v.codes = append(v.codes, code)
 	}
+	return nil
+}
 
-	fi, err := os.Stat(tempFile)
-	if err != nil || fi.Size() == 0 {
-		return DownloadResult{Database: name, Error: fmt.Errorf("downloaded file is empty")}
-	}
-	size := fi.Size()
-
-	// Basic validation for MMDB files
-	if strings.HasSuffix(name, ".mmdb") {
-		if err := g.validateMMDB(tempFile); err != nil {
-			g.logger.Warn("MMDB validation warning for %s: %v", name, err)
-		}
-	}
-
-	// Move to target location
-	if err := os.Rename(tempFile, targetFile); err != nil {
-		// If rename fails (cross-device), copy instead
-		if err := g.copyFile(tempFile, targetFile); err != nil {
-			return DownloadResult{Database: name, Error: fmt.Errorf("failed to move file: %w", err)}
-		}
-		os.Remove(tempFile)
-	}
-
-	return DownloadResult{Database: name, Size: size}
+// spaceMarginValue is a flag.Value for --space-margin: either a percentage
+// of the expected download size ("10%") or a fixed size ("500MB").
+type spaceMarginValue struct {
+	percent int
+	bytes   int64
 }
 
-func (g *GeoIPUpdater) validateMMDB(path string) error {
-	file, err := os.Open(path)
-	if err != nil {
-		return err
+func (m *spaceMarginValue) String() string {
+	if m == nil {
+		return ""
 	}
-	defer file.Close()
-
-	// Get file size
-	stat, err := file.Stat()
-	if err != nil {
-		return err
+	if m.bytes > 0 {
+		return strconv.FormatInt(m.bytes, 10)
 	}
-	size := stat.Size()
+	return strconv.Itoa(m.percent) + "%"
+}
 
-	// MMDB files have metadata at the end with marker \xab\xcd\xef followed by MaxMind.com
-	// Read the last 100KB to find the metadata section
-	readSize := int64(100000)
-	if size < readSize {
-		readSize = size
+func (m *spaceMarginValue) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid margin %q: want a percentage (10%%) or a size (500MB)", s)
+		}
+		m.percent, m.bytes = n, 0
+		return nil
 	}
-
-	// Seek to the position to start reading
-	_, err = file.Seek(size-readSize, 0)
+	n, err := parseByteSize(s)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid margin %q: want a percentage (10%%) or a size (500MB)", s)
 	}
-
-	// Read the last portion of the file
-	buf := make([]byte, readSize)
-	n, err := file.Read(buf)
-	if err != nil && err != io.EOF {
-		return err
-	}
-
-	// Look for the MMDB metadata marker
-	marker := []byte("\xab\xcd\xefMaxMind.com")
-	if !bytes.Contains(buf[:n], marker) {
-		return fmt.Errorf("missing MaxMind metadata marker")
-	}
-
+	m.percent, m.bytes = 0, n
 	return nil
 }
 
-func (g *GeoIPUpdater) copyFile(src, dst string) error {
-	source, err := os.Open(src)
-	if err != nil {
-		return err
+// parseByteSize parses a non-negative byte count with an optional K, M or G
+// binary unit and optional trailing B.
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(s, "B")
+	multiplier := int64(1)
+	for i, unit := range []string{"K", "M", "G"} {
+		if strings.HasSuffix(s, unit) {
+			s = strings.TrimSuffix(s, unit)
+			multiplier = int64(1) << (10 * (i + 1))
+			break
+		}
 	}
-	defer source.Close()
-
-	destination, err := os.Create(dst)
-	if err != nil {
-		return err
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid byte size %q", s)
 	}
-	defer destination.Close()
-
-	_, err = io.Copy(destination, source)
-	return err
+	return int64(f * float64(multiplier)), nil
 }
 
-func (g *GeoIPUpdater) updateDatabases() error {
-	g.logger.Info("Starting GeoIP database update")
-	g.logger.Info("Target directory: %s", g.config.TargetDir)
-
-	// Ensure target directory exists
-	if err := os.MkdirAll(g.config.TargetDir, 0755); err != nil {
-		return fmt.Errorf("failed to create target directory: %w", err)
-	}
-
-	// Get download URLs
-	urls, err := g.authenticate()
-	if err != nil {
-		return fmt.Errorf("authentication failed: %w", err)
-	}
+// ageValue is a flag.Value for --max-age: a number of days ("30d", or a bare
+// "30"), weeks ("2w") or a Go duration ("36h").
+type ageValue struct {
+	d time.Duration
+}
 
-	if len(urls) == 0 {
-		g.logger.Warn("No databases to download")
-		return nil
+func (a *ageValue) String() string {
+	if a == nil {
+		return ""
 	}
+	return formatAge(a.d)
+}
 
-	// Download databases concurrently
-	ctx := context.Background()
-	results := make(chan DownloadResult, len(urls))
-	semaphore := make(chan struct{}, g.config.MaxConcurrent)
-	var wg sync.WaitGroup
-	var successCount, failCount int32
-
-	for name, url := range urls {
-		wg.Add(1)
-		go func(name, url string) {
-			defer wg.Done()
-
-			// Acquire semaphore
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
-
-			result := g.downloadDatabase(ctx, name, url)
-			results <- result
-
-			if result.Error != nil {
-				atomic.AddInt32(&failCount, 1)
-				g.logger.Error("Failed to download %s: %v", result.Database, result.Error)
-			} else {
-				atomic.AddInt32(&successCount, 1)
-				g.logger.Success("Successfully downloaded: %s (%d bytes)", result.Database, result.Size)
+func (a *ageValue) Set(s string) error {
+	s = strings.TrimSpace(s)
+	unit := 24 * time.Hour
+	switch {
+	case strings.HasSuffix(s, "d"):
+		s = strings.TrimSuffix(s, "d")
+	case strings.HasSuffix(s, "w"):
+		s = strings.TrimSuffix(s, "w")
+		unit *= 7
+	default:
+		if _, err := strconv.Atoi(s); err != nil {
+			d, err := time.ParseDuration(s)
+			if err != nil || d < 0 {
+				return fmt.Errorf("invalid age %q: want days (e.g. 30d), weeks (2w) or a duration (36h)", s)
 			}
-		}(name, url)
+			a.d = d
+			return nil
+		}
 	}
-
-	// Wait for all downloads
-	wg.Wait()
-	close(results)
-
-	// Summary
-	total := len(urls)
-	success := int(atomic.LoadInt32(&successCount))
-	failed := int(atomic.LoadInt32(&failCount))
-
-	g.logger.Info("Download summary: %d successful, %d failed out of %d", success, failed, total)
-
-	if failed > 0 {
-		return fmt.Errorf("failed to download %d databases", failed)
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid age %q: want days (e.g. 30d), weeks (2w) or a duration (36h)", s)
 	}
-
+	a.d = time.Duration(n) * unit
 	return nil
 }
 
-func (g *GeoIPUpdater) cleanup() {
-	if g.tempDir != "" {
-		g.logger.Info("Cleaning up temporary files")
-		os.RemoveAll(g.tempDir)
-	}
+// sinceValue is a flag.Value for --since: an RFC3339 time, a date
+// ("2024-03-01", UTC) or an age relative to now ("7d", "2w", "36h").
+type sinceValue struct {
+	t time.Time
 }
 
-// timeoutValue is a flag.Value for --timeout/-t that accepts either a bare
-// integer interpreted as seconds ("1800") or a Go duration string ("5m",
-// "300s", "90s"). Plain integers keep existing callers working; duration
-// strings let the GitHub Action forward its documented "5m / 300s" format
-// straight through to the binary.
-type timeoutValue struct {
-	d time.Duration
-}
-
-func (t *timeoutValue) String() string {
-	if t == nil {
+func (v *sinceValue) String() string {
+	if v == nil || v.t.IsZero() {
 		return ""
 	}
-	return t.d.String()
+	return v.t.Format(time.RFC3339)
 }
 
-func (t *timeoutValue) Set(s string) error {
+func (v *sinceValue) Set(s string) error {
 	s = strings.TrimSpace(s)
-	// A bare integer means seconds (backward compatible with the old flag.Int).
-	if n, err := strconv.Atoi(s); err == nil {
-		t.d = time.Duration(n) * time.Second
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		v.t = t
 		return nil
 	}
-	// Otherwise accept a Go duration string ("5m", "300s", "90s").
-	d, err := time.ParseDuration(s)
-	if err != nil {
-		return fmt.Errorf("invalid value %q: want seconds (e.g. 1800) or a duration (e.g. 5m, 300s)", s)
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		v.t = t
+		return nil
 	}
-	t.d = d
+	var age ageValue
+	if err := age.Set(s); err != nil {
+		return fmt.Errorf("invalid time %q: want RFC3339 (2024-03-01T00:00:00Z), a date (2024-03-01) or an age (7d, 36h)", s)
+	}
+	v.t = time.Now().Add(-age.d)
 	return nil
 }
 
-func parseFlags() (*Config, error) {
-	config := &Config{}
+func parseFlags() (*geoip.Config, error) {
+	config := &geoip.Config{}
 
 	// Define flags
 	flag.StringVar(&config.APIKey, "api-key", os.Getenv("GEOIP_API_KEY"), "API key (or use GEOIP_API_KEY env var)")
 	flag.StringVar(&config.APIKey, "k", os.Getenv("GEOIP_API_KEY"), "API key (short)")
+	apiKeyKeyring := flag.String("api-key-keyring", os.Getenv("GEOIP_API_KEY_KEYRING"), "Read the API key from the OS credential store entry service/account, falling back to the other sources when absent (or use GEOIP_API_KEY_KEYRING env var)")
+	saveKey := flag.Bool("save-key", false, "Store the API key (from --api-key, --api-key-file or GEOIP_API_KEY) in the --api-key-keyring entry and exit")
+	apiKeyFile := flag.String("api-key-file", os.Getenv("GEOIP_API_KEY_FILE"), "Read the API key from this file, keeping it out of the process list (or use GEOIP_API_KEY_FILE env var)")
+	flag.StringVar(&config.AuthMode, "auth-mode", getEnvOrDefault("GEOIP_AUTH_MODE", geoip.AuthAPIKey), "How to authenticate to the API: api-key (X-API-Key header) or bearer (OAuth2 bearer token)")
+	flag.StringVar(&config.Token, "token", os.Getenv("GEOIP_TOKEN"), "Bearer token for --auth-mode bearer; \"-\" reads it from stdin (or use GEOIP_TOKEN env var)")
+	tokenFile := flag.String("token-file", os.Getenv("GEOIP_TOKEN_FILE"), "Read the bearer token from this file (or use GEOIP_TOKEN_FILE env var)")
+	flag.StringVar(&config.TokenURL, "token-url", os.Getenv("GEOIP_TOKEN_URL"), "OAuth2 token endpoint: get the bearer token with the client credentials grant (or use GEOIP_TOKEN_URL env var)")
+	flag.StringVar(&config.OAuthClientID, "client-id", os.Getenv("GEOIP_CLIENT_ID"), "OAuth2 client ID for --token-url (or use GEOIP_CLIENT_ID env var)")
+	flag.StringVar(&config.OAuthClientSecret, "client-secret", os.Getenv("GEOIP_CLIENT_SECRET"), "OAuth2 client secret for --token-url (or use GEOIP_CLIENT_SECRET env var)")
+	flag.StringVar(&config.TokenScope, "token-scope", os.Getenv("GEOIP_TOKEN_SCOPE"), "Space-separated scopes to request from --token-url")
 	
-	flag.StringVar(&config.APIEndpoint, "endpoint", getEnvOrDefault("GEOIP_API_ENDPOINT", defaultEndpoint), "API endpoint URL")
-	flag.StringVar(&config.APIEndpoint, "e", getEnvOrDefault("GEOIP_API_ENDPOINT", defaultEndpoint), "API endpoint URL (short)")
+	endpoints := &endpointsValue{list: splitEndpoints(getEnvOrDefault("GEOIP_API_ENDPOINT", defaultEndpoint))}
+	flag.Var(endpoints, "endpoint", "API endpoint URL; comma-separated or repeated for fallbacks tried in order")
+	flag.Var(endpoints, "e", "API endpoint URL (short)")
 	
 	flag.StringVar(&config.TargetDir, "directory", getEnvOrDefault("GEOIP_TARGET_DIR", defaultTargetDir), "Target directory")
 	flag.StringVar(&config.TargetDir, "d", getEnvOrDefault("GEOIP_TARGET_DIR", defaultTargetDir), "Target directory (short)")
 	
 	databases := flag.String("databases", "all", "Comma-separated database list or 'all'")
 	flag.StringVar(databases, "b", "all", "Databases (short)")
+	databasesFile := flag.String("databases-file", os.Getenv("GEOIP_DATABASES_FILE"), "Read database names or aliases from this file, one per line (# comments); combined with --databases (or use GEOIP_DATABASES_FILE env var)")
 	
 	flag.StringVar(&config.LogFile, "log-file", os.Getenv("GEOIP_LOG_FILE"), "Log file path")
 	flag.StringVar(&config.LogFile, "l", os.Getenv("GEOIP_LOG_FILE"), "Log file (short)")
+	flag.StringVar(&config.Color, "color", "auto", "Color console output: auto (only on a terminal, unless NO_COLOR is set), always or never")
+	noColor := flag.Bool("no-color", false, "Disable colored output (same as --color=never)")
+	flag.StringVar(&config.LogFormat, "log-format", getEnvOrDefault("GEOIP_LOG_FORMAT", "text"), "Log format for console and log file: text or json (one object per line; or use GEOIP_LOG_FORMAT env var)")
 	
 	flag.IntVar(&config.MaxRetries, "retries", defaultRetries, "Max retries")
 	flag.IntVar(&config.MaxRetries, "r", defaultRetries, "Max retries (short)")
 	
+	retryBaseDelay := &timeoutValue{d: time.Second}
+	flag.Var(retryBaseDelay, "retry-base-delay", "Backoff cap before the first retry; each wait is random up to the cap (e.g. 1s, 500ms)")
+	flag.Float64Var(&config.RetryMultiplier, "retry-multiplier", 2, "Growth of the backoff cap per retry")
+	retryMaxDelay := &timeoutValue{d: 60 * time.Second}
+	flag.Var(retryMaxDelay, "retry-max-delay", "Largest backoff cap (e.g. 60s, 5m)")
+	retryMaxElapsed := &timeoutValue{}
+	flag.Var(retryMaxElapsed, "retry-max-elapsed", "Stop retrying a request once this much time has passed, whatever --retries says (e.g. 2m; default: no limit)")
+	retryOn := &statusCodesValue{}
+	flag.Var(retryOn, "retry-on", "Comma-separated HTTP statuses to retry, replacing the default 408,429,5xx (e.g. 429,502,503)")
+	
 	timeout := &timeoutValue{d: defaultTimeout * time.Second}
-	flag.Var(timeout, "timeout", "Download timeout: seconds (e.g. 1800) or duration (e.g. 5m, 300s)")
+	flag.Var(timeout, "timeout", "Deadline for each database download (all attempts) and API request: seconds (e.g. 1800) or duration (e.g. 5m, 300s)")
 	flag.Var(timeout, "t", "Download timeout (short)")
+	connectTimeout := &timeoutValue{d: defaultConnectTimeout * time.Second}
+	flag.Var(connectTimeout, "connect-timeout", "Fail a connection attempt (TCP connect, TLS handshake) after this long (e.g. 10, 5s)")
+	stallTimeout := &timeoutValue{d: defaultStallTimeout * time.Second}
+	flag.Var(stallTimeout, "stall-timeout", "Abort and resume a download that receives no data for this long (e.g. 60, 2m)")
+	requestTimeout := &timeoutValue{}
+	flag.Var(requestTimeout, "request-timeout", "Limit each attempt of a request, body included; retries and resumes start afresh (e.g. 5m; default: no limit)")
+	deadline := &timeoutValue{}
+	flag.Var(deadline, "deadline", "Cancel an update run that takes longer than this and report it failed (e.g. 1h; default: no limit)")
 	
-	flag.IntVar(&config.MaxConcurrent, "concurrent", defaultConcurrent, "Max concurrent downloads")
+	concurrent := &concurrencyValue{n: defaultConcurrent}
+	flag.Var(concurrent, "concurrent", "Max concurrent downloads, or auto to use one per CPU (2-8, at most one per database), adapting to rate limiting")
+	maxRate := &byteRateValue{}
+	flag.Var(maxRate, "max-rate", "Cap total download speed across all downloads, per second (e.g. 500KB, 5MB; 0 = unlimited)")
+	flag.IntVar(&config.ChunksPerFile, "chunks-per-file", 1, "Download each file as this many parallel byte ranges when the server supports it")
+	flag.IntVar(&config.ChunksPerFile, "split", 1, "Same as --chunks-per-file")
+	flag.StringVar(&config.Compression, "compression", getEnvOrDefault("GEOIP_COMPRESSION", geoip.CompressionAuto), "HTTP compression: auto (transport negotiates gzip), off (request bodies unencoded) or gzip (always ask for gzip on downloads); logged with --verbose (or use GEOIP_COMPRESSION env var)")
+	noCompression := flag.Bool("no-compression", false, "Same as --compression off")
 	
 	flag.BoolVar(&config.Quiet, "quiet", false, "Quiet mode")
 	flag.BoolVar(&config.Quiet, "q", false, "Quiet mode (short)")
 	
 	flag.BoolVar(&config.Verbose, "verbose", false, "Verbose output")
 	flag.BoolVar(&config.Verbose, "v", false, "Verbose (short)")
+	flag.BoolVar(&config.Debug, "debug", false, "Log HTTP requests/responses (credentials redacted), retry decisions and timings; independent of --verbose")
 	
 	flag.BoolVar(&config.NoLock, "no-lock", false, "Don't use lock file")
 	flag.BoolVar(&config.NoLock, "n", false, "No lock (short)")
+	lockTimeout := &timeoutValue{}
+	flag.Var(lockTimeout, "lock-timeout", "Wait this long for another instance to finish instead of failing (e.g. 30, 5m)")
+
+	flag.StringVar(&config.CACert, "ca-cert", os.Getenv("GEOIP_CA_CERT"), "PEM file of extra root CAs to trust, e.g. for a private mirror (or use GEOIP_CA_CERT env var)")
+	flag.StringVar(&config.TLSMinVersion, "tls-min-version", "1.2", "Oldest TLS version to accept: 1.2 or 1.3")
+	flag.BoolVar(&config.CAOnly, "ca-only", false, "Trust only --ca-cert, not the system root CAs")
+	flag.StringVar(&config.ClientCert, "client-cert", os.Getenv("GEOIP_CLIENT_CERT"), "PEM client certificate for mutual TLS (with --client-key)")
+	flag.StringVar(&config.ClientKey, "client-key", os.Getenv("GEOIP_CLIENT_KEY"), "PEM private key for --client-cert")
+	flag.StringVar(&config.UserAgent, "user-agent", os.Getenv("GEOIP_USER_AGENT"), "User-Agent for all API and download requests (default: GeoIP-Update-Go/<version> (<os>/<arch>); or use GEOIP_USER_AGENT env var)")
+	userAgentHost := flag.Bool("user-agent-hostname", false, "Add host=<hostname> to the default User-Agent")
+	flag.StringVar(&config.Proxy, "proxy", os.Getenv("GEOIP_PROXY"), "Proxy URL for all requests: http://, https:// or socks5://, optionally with user:password@ (default: HTTPS_PROXY/HTTP_PROXY; or use GEOIP_PROXY env var)")
+	noProxy := flag.String("no-proxy", os.Getenv("GEOIP_NO_PROXY"), "Comma-separated hosts, domains, IPs or CIDRs to reach without the proxy")
+	flag.BoolVar(&config.InsecureSkipVerify, "insecure-skip-verify", false, "Don't verify TLS certificates (testing against self-signed endpoints only)")
+	flag.BoolVar(&config.InsecureSkipVerify, "insecure", false, "Same as --insecure-skip-verify")
+	flag.BoolVar(&config.NoVerifyChecksum, "no-verify-checksum", false, "Skip SHA256 verification of downloaded databases")
+	flag.BoolVar(&config.Manifest, "manifest", false, "Write SHA256SUMS to the target directory after each update, for sha256sum -c")
+	flag.StringVar(&config.ManifestAlgo, "manifest-algo", "sha256", "Hash for --manifest: sha256 (SHA256SUMS) or sha512 (SHA512SUMS)")
+	flag.StringVar(&config.ManifestJSON, "manifest-json", "", "After a successful update, atomically write this JSON file recording each database's file, size, SHA256, source URL and install time")
+	flag.BoolVar(&config.FailFast, "fail-fast", false, "Abort the remaining downloads as soon as one database fails")
+	continueOnError := flag.Bool("continue-on-error", false, "Let every download finish when one fails, then report them all (the default; overrides --fail-fast)")
+	flag.BoolVar(&config.Force, "force", false, "Download even if the cached ETag/Last-Modified or the local file's checksum says unchanged")
+	flag.BoolVar(&config.Backup, "keep-backup", false, "Keep the previous database as <name>.bak after a successful update")
+	flag.BoolVar(&config.Backup, "backup", false, "Same as --keep-backup")
+	flag.IntVar(&config.BackupCount, "backup-count", 1, "Number of rotating backups to keep (<name>.bak.1 ... .bak.N); implies --keep-backup when above 1")
+	flag.BoolVar(&config.Dated, "dated", false, "Store databases as <name>-<YYYYMMDD>.<ext> with <name>.<ext> a symlink to the newest (a copy on Windows)")
+	flag.BoolVar(&config.NormalizeNames, "normalize-names", false, "Install databases under lowercased file names (the server's names are still requested)")
+	flag.BoolVar(&config.StableNames, "stable-names", false, "Install databases served under dated names (<name>_<YYYYMMDD>.<ext>) as served, with <name>.<ext> a symlink to the newest (a copy on Windows)")
+	flag.BoolVar(&config.PurgeUnknown, "purge-unknown", false, "After a fully successful run, delete database files in the directory that were not part of it")
+	flag.IntVar(&config.KeepVersions, "keep-versions", 0, "Store each new download under <directory>/versions/ with a timestamp and keep the newest N per database")
+	flag.IntVar(&config.Keep, "keep", 0, "With --dated or --stable-names, keep only the newest N dated copies of each database (0 keeps all)")
+	flag.BoolVar(&config.AtomicDir, "atomic-dir", false, "Update a copy of the directory (<dir>.new) and swap it in only if every database succeeds; the previous one is kept as <dir>.old")
+	flag.BoolVar(&config.SkipSpaceCheck, "skip-space-check", false, "Don't check free disk space before downloading")
+	spaceEstimate := &byteSizeValue{}
+	spaceMargin := &spaceMarginValue{percent: 10}
+	flag.Var(spaceMargin, "space-margin", "Free space required beyond the expected download size: a percentage (10%) or a size (500MB)")
+	flag.Var(spaceEstimate, "space-estimate", "Size assumed by the disk space check for databases whose size the server doesn't report (e.g. 500MB)")
+	flag.BoolVar(&config.DeepValidate, "deep-validate", false, "Open MMDB files and check metadata and search tree, not just the marker")
+	flag.BoolVar(&config.DryRun, "dry-run", false, "Authenticate and list what would be downloaded, without downloading")
+	flag.BoolVar(&config.HealthCheck, "health-check", false, "Check, without network access, that the databases are present, valid and (with --max-age) fresh; print one status line and exit 0 or 1")
+	flag.BoolVar(&config.VerifyOnly, "verify-only", false, "Compare local files with the server's current versions (checksum, ETag or size) and report drift, without downloading")
+	flag.BoolVar(&config.ShowURLs, "show-urls", false, "With --dry-run, print full download URLs including presigned query strings")
+	flag.StringVar(&config.Output, "output", "text", "Output format: text or json (a versioned summary on stdout; logs go to stderr)")
+	flag.BoolVar(&config.Daemon, "daemon", false, "Keep running and update every --interval (SIGHUP updates immediately)")
+	interval := &timeoutValue{d: 24 * time.Hour}
+	flag.BoolVar(&config.Daemon, "watch", false, "Same as --daemon")
+	flag.Var(interval, "interval", "Time between updates in --daemon mode (e.g. 24h, 12h)")
+	flag.StringVar(&config.Cron, "cron", "", "Keep running and update on this cron schedule, local time (e.g. \"0 3 * * *\"); implies --daemon")
+	flag.StringVar(&config.S3Bucket, "s3-bucket", os.Getenv("GEOIP_S3_BUCKET"), "Upload each database to this S3 bucket after it validates (AWS credential chain)")
+	flag.StringVar(&config.S3Prefix, "s3-prefix", os.Getenv("GEOIP_S3_PREFIX"), "Key prefix for --s3-bucket uploads (e.g. geoip/)")
+	flag.StringVar(&config.S3Region, "s3-region", os.Getenv("GEOIP_S3_REGION"), "Region of the --s3-bucket (default: AWS_REGION, else us-east-1)")
+	flag.StringVar(&config.S3Endpoint, "s3-endpoint", os.Getenv("GEOIP_S3_ENDPOINT"), "S3-compatible endpoint URL for --s3-bucket, e.g. MinIO (path-style requests)")
+	flag.StringVar(&config.GCSBucket, "gcs-bucket", os.Getenv("GEOIP_GCS_BUCKET"), "Upload each database to this Google Cloud Storage bucket after it validates (Application Default Credentials)")
+	flag.StringVar(&config.GCSPrefix, "gcs-prefix", os.Getenv("GEOIP_GCS_PREFIX"), "Object name prefix for --gcs-bucket uploads (e.g. geoip/)")
+	flag.StringVar(&config.GCSCredentialsFile, "gcs-service-account-json", "", "Service account key file for --gcs-bucket (default: Application Default Credentials)")
+	flag.StringVar(&config.MetricsFile, "metrics-file", "", "Write Prometheus textfile metrics here after each run (for node_exporter)")
+	flag.StringVar(&config.MetricsFile, "metrics-textfile", "", "Same as --metrics-file")
+	flag.StringVar(&config.MetricsPushgateway, "metrics-pushgateway", "", "Push metrics to this Prometheus Pushgateway URL after each run")
+	flag.StringVar(&config.StatusFile, "status-file", "", "Keep a JSON status document here (phase, databases remaining, last success/error) for external watchdogs")
+	flag.StringVar(&config.WebhookURL, "webhook-url", os.Getenv("GEOIP_WEBHOOK_URL"), "POST a JSON run summary to this URL after each run (or use GEOIP_WEBHOOK_URL env var)")
+	flag.StringVar(&config.WebhookURL, "webhook", config.WebhookURL, "Same as --webhook-url")
+	flag.StringVar(&config.SlackWebhook, "slack-webhook", os.Getenv("GEOIP_SLACK_WEBHOOK"), "Post a formatted run summary to this Slack incoming webhook URL (or use GEOIP_SLACK_WEBHOOK env var)")
+	flag.StringVar(&config.TeamsWebhook, "teams-webhook", os.Getenv("GEOIP_TEAMS_WEBHOOK"), "Post a Microsoft Teams MessageCard run summary to this incoming webhook URL (or use GEOIP_TEAMS_WEBHOOK env var)")
+	notify := flag.String("notify", "", "Formatted notification to send to --notify-url: slack or teams")
+	notifyURL := flag.String("notify-url", "", "Incoming webhook URL for --notify")
+	flag.StringVar(&config.WebhookOn, "webhook-on", "always", "When to send the webhook, Slack and Teams notifications: always, success or failure")
 	
 	showVersion := flag.Bool("version", false, "Show version")
 	listDatabases := flag.Bool("list-databases", false, "List all available databases and aliases")
@@ -735,80 +502,300 @@ func parseFlags() (*Config, error) {
 	flag.BoolVar(checkNames, "C", false, "Check names (short)")
 	validateOnly := flag.Bool("validate-only", false, "Validate existing database files")
 	flag.BoolVar(validateOnly, "V", false, "Validate files (short)")
+	maxAge := &ageValue{}
+	flag.Var(maxAge, "max-age", "With --validate-only, fail for databases older than this (e.g. 30d); with --only-if-stale or --health-check, the freshness limit (e.g. 6h)")
+	flag.BoolVar(&config.OnlyIfStale, "only-if-stale", false, "Do nothing (not even authenticate) unless a requested database is missing or older than --max-age")
+	since := &sinceValue{}
+	flag.Var(since, "since", "Only download databases updated after this time: RFC3339, a date, or an age like 7d")
+	flag.BoolVar(&config.IfNewer, "if-newer", false, "Before each download, skip it unless the server's Last-Modified (HEAD) is newer than the local file")
+	configFile := flag.String("config", "", "Config file (YAML, JSON or TOML); default ./geoip.yaml if present")
 	
 	flag.Parse()
 
+	// Merge the config file under the command line: flags > file > env > defaults.
+	configPath := findConfigFile(*configFile)
+	var fromFile map[string]bool
+	if configPath != "" {
+		var err error
+		if fromFile, err = applyConfigFile(flag.CommandLine, configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	// An explicit --api-key (command line or config file) beats the
+	// --api-key-keyring entry, then --api-key-file, then GEOIP_API_KEY;
+	// "--api-key -" reads stdin.
+	apiKeyGiven := flagGiven(flag.CommandLine, "api-key")
+	apiKeySource := "api-key"
+	if *apiKeyKeyring != "" && !apiKeyGiven && !*saveKey {
+		key, err := lookupKeyringKey(*apiKeyKeyring)
+		if err == nil {
+			config.APIKey = key
+			apiKeyGiven = true
+			apiKeySource = "api-key-keyring"
+		} else if errors.Is(err, errKeyringNotFound) {
+			log.Printf("Info: no API key in keyring entry %s, using the other sources", *apiKeyKeyring)
+		} else {
+			log.Printf("Warning: %v; using the other API key sources", err)
+		}
+	}
+	if !apiKeyGiven && *apiKeyFile != "" {
+		apiKeySource = "api-key-file"
+	}
+	key, err := resolveSecret("API key", config.APIKey, *apiKeyFile, apiKeyGiven, os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("%v (%s)", err, settingSource("api-key-file", configPath, fromFile))
+	}
+	config.APIKey = key
+	token, err := resolveSecret("bearer token", config.Token, *tokenFile, flagGiven(flag.CommandLine, "token"), os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("%v (%s)", err, settingSource("token-file", configPath, fromFile))
+	}
+	config.Token = token
+
+	if *saveKey {
+		if *apiKeyKeyring == "" {
+			return nil, fmt.Errorf("--save-key requires --api-key-keyring service/account")
+		}
+		if !isValidAPIKey(config.APIKey) {
+			return nil, fmt.Errorf("invalid API key format (%s)", settingSource(apiKeySource, configPath, fromFile))
+		}
+		if err := saveKeyringKey(*apiKeyKeyring, config.APIKey); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Saved the API key to keyring entry %s\n", *apiKeyKeyring)
+		os.Exit(0)
+	}
+
 	// Handle version flag
 	if *showVersion {
 		fmt.Printf("GeoIP Update Go %s\n", displayVersion())
 		os.Exit(0)
 	}
-
-	// Handle list databases flag
-	if *listDatabases {
-		listDatabasesCmd()
-		os.Exit(0)
+
+	if config.UserAgent == "" {
+		config.UserAgent = geoip.DefaultUserAgent(version, *userAgentHost)
+	}
+
+	// Clean and normalize the API endpoints
+	for _, endpoint := range endpoints.list {
+		endpoint = strings.TrimRight(endpoint, "/ \t\n\r")
+
+		// Auto-append /auth if it's the base geoipdb.net domain
+		if endpoint == "https://geoipdb.net" || endpoint == "http://geoipdb.net" {
+			endpoint = endpoint + "/auth"
+			log.Printf("Info: Appended /auth to endpoint: %s\n", endpoint)
+		}
+		config.APIEndpoints = append(config.APIEndpoints, endpoint)
+	}
+	if len(config.APIEndpoints) == 0 {
+		return nil, fmt.Errorf("no API endpoint given (%s)", settingSource("endpoint", configPath, fromFile))
+	}
+	config.NoProxy = splitEndpoints(*noProxy)
+
+	// Handle list databases and show examples flags. Their discovery
+	// request logs retries like an update run would and fails over across
+	// the same endpoints.
+	if *listDatabases || *showExamples {
+		logger, err := geoip.NewLogger(config, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup logger: %w", err)
+		}
+		if *listDatabases {
+			listDatabasesCmd(config, logger)
+		} else {
+			showExamplesCmd(config, logger)
+		}
+		logger.Close()
+		os.Exit(0)
+	}
+
+	// --databases-file adds to an explicit --databases list and replaces the
+	// "all" default.
+	var fileDatabases []string
+	if *databasesFile != "" {
+		if fileDatabases, err = readDatabasesFile(*databasesFile); err != nil {
+			return nil, fmt.Errorf("%v (%s)", err, settingSource("databases-file", configPath, fromFile))
+		}
+	}
+	selection := mergeDatabases(*databases, flagGiven(flag.CommandLine, "databases"), fileDatabases)
+
+	// Handle check names flag
+	if *checkNames {
+		// Need API key for name checking
+		if config.AuthMode == geoip.AuthAPIKey && config.APIKey == "" {
+			return nil, fmt.Errorf("API key required for name checking. Use --api-key, --api-key-file or set GEOIP_API_KEY")
+		}
+		checkDatabaseNamesCmd(config, selection)
+		os.Exit(0)
+	}
+	
+	// Handle validate only flag (file validation)
+	config.MaxAge = maxAge.d
+	if *validateOnly {
+		validateDatabaseFilesCmd(config)
+		os.Exit(0)
+	}
+
+	config.Databases = selection
+	if *continueOnError {
+		config.FailFast = false
+	}
+
+	if config.Output != "text" && config.Output != "json" {
+		return nil, fmt.Errorf("invalid --output %q: must be text or json (%s)", config.Output, settingSource("output", configPath, fromFile))
+	}
+
+	if *noColor {
+		config.Color = "never"
+	}
+	switch config.Color {
+	case "auto", "always", "never":
+	default:
+		return nil, fmt.Errorf("invalid --color %q: must be auto, always or never (%s)", config.Color, settingSource("color", configPath, fromFile))
+	}
+
+	if config.LogFormat != "text" && config.LogFormat != "json" {
+		return nil, fmt.Errorf("invalid --log-format %q: must be text or json (%s)", config.LogFormat, settingSource("log-format", configPath, fromFile))
+	}
+
+	switch config.WebhookOn {
+	case "always", "success", "failure":
+	default:
+		return nil, fmt.Errorf("invalid --webhook-on %q: must be always, success or failure (%s)", config.WebhookOn, settingSource("webhook-on", configPath, fromFile))
+	}
+
+	if (*notify == "") != (*notifyURL == "") {
+		return nil, fmt.Errorf("--notify and --notify-url must be used together (%s)", settingSource("notify", configPath, fromFile))
+	}
+	switch *notify {
+	case "":
+	case "slack":
+		config.SlackWebhook = *notifyURL
+	case "teams":
+		config.TeamsWebhook = *notifyURL
+	default:
+		return nil, fmt.Errorf("invalid --notify %q: must be slack or teams (%s)", *notify, settingSource("notify", configPath, fromFile))
+	}
+
+	// timeoutValue already parsed seconds-or-duration into a time.Duration.
+	config.Timeout = timeout.d
+	config.StallTimeout = stallTimeout.d
+	config.ConnectTimeout = connectTimeout.d
+	config.RequestTimeout = requestTimeout.d
+	config.Deadline = deadline.d
+	config.RetryBaseDelay = retryBaseDelay.d
+	config.RetryMaxDelay = retryMaxDelay.d
+	config.RetryMaxElapsed = retryMaxElapsed.d
+	config.RetryOn = retryOn.codes
+	if config.RetryMultiplier < 1 {
+		return nil, fmt.Errorf("invalid --retry-multiplier %v: must be at least 1 (%s)", config.RetryMultiplier, settingSource("retry-multiplier", configPath, fromFile))
+	}
+	config.Interval = interval.d
+	if config.Cron != "" {
+		if flagGiven(flag.CommandLine, "interval") || fromFile["interval"] {
+			return nil, fmt.Errorf("--cron and --interval are mutually exclusive (%s)", settingSource("cron", configPath, fromFile))
+		}
+		if _, err := parseCron(config.Cron); err != nil {
+			return nil, fmt.Errorf("%v (%s)", err, settingSource("cron", configPath, fromFile))
+		}
+		config.Daemon = true
+	}
+	if config.Daemon && config.Interval <= 0 {
+		return nil, fmt.Errorf("invalid --interval %v: must be positive (%s)", config.Interval, settingSource("interval", configPath, fromFile))
+	}
+	config.LockTimeout = lockTimeout.d
+	config.MaxRate = maxRate.n
+	config.MaxConcurrent, config.ConcurrencyAuto = concurrent.n, concurrent.auto
+	if config.ChunksPerFile < 1 {
+		return nil, fmt.Errorf("invalid --chunks-per-file %d: must be at least 1 (%s)", config.ChunksPerFile, settingSource("chunks-per-file", configPath, fromFile))
+	}
+	config.SpaceEstimate = spaceEstimate.n
+	config.Since = since.t
+	config.SpaceMargin = spaceMargin.percent
+	config.SpaceMarginBytes = spaceMargin.bytes
+	if config.BackupCount < 1 {
+		return nil, fmt.Errorf("invalid --backup-count %d: must be at least 1 (%s)", config.BackupCount, settingSource("backup-count", configPath, fromFile))
+	}
+	if config.BackupCount > 1 {
+		config.Backup = true
+	}
+	if config.Keep < 0 {
+		return nil, fmt.Errorf("invalid --keep %d: must not be negative (%s)", config.Keep, settingSource("keep", configPath, fromFile))
+	}
+	if config.OnlyIfStale && config.MaxAge <= 0 {
+		return nil, fmt.Errorf("--only-if-stale requires --max-age (%s)", settingSource("only-if-stale", configPath, fromFile))
+	}
+	if config.KeepVersions < 0 {
+		return nil, fmt.Errorf("invalid --keep-versions %d: must not be negative (%s)", config.KeepVersions, settingSource("keep-versions", configPath, fromFile))
+	}
+	if config.Keep > 0 && !config.Dated && !config.StableNames {
+		return nil, fmt.Errorf("--keep requires --dated or --stable-names (%s)", settingSource("keep", configPath, fromFile))
+	}
+	if config.Dated && config.StableNames {
+		return nil, fmt.Errorf("--dated and --stable-names cannot be combined: --dated adds its own date to every name (%s)", settingSource("stable-names", configPath, fromFile))
 	}
-
-	// Handle show examples flag
-	if *showExamples {
-		showExamplesCmd()
-		os.Exit(0)
+	if config.Dated && config.Backup {
+		return nil, fmt.Errorf("--dated keeps older versions itself and cannot be combined with --keep-backup or --backup-count (%s)", settingSource("dated", configPath, fromFile))
 	}
 
-	// Handle check names flag
-	if *checkNames {
-		// Need API key for name checking
-		if config.APIKey == "" {
-			config.APIKey = os.Getenv("GEOIP_API_KEY")
-		}
-		if config.APIKey == "" {
-			return nil, fmt.Errorf("API key required for name checking. Use --api-key or set GEOIP_API_KEY")
+
+	// Validate configuration; a health check reads local files only and
+	// needs no credentials.
+	if !config.HealthCheck {
+		switch config.AuthMode {
+		case geoip.AuthAPIKey:
+			if config.APIKey == "" {
+				return nil, fmt.Errorf("API key not provided. Use --api-key, api_key in the config file, or set GEOIP_API_KEY")
+			}
+
+			// Validate API key format, wherever it came from
+			if !isValidAPIKey(config.APIKey) {
+				return nil, fmt.Errorf("invalid API key format (%s)", settingSource(apiKeySource, configPath, fromFile))
+			}
+		case geoip.AuthBearer:
+			if config.Token == "" && (config.TokenURL == "" || config.OAuthClientID == "" || config.OAuthClientSecret == "") {
+				return nil, fmt.Errorf("--auth-mode bearer needs --token, --token-file, or --token-url with --client-id and --client-secret (%s)", settingSource("auth-mode", configPath, fromFile))
+			}
+		default:
+			return nil, fmt.Errorf("invalid --auth-mode %q: must be api-key or bearer (%s)", config.AuthMode, settingSource("auth-mode", configPath, fromFile))
 		}
-		checkDatabaseNamesCmd(config, strings.Split(*databases, ","))
-		os.Exit(0)
-	}
-	
-	// Handle validate only flag (file validation)
-	if *validateOnly {
-		validateDatabaseFilesCmd(config)
-		os.Exit(0)
 	}
 
-	// Parse databases
-	if *databases != "all" {
-		config.Databases = strings.Split(*databases, ",")
-		for i := range config.Databases {
-			config.Databases[i] = strings.TrimSpace(config.Databases[i])
+	if config.ManifestAlgo != "sha256" && config.ManifestAlgo != "sha512" {
+		return nil, fmt.Errorf("invalid --manifest-algo %q: must be sha256 or sha512 (%s)", config.ManifestAlgo, settingSource("manifest-algo", configPath, fromFile))
+	}
+	if *noCompression {
+		if flagGiven(flag.CommandLine, "compression") && config.Compression != geoip.CompressionOff {
+			return nil, fmt.Errorf("--no-compression conflicts with --compression %s (%s)", config.Compression, settingSource("no-compression", configPath, fromFile))
 		}
-	} else {
-		config.Databases = []string{"all"}
+		config.Compression = geoip.CompressionOff
 	}
-
-	// timeoutValue already parsed seconds-or-duration into a time.Duration.
-	config.Timeout = timeout.d
-
-	// Clean and normalize the API endpoint
-	config.APIEndpoint = strings.TrimRight(config.APIEndpoint, "/ \t\n\r")
-	
-	// Auto-append /auth if it's the base geoipdb.net domain
-	if config.APIEndpoint == "https://geoipdb.net" || config.APIEndpoint == "http://geoipdb.net" {
-		config.APIEndpoint = config.APIEndpoint + "/auth"
-		log.Printf("Info: Appended /auth to endpoint: %s\n", config.APIEndpoint)
+	switch config.Compression {
+	case geoip.CompressionAuto, geoip.CompressionOff, geoip.CompressionGzip:
+	default:
+		return nil, fmt.Errorf("invalid --compression %q: must be auto, off or gzip (%s)", config.Compression, settingSource("compression", configPath, fromFile))
 	}
-
-	// Validate configuration
-	if config.APIKey == "" {
-		return nil, fmt.Errorf("API key not provided. Use --api-key or set GEOIP_API_KEY")
+	if config.TLSMinVersion != "1.2" && config.TLSMinVersion != "1.3" {
+		return nil, fmt.Errorf("invalid --tls-min-version %q: must be 1.2 or 1.3 (%s)", config.TLSMinVersion, settingSource("tls-min-version", configPath, fromFile))
 	}
-
-	// Validate API key format
-	if !isValidAPIKey(config.APIKey) {
-		return nil, fmt.Errorf("invalid API key format")
+	if config.S3Endpoint != "" && !strings.HasPrefix(config.S3Endpoint, "http://") && !strings.HasPrefix(config.S3Endpoint, "https://") {
+		return nil, fmt.Errorf("invalid --s3-endpoint %q: must be an http:// or https:// URL (%s)", config.S3Endpoint, settingSource("s3-endpoint", configPath, fromFile))
+	}
+	if config.CAOnly && config.CACert == "" {
+		return nil, fmt.Errorf("--ca-only requires --ca-cert (%s)", settingSource("ca-only", configPath, fromFile))
+	}
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		return nil, fmt.Errorf("--client-cert and --client-key must be given together (%s)", settingSource("client-cert", configPath, fromFile))
+	}
+	if config.InsecureSkipVerify {
+		log.Println("WARNING: --insecure(-skip-verify) disables TLS certificate verification; API keys and databases can be intercepted. Use only for testing.")
 	}
 
-	if config.APIEndpoint == defaultEndpoint {
-		log.Println("Warning: Using placeholder API endpoint. Please update with your actual API Gateway URL.")
+	for _, endpoint := range config.APIEndpoints {
+		if endpoint == defaultEndpoint && !config.HealthCheck {
+			log.Println("Warning: Using placeholder API endpoint. Please update with your actual API Gateway URL.")
+		}
 	}
 
 	return config, nil
@@ -821,6 +808,79 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// resolveSecret returns the API key or token (what) to use: read from
+// stdin when value is "-", else read from file unless the value was given
+// explicitly, else value itself. Secrets read are trimmed of surrounding
+// whitespace.
+func resolveSecret(what, value, file string, given bool, stdin io.Reader) (string, error) {
+	if value == "-" {
+		data, err := io.ReadAll(io.LimitReader(stdin, 1<<16))
+		if err != nil {
+			return "", fmt.Errorf("failed to read the %s from stdin: %w", what, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if file != "" && !given {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the %s file: %w", what, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return value, nil
+}
+
+// readDatabasesFile reads a --databases-file: one database name or alias
+// per line, ignoring blank lines and everything after a '#'.
+func readDatabasesFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the databases file: %w", err)
+	}
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no database names in %s", path)
+	}
+	return names, nil
+}
+
+// mergeDatabases combines the comma-separated --databases value with the
+// names from --databases-file, dropping duplicates. The "all" default only
+// applies when neither was given; an explicit "all" selects everything.
+func mergeDatabases(inline string, inlineGiven bool, fromFile []string) []string {
+	var names []string
+	if inlineGiven || len(fromFile) == 0 {
+		for _, name := range strings.Split(inline, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	names = append(names, fromFile...)
+
+	seen := make(map[string]bool, len(names))
+	merged := names[:0]
+	for _, name := range names {
+		if name == "all" {
+			return []string{"all"}
+		}
+		if !seen[name] {
+			seen[name] = true
+			merged = append(merged, name)
+		}
+	}
+	if len(merged) == 0 {
+		return []string{"all"}
+	}
+	return merged
+}
+
 func isValidAPIKey(key string) bool {
 	// Allow shorter keys for testing (minimum 8 characters)
 	if len(key) < 8 || len(key) > 64 {
@@ -834,13 +894,6 @@ func isValidAPIKey(key string) bool {
 	return true
 }
 
-func minDuration(a, b time.Duration) time.Duration {
-	if a < b {
-		return a
-	}
-	return b
-}
-
 // DatabaseInfo represents the /databases endpoint response
 type DatabaseInfo struct {
 	Total     int `json:"total"`
@@ -867,19 +920,65 @@ type DatabaseInfo struct {
 	} `json:"examples"`
 }
 
-// fetchDatabasesInfo fetches database information from the /databases endpoint
-func fetchDatabasesInfo(endpoint string) (*DatabaseInfo, error) {
+// fetchDatabasesInfo fetches database information from the /databases
+// endpoint next to each /auth endpoint in turn, until one answers.
+func fetchDatabasesInfo(config *geoip.Config, logger geoip.Logger, endpoints []string) (*DatabaseInfo, error) {
+	var lastErr error
+	for i, endpoint := range endpoints {
+		dbInfo, err := fetchDatabasesInfoFrom(config, logger, endpoint)
+		if err == nil {
+			if i > 0 {
+				log.Printf("Info: database list served by fallback endpoint %s", endpoint)
+			}
+			return dbInfo, nil
+		}
+		// Mirrors share the key: one that rejects it speaks for all.
+		if geoip.IsRejected(err) {
+			return nil, err
+		}
+		if i+1 < len(endpoints) {
+			log.Printf("Info: database discovery at %s failed: %v; trying %s", endpoint, err, endpoints[i+1])
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// fetchDatabasesInfoFrom asks one endpoint for its database list, with the
+// User-Agent of the downloads and the configured credentials when there
+// are any (the default server lists databases without them), retrying
+// like every other API request.
+func fetchDatabasesInfoFrom(config *geoip.Config, logger geoip.Logger, endpoint string) (*DatabaseInfo, error) {
 	// Convert /auth endpoint to /databases endpoint
 	databasesEndpoint := strings.Replace(endpoint, "/auth", "/databases", 1)
 	
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	req, err := http.NewRequest("GET", databasesEndpoint, nil)
+	if err != nil {
+		return nil, err
 	}
+	req.Header.Set("User-Agent", config.UserAgent)
 	
-	resp, err := client.Get(databasesEndpoint)
+	// The same proxy and TLS settings as an update, with a short overall
+	// limit for an interactive command.
+	client, err := geoip.NewConfiguredClient(config)
 	if err != nil {
 		return nil, err
 	}
+	client.Timeout = 10 * time.Second
+	if config.APIKey != "" || config.AuthMode == geoip.AuthBearer {
+		auth, err := geoip.NewAuthenticator(config, client)
+		if err == nil {
+			err = auth.Authorize(req.Context(), req)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	
+	resp, err := geoip.NewHTTPClient(client, config.MaxRetries, logger).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("database discovery not available: %w", err)
+	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK {
@@ -895,11 +994,8 @@ func fetchDatabasesInfo(endpoint string) (*DatabaseInfo, error) {
 }
 
 // listDatabasesCmd lists all available databases and aliases
-func listDatabasesCmd() {
-	endpoint := getEnvOrDefault("GEOIP_API_ENDPOINT", defaultEndpoint)
-	endpoint = strings.TrimRight(endpoint, "/ \t\n\r")
-	
-	dbInfo, err := fetchDatabasesInfo(endpoint)
+func listDatabasesCmd(config *geoip.Config, logger geoip.Logger) {
+	dbInfo, err := fetchDatabasesInfo(config, logger, config.APIEndpoints)
 	if err != nil {
 		fmt.Println("Database discovery not available.")
 		fmt.Println("Using legacy database list:")
@@ -947,11 +1043,8 @@ func listDatabasesCmd() {
 }
 
 // showExamplesCmd shows usage examples for database selection
-func showExamplesCmd() {
-	endpoint := getEnvOrDefault("GEOIP_API_ENDPOINT", defaultEndpoint)
-	endpoint = strings.TrimRight(endpoint, "/ \t\n\r")
-	
-	dbInfo, err := fetchDatabasesInfo(endpoint)
+func showExamplesCmd(config *geoip.Config, logger geoip.Logger) {
+	dbInfo, err := fetchDatabasesInfo(config, logger, config.APIEndpoints)
 	if err != nil {
 		fmt.Println("Database Selection Examples (Legacy Mode):")
 		fmt.Println("==========================================")
@@ -1004,7 +1097,7 @@ func showExamplesCmd() {
 }
 
 // checkDatabaseNamesCmd validates database names with API without downloading
-func checkDatabaseNamesCmd(config *Config, databases []string) {
+func checkDatabaseNamesCmd(config *geoip.Config, databases []string) {
 	if len(databases) == 0 || (len(databases) == 1 && databases[0] == "all") {
 		fmt.Println("✓ Database selection 'all' is valid")
 		return
@@ -1026,19 +1119,32 @@ func checkDatabaseNamesCmd(config *Config, databases []string) {
 		os.Exit(1)
 	}
 	
-	// Create request
-	req, err := http.NewRequest("POST", config.APIEndpoint, bytes.NewReader(jsonBody))
+	// Create request; Ctrl-C abandons it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	req, err := http.NewRequestWithContext(ctx, "POST", config.APIEndpoints[0], bytes.NewReader(jsonBody))
 	if err != nil {
 		fmt.Printf("✗ Validation failed: %v\n", err)
 		os.Exit(1)
 	}
 	
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", config.APIKey)
+	req.Header.Set("User-Agent", config.UserAgent)
 	
 	// Make request
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	client, err := geoip.NewConfiguredClient(config)
+	if err != nil {
+		fmt.Printf("✗ Validation failed: %v\n", err)
+		os.Exit(1)
+	}
+	client.Timeout = 10 * time.Second
+	auth, err := geoip.NewAuthenticator(config, client)
+	if err == nil {
+		err = auth.Authorize(ctx, req)
+	}
+	if err != nil {
+		fmt.Printf("✗ Validation failed: %v\n", err)
+		os.Exit(1)
 	}
 	
 	resp, err := client.Do(req)
@@ -1082,7 +1188,7 @@ func checkDatabaseNamesCmd(config *Config, databases []string) {
 }
 
 // validateDatabaseFilesCmd validates existing database files
-func validateDatabaseFilesCmd(config *Config) {
+func validateDatabaseFilesCmd(config *geoip.Config) {
 	fmt.Println("Validating database files...")
 	
 	// Check if directory exists
@@ -1091,77 +1197,114 @@ func validateDatabaseFilesCmd(config *Config) {
 		os.Exit(1)
 	}
 	
-	var totalFiles, validFiles, invalidFiles int
+	var totalFiles, validFiles, invalidFiles, staleFiles int
 	var hasErrors bool
+
+	// With --max-age, databases built longer ago than the limit (per their
+	// metadata or BIN header, else their mtime) count as stale and fail
+	// validation.
+	checkAge := func(built time.Time) {
+		if config.MaxAge > 0 && time.Since(built) > config.MaxAge {
+			fmt.Printf("      ⚠️  Stale: older than %s\n", formatAge(config.MaxAge))
+			staleFiles++
+		}
+	}
+	
+	mmdbFiles, binFiles, err := findDatabaseFiles(config.TargetDir)
+	if err != nil {
+		fmt.Printf("✗ Cannot read directory: %v\n", err)
+		os.Exit(1)
+	}
 	
 	// Validate MMDB files
-	mmdbFiles, err := filepath.Glob(filepath.Join(config.TargetDir, "*.mmdb"))
-	if err == nil {
-		for _, file := range mmdbFiles {
-			totalFiles++
-			basename := filepath.Base(file)
-			
-			// Check file size
-			info, err := os.Stat(file)
-			if err != nil {
-				fmt.Printf("  ❌ %s - Cannot read file: %v\n", basename, err)
-				invalidFiles++
-				hasErrors = true
-				continue
-			}
-			
-			if info.Size() < 1000 {
-				fmt.Printf("  ❌ %s - File too small (%d bytes)\n", basename, info.Size())
-				invalidFiles++
-				hasErrors = true
-				continue
-			}
-			
-			// Validate MMDB format
-			if err := validateMMDBFile(file); err != nil {
-				fmt.Printf("  ❌ %s - Invalid MMDB format: %v\n", basename, err)
+	for _, file := range mmdbFiles {
+		totalFiles++
+		basename := filepath.Base(file)
+		
+		// Check file size
+		info, err := os.Stat(file)
+		if err != nil {
+			fmt.Printf("  ❌ %s - Cannot read file: %v\n", basename, err)
+			invalidFiles++
+			hasErrors = true
+			continue
+		}
+		
+		if info.Size() < 1000 {
+			fmt.Printf("  ❌ %s - File too small (%d bytes)\n", basename, info.Size())
+			invalidFiles++
+			hasErrors = true
+			continue
+		}
+		
+		// Validate MMDB format. Deep validation must open the database;
+		// otherwise report its metadata when readable and fall back to
+		// the marker check when not.
+		sizeMB := info.Size() / 1024 / 1024
+		if config.DeepValidate {
+			if mmdb, err := geoip.InspectMMDB(file); err != nil {
+				fmt.Printf("  ❌ %s - Invalid MMDB database: %v\n", basename, err)
 				invalidFiles++
 				hasErrors = true
 			} else {
-				sizeMB := info.Size() / 1024 / 1024
-				fmt.Printf("  ✅ %s (%dMB) - Valid MMDB format\n", basename, sizeMB)
+				fmt.Printf("  ✅ %s (%dMB) - Valid MMDB database (search tree OK)\n", basename, sizeMB)
+				printMMDBMetadata(mmdb)
+				checkAge(mmdb.BuildEpoch)
 				validFiles++
 			}
+		} else if mmdb, err := geoip.ReadMMDBMetadata(file); err == nil {
+			fmt.Printf("  ✅ %s (%dMB) - Valid MMDB format\n", basename, sizeMB)
+			printMMDBMetadata(mmdb)
+			checkAge(mmdb.BuildEpoch)
+			validFiles++
+		} else if err := geoip.ValidateMMDB(file); err != nil {
+			fmt.Printf("  ❌ %s - Invalid MMDB format: %v\n", basename, err)
+			invalidFiles++
+			hasErrors = true
+		} else {
+			fmt.Printf("  ✅ %s (%dMB) - Valid MMDB format (metadata unreadable)\n", basename, sizeMB)
+			printFileAge(info.ModTime())
+			checkAge(info.ModTime())
+			validFiles++
 		}
 	}
 	
 	// Validate BIN files
-	binFiles, err := filepath.Glob(filepath.Join(config.TargetDir, "*.BIN"))
-	if err == nil {
-		for _, file := range binFiles {
-			totalFiles++
-			basename := filepath.Base(file)
-			
-			// Check file size
-			info, err := os.Stat(file)
-			if err != nil {
-				fmt.Printf("  ❌ %s - Cannot read file: %v\n", basename, err)
-				invalidFiles++
-				hasErrors = true
-				continue
-			}
-			
-			if info.Size() < 1000 {
-				fmt.Printf("  ❌ %s - File too small (%d bytes)\n", basename, info.Size())
-				invalidFiles++
-				hasErrors = true
-				continue
-			}
-			
-			// Basic BIN validation - check if it's binary data
-			if err := validateBINFile(file); err != nil {
-				fmt.Printf("  ⚠️  %s - Could not verify BIN format: %v\n", basename, err)
-				// Don't count as invalid since BIN validation is harder
-			} else {
-				sizeMB := info.Size() / 1024 / 1024
-				fmt.Printf("  ✅ %s (%dMB) - Valid BIN format\n", basename, sizeMB)
-				validFiles++
-			}
+	for _, file := range binFiles {
+		totalFiles++
+		basename := filepath.Base(file)
+		
+		// Check file size
+		info, err := os.Stat(file)
+		if err != nil {
+			fmt.Printf("  ❌ %s - Cannot read file: %v\n", basename, err)
+			invalidFiles++
+			hasErrors = true
+			continue
+		}
+		
+		if info.Size() < 1000 {
+			fmt.Printf("  ❌ %s - File too small (%d bytes)\n", basename, info.Size())
+			invalidFiles++
+			hasErrors = true
+			continue
+		}
+		
+		// Parse the IP2Location header; a file whose header does not
+		// describe the rows it holds is invalid.
+		bin, err := geoip.InspectBIN(file)
+		if err != nil {
+			fmt.Printf("  ❌ %s - Invalid BIN format: %v\n", basename, err)
+			invalidFiles++
+			hasErrors = true
+		} else {
+			sizeMB := info.Size() / 1024 / 1024
+			fmt.Printf("  ✅ %s (%dMB) - Valid BIN format\n", basename, sizeMB)
+			age := int(time.Since(bin.Date).Hours() / 24)
+			fmt.Printf("      Type: %s, %d columns, %d IPv4 / %d IPv6 ranges\n", bin.Name(), bin.Columns, bin.IPv4Count, bin.IPv6Count)
+			fmt.Printf("      Built: %s (%d days ago)\n", bin.Date.Format("2006-01-02"), age)
+			checkAge(bin.Date)
+			validFiles++
 		}
 	}
 	
@@ -1170,6 +1313,9 @@ func validateDatabaseFilesCmd(config *Config) {
 	fmt.Printf("  Total files: %d\n", totalFiles)
 	fmt.Printf("  Valid files: %d\n", validFiles)
 	fmt.Printf("  Invalid files: %d\n", invalidFiles)
+	if config.MaxAge > 0 {
+		fmt.Printf("  Stale files: %d (max age %s)\n", staleFiles, formatAge(config.MaxAge))
+	}
 	
 	if totalFiles == 0 {
 		fmt.Println("\n✗ No database files found!")
@@ -1179,123 +1325,460 @@ func validateDatabaseFilesCmd(config *Config) {
 	if hasErrors {
 		fmt.Println("\n✗ Validation FAILED - some databases are invalid!")
 		os.Exit(1)
+	} else if staleFiles > 0 {
+		fmt.Printf("\n✗ Validation FAILED - %d databases are older than %s!\n", staleFiles, formatAge(config.MaxAge))
+		os.Exit(1)
 	} else {
 		fmt.Println("\n✓ Validation PASSED - all databases are valid!")
 		os.Exit(0)
 	}
 }
 
-// validateMMDBFile validates a single MMDB file
-func validateMMDBFile(path string) error {
-	// Reuse existing validateMMDB logic
-	file, err := os.Open(path)
+// findDatabaseFiles returns the MMDB and BIN files in dir, matching the
+// extension in any case: ".MMDB" and ".bin" are as valid as the usual
+// spellings, and a case-sensitive glob would silently skip them.
+func findDatabaseFiles(dir string) (mmdbFiles, binFiles []string, err error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	defer file.Close()
-	
-	// Get file size
-	stat, err := file.Stat()
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		switch ext := filepath.Ext(e.Name()); {
+		case strings.EqualFold(ext, ".mmdb"):
+			mmdbFiles = append(mmdbFiles, filepath.Join(dir, e.Name()))
+		case strings.EqualFold(ext, ".bin"):
+			binFiles = append(binFiles, filepath.Join(dir, e.Name()))
+		}
+	}
+	return mmdbFiles, binFiles, nil
+}
+
+// printMMDBMetadata prints the metadata lines under a validated MMDB file,
+// including the build age so stale databases stand out.
+func printMMDBMetadata(info *geoip.MMDBInfo) {
+	age := int(time.Since(info.BuildEpoch).Hours() / 24)
+	fmt.Printf("      Type: %s, IPv%d, %d nodes, %d-bit records\n", info.DatabaseType, info.IPVersion, info.NodeCount, info.RecordSize)
+	fmt.Printf("      Built: %s (%d days ago)\n", info.BuildEpoch.Format("2006-01-02 15:04 MST"), age)
+}
+
+// printFileAge prints the modification time of an MMDB file whose build
+// metadata could not be read.
+func printFileAge(modified time.Time) {
+	age := int(time.Since(modified).Hours() / 24)
+	fmt.Printf("      Modified: %s (%d days ago)\n", modified.Format("2006-01-02 15:04 MST"), age)
+}
+
+// formatAge renders a --max-age limit, in days when it is a whole number
+// of them.
+func formatAge(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+func main() {
+	// Parse configuration
+	config, err := parseFlags()
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
-	size := stat.Size()
-	
-	// MMDB files have metadata at the end with marker \xab\xcd\xef followed by MaxMind.com
-	// Read the last 100KB to find the metadata section
-	readSize := int64(100000)
-	if size < readSize {
-		readSize = size
+
+	os.Exit(run(config))
+}
+
+// dryRunCmd authenticates and prints each database that would be downloaded
+// with its URL host and announced size, as text or JSON. The full URL is not
+// printed since it may be presigned.
+func dryRunCmd(config *geoip.Config, logger *geoip.ConsoleLogger) int {
+	updater, err := geoip.New(config, geoip.Options{Logger: logger})
+	if err != nil {
+		logger.Error("Failed to initialize updater: %v", err)
+		return 1
 	}
-	
-	// Seek to the position to start reading
-	_, err = file.Seek(size-readSize, 0)
+	defer updater.Close()
+
+	plan, err := updater.Plan(context.Background())
 	if err != nil {
-		return err
+		logger.Error("Dry run failed: %v", err)
+		return 1
 	}
-	
-	// Read the last portion of the file
-	buf := make([]byte, readSize)
-	n, err := file.Read(buf)
-	if err != nil && err != io.EOF {
-		return err
+
+	if config.Output == "json" {
+		type entry struct {
+			Database string `json:"database"`
+			Host     string `json:"host"`
+			URL      string `json:"url"`
+			Size     *int64 `json:"size"` // null when the server did not announce it
+		}
+		entries := make([]entry, 0, len(plan))
+		for _, p := range plan {
+			e := entry{Database: p.Database, Host: p.Host, URL: dryRunURL(p, config.ShowURLs)}
+			if p.Size >= 0 {
+				size := p.Size
+				e.Size = &size
+			}
+			entries = append(entries, e)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			logger.Error("Failed to write JSON: %v", err)
+			return 1
+		}
+		return 0
 	}
-	
-	// Look for the MMDB metadata marker
-	marker := []byte("\xab\xcd\xefMaxMind.com")
-	if !bytes.Contains(buf[:n], marker) {
-		return fmt.Errorf("missing MaxMind metadata marker")
+
+	fmt.Printf("Dry run: %d databases would be downloaded to %s\n", len(plan), config.TargetDir)
+	var total int64
+	unknown := 0
+	for _, p := range plan {
+		size := "unknown size"
+		if p.Size >= 0 {
+			size = fmt.Sprintf("%d bytes", p.Size)
+			total += p.Size
+		} else {
+			unknown++
+		}
+		fmt.Printf("  • %s (%s, %s)\n", p.Database, p.Host, size)
+		fmt.Printf("    %s\n", dryRunURL(p, config.ShowURLs))
 	}
-	
-	return nil
+	if unknown > 0 {
+		fmt.Printf("Total known size: %d bytes (%d databases of unknown size)\n", total, unknown)
+	} else {
+		fmt.Printf("Total size: %d bytes\n", total)
+	}
+	return 0
 }
 
-// validateBINFile validates a BIN format file (IP2Location)
-func validateBINFile(path string) error {
-	file, err := os.Open(path)
+// verifyCmd reports, for every database the server offers, whether the
+// local file is current, stale or missing. It exits 1 when any database is
+// stale or missing, so it can gate a fleet-wide drift check.
+func verifyCmd(config *geoip.Config, logger *geoip.ConsoleLogger) int {
+	updater, err := geoip.New(config, geoip.Options{Logger: logger})
 	if err != nil {
-		return err
+		logger.Error("Failed to initialize updater: %v", err)
+		return 1
 	}
-	defer file.Close()
-	
-	// Read first 10KB to check for IP2Location markers
-	buf := make([]byte, 10000)
-	n, err := file.Read(buf)
-	if err != nil && err != io.EOF {
-		return err
+	defer updater.Close()
+
+	results, err := updater.Verify(context.Background())
+	if err != nil {
+		logger.Error("Verification failed: %v", err)
+		return 1
 	}
-	
-	// Check if file contains binary data (not text)
-	for i := 0; i < n && i < 100; i++ {
-		if buf[i] < 0x20 && buf[i] != 0x09 && buf[i] != 0x0A && buf[i] != 0x0D {
-			// Found non-printable character, likely binary
-			return nil
+
+	drift := 0
+	for _, r := range results {
+		if r.Status == geoip.VerifyStale || r.Status == geoip.VerifyMissing {
+			drift++
 		}
 	}
-	
-	// If we get here, file might be text (error response)
-	return fmt.Errorf("file appears to be text, not binary")
+	exitCode := 0
+	if drift > 0 {
+		exitCode = 1
+	}
+
+	if config.Output == "json" {
+		type entry struct {
+			Database   string `json:"database"`
+			Path       string `json:"path"`
+			Status     string `json:"status"`
+			Method     string `json:"method"`
+			LocalSize  *int64 `json:"local_size"`  // null when missing
+			RemoteSize *int64 `json:"remote_size"` // null when the server did not announce it
+		}
+		sizeOrNil := func(n int64) *int64 {
+			if n < 0 {
+				return nil
+			}
+			return &n
+		}
+		entries := make([]entry, 0, len(results))
+		for _, r := range results {
+			entries = append(entries, entry{Database: r.Database, Path: r.Path, Status: r.Status, Method: r.Method,
+				LocalSize: sizeOrNil(r.LocalSize), RemoteSize: sizeOrNil(r.RemoteSize)})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			logger.Error("Failed to write JSON: %v", err)
+			return 1
+		}
+		return exitCode
+	}
+
+	fmt.Printf("Verifying %d databases in %s against the server\n", len(results), config.TargetDir)
+	for _, r := range results {
+		fmt.Printf("  %-8s %s (%s)\n", r.Status, r.Database, r.Method)
+	}
+	fmt.Printf("%d of %d databases stale or missing\n", drift, len(results))
+	return exitCode
 }
 
-func main() {
-	// Parse configuration
-	config, err := parseFlags()
+// healthCheckCmd prints a one-line status of the local databases for a
+// container HEALTHCHECK or Kubernetes probe, without network access, and
+// returns 0 when they are healthy and 1 otherwise.
+func healthCheckCmd(config *geoip.Config, logger *geoip.ConsoleLogger) int {
+	// The check never authenticates, so bearer settings need not be
+	// complete.
+	local := *config
+	local.AuthMode = geoip.AuthAPIKey
+	updater, err := geoip.New(&local, geoip.Options{Logger: logger})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fmt.Printf("UNHEALTHY: %v\n", err)
+		return 1
 	}
+	defer updater.Close()
+
+	n, err := updater.Health()
+	if err != nil {
+		fmt.Printf("UNHEALTHY: %v\n", err)
+		return 1
+	}
+	fmt.Printf("OK: %d database(s) in %s\n", n, config.TargetDir)
+	return 0
+}
+
+// dryRunURL returns the download URL to print: redacted unless showURLs, as
+// presigned URLs carry credentials in their query string.
+func dryRunURL(p geoip.PlannedDownload, showURLs bool) string {
+	if showURLs {
+		return p.URL
+	}
+	return p.RedactedURL()
+}
+
+// exitInterrupted is the conventional exit code for a run stopped by SIGINT.
+const exitInterrupted = 130
 
+// shutdownGracePeriod bounds how long an interrupted run may take to abort
+// its downloads before the process exits anyway.
+const shutdownGracePeriod = 10 * time.Second
+
+// run performs the update (or, with --daemon, keeps performing it) and
+// returns the process exit code. It is separate from main so deferred
+// cleanup (temp files, lock, log file) always runs before os.Exit.
+func run(config *geoip.Config) int {
 	// Setup logger
-	logger, err := newLogger(config)
+	// With --output json, stdout carries only the JSON document, so the
+	// log goes (uncolored) to stderr.
+	var logOut io.Writer
+	if config.Output == "json" {
+		logOut = os.Stderr
+	}
+	logger, err := geoip.NewLogger(config, logOut)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to setup logger: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 	defer logger.Close()
 
 	logger.Info("GeoIP Update Script starting (v%s)", version)
 
+	// A dry run writes nothing, so it needs neither the lock nor TargetDir.
+	if config.DryRun {
+		return dryRunCmd(config, logger)
+	}
+	if config.VerifyOnly {
+		return verifyCmd(config, logger)
+	}
+	if config.HealthCheck {
+		return healthCheckCmd(config, logger)
+	}
+
+	// Cancel the run on SIGINT/SIGTERM; Update returns once in-flight
+	// downloads have aborted, and updateOnce's defers then clean up. A
+	// second signal, or downloads that fail to stop within
+	// shutdownGracePeriod, force an immediate exit after removing temp
+	// files and the lock. In daemon mode SIGHUP starts a run immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	active := &activeRun{status: newStatusFile(config.StatusFile, logger)}
+	trigger := make(chan struct{}, 1)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	if config.Daemon {
+		signal.Notify(signals, syscall.SIGHUP)
+	}
+	defer signal.Stop(signals)
+	forceExit := func() {
+		active.cleanup()
+		logger.Close()
+		os.Exit(exitInterrupted)
+	}
+	go func() {
+		// Wait for a stop signal, passing SIGHUP on as a run trigger.
+		for stopping := false; !stopping; {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGHUP {
+					select {
+					case trigger <- struct{}{}:
+					default:
+					}
+					continue
+				}
+				logger.Warn("Received %v, cancelling downloads (send again to force exit)...", sig)
+				cancel()
+				stopping = true
+			case <-done:
+				return
+			}
+		}
+		deadline := time.After(shutdownGracePeriod)
+		for {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGHUP {
+					continue
+				}
+				logger.Error("Received %v again, exiting immediately", sig)
+				forceExit()
+			case <-deadline:
+				logger.Error("Downloads did not stop within %v, exiting", shutdownGracePeriod)
+				forceExit()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	if config.Daemon {
+		return daemonLoop(ctx, config, logger, active, trigger)
+	}
+	return updateOnce(ctx, config, logger, active)
+}
+
+// activeRun records the updater and lock of the update in progress so a
+// forced exit can clean them up, and the --status-file runs report to.
+type activeRun struct {
+	mu      sync.Mutex
+	updater *geoip.Updater
+	lock    *LockFile
+	status  *statusFile // nil without --status-file
+}
+
+func (a *activeRun) set(updater *geoip.Updater, lock *LockFile) {
+	a.mu.Lock()
+	a.updater, a.lock = updater, lock
+	a.mu.Unlock()
+}
+
+func (a *activeRun) cleanup() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.updater != nil {
+		a.updater.Close()
+	}
+	if a.lock != nil {
+		a.lock.Release()
+	}
+}
+
+// updateOnce takes the lock, runs one update and writes metrics, returning
+// the exit code for that run.
+func updateOnce(ctx context.Context, config *geoip.Config, logger *geoip.ConsoleLogger, active *activeRun) int {
+	started := time.Now()
+	active.status.phase(phaseStarting, 0)
+	writeSummary := func(results []geoip.DownloadResult, err error) {
+		active.status.finished(err)
+		if config.Output != "json" && config.WebhookURL == "" && config.SlackWebhook == "" && config.TeamsWebhook == "" {
+			return
+		}
+		summary := newRunSummary(config, started, time.Since(started), results, err, ctx.Err() != nil)
+		if config.Output == "json" {
+			if werr := writeRunSummary(os.Stdout, summary); werr != nil {
+				logger.Error("Failed to write JSON: %v", werr)
+			}
+		}
+		if config.WebhookURL != "" && shouldNotify(config.WebhookOn, summary) {
+			if werr := sendWebhook(config.WebhookURL, summary, config.MaxRetries, logger); werr != nil {
+				logger.Warn("Failed to send webhook: %v", werr)
+			} else {
+				logger.Info("Webhook notification sent")
+			}
+		}
+		if config.SlackWebhook != "" && shouldNotify(config.WebhookOn, summary) {
+			if werr := sendSlack(config.SlackWebhook, summary, config.MaxRetries, logger); werr != nil {
+				logger.Warn("Failed to send Slack notification: %v", werr)
+			} else {
+				logger.Info("Slack notification sent")
+			}
+		}
+		if config.TeamsWebhook != "" && shouldNotify(config.WebhookOn, summary) {
+			if werr := sendTeams(config.TeamsWebhook, summary, config.MaxRetries, logger); werr != nil {
+				logger.Warn("Failed to send Teams notification: %v", werr)
+			} else {
+				logger.Info("Teams notification sent")
+			}
+		}
+	}
+
 	// Acquire lock
 	lock := newLockFile(config.NoLock)
-	if err := lock.Acquire(); err != nil {
+	if err := lock.Acquire(config.LockTimeout); err != nil {
 		logger.Error("Failed to acquire lock: %v", err)
-		os.Exit(1)
+		writeSummary(nil, fmt.Errorf("failed to acquire lock: %w", err))
+		return 1
 	}
 	defer lock.Release()
 
 	// Create updater
-	updater, err := newGeoIPUpdater(config, logger)
+	opts := geoip.Options{Logger: logger, Progress: !config.Quiet}
+	if active.status != nil {
+		opts.OnPhase = active.status.phase
+	}
+	updater, err := geoip.New(config, opts)
 	if err != nil {
 		logger.Error("Failed to initialize updater: %v", err)
-		os.Exit(1)
+		writeSummary(nil, fmt.Errorf("failed to initialize updater: %w", err))
+		return 1
 	}
-	defer updater.cleanup()
+	defer updater.Close()
+	active.set(updater, lock)
+	defer active.set(nil, nil)
 
 	// Run update
-	if err := updater.updateDatabases(); err != nil {
+	updateStarted := time.Now()
+	runCtx, cancel := ctx, context.CancelFunc(func() {})
+	if config.Deadline > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, config.Deadline)
+	}
+	results, err := updater.Update(runCtx)
+	if err != nil && runCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		err = fmt.Errorf("update did not finish within the --deadline of %v: %w", config.Deadline, err)
+	}
+	cancel()
+	if (config.MetricsFile != "" || config.MetricsPushgateway != "") && ctx.Err() == nil {
+		m := runMetrics{results: results, failed: err != nil, duration: time.Since(updateStarted), finished: time.Now(), retries: updater.Retries()}
+		if config.MetricsFile != "" {
+			if werr := writeMetricsFile(config.MetricsFile, m); werr != nil {
+				logger.Warn("Failed to write metrics file: %v", werr)
+			}
+		}
+		if config.MetricsPushgateway != "" {
+			if werr := pushMetrics(config.MetricsPushgateway, m, config.MaxRetries, logger); werr != nil {
+				logger.Warn("Failed to push metrics: %v", werr)
+			}
+		}
+	}
+	writeSummary(results, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			logger.Error("Update interrupted")
+			return exitInterrupted
+		}
 		logger.Error("Update failed: %v", err)
-		os.Exit(1)
+		return 1
 	}
 
 	logger.Success("GeoIP update completed successfully")
-}
\ No newline at end of file
+	return 0
+}
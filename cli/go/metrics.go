@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+// runMetrics is what one run reports to --metrics-file and
+// --metrics-pushgateway.
+type runMetrics struct {
+	results  []geoip.DownloadResult
+	failed   bool
+	duration time.Duration
+	finished time.Time
+	retries  int64
+}
+
+// metricsJob is the Pushgateway job label; the instance is the hostname.
+const metricsJob = "geoip_updater"
+
+// metricsBuilder accumulates Prometheus text exposition format.
+type metricsBuilder struct {
+	strings.Builder
+}
+
+func (b *metricsBuilder) metric(name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// runDetails adds what both outputs report about the run itself and each
+// database: duration, retries, and per-database size, file modification
+// time, duration and outcome.
+func (b *metricsBuilder) runDetails(m runMetrics) {
+	b.metric("geoip_update_duration_seconds", "gauge", "Duration of the last update run in seconds.")
+	fmt.Fprintf(b, "geoip_update_duration_seconds %s\n", formatMetric(m.duration.Seconds()))
+	b.metric("geoip_update_retries", "gauge", "HTTP requests retried during the last update run.")
+	fmt.Fprintf(b, "geoip_update_retries %d\n", m.retries)
+
+	results := append([]geoip.DownloadResult(nil), m.results...)
+	sort.Slice(results, func(i, j int) bool { return results[i].Database < results[j].Database })
+	var sizes []geoip.DownloadResult
+	for _, r := range results {
+		if r.Error == nil && r.Size > 0 {
+			sizes = append(sizes, r)
+		}
+	}
+	if len(sizes) > 0 {
+		b.metric("geoip_update_database_size_bytes", "gauge", "Size of each database after the last run.")
+		for _, r := range sizes {
+			fmt.Fprintf(b, "geoip_update_database_size_bytes{database=%s} %d\n", strconv.Quote(r.Database), r.Size)
+		}
+	}
+	// The installed file's modification time does not move when the server
+	// answers 304, so time() minus it is how old the local copy really is.
+	var installed []geoip.DownloadResult
+	var modified []time.Time
+	for _, r := range results {
+		if r.Error != nil || r.Path == "" {
+			continue
+		}
+		if fi, err := os.Stat(r.Path); err == nil {
+			installed = append(installed, r)
+			modified = append(modified, fi.ModTime())
+		}
+	}
+	if len(installed) > 0 {
+		b.metric("geoip_update_database_modified_timestamp", "gauge", "Unix time each installed database file was last written.")
+		for i, r := range installed {
+			fmt.Fprintf(b, "geoip_update_database_modified_timestamp{database=%s} %d\n", strconv.Quote(r.Database), modified[i].Unix())
+		}
+	}
+	var attempted []geoip.DownloadResult
+	for _, r := range results {
+		if !r.Skipped {
+			attempted = append(attempted, r)
+		}
+	}
+	if len(attempted) > 0 {
+		b.metric("geoip_update_database_duration_seconds", "gauge", "Time spent on each database in the last run.")
+		for _, r := range attempted {
+			fmt.Fprintf(b, "geoip_update_database_duration_seconds{database=%s} %s\n", strconv.Quote(r.Database), formatMetric(r.Duration.Seconds()))
+		}
+		b.metric("geoip_update_database_success", "gauge", "Whether each database updated (or was current) in the last run.")
+		for _, r := range attempted {
+			fmt.Fprintf(b, "geoip_update_database_success{database=%s} %d\n", strconv.Quote(r.Database), boolMetric(r.Error == nil))
+		}
+	}
+}
+
+// writeMetricsFile writes Prometheus text-format metrics for the run to
+// path, for node_exporter's textfile collector. The last success time and
+// the failure counter are carried over from the previous file so they
+// survive failed runs. The file is replaced atomically so the collector
+// never reads a partial write.
+func writeMetricsFile(path string, m runMetrics) error {
+	prev := readMetricValues(path)
+	lastSuccess := prev["geoip_update_last_success_timestamp"]
+	failures := prev["geoip_update_failures_total"]
+	if m.failed {
+		failures++
+	} else {
+		lastSuccess = float64(m.finished.Unix())
+	}
+
+	var b metricsBuilder
+	b.metric("geoip_update_last_success_timestamp", "gauge", "Unix time of the last successful update run.")
+	fmt.Fprintf(&b, "geoip_update_last_success_timestamp %s\n", formatMetric(lastSuccess))
+	b.metric("geoip_update_failures_total", "counter", "Number of update runs that failed.")
+	fmt.Fprintf(&b, "geoip_update_failures_total %s\n", formatMetric(failures))
+	b.metric("geoip_update_failed", "gauge", "Whether the last update run failed.")
+	fmt.Fprintf(&b, "geoip_update_failed %d\n", boolMetric(m.failed))
+	b.runDetails(m)
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, ".geoip-metrics-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readMetricValues returns the unlabelled samples in an existing metrics
+// file; a missing or unreadable file yields an empty map.
+func readMetricValues(path string) map[string]float64 {
+	values := map[string]float64{}
+	f, err := os.Open(path)
+	if err != nil {
+		return values
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.HasPrefix(fields[0], "#") || strings.Contains(fields[0], "{") {
+			continue
+		}
+		if v, err := strconv.ParseFloat(fields[1], 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+	return values
+}
+
+// pushMetrics sends the run's metrics to the Prometheus Pushgateway at
+// gateway, grouped by job and instance (the hostname). It POSTs, which
+// replaces only the metrics sent, so a failed run leaves the last success
+// time of the previous successful push in place.
+func pushMetrics(gateway string, m runMetrics, maxRetries int, logger geoip.Logger) error {
+	var b metricsBuilder
+	b.metric("geoip_update_success", "gauge", "Whether the last update run succeeded.")
+	fmt.Fprintf(&b, "geoip_update_success %d\n", boolMetric(!m.failed))
+	b.metric("geoip_update_last_run_timestamp", "gauge", "Unix time the last update run finished.")
+	fmt.Fprintf(&b, "geoip_update_last_run_timestamp %d\n", m.finished.Unix())
+	if !m.failed {
+		b.metric("geoip_update_last_success_timestamp", "gauge", "Unix time of the last successful update run.")
+		fmt.Fprintf(&b, "geoip_update_last_success_timestamp %d\n", m.finished.Unix())
+	}
+	b.runDetails(m)
+
+	instance, err := os.Hostname()
+	if err != nil || instance == "" {
+		instance = "unknown"
+	}
+	url := strings.TrimSuffix(gateway, "/") + "/metrics/job/" + metricsJob + "/instance/" + neturl.PathEscape(instance)
+	return postBody(url, "text/plain; version=0.0.4", []byte(b.String()), maxRetries, logger)
+}
+
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func formatMetric(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
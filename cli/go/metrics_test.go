@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+// TestWriteMetricsFile verifies the textfile output and that the last
+// success time and failure counter carry over across runs.
+func TestWriteMetricsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geoip.prom")
+	success := time.Unix(1700000000, 0)
+	installed := filepath.Join(t.TempDir(), "GeoIP2-City.mmdb")
+	if err := os.WriteFile(installed, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(installed, success, time.Unix(1690000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := writeMetricsFile(path, runMetrics{
+		results: []geoip.DownloadResult{
+			{Database: "GeoIP2-City.mmdb", Size: 1234, Path: installed},
+			{Database: "GeoIP2-ISP.mmdb", Error: errors.New("boom")},
+		},
+		duration: 1500 * time.Millisecond,
+		finished: success,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	for _, want := range []string{
+		"geoip_update_last_success_timestamp 1700000000\n",
+		"geoip_update_duration_seconds 1.5\n",
+		"geoip_update_failures_total 0\n",
+		"geoip_update_failed 0\n",
+		`geoip_update_database_modified_timestamp{database="GeoIP2-City.mmdb"} 1690000000` + "\n",
+		`geoip_update_database_size_bytes{database="GeoIP2-City.mmdb"} 1234` + "\n",
+		"# TYPE geoip_update_failures_total counter\n",
+		`geoip_update_database_success{database="GeoIP2-ISP.mmdb"} 0` + "\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("missing %q in:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), `size_bytes{database="GeoIP2-ISP`) {
+		t.Errorf("failed database reported a size:\n%s", data)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := writeMetricsFile(path, runMetrics{failed: true, finished: success.Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	values := readMetricValues(path)
+	if values["geoip_update_failures_total"] != 2 || values["geoip_update_last_success_timestamp"] != 1700000000 || values["geoip_update_failed"] != 1 {
+		t.Errorf("after two failures: %v", values)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("temp files left behind: %v", entries)
+	}
+}
+
+// TestPushMetrics verifies the Pushgateway request: grouping path, format
+// and the last success time only on success.
+func TestPushMetrics(t *testing.T) {
+	var path, body, contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		path, body, contentType = r.URL.Path, string(data), r.Header.Get("Content-Type")
+	}))
+	defer srv.Close()
+
+	logger := &geoip.ConsoleLogger{}
+	m := runMetrics{
+		results:  []geoip.DownloadResult{{Database: "GeoIP2-City.mmdb", Size: 1234, Duration: time.Second}},
+		duration: 2 * time.Second,
+		finished: time.Unix(1700000000, 0),
+		retries:  3,
+	}
+	if err := pushMetrics(srv.URL+"/", m, 1, logger); err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	if want := "/metrics/job/geoip_updater/instance/" + hostname; path != want {
+		t.Errorf("path = %q, want %q", path, want)
+	}
+	if !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+	for _, want := range []string{
+		"geoip_update_success 1\n",
+		"geoip_update_last_success_timestamp 1700000000\n",
+		"geoip_update_retries 3\n",
+		`geoip_update_database_duration_seconds{database="GeoIP2-City.mmdb"} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+
+	m.failed = true
+	if err := pushMetrics(srv.URL, m, 1, logger); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, "geoip_update_success 0\n") || strings.Contains(body, "last_success") {
+		t.Errorf("failed run:\n%s", body)
+	}
+
+	srv.Close()
+	if err := pushMetrics(srv.URL, m, 1, logger); err == nil {
+		t.Error("unreachable gateway: expected error")
+	}
+}
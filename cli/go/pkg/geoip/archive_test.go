@@ -0,0 +1,141 @@
+package geoip
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type tarEntry struct {
+	name string
+	body []byte
+}
+
+func tarGz(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	return gzipBytes(t, tarBytes(t, entries))
+}
+
+func tarBytes(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		if e.body == nil {
+			tw.WriteHeader(&tar.Header{Name: e.name, Typeflag: tar.TypeDir, Mode: 0755})
+			continue
+		}
+		tw.WriteHeader(&tar.Header{Name: e.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(e.body))})
+		tw.Write(e.body)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestDownloadDatabaseArchive verifies MaxMind permalink archives: the .mmdb
+// is pulled out of its dated directory and written under the requested name,
+// the matching member wins when several databases are bundled, and an archive
+// without a database fails cleanly. IP2Location .BIN bundles are extracted
+// the same way, whether plain tar or gzip announced only by Content-Type.
+func TestDownloadDatabaseArchive(t *testing.T) {
+	city := []byte("city database")
+	country := []byte("country database")
+	ip2l := []byte("ip2location database")
+
+	archives := map[string][]byte{
+		"/city": tarGz(t, []tarEntry{
+			{"GeoLite2-City_20240101/", nil},
+			{"GeoLite2-City_20240101/COPYRIGHT.txt", []byte("copyright")},
+			{"GeoLite2-City_20240101/GeoLite2-City.mmdb", city},
+		}),
+		"/bundle": tarGz(t, []tarEntry{
+			{"dist/GeoLite2-City_20240101/GeoLite2-City.mmdb", city},
+			{"dist/GeoLite2-Country_20240101/GeoLite2-Country.mmdb", country},
+		}),
+		"/empty": tarGz(t, []tarEntry{
+			{"GeoLite2-City_20240101/LICENSE.txt", []byte("license")},
+		}),
+		"/city.tar": tarBytes(t, []tarEntry{
+			{"GeoLite2-City_20240101/", nil},
+			{"GeoLite2-City_20240101/LICENSE.txt", []byte("license")},
+			{"GeoLite2-City_20240101/GeoLite2-City.mmdb", city},
+		}),
+		"/ip2location.tar": tarBytes(t, []tarEntry{
+			{"README_LITE.TXT", []byte("readme")},
+			{"IP2LOCATION-LITE-DB1.BIN", ip2l},
+		}),
+		"/ip2location-typed": tarGz(t, []tarEntry{
+			{"IP2LOCATION-LITE-DB1.BIN", ip2l},
+		}),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ip2location.tar", "/city.tar":
+			// Plain tar named only by the URL.
+		case "/ip2location-typed":
+			// Compression announced only by Content-Type.
+			w.Header().Set("Content-Type", "application/gzip")
+		default:
+			// Permalinks name the archive only in Content-Disposition.
+			w.Header().Set("Content-Disposition", "attachment; filename=GeoLite2_20240101.tar.gz")
+		}
+		w.Write(archives[r.URL.Path])
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		path, name, wantFile string
+		want                 []byte
+	}{
+		{"/city", "GeoLite2-City.mmdb", "GeoLite2-City.mmdb", city},
+		{"/bundle", "GeoLite2-Country.mmdb", "GeoLite2-Country.mmdb", country},
+		{"/city", "GeoLite2-City.tar.gz", "GeoLite2-City.mmdb", city},
+		{"/city.tar", "GeoLite2-City.tar", "GeoLite2-City.mmdb", city},
+		{"/empty", "GeoLite2-City.mmdb", "", nil},
+		{"/ip2location.tar", "IP2LOCATION-LITE-DB1.BIN", "IP2LOCATION-LITE-DB1.BIN", ip2l},
+		{"/ip2location-typed", "IP2LOCATION-LITE-DB1.BIN", "IP2LOCATION-LITE-DB1.BIN", ip2l},
+		{"/ip2location.tar", "DB1LITEBIN.tar", "IP2LOCATION-LITE-DB1.BIN", ip2l},
+	}
+	for _, c := range cases {
+		t.Run(c.path+"/"+c.name, func(t *testing.T) {
+			logger := &ConsoleLogger{quiet: true}
+			cfg := &Config{TargetDir: t.TempDir(), Timeout: 10 * time.Second, MaxRetries: 1}
+			g := &Updater{
+				config:     cfg,
+				httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+				logger:     logger,
+				tempDir:    t.TempDir(),
+			}
+
+			res := g.downloadDatabase(context.Background(), c.name, srv.URL+c.path, "")
+			if c.want == nil {
+				if res.Error == nil || !strings.Contains(res.Error.Error(), "LICENSE.txt") {
+					t.Fatalf("error = %v, want one naming the archive's members", res.Error)
+				}
+				if entries, _ := os.ReadDir(cfg.TargetDir); len(entries) != 0 {
+					t.Fatalf("TargetDir not empty: %v", entries)
+				}
+				return
+			}
+			if res.Error != nil {
+				t.Fatalf("unexpected error: %v", res.Error)
+			}
+			got, err := os.ReadFile(filepath.Join(cfg.TargetDir, c.wantFile))
+			if err != nil {
+				t.Fatalf("extracted file missing: %v", err)
+			}
+			if !bytes.Equal(got, c.want) {
+				t.Fatalf("content = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
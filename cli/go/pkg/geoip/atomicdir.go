@@ -0,0 +1,139 @@
+package geoip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// updateAtomic runs update against <TargetDir>.new, seeded with hard links
+// to the current files so unchanged databases, cache sidecars and anything
+// else in the directory carry over, then swaps it into place if every
+// database succeeded: TargetDir becomes <TargetDir>.old and the staging
+// directory becomes TargetDir.
+func (g *Updater) updateAtomic(ctx context.Context) ([]DownloadResult, error) {
+	target := filepath.Clean(g.config.TargetDir)
+	staging, old := target+".new", target+".old"
+
+	// A leftover from an interrupted run is incomplete by definition.
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, fmt.Errorf("failed to remove stale %s: %w", staging, err)
+	}
+	if err := linkTree(target, staging); err != nil {
+		os.RemoveAll(staging)
+		return nil, fmt.Errorf("failed to prepare %s: %w", staging, err)
+	}
+	g.logger.Info("Staging update in %s", staging)
+
+	orig := g.config
+	staged := *orig
+	staged.TargetDir = staging
+	staged.ManifestJSON = rebase(orig.ManifestJSON, target, staging)
+	g.config = &staged
+	results, err := g.update(ctx)
+	g.config = orig
+
+	if err != nil || len(results) == 0 {
+		os.RemoveAll(staging)
+		if err != nil {
+			g.logger.Warn("Update incomplete, %s left unchanged", target)
+		}
+		return results, err
+	}
+
+	if err := swapDir(target, staging, old); err != nil {
+		os.RemoveAll(staging)
+		return results, err
+	}
+	for i := range results {
+		if rel, err := filepath.Rel(staging, results[i].Path); err == nil && results[i].Path != "" && !strings.HasPrefix(rel, "..") {
+			results[i].Path = filepath.Join(target, rel)
+		}
+	}
+	g.logger.Success("Swapped updated databases into %s (previous kept as %s)", target, old)
+	return results, nil
+}
+
+// swapDir replaces target with staging, moving target to old. The previous
+// old directory is removed first; a missing target is simply created.
+func swapDir(target, staging, old string) error {
+	if err := os.RemoveAll(old); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", old, err)
+	}
+	if err := os.Rename(target, old); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to move %s aside: %w", target, err)
+	}
+	if err := os.Rename(staging, target); err != nil {
+		// Put the previous directory back rather than leave none.
+		os.Rename(old, target)
+		return fmt.Errorf("failed to move %s into place: %w", staging, err)
+	}
+	if err := syncDir(filepath.Dir(target)); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", filepath.Dir(target), err)
+	}
+	return nil
+}
+
+// linkTree recreates the tree at src under dst, hard-linking regular files
+// (copying where links are unsupported) and recreating symlinks. Staging
+// directories of interrupted updates are skipped; a missing src yields an
+// empty dst.
+func linkTree(src, dst string) error {
+	if _, err := os.Stat(src); errors.Is(err, fs.ErrNotExist) {
+		return os.MkdirAll(dst, 0755)
+	}
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		out := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			if strings.HasPrefix(d.Name(), ".geoip-update-") {
+				return filepath.SkipDir
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return os.MkdirAll(out, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, out)
+		case d.Type().IsRegular():
+			if err := os.Link(path, out); err != nil {
+				return copyFile(path, out)
+			}
+		}
+		return nil
+	})
+}
+
+// rebase returns path moved from under dir to the same place under newDir,
+// or path unchanged if it is not inside dir.
+func rebase(path, dir, newDir string) string {
+	if path == "" {
+		return path
+	}
+	absPath, err1 := filepath.Abs(path)
+	absDir, err2 := filepath.Abs(dir)
+	if err1 != nil || err2 != nil {
+		return path
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.Join(newDir, rel)
+}
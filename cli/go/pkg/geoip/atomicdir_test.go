@@ -0,0 +1,104 @@
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestUpdateAtomicDir verifies a successful run swaps in a complete new
+// directory, keeping the previous one as .old and other files in place,
+// while a run with a failed database leaves the directory untouched.
+func TestUpdateAtomicDir(t *testing.T) {
+	var srvURL string
+	failB := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			json.NewEncoder(w).Encode(map[string]string{"a.db": srvURL + "/a", "b.db": srvURL + "/b"})
+		case "/b":
+			if failB {
+				http.NotFound(w, r)
+				return
+			}
+			fallthrough
+		default:
+			w.Header().Set("ETag", `"`+r.URL.Path+`"`)
+			w.Write([]byte("new " + r.URL.Path))
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	target := filepath.Join(t.TempDir(), "geoip")
+	os.MkdirAll(target, 0755)
+	os.WriteFile(filepath.Join(target, "a.db"), []byte("old a"), 0644)
+	os.WriteFile(filepath.Join(target, "README"), []byte("keep me"), 0644)
+
+	cfg := &Config{
+		APIEndpoints:   []string{srv.URL + "/auth"},
+		TargetDir:      target,
+		Timeout:        10 * time.Second,
+		MaxRetries:     1,
+		MaxConcurrent:  2,
+		AtomicDir:      true,
+		SkipSpaceCheck: true,
+	}
+	run := func() ([]DownloadResult, error) {
+		updater, err := New(cfg, Options{HTTPClient: srv.Client()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer updater.Close()
+		return updater.Update(context.Background())
+	}
+	read := func(path string) string {
+		data, _ := os.ReadFile(path)
+		return string(data)
+	}
+
+	failB = true
+	if _, err := run(); err == nil {
+		t.Fatal("expected failure")
+	}
+	if got := read(filepath.Join(target, "a.db")); got != "old a" {
+		t.Errorf("failed run changed a.db to %q", got)
+	}
+	if _, err := os.Stat(target + ".new"); !os.IsNotExist(err) {
+		t.Errorf("staging directory left behind: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "a.db.meta.json")); !os.IsNotExist(err) {
+		t.Errorf("failed run wrote into the live directory: %v", err)
+	}
+
+	failB = false
+	results, err := run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a.db": "new /a", "b.db": "new /b", "README": "keep me"} {
+		if got := read(filepath.Join(target, name)); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if got := read(filepath.Join(target+".old", "a.db")); got != "old a" {
+		t.Errorf("previous directory a.db = %q", got)
+	}
+	if results[0].Path != filepath.Join(target, "a.db") {
+		t.Errorf("result path = %s", results[0].Path)
+	}
+
+	// The next run revalidates against sidecars carried over by the
+	// hard-linked copy and still swaps cleanly.
+	if _, err := run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(filepath.Join(target+".old", "b.db")); got != "new /b" {
+		t.Errorf("second swap: previous b.db = %q", got)
+	}
+}
@@ -0,0 +1,154 @@
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication modes for Config.AuthMode.
+const (
+	AuthAPIKey = "api-key" // X-API-Key header with Config.APIKey (the default)
+	AuthBearer = "bearer"  // Authorization: Bearer with an OAuth2 access token
+)
+
+// Authenticator adds credentials to an API request.
+type Authenticator interface {
+	Authorize(ctx context.Context, req *http.Request) error
+}
+
+// NewAuthenticator returns the Authenticator for config.AuthMode. In bearer
+// mode it sends Config.Token, or else a token obtained from
+// Config.TokenURL with the OAuth2 client credentials grant, which is
+// requested through client and reused until shortly before it expires.
+func NewAuthenticator(config *Config, client *http.Client) (Authenticator, error) {
+	switch config.AuthMode {
+	case "", AuthAPIKey:
+		return apiKeyAuth(config.APIKey), nil
+	case AuthBearer:
+		if config.Token != "" {
+			return bearerAuth(config.Token), nil
+		}
+		if config.TokenURL == "" || config.OAuthClientID == "" || config.OAuthClientSecret == "" {
+			return nil, errors.New("bearer authentication needs Token, or TokenURL with OAuthClientID and OAuthClientSecret")
+		}
+		if _, err := url.ParseRequestURI(config.TokenURL); err != nil {
+			return nil, fmt.Errorf("invalid token URL: %w", err)
+		}
+		if client == nil {
+			client = http.DefaultClient
+		}
+		return &clientCredentials{
+			tokenURL: config.TokenURL, clientID: config.OAuthClientID, clientSecret: config.OAuthClientSecret,
+			scope: config.TokenScope, client: client, userAgent: configUserAgent(config),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown authentication mode %q: use %s or %s", config.AuthMode, AuthAPIKey, AuthBearer)
+}
+
+type apiKeyAuth string
+
+func (k apiKeyAuth) Authorize(_ context.Context, req *http.Request) error {
+	req.Header.Set("X-API-Key", string(k))
+	return nil
+}
+
+type bearerAuth string
+
+func (t bearerAuth) Authorize(_ context.Context, req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(t))
+	return nil
+}
+
+// tokenExpiryMargin renews a cached access token this long before the
+// server says it expires, so it doesn't lapse in flight.
+const tokenExpiryMargin = 30 * time.Second
+
+// clientCredentials obtains bearer tokens with the OAuth2 client
+// credentials grant (RFC 6749 section 4.4).
+type clientCredentials struct {
+	tokenURL, clientID, clientSecret, scope string
+	client                                  *http.Client
+	userAgent                               string
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time // zero when the server gave no lifetime
+}
+
+func (c *clientCredentials) Authorize(ctx context.Context, req *http.Request) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == "" || (!c.expiry.IsZero() && time.Now().After(c.expiry)) {
+		if err := c.fetch(ctx); err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	return nil
+}
+
+// fetch requests a new access token, authenticating the client with HTTP
+// Basic as the RFC recommends.
+func (c *clientCredentials) fetch(ctx context.Context) error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if c.scope != "" {
+		form.Set("scope", c.scope)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("token request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return fmt.Errorf("token request failed: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(e.Error+" "+e.Description))
+		}
+		return fmt.Errorf("token request failed: HTTP %d", resp.StatusCode)
+	}
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return errors.New("token response has no access_token")
+	}
+	if tok.TokenType != "" && !strings.EqualFold(tok.TokenType, "bearer") {
+		return fmt.Errorf("unsupported token type %q", tok.TokenType)
+	}
+	c.token = tok.AccessToken
+	c.expiry = time.Time{}
+	if tok.ExpiresIn > 0 {
+		c.expiry = time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second - tokenExpiryMargin)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestBearerAuth verifies bearer mode sends a static token, or one from a
+// client credentials grant that is fetched once and reused.
+func TestBearerAuth(t *testing.T) {
+	var grants int32
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		r.ParseForm()
+		if id != "cli" || secret != "s3cret" || r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("scope") != "geoip.read" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		atomic.AddInt32(&grants, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "granted", "token_type": "Bearer", "expires_in": 3600})
+	}))
+	defer tokenSrv.Close()
+
+	var gotAuth, gotKey string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth, gotKey = r.Header.Get("Authorization"), r.Header.Get("X-API-Key")
+		json.NewEncoder(w).Encode(map[string]string{"a.mmdb": "https://example.com/a"})
+	}))
+	defer api.Close()
+
+	for _, tc := range []struct {
+		cfg  Config
+		auth string
+		key  string
+	}{
+		{Config{APIKey: "key-12345"}, "", "key-12345"},
+		{Config{AuthMode: AuthBearer, Token: "static"}, "Bearer static", ""},
+		{Config{AuthMode: AuthBearer, TokenURL: tokenSrv.URL, OAuthClientID: "cli", OAuthClientSecret: "s3cret", TokenScope: "geoip.read"}, "Bearer granted", ""},
+	} {
+		atomic.StoreInt32(&grants, 0)
+		cfg := tc.cfg
+		cfg.APIEndpoints = []string{api.URL + "/auth"}
+		cfg.TargetDir = t.TempDir()
+		cfg.Timeout = 5 * time.Second
+		g, err := New(&cfg, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := g.authenticate(context.Background()); err != nil {
+				t.Fatalf("%+v: %v", tc.cfg, err)
+			}
+		}
+		if gotAuth != tc.auth || gotKey != tc.key {
+			t.Errorf("%s: Authorization %q, X-API-Key %q; want %q, %q", cfg.AuthMode, gotAuth, gotKey, tc.auth, tc.key)
+		}
+		if cfg.TokenURL != "" && grants != 1 {
+			t.Errorf("%d token grants for 2 requests, want 1", grants)
+		}
+	}
+
+	auth, err := NewAuthenticator(&Config{AuthMode: AuthBearer, TokenURL: tokenSrv.URL, OAuthClientID: "cli", OAuthClientSecret: "wrong"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("POST", api.URL, nil)
+	if err := auth.Authorize(context.Background(), req); err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("err = %v, want the token endpoint's error", err)
+	}
+	if _, err := NewAuthenticator(&Config{AuthMode: AuthBearer}, nil); err == nil {
+		t.Error("bearer mode without a token or token URL accepted")
+	}
+}
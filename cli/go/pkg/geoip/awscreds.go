@@ -0,0 +1,218 @@
+package geoip
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// awsCredentials are the keys used to sign S3 requests.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	source          string // where they came from, for logging
+}
+
+// metadataTimeout bounds each request to the ECS or EC2 credential
+// endpoints, which are link-local and answer quickly when present.
+const metadataTimeout = 2 * time.Second
+
+// Link-local credential endpoints, variables so tests can redirect them.
+var (
+	ecsCredentialsHost = "http://169.254.170.2"
+	imdsEndpoint       = "http://169.254.169.254"
+)
+
+// loadAWSCredentials resolves credentials the way the AWS SDKs do, in
+// order: the AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY environment
+// variables, the shared credentials file (AWS_SHARED_CREDENTIALS_FILE or
+// ~/.aws/credentials, profile AWS_PROFILE or "default"), the ECS container
+// endpoint, and the EC2 instance metadata service (IMDSv2).
+func loadAWSCredentials(ctx context.Context) (*awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return &awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN"), source: "environment"}, nil
+	}
+
+	if creds, err := sharedFileCredentials(); err != nil {
+		return nil, err
+	} else if creds != nil {
+		return creds, nil
+	}
+
+	client := &http.Client{Timeout: metadataTimeout}
+	if creds, err := containerCredentials(ctx, client); err != nil {
+		return nil, err
+	} else if creds != nil {
+		return creds, nil
+	}
+
+	creds, err := instanceCredentials(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("no AWS credentials found (environment, shared credentials file, ECS or EC2 metadata): %w", err)
+	}
+	return creds, nil
+}
+
+// sharedFileCredentials reads the profile's keys from the shared
+// credentials file; a missing file or profile yields nil.
+func sharedFileCredentials() (*awsCredentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read AWS credentials file: %w", err)
+	}
+	defer f.Close()
+
+	creds := &awsCredentials{source: fmt.Sprintf("%s [%s]", path, profile)}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read AWS credentials file: %w", err)
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, nil
+	}
+	return creds, nil
+}
+
+// metadataCredentials is the JSON returned by the ECS and EC2 credential
+// endpoints.
+type metadataCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+// containerCredentials fetches task role credentials when running on ECS
+// (or anything else setting AWS_CONTAINER_CREDENTIALS_*); nil otherwise.
+func containerCredentials(ctx context.Context, client *http.Client) (*awsCredentials, error) {
+	url := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+		url = ecsCredentialsHost + rel
+	}
+	if url == "" {
+		return nil, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	body, err := metadataGet(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("container credentials: %w", err)
+	}
+	return decodeMetadataCredentials(body, "container credentials endpoint")
+}
+
+// instanceCredentials fetches the instance profile's credentials from the
+// EC2 instance metadata service, using an IMDSv2 session token.
+func instanceCredentials(ctx context.Context, client *http.Client) (*awsCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, "PUT", imdsEndpoint+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	token, err := metadataGet(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("instance metadata: %w", err)
+	}
+
+	get := func(path string) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", imdsEndpoint+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		return metadataGet(client, req)
+	}
+	roles, err := get("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, fmt.Errorf("instance metadata: %w", err)
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return nil, errors.New("instance metadata: no instance profile attached")
+	}
+	body, err := get("/latest/meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return nil, fmt.Errorf("instance metadata: %w", err)
+	}
+	return decodeMetadataCredentials(body, "instance profile "+role)
+}
+
+func metadataGet(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d from %s", resp.StatusCode, req.URL.Path)
+	}
+	return body, nil
+}
+
+func decodeMetadataCredentials(body []byte, source string) (*awsCredentials, error) {
+	var m metadataCredentials
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	if m.AccessKeyID == "" || m.SecretAccessKey == "" {
+		return nil, fmt.Errorf("%s: response has no keys", source)
+	}
+	return &awsCredentials{AccessKeyID: m.AccessKeyID, SecretAccessKey: m.SecretAccessKey, SessionToken: m.Token, source: source}, nil
+}
@@ -0,0 +1,91 @@
+package geoip
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// backupPath returns the name of backup slot n (1 = newest) of targetFile:
+// "<name>.bak" when only one backup is kept, "<name>.bak.<n>" otherwise.
+func backupPath(targetFile string, n, count int) string {
+	if count <= 1 {
+		return targetFile + ".bak"
+	}
+	return fmt.Sprintf("%s.bak.%d", targetFile, n)
+}
+
+// backupExisting makes targetFile's current contents backup slot 1,
+// shifting older backups up one slot and dropping the one past count. The
+// backup is a hard link (a copy where links are unsupported), so targetFile
+// stays in place until the replacement is renamed over it. It reports
+// whether a backup was made; a missing targetFile is not an error.
+func backupExisting(targetFile string, count int) (bool, error) {
+	if count < 1 {
+		count = 1
+	}
+	if _, err := os.Stat(targetFile); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	os.Remove(backupPath(targetFile, count, count))
+	for n := count - 1; n >= 1; n-- {
+		older := backupPath(targetFile, n, count)
+		if _, err := os.Stat(older); err == nil {
+			if err := os.Rename(older, backupPath(targetFile, n+1, count)); err != nil {
+				return false, fmt.Errorf("failed to rotate backup %s: %w", older, err)
+			}
+		}
+	}
+	slot := backupPath(targetFile, 1, count)
+	if err := os.Link(targetFile, slot); err != nil {
+		if err := copyFile(targetFile, slot); err != nil {
+			os.Remove(slot)
+			return false, fmt.Errorf("failed to back up %s: %w", targetFile, err)
+		}
+	}
+	return true, nil
+}
+
+// discardBackup removes the transient backup made for a replacement that
+// installed cleanly.
+func discardBackup(targetFile string) {
+	os.Remove(backupPath(targetFile, 1, 1))
+}
+
+func copyFile(src, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destination, source); err != nil {
+		destination.Close()
+		return err
+	}
+	return destination.Close()
+}
+
+// restoreBackup undoes backupExisting: the newest backup is renamed over
+// targetFile and older backups move back down one slot.
+func restoreBackup(targetFile string, count int) error {
+	if count < 1 {
+		count = 1
+	}
+	if err := os.Rename(backupPath(targetFile, 1, count), targetFile); err != nil {
+		return fmt.Errorf("failed to restore backup of %s: %w", targetFile, err)
+	}
+	for n := 2; n <= count; n++ {
+		newer := backupPath(targetFile, n, count)
+		if _, err := os.Stat(newer); err != nil {
+			break
+		}
+		os.Rename(newer, backupPath(targetFile, n-1, count))
+	}
+	return nil
+}
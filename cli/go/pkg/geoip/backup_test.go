@@ -0,0 +1,143 @@
+package geoip
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func readString(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// TestDownloadDatabaseBackup verifies each replacement pushes the previous
+// database into rotating backups and the oldest falls off.
+func TestDownloadDatabaseBackup(t *testing.T) {
+	version := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version++
+		w.Write([]byte("database version " + strconv.Itoa(version)))
+	}))
+	defer srv.Close()
+
+	logger := &ConsoleLogger{quiet: true}
+	cfg := &Config{TargetDir: t.TempDir(), Timeout: 10 * time.Second, MaxRetries: 1, Backup: true, BackupCount: 2, Force: true}
+	g := &Updater{
+		config:     cfg,
+		httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+		logger:     logger,
+		tempDir:    t.TempDir(),
+	}
+	target := filepath.Join(cfg.TargetDir, "test.BIN")
+
+	for i := 0; i < 3; i++ {
+		if res := g.downloadDatabase(context.Background(), "test.BIN", srv.URL, ""); res.Error != nil {
+			t.Fatal(res.Error)
+		}
+	}
+	if got := readString(t, target); got != "database version 3" {
+		t.Errorf("target = %q", got)
+	}
+	if got := readString(t, target+".bak.1"); got != "database version 2" {
+		t.Errorf(".bak.1 = %q", got)
+	}
+	if got := readString(t, target+".bak.2"); got != "database version 1" {
+		t.Errorf(".bak.2 = %q", got)
+	}
+	if _, err := os.Stat(target + ".bak.3"); !os.IsNotExist(err) {
+		t.Errorf(".bak.3 should not exist: %v", err)
+	}
+}
+
+// TestRollbackRestoresBackup verifies a failed install puts the previous
+// database and the older backups back where they were.
+func TestRollbackRestoresBackup(t *testing.T) {
+	for _, count := range []int{1, 3} {
+		dir := t.TempDir()
+		target := filepath.Join(dir, "GeoIP2-City.mmdb")
+		os.WriteFile(target, []byte("good"), 0644)
+		if count > 1 {
+			os.WriteFile(backupPath(target, 1, count), []byte("older"), 0644)
+		}
+
+		backedUp, err := backupExisting(target, count)
+		if err != nil || !backedUp {
+			t.Fatalf("count %d: backupExisting = %v, %v", count, backedUp, err)
+		}
+		// Replacements are renamed over the target, leaving the backup's
+		// link to the old contents intact.
+		os.WriteFile(target+".new", []byte("bad replacement"), 0644)
+		os.Rename(target+".new", target)
+
+		g := &Updater{config: &Config{Backup: true, BackupCount: count}, logger: &ConsoleLogger{quiet: true}}
+		res := g.rollback("GeoIP2-City.mmdb", target, true, os.ErrInvalid)
+		if res.Error == nil {
+			t.Fatal("rollback should report the failure")
+		}
+		if got := readString(t, target); got != "good" {
+			t.Errorf("count %d: target = %q after rollback", count, got)
+		}
+		if count > 1 {
+			if got := readString(t, backupPath(target, 1, count)); got != "older" {
+				t.Errorf("count %d: .bak.1 = %q after rollback", count, got)
+			}
+		}
+	}
+}
+
+// TestDownloadDatabaseTransientBackup verifies that without Backup the
+// previous database is restored when the replacement fails verification in
+// place, and no .bak is left after a good replacement.
+func TestDownloadDatabaseTransientBackup(t *testing.T) {
+	body := "good database"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	logger := &ConsoleLogger{quiet: true}
+	cfg := &Config{TargetDir: t.TempDir(), Timeout: 10 * time.Second, MaxRetries: 1, BackupCount: 1, Force: true}
+	g := &Updater{
+		config:     cfg,
+		httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+		logger:     logger,
+		tempDir:    t.TempDir(),
+	}
+	target := filepath.Join(cfg.TargetDir, "test.BIN")
+	os.WriteFile(target, []byte("previous"), 0644)
+
+	if res := g.downloadDatabase(context.Background(), "test.BIN", srv.URL, ""); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if got := readString(t, target); got != body {
+		t.Errorf("target = %q", got)
+	}
+	if _, err := os.Stat(target + ".bak"); !os.IsNotExist(err) {
+		t.Errorf(".bak left after a good replacement: %v", err)
+	}
+
+	// An MMDB without the metadata marker installs (with a warning) but
+	// fails the in-place check, so the working copy comes back.
+	mmdb := filepath.Join(cfg.TargetDir, "test.mmdb")
+	os.WriteFile(mmdb, []byte("working copy"), 0644)
+	body = "not a database"
+	if res := g.downloadDatabase(context.Background(), "test.mmdb", srv.URL, ""); res.Error == nil {
+		t.Fatal("expected failure for an invalid MMDB")
+	}
+	if got := readString(t, mmdb); got != "working copy" {
+		t.Errorf("target = %q after rollback", got)
+	}
+	if _, err := os.Stat(mmdb + ".bak"); !os.IsNotExist(err) {
+		t.Errorf(".bak left after rollback: %v", err)
+	}
+}
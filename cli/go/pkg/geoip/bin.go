@@ -0,0 +1,126 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// binHeaderSize is the fixed IP2Location/IP2Proxy BIN header read by
+// InspectBIN. Older files end the meaningful part at byte 29 and leave the
+// product code zero.
+const binHeaderSize = 32
+
+// BINInfo describes an IP2Location or IP2Proxy BIN database, as reported by
+// InspectBIN.
+type BINInfo struct {
+	Product      string // "IP2Location", "IP2Proxy" or "IP2Location/IP2Proxy" for legacy headers
+	DatabaseType int    // the N in DBn / PXn
+	Columns      int
+	Date         time.Time
+	IPv4Count    uint32
+	IPv6Count    uint32
+}
+
+// Name returns the product and type, e.g. "IP2Location DB11".
+func (b *BINInfo) Name() string {
+	switch b.Product {
+	case "IP2Proxy":
+		return fmt.Sprintf("IP2Proxy PX%d", b.DatabaseType)
+	default:
+		return fmt.Sprintf("%s DB%d", b.Product, b.DatabaseType)
+	}
+}
+
+// InspectBIN parses the fixed header of the BIN database at path and checks
+// that the IPv4 and IPv6 tables and their indexes it describes fit in the
+// file. An HTML error page or truncated download fails here rather than
+// passing as "binary".
+func InspectBIN(path string) (*BINInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+
+	h := make([]byte, binHeaderSize)
+	if _, err := io.ReadFull(f, h); err != nil {
+		return nil, fmt.Errorf("file too short for a BIN header")
+	}
+
+	info := &BINInfo{
+		DatabaseType: int(h[0]),
+		Columns:      int(h[1]),
+		IPv4Count:    binary.LittleEndian.Uint32(h[5:9]),
+		IPv6Count:    binary.LittleEndian.Uint32(h[13:17]),
+	}
+	year, month, day := int(h[2]), int(h[3]), int(h[4])
+	ipv4Base := int64(binary.LittleEndian.Uint32(h[9:13]))
+	ipv6Base := int64(binary.LittleEndian.Uint32(h[17:21]))
+	ipv4Index := int64(binary.LittleEndian.Uint32(h[21:25]))
+	ipv6Index := int64(binary.LittleEndian.Uint32(h[25:29]))
+
+	switch h[29] {
+	case 1:
+		info.Product = "IP2Location"
+	case 2:
+		info.Product = "IP2Proxy"
+	case 0:
+		info.Product = "IP2Location/IP2Proxy"
+	default:
+		return nil, fmt.Errorf("unknown product code %d", h[29])
+	}
+	if info.DatabaseType < 1 || info.DatabaseType > 26 {
+		return nil, fmt.Errorf("invalid database type %d", info.DatabaseType)
+	}
+	if info.Columns < 1 || info.Columns > 32 {
+		return nil, fmt.Errorf("invalid column count %d", info.Columns)
+	}
+	info.Date = time.Date(2000+year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	// time.Date normalises 02-31 to 03-02; a day past the month's end is
+	// not a date the header could have been written with.
+	if month < 1 || month > 12 || day < 1 || info.Date.Day() != day {
+		return nil, fmt.Errorf("invalid build date %02d-%02d-%02d", year, month, day)
+	}
+	// Allow for time zones and a slightly slow local clock.
+	if info.Date.After(time.Now().Add(48 * time.Hour)) {
+		return nil, fmt.Errorf("build date %s is in the future", info.Date.Format("2006-01-02"))
+	}
+
+	if info.IPv4Count == 0 && info.IPv6Count == 0 {
+		return nil, fmt.Errorf("header describes no IP ranges")
+	}
+	// Base addresses are 1-based; IPv4 rows are one 32-bit IP plus a 32-bit
+	// pointer per remaining column, IPv6 rows a 128-bit IP plus the same.
+	ipv4Row := int64(info.Columns) * 4
+	ipv6Row := 16 + int64(info.Columns-1)*4
+	check := func(what string, base int64, count uint32, row int64) error {
+		if count == 0 {
+			return nil
+		}
+		if base < 1 || base-1+int64(count)*row > size {
+			return fmt.Errorf("%s table (%d rows at %d) exceeds file size %d", what, count, base, size)
+		}
+		return nil
+	}
+	if err := check("IPv4", ipv4Base, info.IPv4Count, ipv4Row); err != nil {
+		return nil, err
+	}
+	if err := check("IPv6", ipv6Base, info.IPv6Count, ipv6Row); err != nil {
+		return nil, err
+	}
+	// Optional indexes are 65536 entries of two 32-bit row numbers.
+	for _, idx := range []int64{ipv4Index, ipv6Index} {
+		if idx > 0 && idx-1+65536*8 > size {
+			return nil, fmt.Errorf("index at %d exceeds file size %d", idx, size)
+		}
+	}
+	return info, nil
+}
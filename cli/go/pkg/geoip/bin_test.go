@@ -0,0 +1,80 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// buildTestBIN returns a minimal IP2Location BIN with a valid header and
+// room for the rows it describes.
+func buildTestBIN(t *testing.T, product byte, dbType, columns int, ipv4Rows, ipv6Rows uint32) []byte {
+	t.Helper()
+	ipv4Base := uint32(64 + 1)
+	ipv6Base := ipv4Base + ipv4Rows*uint32(columns*4)
+	size := int(ipv6Base-1) + int(ipv6Rows)*(16+(columns-1)*4)
+
+	b := make([]byte, size)
+	b[0] = byte(dbType)
+	b[1] = byte(columns)
+	b[2], b[3], b[4] = 24, 3, 1 // 2024-03-01
+	binary.LittleEndian.PutUint32(b[5:], ipv4Rows)
+	binary.LittleEndian.PutUint32(b[9:], ipv4Base)
+	binary.LittleEndian.PutUint32(b[13:], ipv6Rows)
+	binary.LittleEndian.PutUint32(b[17:], ipv6Base)
+	b[29] = product
+	return b
+}
+
+func TestInspectBIN(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	info, err := InspectBIN(write("db11.BIN", buildTestBIN(t, 1, 11, 12, 100, 10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "IP2Location DB11" || info.Columns != 12 || info.IPv4Count != 100 || info.IPv6Count != 10 {
+		t.Errorf("info = %+v (%s)", info, info.Name())
+	}
+	if !info.Date.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Date = %v", info.Date)
+	}
+
+	proxy, err := InspectBIN(write("px2.BIN", buildTestBIN(t, 2, 2, 3, 50, 0)))
+	if err != nil || proxy.Name() != "IP2Proxy PX2" {
+		t.Errorf("proxy = %+v, %v", proxy, err)
+	}
+
+	good := buildTestBIN(t, 1, 1, 2, 100, 0)
+	badType := append([]byte{}, good...)
+	badType[0] = 0
+	badDate := append([]byte{}, good...)
+	badDate[3] = 13
+	badDay := append([]byte{}, good...)
+	badDay[3], badDay[4] = 2, 30
+	future := append([]byte{}, good...)
+	future[2] = 99
+	html := []byte("<html><head><title>403 Forbidden</title></head><body>\x01</body></html>")
+	for name, data := range map[string][]byte{
+		"truncated": good[:len(good)/2],
+		"bad-type":  badType,
+		"bad-date":  badDate,
+		"bad-day":   badDay,
+		"future":    future,
+		"html":      html,
+		"tiny":      good[:10],
+	} {
+		if _, err := InspectBIN(write(name+".BIN", data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
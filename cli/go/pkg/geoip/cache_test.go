@@ -0,0 +1,207 @@
+package geoip
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestDownloadDatabaseConditional verifies the ETag sidecar round trip: the
+// first download stores the validators, the next run sends If-None-Match and
+// keeps the existing file on a 304, and --force bypasses the cache.
+func TestDownloadDatabaseConditional(t *testing.T) {
+	const etag = `"v1"`
+	content := []byte("database contents")
+
+	var full int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&full, 1)
+		w.Header().Set("ETag", etag)
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	logger := &ConsoleLogger{quiet: true}
+	cfg := &Config{TargetDir: t.TempDir(), Timeout: 10 * time.Second, MaxRetries: 1}
+	g := &Updater{
+		config:     cfg,
+		httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+		logger:     logger,
+		tempDir:    t.TempDir(),
+	}
+	target := filepath.Join(cfg.TargetDir, "test.bin")
+
+	res := g.downloadDatabase(context.Background(), "test.bin", srv.URL, "")
+	if res.Error != nil || res.Unchanged {
+		t.Fatalf("first download: %+v", res)
+	}
+	if meta := readCacheMeta(target); meta == nil || meta.ETag != etag {
+		t.Fatalf("sidecar not written: %+v", meta)
+	}
+
+	res = g.downloadDatabase(context.Background(), "test.bin", srv.URL, "")
+	if res.Error != nil || !res.Unchanged {
+		t.Fatalf("second download should be unchanged: %+v", res)
+	}
+	if res.Size != int64(len(content)) {
+		t.Fatalf("unchanged size = %d, want %d", res.Size, len(content))
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256(content)); res.SHA256 != want {
+		t.Fatalf("unchanged sha256 = %q, want %q", res.SHA256, want)
+	}
+	if n := atomic.LoadInt32(&full); n != 1 {
+		t.Fatalf("expected 1 full download, got %d", n)
+	}
+
+	cfg.Force = true
+	res = g.downloadDatabase(context.Background(), "test.bin", srv.URL, "")
+	if res.Error != nil || res.Unchanged {
+		t.Fatalf("forced download: %+v", res)
+	}
+	if n := atomic.LoadInt32(&full); n != 2 {
+		t.Fatalf("expected --force to re-download, got %d full downloads", n)
+	}
+
+	// A sidecar without its database must not turn into a 304 that leaves
+	// nothing on disk.
+	cfg.Force = false
+	if err := os.Remove(target); err != nil {
+		t.Fatal(err)
+	}
+	res = g.downloadDatabase(context.Background(), "test.bin", srv.URL, "")
+	if res.Error != nil || res.Unchanged {
+		t.Fatalf("download with missing target: %+v", res)
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Fatalf("target not restored: %v", err)
+	}
+}
+
+// TestDownloadDatabaseConditionalReplacedFile verifies that a sidecar whose
+// recorded size no longer matches the database (the file was replaced by
+// another tool) is ignored instead of producing a 304 for the wrong file.
+func TestDownloadDatabaseConditionalReplacedFile(t *testing.T) {
+	const etag = `"v1"`
+	content := []byte("database contents")
+
+	var conditional int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" {
+			atomic.AddInt32(&conditional, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	logger := &ConsoleLogger{quiet: true}
+	cfg := &Config{TargetDir: t.TempDir(), Timeout: 10 * time.Second, MaxRetries: 1}
+	g := &Updater{
+		config:     cfg,
+		httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+		logger:     logger,
+		tempDir:    t.TempDir(),
+	}
+	target := filepath.Join(cfg.TargetDir, "test.bin")
+
+	if res := g.downloadDatabase(context.Background(), "test.bin", srv.URL, ""); res.Error != nil {
+		t.Fatalf("first download: %v", res.Error)
+	}
+	if err := os.WriteFile(target, []byte("a different file copied in by hand"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	res := g.downloadDatabase(context.Background(), "test.bin", srv.URL, "")
+	if res.Error != nil || res.Unchanged {
+		t.Fatalf("replaced file should be re-downloaded: %+v", res)
+	}
+	if n := atomic.LoadInt32(&conditional); n != 0 {
+		t.Fatalf("sent %d conditional requests for a replaced file", n)
+	}
+	if got, _ := os.ReadFile(target); string(got) != string(content) {
+		t.Fatalf("target = %q", got)
+	}
+}
+
+// TestDownloadDatabaseUnexpectedNotModified verifies that a 304 answering a
+// request without validators fails the download instead of being taken for
+// an unchanged file that does not exist.
+func TestDownloadDatabaseUnexpectedNotModified(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer srv.Close()
+
+	for _, chunks := range []int{1, 4} {
+		logger := &ConsoleLogger{quiet: true}
+		cfg := &Config{TargetDir: t.TempDir(), Timeout: 10 * time.Second, MaxRetries: 1, ChunksPerFile: chunks}
+		g := &Updater{
+			config:     cfg,
+			httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+			logger:     logger,
+			tempDir:    t.TempDir(),
+		}
+
+		res := g.downloadDatabase(context.Background(), "test.bin", srv.URL, "")
+		if res.Error == nil || res.Unchanged {
+			t.Fatalf("chunks=%d: expected an error, got %+v", chunks, res)
+		}
+		if !strings.Contains(res.Error.Error(), "unexpected 304") {
+			t.Fatalf("chunks=%d: error = %v", chunks, res.Error)
+		}
+		if _, err := os.Stat(filepath.Join(cfg.TargetDir, "test.bin")); !os.IsNotExist(err) {
+			t.Fatalf("chunks=%d: target should not exist: %v", chunks, err)
+		}
+	}
+}
+
+// TestIfNewer verifies IfNewer keeps a local file at least as recent as the
+// server's Last-Modified and downloads one that is older.
+func TestIfNewer(t *testing.T) {
+	lastModified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var gets int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		if r.Method == "GET" {
+			gets++
+			w.Write([]byte("new-data"))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "a.BIN")
+	cfg := &Config{TargetDir: dir, MaxRetries: 1, Timeout: 5 * time.Second, IfNewer: true}
+	g := &Updater{config: cfg, httpClient: newHTTPClient(cfg.Timeout, 1, &ConsoleLogger{quiet: true}), logger: &ConsoleLogger{quiet: true}, tempDir: t.TempDir()}
+
+	os.WriteFile(target, []byte("old-data"), 0644)
+	os.Chtimes(target, lastModified, lastModified)
+	result := g.downloadDatabase(context.Background(), "a.BIN", srv.URL+"/a.BIN", "")
+	if result.Error != nil || !result.NotNewer || gets != 0 {
+		t.Fatalf("current file: %+v after %d GETs, want NotNewer without a download", result, gets)
+	}
+
+	older := lastModified.Add(-time.Hour)
+	os.Chtimes(target, older, older)
+	result = g.downloadDatabase(context.Background(), "a.BIN", srv.URL+"/a.BIN", "")
+	if result.Error != nil || result.NotNewer || gets != 1 {
+		t.Fatalf("stale file: %+v after %d GETs, want a download", result, gets)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "new-data" {
+		t.Errorf("a.BIN = %q", data)
+	}
+}
@@ -0,0 +1,166 @@
+package geoip
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestDownloadDatabaseChecksum verifies that a download matching the
+// server-provided SHA256 is moved into TargetDir, and that a mismatch fails
+// without ever placing the file there.
+func TestDownloadDatabaseChecksum(t *testing.T) {
+	content := []byte("not really an mmdb, but good enough for hashing")
+	sum := sha256.Sum256(content)
+	good := hex.EncodeToString(sum[:])
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name     string
+		checksum string
+		wantErr  bool
+	}{
+		{"match", good, false},
+		{"match-uppercase", strings.ToUpper(good), false},
+		{"mismatch", "0000000000000000000000000000000000000000000000000000000000000000", true},
+		{"omitted", "", false}, // older endpoints: fail open
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			logger := &ConsoleLogger{quiet: true}
+			cfg := &Config{TargetDir: t.TempDir(), Timeout: 10 * time.Second, MaxRetries: 1}
+			g := &Updater{
+				config:     cfg,
+				httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+				logger:     logger,
+				tempDir:    t.TempDir(),
+			}
+
+			res := g.downloadDatabase(context.Background(), "test.bin", srv.URL, c.checksum)
+			_, statErr := os.Stat(filepath.Join(cfg.TargetDir, "test.bin"))
+			if c.wantErr {
+				if res.Error == nil {
+					t.Fatal("expected checksum error, got nil")
+				}
+				if statErr == nil {
+					t.Fatal("file with bad checksum was moved into TargetDir")
+				}
+				return
+			}
+			if res.Error != nil {
+				t.Fatalf("unexpected error: %v", res.Error)
+			}
+			if statErr != nil {
+				t.Fatalf("expected file in TargetDir: %v", statErr)
+			}
+		})
+	}
+}
+
+// TestDownloadDatabaseLocalChecksum verifies a local file matching the
+// server's checksum is kept without a request, even with no cache
+// metadata, and that Force downloads it anyway.
+func TestDownloadDatabaseLocalChecksum(t *testing.T) {
+	content := []byte("placed here by another tool")
+	sum := sha256.Sum256(content)
+
+	var reqs int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&reqs, 1)
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	logger := &ConsoleLogger{quiet: true}
+	cfg := &Config{TargetDir: t.TempDir(), Timeout: 10 * time.Second, MaxRetries: 1}
+	g := &Updater{
+		config:     cfg,
+		httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+		logger:     logger,
+		tempDir:    t.TempDir(),
+	}
+	os.WriteFile(filepath.Join(cfg.TargetDir, "test.bin"), content, 0644)
+
+	res := g.downloadDatabase(context.Background(), "test.bin", srv.URL, hex.EncodeToString(sum[:]))
+	if res.Error != nil || !res.Unchanged || reqs != 0 {
+		t.Errorf("matching local copy: unchanged %v, err %v, %d requests", res.Unchanged, res.Error, reqs)
+	}
+
+	cfg.Force = true
+	res = g.downloadDatabase(context.Background(), "test.bin", srv.URL, hex.EncodeToString(sum[:]))
+	if res.Error != nil || res.Unchanged || reqs != 1 {
+		t.Errorf("Force: unchanged %v, err %v, %d requests", res.Unchanged, res.Error, reqs)
+	}
+}
+
+// TestDownloadDatabaseChecksumRetry verifies that a checksum mismatch is
+// treated as a failed download and retried, so a transiently corrupt transfer
+// recovers instead of failing the run.
+func TestDownloadDatabaseChecksumRetry(t *testing.T) {
+	content := []byte("the real database bytes")
+	sum := sha256.Sum256(content)
+
+	var reqs int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&reqs, 1) == 1 {
+			w.Write([]byte("corrupted in transit"))
+			return
+		}
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	logger := &ConsoleLogger{quiet: true}
+	cfg := &Config{TargetDir: t.TempDir(), Timeout: 10 * time.Second, MaxRetries: 3}
+	g := &Updater{
+		config:     cfg,
+		httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+		logger:     logger,
+		tempDir:    t.TempDir(),
+	}
+
+	res := g.downloadDatabase(context.Background(), "test.bin", srv.URL, hex.EncodeToString(sum[:]))
+	if res.Error != nil {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	if n := atomic.LoadInt32(&reqs); n != 2 {
+		t.Fatalf("expected 2 requests (corrupt + retry), got %d", n)
+	}
+	got, err := os.ReadFile(filepath.Join(cfg.TargetDir, "test.bin"))
+	if err != nil || string(got) != string(content) {
+		t.Fatalf("target content = %q, %v", got, err)
+	}
+}
+
+// TestAuthResponseChecksums verifies the /auth response decodes both the
+// legacy flat URL map and the optional "checksums" object.
+func TestAuthResponseChecksums(t *testing.T) {
+	var auth authResponse
+	body := `{"GeoIP2-City.mmdb": "https://example.com/city", "checksums": {"GeoIP2-City.mmdb": "abc123"}}`
+	if err := json.Unmarshal([]byte(body), &auth); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got := auth.URLs["GeoIP2-City.mmdb"]; got != "https://example.com/city" {
+		t.Errorf("URL = %q", got)
+	}
+	if _, ok := auth.URLs["checksums"]; ok {
+		t.Error("checksums key leaked into URLs")
+	}
+	if got := auth.Checksums["GeoIP2-City.mmdb"]; got != "abc123" {
+		t.Errorf("checksum = %q", got)
+	}
+}
@@ -0,0 +1,234 @@
+package geoip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minChunkSize keeps small files, and chunks, on one stream: below it the
+// extra requests cost more than the parallelism saves.
+const minChunkSize = 1 << 20
+
+// errRangeIgnored means the server answered a range request with something
+// other than the requested bytes: no range support after all, or a new
+// version of the file (If-Range) mid-download.
+var errRangeIgnored = errors.New("server did not honor the range request")
+
+// fetchChunked downloads url into tempFile like fetchToFile, but splits the
+// body into ChunksPerFile byte ranges fetched concurrently. A HEAD request
+// decides: the file is split only when the server announces
+// "Accept-Ranges: bytes" and an unencoded length of at least two chunks.
+// Otherwise, or if the server stops honoring the ranges, it falls back to a
+// single stream.
+func (g *Updater) fetchChunked(ctx context.Context, name, url, tempFile string, cached *cacheMeta) (_ *cacheMeta, err error) {
+	size, meta, err := g.probeRanges(ctx, name, url, cached)
+	if err != nil {
+		return nil, err
+	}
+	chunks := int64(g.config.ChunksPerFile)
+	if size/chunks < minChunkSize {
+		chunks = size / minChunkSize
+	}
+	if meta == nil || chunks < 2 {
+		return g.fetchToFile(ctx, name, url, tempFile, cached)
+	}
+
+	partFile := tempFile + ".part"
+	defer func() {
+		if err != nil {
+			os.Remove(partFile)
+		}
+	}()
+	os.Remove(tempFile)
+	out, err := os.Create(partFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open temp file: %w", err)
+	}
+	if err := out.Truncate(size); err != nil {
+		out.Close()
+		return nil, fmt.Errorf("failed to allocate temp file: %w", err)
+	}
+	g.logger.Info("Downloading %s in %d parallel ranges", name, chunks)
+
+	chunkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	bar := g.progress.add(name, size, 0)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	step := size / chunks
+	for i := int64(0); i < chunks; i++ {
+		start, end := i*step, (i+1)*step-1
+		if i == chunks-1 {
+			end = size - 1
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := g.fetchRange(chunkCtx, name, url, out, start, end, meta.ETag, bar); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	g.progress.remove(bar)
+	closeErr := out.Close()
+
+	if errors.Is(firstErr, errRangeIgnored) && ctx.Err() == nil {
+		g.logger.Info("%s: %v - downloading as a single stream", name, firstErr)
+		os.Remove(partFile)
+		return g.fetchToFile(ctx, name, url, tempFile, nil)
+	}
+	if firstErr != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, firstErr
+	}
+	if closeErr != nil {
+		return nil, fmt.Errorf("failed to write temp file: %w", closeErr)
+	}
+	if err := os.Rename(partFile, tempFile); err != nil {
+		return nil, fmt.Errorf("failed to finalize download: %w", err)
+	}
+	return meta, nil
+}
+
+// probeRanges asks with a HEAD request whether url can be fetched in byte
+// ranges, returning the length and validators if so and a nil meta if not.
+// A HEAD that fails is not an error: presigned URLs are often valid for GET
+// only. When cached is non-nil the request is conditional and a 304
+// returns errNotModified.
+func (g *Updater) probeRanges(ctx context.Context, name, url string, cached *cacheMeta) (int64, *cacheMeta, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", g.userAgent())
+	conditional := false
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+			conditional = true
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+			conditional = true
+		}
+	}
+	resp, err := g.httpClient.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, nil, ctx.Err()
+		}
+		debugf(g.logger, "%s: HEAD request failed, not splitting: %v", name, err)
+		return 0, nil, nil
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		if !conditional {
+			return 0, nil, errUnexpectedNotModified
+		}
+		return 0, nil, errNotModified
+	}
+	if resp.StatusCode != http.StatusOK || !strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes") ||
+		resp.ContentLength <= 0 || resp.Header.Get("Content-Encoding") != "" {
+		debugf(g.logger, "%s: no byte-range support announced (HEAD %d, Accept-Ranges %q), not splitting",
+			name, resp.StatusCode, resp.Header.Get("Accept-Ranges"))
+		return 0, nil, nil
+	}
+
+	meta := &cacheMeta{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		contentType:  strings.ToLower(resp.Header.Get("Content-Type")),
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		meta.filename = filepath.Base(params["filename"])
+	}
+	return resp.ContentLength, meta, nil
+}
+
+// fetchRange writes bytes start..end (inclusive) of url into out at their
+// offset, resuming within the range after interruptions the way
+// fetchToFile does. A strong etag is sent as If-Range so a file replaced
+// mid-download yields errRangeIgnored rather than a mix of versions.
+func (g *Updater) fetchRange(ctx context.Context, name, url string, out *os.File, start, end int64, etag string, bar *progressBar) error {
+	const maxNoProgress = 3
+	noProgress := 0
+	pos := start
+	for pos <= end {
+		reqCtx, cancel := context.WithCancel(ctx)
+		req, err := http.NewRequestWithContext(reqCtx, "GET", url, nil)
+		if err != nil {
+			cancel()
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("User-Agent", g.userAgent())
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", pos, end))
+		if etag != "" && !strings.HasPrefix(etag, "W/") {
+			req.Header.Set("If-Range", etag)
+		}
+
+		resp, err := g.httpClient.doWithRetry(req)
+		var n int64
+		if err == nil {
+			if resp.StatusCode != http.StatusPartialContent {
+				resp.Body.Close()
+				cancel()
+				return fmt.Errorf("%w (HTTP %d for bytes %d-%d)", errRangeIgnored, resp.StatusCode, pos, end)
+			}
+			if first, last, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || first != pos || last > end {
+				resp.Body.Close()
+				cancel()
+				return fmt.Errorf("%w (Content-Range %q for bytes %d-%d)", errRangeIgnored, resp.Header.Get("Content-Range"), pos, end)
+			}
+			body := newIdleTimeoutReader(resp.Body, g.stallTimeout(), cancel)
+			limited := &rateLimitedReader{ctx: ctx, r: io.LimitReader(body, end-pos+1), limiter: g.limiter}
+			n, err = io.Copy(io.NewOffsetWriter(out, pos), &progressReader{r: limited, bar: bar})
+			body.Stop()
+			resp.Body.Close()
+			pos += n
+			if err == nil && pos <= end {
+				err = fmt.Errorf("short body: range ended at %d of %d", pos, end+1)
+			}
+		}
+		cancel()
+		if err == nil {
+			break
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if isPermanent(err) {
+			return err
+		}
+		if n > 0 {
+			noProgress = 0
+			g.logger.Warn("%s: range interrupted at %d bytes - resuming (%v)", name, pos, err)
+			continue
+		}
+		noProgress++
+		g.logger.Warn("%s: no progress on bytes %d-%d (attempt %d/%d): %v", name, pos, end, noProgress, maxNoProgress, err)
+		if noProgress >= maxNoProgress {
+			return fmt.Errorf("failed to download: %w", err)
+		}
+		if err := sleepContext(ctx, 5*time.Second); err != nil {
+			return err
+		}
+	}
+	return nil
+}
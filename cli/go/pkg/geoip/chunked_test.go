@@ -0,0 +1,63 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestChunkedDownload verifies a file is fetched as parallel byte ranges
+// when the server supports them, and as one stream when it does not.
+func TestChunkedDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 3*minChunkSize/16+5)
+	var ranges, plain int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			if r.Header.Get("Range") != "" {
+				atomic.AddInt32(&ranges, 1)
+			} else {
+				atomic.AddInt32(&plain, 1)
+			}
+		}
+		if strings.HasPrefix(r.URL.Path, "/noranges") {
+			w.Write(content)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "a.BIN", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path          string
+		ranges, plain int32
+	}{
+		{"/a.BIN", 3, 0},
+		{"/noranges/a.BIN", 0, 1},
+	} {
+		atomic.StoreInt32(&ranges, 0)
+		atomic.StoreInt32(&plain, 0)
+		dir := t.TempDir()
+		cfg := &Config{TargetDir: dir, MaxRetries: 1, Timeout: 5 * time.Second, ChunksPerFile: 4}
+		g := &Updater{config: cfg, httpClient: newHTTPClient(cfg.Timeout, 1, &ConsoleLogger{quiet: true}), logger: &ConsoleLogger{quiet: true}, tempDir: t.TempDir()}
+
+		result := g.downloadDatabase(context.Background(), "a.BIN", srv.URL+tc.path, "")
+		if result.Error != nil {
+			t.Fatalf("%s: %v", tc.path, result.Error)
+		}
+		if data, _ := os.ReadFile(filepath.Join(dir, "a.BIN")); !bytes.Equal(data, content) {
+			t.Errorf("%s: downloaded %d bytes that differ from the %d served", tc.path, len(data), len(content))
+		}
+		// 3MB and change allows only three ranges of at least minChunkSize.
+		if ranges != tc.ranges || plain != tc.plain {
+			t.Errorf("%s: %d range and %d plain requests, want %d and %d", tc.path, ranges, plain, tc.ranges, tc.plain)
+		}
+	}
+}
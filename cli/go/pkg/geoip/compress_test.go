@@ -0,0 +1,179 @@
+package geoip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompressionLayers(t *testing.T) {
+	cases := []struct {
+		name, url, encoding, contentType string
+		want                             []string
+	}{
+		{"GeoIP2-City.mmdb", "https://cdn/GeoIP2-City.mmdb", "", "", nil},
+		{"GeoIP2-City.mmdb.gz", "https://cdn/x?sig=1", "", "", []string{"gzip"}},
+		{"GeoIP2-City.mmdb", "https://cdn/GeoIP2-City.mmdb.gz?X-Amz-Signature=abc", "", "", []string{"gzip"}},
+		{"GeoIP2-City.mmdb.gz", "https://cdn/x", "gzip", "", []string{"gzip", "gzip"}},
+		{"DB.BIN.zst", "https://cdn/x", "", "", []string{"zstd"}},
+		{"GeoIP2-City.mmdb", "https://cdn/x", "", "application/gzip", []string{"gzip"}},
+		{"GeoIP2-City.mmdb", "https://cdn/x", "", "application/x-gzip; charset=binary", []string{"gzip"}},
+		{"GeoIP2-City.mmdb.gz", "https://cdn/x", "", "application/gzip", []string{"gzip"}},
+		{"GeoIP2-City.mmdb", "https://cdn/x", "", "application/octet-stream", nil},
+	}
+	for _, c := range cases {
+		if got := compressionLayers(c.encoding, c.contentType, c.name, urlPath(c.url)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("compressionLayers(%q, %q, %q, %q) = %v, want %v", c.name, c.url, c.encoding, c.contentType, got, c.want)
+		}
+	}
+}
+
+// TestDownloadDatabaseGzip verifies a .gz download is stored decompressed
+// under the name without the suffix, that a transport-level gzip on top of a
+// .gz file is handled, and that a truncated stream fails without leaving a
+// half-written database behind.
+func TestDownloadDatabaseGzip(t *testing.T) {
+	content := bytes.Repeat([]byte("geoip-database-bytes "), 4096)
+	packed := gzipBytes(t, content)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/plain.gz":
+			w.Write(packed)
+		case "/double.gz":
+			// Transport gzip on top of an already gzipped file.
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gzipBytes(t, packed))
+		case "/truncated.gz":
+			w.Write(packed[:len(packed)/2])
+		case "/zstd.zst":
+			w.Write([]byte{0x28, 0xb5, 0x2f, 0xfd, 0, 0, 0, 0})
+		}
+	}))
+	defer srv.Close()
+
+	newUpdater := func(t *testing.T) *Updater {
+		logger := &ConsoleLogger{quiet: true}
+		cfg := &Config{TargetDir: t.TempDir(), Timeout: 10 * time.Second, MaxRetries: 1}
+		return &Updater{
+			config:     cfg,
+			httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+			logger:     logger,
+			tempDir:    t.TempDir(),
+		}
+	}
+
+	for _, path := range []string{"/plain.gz", "/double.gz"} {
+		t.Run(strings.TrimPrefix(path, "/"), func(t *testing.T) {
+			g := newUpdater(t)
+			res := g.downloadDatabase(context.Background(), "test.bin.gz", srv.URL+path, "")
+			if res.Error != nil {
+				t.Fatalf("unexpected error: %v", res.Error)
+			}
+			got, err := os.ReadFile(filepath.Join(g.config.TargetDir, "test.bin"))
+			if err != nil {
+				t.Fatalf("decompressed file missing: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatalf("content mismatch: %d bytes", len(got))
+			}
+			if res.Size != int64(len(content)) {
+				t.Fatalf("Size = %d, want decompressed %d", res.Size, len(content))
+			}
+		})
+	}
+
+	for _, c := range []struct{ path, name, wantErr string }{
+		{"/truncated.gz", "test.bin.gz", "truncated"},
+		{"/zstd.zst", "test.bin.zst", "zstd"},
+	} {
+		t.Run(strings.TrimPrefix(c.path, "/"), func(t *testing.T) {
+			g := newUpdater(t)
+			res := g.downloadDatabase(context.Background(), c.name, srv.URL+c.path, "")
+			if res.Error == nil || !strings.Contains(res.Error.Error(), c.wantErr) {
+				t.Fatalf("error = %v, want %q", res.Error, c.wantErr)
+			}
+			entries, _ := os.ReadDir(g.config.TargetDir)
+			if len(entries) != 0 {
+				t.Fatalf("TargetDir not empty after failure: %v", entries)
+			}
+		})
+	}
+}
+
+// TestCompressionModes verifies what each Config.Compression asks for and
+// that every mode checks the same checksum, of the decoded artifact, also
+// when the transport leaves a Content-Encoding in place (x-gzip).
+func TestCompressionModes(t *testing.T) {
+	content := bytes.Repeat([]byte("geoip-database-bytes "), 4096)
+	sum := sha256.Sum256(content)
+	var accepted string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepted = r.Header.Get("Accept-Encoding")
+		if r.URL.Path == "/x-gzip" {
+			w.Header().Set("Content-Encoding", "x-gzip")
+			w.Write(gzipBytes(t, content))
+			return
+		}
+		if strings.Contains(accepted, "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gzipBytes(t, content))
+			return
+		}
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	for _, c := range []struct{ mode, path, wantAccept string }{
+		{CompressionAuto, "/test.bin", "gzip"},
+		{CompressionOff, "/test.bin", ""},
+		{CompressionGzip, "/test.bin", "gzip"},
+		{CompressionAuto, "/x-gzip", "gzip"},
+		{CompressionGzip, "/x-gzip", "gzip"},
+	} {
+		t.Run(c.mode+c.path, func(t *testing.T) {
+			logger := &ConsoleLogger{quiet: true}
+			cfg := &Config{TargetDir: t.TempDir(), Timeout: 10 * time.Second, MaxRetries: 1, Compression: c.mode}
+			g := &Updater{
+				config:     cfg,
+				httpClient: newHTTPClientTLS(transportTimeouts{}, 1, nil, nil, c.mode == CompressionOff, logger),
+				logger:     logger,
+				tempDir:    t.TempDir(),
+			}
+			res := g.downloadDatabase(context.Background(), "test.bin", srv.URL+c.path, hex.EncodeToString(sum[:]))
+			if res.Error != nil {
+				t.Fatal(res.Error)
+			}
+			if accepted != c.wantAccept {
+				t.Errorf("Accept-Encoding = %q, want %q", accepted, c.wantAccept)
+			}
+			got, _ := os.ReadFile(filepath.Join(cfg.TargetDir, "test.bin"))
+			if !bytes.Equal(got, content) {
+				t.Errorf("stored %d bytes, want the %d decoded ones", len(got), len(content))
+			}
+		})
+	}
+}
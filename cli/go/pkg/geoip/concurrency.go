@@ -0,0 +1,108 @@
+package geoip
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// ConcurrencyAuto bounds: enough streams for a small container to fill its
+// link, few enough that a large build server does not flood the API.
+const (
+	autoConcurrencyMin = 2
+	autoConcurrencyMax = 8
+)
+
+// throttleThreshold is how many 429 responses an adaptive run tolerates
+// before halving its concurrency.
+const throttleThreshold = 2
+
+// maxConcurrent returns how many of databases may download at once: with
+// ConcurrencyAuto one per CPU within autoConcurrencyMin..autoConcurrencyMax
+// but no more than there are databases, otherwise MaxConcurrent (at least
+// 1).
+func (g *Updater) maxConcurrent(databases int) int {
+	if g.config.ConcurrencyAuto {
+		n := min(max(runtime.NumCPU(), autoConcurrencyMin), autoConcurrencyMax)
+		return max(min(n, databases), 1)
+	}
+	return max(g.config.MaxConcurrent, 1)
+}
+
+// slots limits concurrent downloads. Unlike a buffered channel the limit
+// can change mid-run: when it shrinks, downloads already running finish
+// and no new one starts until fewer than the new limit are active.
+type slots struct {
+	mu        sync.Mutex
+	limit     int
+	ceiling   int // the starting limit, which an adaptive run never exceeds
+	active    int
+	changed   chan struct{} // closed and replaced whenever a slot frees up
+	throttled int           // 429s since the limit last changed
+	completed int           // downloads finished since the limit last changed
+	adaptive  bool
+	logger    Logger
+}
+
+func newSlots(limit int, adaptive bool, logger Logger) *slots {
+	return &slots{limit: limit, ceiling: limit, changed: make(chan struct{}), adaptive: adaptive, logger: logger}
+}
+
+// acquire waits for a free slot, or returns ctx's error if it is cancelled
+// first.
+func (s *slots) acquire(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		if s.active < s.limit {
+			s.active++
+			s.mu.Unlock()
+			return nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release frees a slot. In adaptive mode a reduced limit grows back by one
+// each time as many downloads as it allows finish without a 429, the
+// additive half of AIMD. Downloads still draining from above the limit
+// do not count.
+func (s *slots) release() {
+	s.mu.Lock()
+	s.active--
+	if s.adaptive && s.limit < s.ceiling && s.active < s.limit {
+		s.completed++
+		if s.completed >= s.limit {
+			s.completed = 0
+			s.limit++
+			s.logger.Info("No rate limiting since the last reduction: raising concurrent downloads to %d", s.limit)
+		}
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// rateLimited records a 429 from the server. In adaptive mode every
+// throttleThreshold of them halve the limit, down to one download at a
+// time, and restart the count release uses to raise it again.
+func (s *slots) rateLimited() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.adaptive || s.limit == 1 {
+		return
+	}
+	s.throttled++
+	s.completed = 0
+	if s.throttled < throttleThreshold {
+		return
+	}
+	s.throttled = 0
+	s.limit = max(s.limit/2, 1)
+	s.logger.Warn("Server is rate limiting: reducing concurrent downloads to %d", s.limit)
+}
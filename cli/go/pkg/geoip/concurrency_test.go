@@ -0,0 +1,106 @@
+package geoip
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestSlotsRateLimited verifies adaptive slots halve after repeated 429s,
+// keep running downloads going but start no new one above the lower
+// limit, never drop below one, and grow back to the starting limit.
+func TestSlotsRateLimited(t *testing.T) {
+	s := newSlots(4, true, &ConsoleLogger{quiet: true})
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		if err := s.acquire(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.rateLimited()
+	if s.limit != 4 {
+		t.Errorf("limit %d after one 429, want 4", s.limit)
+	}
+	s.rateLimited()
+	if s.limit != 2 {
+		t.Errorf("limit %d after two 429s, want 2", s.limit)
+	}
+
+	got := make(chan struct{})
+	go func() {
+		s.acquire(ctx)
+		close(got)
+	}()
+	s.release()
+	s.release()
+	select {
+	case <-got:
+		t.Fatal("acquired a slot with 2 active at limit 2")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.release()
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("no slot after dropping below the limit")
+	}
+
+	for i := 0; i < 10; i++ {
+		s.rateLimited()
+	}
+	if s.limit != 1 {
+		t.Errorf("limit %d, want 1", s.limit)
+	}
+
+	// Additive increase: one more slot per round of 429-free downloads,
+	// back up to the starting limit and no further. Of the two downloads
+	// still running only the last to finish was within the limit.
+	s.release()
+	s.release()
+	if s.limit != 2 {
+		t.Fatalf("limit %d after draining, want 2", s.limit)
+	}
+	for _, want := range []int{2, 3, 3, 3, 4} {
+		s.acquire(ctx)
+		s.release()
+		if s.limit != want {
+			t.Fatalf("limit %d while recovering, want %d", s.limit, want)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		s.acquire(ctx)
+		s.release()
+	}
+	if s.limit != 4 {
+		t.Errorf("limit %d after recovering, want the starting 4", s.limit)
+	}
+
+	fixed := newSlots(4, false, &ConsoleLogger{quiet: true})
+	fixed.rateLimited()
+	fixed.rateLimited()
+	if fixed.limit != 4 {
+		t.Errorf("non-adaptive limit changed to %d", fixed.limit)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	full := newSlots(1, false, nil)
+	full.acquire(ctx)
+	if err := full.acquire(cancelled); err == nil {
+		t.Error("acquire ignored a cancelled context")
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	g := &Updater{config: &Config{ConcurrencyAuto: true}}
+	if n := g.maxConcurrent(20); n < autoConcurrencyMin || n > autoConcurrencyMax {
+		t.Errorf("auto = %d", n)
+	}
+	if n := g.maxConcurrent(1); n != 1 {
+		t.Errorf("auto for one database = %d, want 1", n)
+	}
+	g.config = &Config{}
+	if n := g.maxConcurrent(20); n != 1 {
+		t.Errorf("unset = %d, want 1", n)
+	}
+}
@@ -0,0 +1,277 @@
+package geoip
+
+import "time"
+
+// Config holds the updater configuration. LogFile, LogFormat, Color, Quiet,
+// Verbose, Debug, NoLock, LockTimeout, DryRun, VerifyOnly, HealthCheck, ShowURLs, Output,
+// MetricsFile, MetricsPushgateway, StatusFile, Daemon, Interval, Cron, Deadline, WebhookURL,
+// SlackWebhook, TeamsWebhook and WebhookOn are only consulted by NewLogger
+// and the CLI.
+type Config struct {
+	APIKey string
+	// AuthMode selects how API requests are authenticated (see
+	// NewAuthenticator): AuthAPIKey, the default, sends APIKey; AuthBearer
+	// sends Token, or a token obtained from TokenURL with the OAuth2 client
+	// credentials grant for OAuthClientID and OAuthClientSecret, requesting
+	// TokenScope if set.
+	AuthMode          string
+	Token             string
+	TokenURL          string
+	OAuthClientID     string
+	OAuthClientSecret string
+	TokenScope        string
+	// APIEndpoints are tried in order by authentication; each one gets the
+	// full MaxRetries before the next is used.
+	APIEndpoints []string
+	TargetDir    string
+	Databases    []string
+	LogFile      string
+	// LogFormat is "text" (the default) or "json" for one JSON object per
+	// log line.
+	LogFormat string
+	// Color is "always", "never" or "auto" (the default, also used when
+	// empty): color console log lines only on a terminal and when NO_COLOR
+	// is unset.
+	Color      string
+	MaxRetries int
+	// RetryBaseDelay, RetryMultiplier and RetryMaxDelay shape the jittered
+	// exponential backoff between retries (see Backoff); zero values mean
+	// 1s, 2 and 60s. A Retry-After header takes precedence.
+	RetryBaseDelay  time.Duration
+	RetryMultiplier float64
+	RetryMaxDelay   time.Duration
+	// RetryJitterSeed, when non-zero, makes the random waits below the
+	// backoff cap reproducible, for tests; zero seeds them per process.
+	RetryJitterSeed int64
+	// RetryMaxElapsed bounds the time spent retrying one request; whichever
+	// of it and MaxRetries is reached first ends the retries. Zero means no
+	// limit.
+	RetryMaxElapsed time.Duration
+	// RetryOn replaces the HTTP statuses that are retried (408, 429 and
+	// 5xx when empty); 401 and 403 always fail at once.
+	RetryOn []int
+	// Timeout is the deadline for each database's download, all attempts
+	// and resumes included, and for each API request; keep it generous for
+	// large databases and rely on StallTimeout to catch hung transfers.
+	// Zero means no deadline.
+	Timeout time.Duration
+	// RequestTimeout bounds each attempt of a request, response body
+	// included, so a retry or a resumed download starts with a fresh
+	// budget; Timeout still caps all of them together. Zero means no
+	// per-attempt limit.
+	RequestTimeout time.Duration
+	// ConnectTimeout bounds the TCP connect and, separately, the TLS
+	// handshake; ResponseHeaderTimeout bounds the wait for response headers
+	// once the request is sent. Zero means 30s for each.
+	ConnectTimeout        time.Duration
+	ResponseHeaderTimeout time.Duration
+	// Compression is CompressionAuto (the default, also used when empty),
+	// CompressionOff to request every body unencoded, or CompressionGzip to
+	// ask for gzip on single-stream downloads even where the transport
+	// would not. Which one is faster depends on the link and the CDN. In
+	// every mode the server's checksum is compared with the artifact as
+	// the server stores it: any gzip Content-Encoding is removed first,
+	// while compression that is part of the file (a .gz name or gzip
+	// Content-Type) is only removed after the check.
+	Compression string
+	// StallTimeout aborts (and resumes) a download that receives no bytes
+	// for this long; zero means 120s.
+	StallTimeout  time.Duration
+	MaxConcurrent int
+	// ConcurrencyAuto replaces MaxConcurrent with one download per CPU,
+	// between 2 and 8 and no more than there are databases, and adjusts
+	// it AIMD-style during the run: every second 429 Too Many Requests
+	// halves it (down to 1), and each time as many downloads as the
+	// current limit finish without one it grows back by one, up to where
+	// the run started.
+	ConcurrencyAuto bool
+	Quiet           bool
+	Verbose         bool
+	// Debug logs HTTP requests and responses (credentials redacted), retry
+	// decisions and attempt timings at DEBUG level, independently of
+	// Verbose.
+	Debug  bool
+	NoLock bool
+	// LockTimeout is how long the CLI waits for another instance's lock
+	// before giving up; zero fails immediately.
+	LockTimeout time.Duration
+	// Deadline is how long the CLI lets a whole update run take before
+	// cancelling it and reporting a failure; zero means no limit.
+	Deadline time.Duration
+	// DryRun lists what would be downloaded (see Updater.Plan) instead of
+	// downloading.
+	DryRun bool
+	// VerifyOnly reports whether local files match the server's versions
+	// (see Updater.Verify) instead of downloading.
+	VerifyOnly bool
+	// HealthCheck checks the local databases (see Updater.Health) instead
+	// of downloading.
+	HealthCheck bool
+	// ShowURLs makes a dry run print full download URLs instead of
+	// redacting their query strings.
+	ShowURLs bool
+	// Output selects the CLI's result format: "text" or "json".
+	Output string
+	// MaxAge makes --validate-only fail for databases built longer ago than
+	// this; zero disables the check. With OnlyIfStale it is instead the age
+	// (by modification time) below which Update leaves the files alone, and
+	// Health reports files modified longer ago as unhealthy.
+	MaxAge time.Duration
+	// OnlyIfStale makes Update a no-op, skipping authentication, unless a
+	// requested database is missing from TargetDir or was modified more
+	// than MaxAge ago.
+	OnlyIfStale bool
+	// MetricsFile is where the CLI writes Prometheus textfile metrics
+	// after each run; empty disables them.
+	MetricsFile string
+	// MetricsPushgateway is the Prometheus Pushgateway the CLI pushes the
+	// same metrics to after each run; empty disables it.
+	MetricsPushgateway string
+	// StatusFile is where the CLI keeps a JSON document describing the
+	// current phase and the last run's outcome; empty disables it.
+	StatusFile string
+	// Daemon keeps the CLI running, updating every Interval or, when Cron
+	// holds a five-field cron expression, at the times it selects.
+	Daemon   bool
+	Interval time.Duration
+	Cron     string
+	// WebhookURL receives a JSON run summary after each run, SlackWebhook
+	// a formatted Slack message and TeamsWebhook a Microsoft Teams
+	// MessageCard; WebhookOn limits all three to "success" or "failure"
+	// runs (default "always").
+	WebhookURL   string
+	SlackWebhook string
+	TeamsWebhook string
+	WebhookOn    string
+	// UserAgent is sent with every request; defaults to
+	// DefaultUserAgent("", false).
+	UserAgent string
+	// NoVerifyChecksum skips SHA256 verification for endpoints that don't
+	// return a "checksums" map in the /auth response.
+	NoVerifyChecksum bool
+	// TLSMinVersion is the oldest TLS version accepted: "1.2" (the
+	// default when empty) or "1.3".
+	TLSMinVersion string
+	// CACert is a PEM file of extra root CAs trusted alongside the system
+	// roots, e.g. for a private mirror.
+	CACert string
+	// CAOnly trusts only CACert, not the system roots.
+	CAOnly bool
+	// ClientCert and ClientKey are a PEM certificate and key presented for
+	// mutual TLS.
+	ClientCert string
+	ClientKey  string
+	// InsecureSkipVerify disables TLS certificate verification. For testing
+	// against self-signed endpoints only.
+	InsecureSkipVerify bool
+	// Proxy routes every request through an http://, https:// or
+	// socks5:// proxy URL, with optional user:password; empty means the
+	// HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables.
+	Proxy string
+	// NoProxy lists hosts reached directly whichever proxy applies:
+	// domains (matching subdomains too), IPs, CIDR ranges, or "*".
+	NoProxy []string
+	// Force ignores the cached ETag/Last-Modified sidecar and a local copy
+	// matching the server's checksum, and always downloads.
+	Force bool
+	// DeepValidate opens downloaded MaxMind databases (see InspectMMDB)
+	// instead of only checking for the metadata marker, and rejects those
+	// that fail.
+	DeepValidate bool
+	// MaxRate caps aggregate download throughput across all concurrent
+	// downloads, in bytes per second; zero means unlimited.
+	MaxRate int64
+	// ChunksPerFile splits each download into that many byte ranges fetched
+	// concurrently, when the server supports ranges (see fetchChunked);
+	// zero or one means a single stream.
+	ChunksPerFile int
+	// IfNewer sends a HEAD request before each download and keeps the
+	// existing file when the server's Last-Modified is not after the
+	// file's modification time (see DownloadResult.NotNewer).
+	IfNewer bool
+	// Manifest writes SHA256SUMS (or SHA512SUMS with ManifestAlgo
+	// "sha512") to TargetDir after each update, listing every installed
+	// database in the format "sha256sum -c" reads.
+	Manifest     bool
+	ManifestAlgo string
+	// ManifestJSON, when set, is where a JSON record of every installed
+	// database (file, size, SHA256, source URL without its query string,
+	// install time) is written after each fully successful update.
+	ManifestJSON string
+	// FailFast cancels the remaining downloads as soon as one database
+	// fails; by default every download finishes before Update reports the
+	// failures.
+	FailFast bool
+	// Backup keeps the previous database as <name>.bak after a successful
+	// replacement. Either way the previous database is restored if the
+	// replacement fails validation once in place.
+	Backup bool
+	// BackupCount keeps that many rotating backups (<name>.bak.1 newest)
+	// instead of a single <name>.bak.
+	BackupCount int
+	// Dated stores each database as <name>-<YYYYMMDD><ext> and makes
+	// <name><ext> a symlink to the newest one (a copy on Windows). Backup
+	// and BackupCount do not apply.
+	Dated bool
+	// NormalizeNames installs every database under its lowercased name
+	// ("geoip2-city.mmdb"); requests still use the server's name.
+	NormalizeNames bool
+	// StableNames installs databases the provider serves under dated names
+	// ("GeoIP2-City_20240115.mmdb") as served and makes the undated name
+	// a symlink to the newest one (a copy on Windows), so consumers can
+	// open a fixed path.
+	StableNames bool
+	// Keep prunes dated copies beyond the newest Keep after each run; zero
+	// keeps them all.
+	Keep int
+	// KeepVersions stores every newly installed database under
+	// <TargetDir>/versions/<name>.<timestamp><ext> (a hard link where
+	// possible) and removes all but the newest KeepVersions of each; zero
+	// stores none.
+	KeepVersions int
+	// PurgeUnknown removes, after a run in which every database succeeded,
+	// the .mmdb and .BIN files in TargetDir that the server did not list
+	// for this run, such as databases dropped from Databases. Dated copies
+	// of listed databases and the versions directory are kept.
+	PurgeUnknown bool
+	// AtomicDir updates a copy of TargetDir (<TargetDir>.new) and only
+	// swaps it in, keeping the previous directory as <TargetDir>.old, once
+	// every database has succeeded, so readers never see a mix of old and
+	// new databases. A failed run leaves TargetDir untouched.
+	AtomicDir bool
+	// S3Bucket, when set, receives a copy of every database after it has
+	// been downloaded and validated (or found unchanged), as
+	// S3Prefix/<file name>. Objects whose ETag already matches are not
+	// re-uploaded. Credentials come from the standard AWS chain.
+	S3Bucket string
+	S3Prefix string
+	// S3Region is the bucket's region; empty falls back to AWS_REGION,
+	// then AWS_DEFAULT_REGION, then us-east-1.
+	S3Region string
+	// S3Endpoint replaces AWS with an S3-compatible service such as MinIO
+	// ("http://minio:9000"), addressed path-style.
+	S3Endpoint string
+	// GCSBucket, when set, receives a copy of every database the same way
+	// as S3Bucket, as GCSPrefix/<file name> in Google Cloud Storage, with
+	// its SHA256 and source URL as object metadata. Objects whose MD5
+	// already matches are not re-uploaded. Credentials come from
+	// GCSCredentialsFile (a service account key) or Application Default
+	// Credentials.
+	GCSBucket          string
+	GCSPrefix          string
+	GCSCredentialsFile string
+	// SkipSpaceCheck disables the free-space check Update makes before
+	// downloading.
+	SkipSpaceCheck bool
+	// SpaceEstimate is the size, in bytes, assumed by the space check for a
+	// database whose HEAD request reports no Content-Length.
+	SpaceEstimate int64
+	// SpaceMargin (a percentage of the expected size) and SpaceMarginBytes
+	// are required free on top of the expected size by the space check.
+	SpaceMargin      int
+	SpaceMarginBytes int64
+	// Since skips databases not updated after this time, judged by the
+	// last_updated times in the /databases listing or, without them, by the
+	// local file's modification time. Zero downloads everything.
+	Since time.Time
+}
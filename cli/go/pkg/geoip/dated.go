@@ -0,0 +1,186 @@
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// datedLayout is the date stamped into dated file names.
+const datedLayout = "20060102"
+
+// datedName returns the dated copy of targetFile for t:
+// "GeoIP2-City.mmdb" becomes "GeoIP2-City-20240115.mmdb".
+func datedName(targetFile string, t time.Time) string {
+	ext := filepath.Ext(targetFile)
+	return strings.TrimSuffix(targetFile, ext) + "-" + t.Format(datedLayout) + ext
+}
+
+// datedSeparators may join the date to the stem: ours use "-", providers
+// also use "_" ("GeoIP2-City_20240115.mmdb") and ".".
+const datedSeparators = "-_."
+
+// isDatedName reports whether name is a dated copy of the file stem+ext.
+func isDatedName(name, stem, ext string) bool {
+	if len(name) != len(stem)+1+len(datedLayout)+len(ext) || !strings.HasPrefix(name, stem) ||
+		!strings.HasSuffix(name, ext) || !strings.ContainsRune(datedSeparators, rune(name[len(stem)])) {
+		return false
+	}
+	_, err := time.Parse(datedLayout, name[len(stem)+1:len(name)-len(ext)])
+	return err == nil
+}
+
+// stableName returns the file name without the provider's date stamp:
+// "GeoIP2-City_20240115.mmdb" becomes "GeoIP2-City.mmdb". It reports false
+// for names that carry no date.
+func stableName(name string) (string, bool) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if len(stem) <= len(datedLayout)+1 {
+		return "", false
+	}
+	base := stem[:len(stem)-len(datedLayout)-1]
+	if !isDatedName(name, base, ext) {
+		return "", false
+	}
+	return base + ext, true
+}
+
+// placeDated moves tempFile to today's dated copy of targetFile and points
+// targetFile at it. Older dated copies stay in place, so a copy that fails
+// verification is removed and targetFile keeps its previous version.
+func (g *Updater) placeDated(name, tempFile, targetFile string, size int64) error {
+	return g.placeLinked(name, tempFile, datedName(targetFile, time.Now().UTC()), targetFile, size)
+}
+
+// placeLinked moves tempFile to datedFile and, once it has been verified
+// there, repoints targetFile at it.
+func (g *Updater) placeLinked(name, tempFile, datedFile, targetFile string, size int64) error {
+	if err := syncFile(tempFile); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := os.Rename(tempFile, datedFile); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+	if err := g.verifyPlaced(datedFile, size); err != nil {
+		// A same-day re-run replaced the file targetFile points at; removing
+		// it would leave nothing behind.
+		if linkTarget(targetFile) != datedFile {
+			os.Remove(datedFile)
+		}
+		return err
+	}
+	if err := linkLatest(targetFile, datedFile); err != nil {
+		return fmt.Errorf("failed to update %s: %w", filepath.Base(targetFile), err)
+	}
+	if err := syncDir(filepath.Dir(targetFile)); err != nil {
+		g.logger.Warn("%s: failed to sync %s: %v", name, filepath.Dir(targetFile), err)
+	}
+	g.logger.Info("%s: %s -> %s", name, filepath.Base(targetFile), filepath.Base(datedFile))
+	return nil
+}
+
+// stableTarget returns the undated path for targetFile when StableNames is
+// set and the provider's file name carries a date.
+func (g *Updater) stableTarget(targetFile string) (string, bool) {
+	if !g.config.StableNames {
+		return "", false
+	}
+	stable, ok := stableName(filepath.Base(targetFile))
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(filepath.Dir(targetFile), stable), true
+}
+
+// linkLatest atomically replaces targetFile with a relative symlink to
+// datedFile, or with a copy of it on Windows (where symlinks need
+// privileges) and on filesystems without symlinks.
+func linkLatest(targetFile, datedFile string) error {
+	tmp := targetFile + ".link.tmp"
+	os.Remove(tmp)
+	err := errors.New("symlinks not used on windows")
+	if runtime.GOOS != "windows" {
+		err = os.Symlink(filepath.Base(datedFile), tmp)
+	}
+	if err != nil {
+		if err := copyFile(datedFile, tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, targetFile); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// linkTarget returns the path targetFile links to, or "" if it is not a
+// symlink.
+func linkTarget(targetFile string) string {
+	link, err := os.Readlink(targetFile)
+	if err != nil {
+		return ""
+	}
+	if !filepath.IsAbs(link) {
+		link = filepath.Join(filepath.Dir(targetFile), link)
+	}
+	return link
+}
+
+// pruneDated removes all but the newest keep dated copies of targetFile,
+// never the one targetFile points at, and returns the removed paths.
+func pruneDated(targetFile string, keep int) ([]string, error) {
+	dir := filepath.Dir(targetFile)
+	ext := filepath.Ext(targetFile)
+	stem := strings.TrimSuffix(filepath.Base(targetFile), ext)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var dated []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && isDatedName(e.Name(), stem, ext) {
+			dated = append(dated, filepath.Join(dir, e.Name()))
+		}
+	}
+	// The date sorts lexically, newest first.
+	sort.Sort(sort.Reverse(sort.StringSlice(dated)))
+
+	current := linkTarget(targetFile)
+	var removed []string
+	for i, path := range dated {
+		if i < keep || path == current {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// pruneVersions applies Config.Keep to every database in results.
+func (g *Updater) pruneVersions(results []DownloadResult) {
+	for _, r := range results {
+		if r.Error != nil || r.Path == "" {
+			continue
+		}
+		removed, err := pruneDated(r.Path, g.config.Keep)
+		for _, path := range removed {
+			g.logger.Info("Pruned old version: %s", filepath.Base(path))
+		}
+		if err != nil {
+			g.logger.Warn("%s: failed to prune old versions: %v", r.Database, err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestDatedName(t *testing.T) {
+	day := time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC)
+	if got := datedName("/data/GeoIP2-City.mmdb", day); got != "/data/GeoIP2-City-20240115.mmdb" {
+		t.Errorf("datedName = %q", got)
+	}
+	for name, want := range map[string]bool{
+		"GeoIP2-City-20240115.mmdb":          true,
+		"GeoIP2-City_20240115.mmdb":          true,
+		"GeoIP2-City.mmdb":                   false,
+		"GeoIP2-City-2024011.mmdb":           false,
+		"GeoIP2-City-20241315.mmdb":          false,
+		"GeoIP2-City-Extra-20240115.mmdb":    false,
+		"GeoIP2-City-20240115.mmdb.bak":      false,
+		"GeoIP2-City-20240115.mmdb.link.tmp": false,
+	} {
+		if got := isDatedName(name, "GeoIP2-City", ".mmdb"); got != want {
+			t.Errorf("isDatedName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestStableName(t *testing.T) {
+	for name, want := range map[string]string{
+		"GeoIP2-City_20240115.mmdb": "GeoIP2-City.mmdb",
+		"DB11-20240115.BIN":         "DB11.BIN",
+		"GeoIP2-City.20240115.mmdb": "GeoIP2-City.mmdb",
+		"GeoIP2-City.mmdb":          "",
+		"GeoIP2-City_2024.mmdb":     "",
+		"20240115.mmdb":             "",
+	} {
+		got, ok := stableName(name)
+		if got != want || ok != (want != "") {
+			t.Errorf("stableName(%q) = %q, %v, want %q", name, got, ok, want)
+		}
+	}
+}
+
+func TestPruneDated(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "a.db")
+	for _, date := range []string{"20240101", "20240102", "20240103", "20240104"} {
+		os.WriteFile(filepath.Join(dir, "a-"+date+".db"), []byte(date), 0644)
+	}
+	os.WriteFile(filepath.Join(dir, "ab-20240101.db"), []byte("other"), 0644)
+	// The link still points at an older copy, which must survive.
+	if err := linkLatest(target, filepath.Join(dir, "a-20240101.db")); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := pruneDated(target, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "a-20240102.db")}
+	if runtime.GOOS == "windows" {
+		// Copies don't record their source, so nothing is protected.
+		want = append(want, filepath.Join(dir, "a-20240101.db"))
+	}
+	if len(removed) != len(want) || removed[0] != want[0] {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	for _, name := range []string{"a-20240103.db", "a-20240104.db", "ab-20240101.db", "a.db"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// TestUpdateDated verifies a dated run installs the database under today's
+// name, points the stable name at it and prunes beyond Keep.
+func TestUpdateDated(t *testing.T) {
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" {
+			json.NewEncoder(w).Encode(map[string]string{"a.db": srvURL + "/a"})
+			return
+		}
+		w.Write([]byte("new"))
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a-20000101.db"), []byte("oldest"), 0644)
+	os.WriteFile(filepath.Join(dir, "a-20000102.db"), []byte("old"), 0644)
+	cfg := &Config{
+		APIEndpoints:   []string{srv.URL + "/auth"},
+		TargetDir:      dir,
+		Timeout:        10 * time.Second,
+		MaxRetries:     1,
+		MaxConcurrent:  1,
+		Dated:          true,
+		Keep:           2,
+		SkipSpaceCheck: true,
+	}
+	updater, err := New(cfg, Options{HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updater.Close()
+	if _, err := updater.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	dated := datedName(filepath.Join(dir, "a.db"), time.Now().UTC())
+	if data, err := os.ReadFile(filepath.Join(dir, "a.db")); err != nil || string(data) != "new" {
+		t.Errorf("a.db = %q, %v", data, err)
+	}
+	if runtime.GOOS != "windows" && linkTarget(filepath.Join(dir, "a.db")) != dated {
+		t.Errorf("a.db links to %q, want %q", linkTarget(filepath.Join(dir, "a.db")), dated)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a-20000102.db")); err != nil {
+		t.Errorf("newest old copy pruned: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a-20000101.db")); !os.IsNotExist(err) {
+		t.Errorf("oldest copy not pruned: %v", err)
+	}
+}
+
+// TestUpdateStableNames verifies a database served under a dated name is
+// installed as served, the undated name follows it and Keep prunes the
+// provider's older versions.
+func TestUpdateStableNames(t *testing.T) {
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" {
+			json.NewEncoder(w).Encode(map[string]string{"a_20240115.db": srvURL + "/a", "b.db": srvURL + "/b"})
+			return
+		}
+		w.Write([]byte("new"))
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a_20231201.db"), []byte("oldest"), 0644)
+	os.WriteFile(filepath.Join(dir, "a_20240101.db"), []byte("old"), 0644)
+	if err := linkLatest(filepath.Join(dir, "a.db"), filepath.Join(dir, "a_20240101.db")); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		APIEndpoints:   []string{srv.URL + "/auth"},
+		TargetDir:      dir,
+		Timeout:        10 * time.Second,
+		MaxRetries:     1,
+		MaxConcurrent:  1,
+		StableNames:    true,
+		Keep:           2,
+		SkipSpaceCheck: true,
+	}
+	updater, err := New(cfg, Options{HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updater.Close()
+	results, err := updater.Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stable := filepath.Join(dir, "a.db")
+	if results[0].Path != stable {
+		t.Errorf("result path %q, want %q", results[0].Path, stable)
+	}
+	if data, err := os.ReadFile(stable); err != nil || string(data) != "new" {
+		t.Errorf("a.db = %q, %v", data, err)
+	}
+	if runtime.GOOS != "windows" && linkTarget(stable) != filepath.Join(dir, "a_20240115.db") {
+		t.Errorf("a.db links to %q", linkTarget(stable))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a_20240101.db")); err != nil {
+		t.Errorf("previous version pruned: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a_20231201.db")); !os.IsNotExist(err) {
+		t.Errorf("oldest version not pruned: %v", err)
+	}
+	if fi, err := os.Lstat(filepath.Join(dir, "b.db")); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("undated b.db not installed as a plain file: %v", err)
+	}
+}
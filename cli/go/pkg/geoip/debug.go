@@ -0,0 +1,83 @@
+package geoip
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sensitiveHeaders are logged as "REDACTED" by debugTransport.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":        true,
+	"Proxy-Authorization":  true,
+	"Cookie":               true,
+	"Set-Cookie":           true,
+	"X-Api-Key":            true,
+	"X-Amz-Security-Token": true,
+}
+
+// debugTransport logs every round trip at DEBUG level: the request line and
+// headers, then the response status, headers and time to first byte, or
+// the error. Redirects go through RoundTrip hop by hop, so the chain shows
+// up as consecutive entries.
+type debugTransport struct {
+	next   http.RoundTripper
+	logger Logger
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	logFields(t.logger, "DEBUG", fmt.Sprintf("HTTP > %s %s %s", req.Method, redactURL(req.URL.String()), formatHeaders(req.Header)),
+		map[string]interface{}{"method": req.Method, "url": redactURL(req.URL.String())})
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		logFields(t.logger, "DEBUG", fmt.Sprintf("HTTP < %s %s failed after %v: %v", req.Method, redactURL(req.URL.String()), elapsed, err),
+			map[string]interface{}{"method": req.Method, "url": redactURL(req.URL.String()), "duration_ms": elapsed.Milliseconds()})
+		return nil, err
+	}
+	logFields(t.logger, "DEBUG", fmt.Sprintf("HTTP < %s in %v %s", resp.Status, elapsed, formatHeaders(resp.Header)),
+		map[string]interface{}{"method": req.Method, "url": redactURL(req.URL.String()), "status": resp.StatusCode, "duration_ms": elapsed.Milliseconds()})
+	return resp, nil
+}
+
+// formatHeaders renders h as "{Name: value; ...}" in name order, with
+// credentials and the query strings of Location and Referer redacted.
+func formatHeaders(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.Join(h[name], ", ")
+		switch {
+		case sensitiveHeaders[http.CanonicalHeaderKey(name)]:
+			value = "REDACTED"
+		case http.CanonicalHeaderKey(name) == "Location", http.CanonicalHeaderKey(name) == "Referer":
+			value = redactURL(value)
+		}
+		parts = append(parts, name+": "+value)
+	}
+	return "{" + strings.Join(parts, "; ") + "}"
+}
+
+// withDebug returns client with its transport wrapped in a debugTransport
+// when logger wants DEBUG messages, and client itself otherwise. The
+// caller's client is copied, not modified.
+func withDebug(client *http.Client, logger Logger) *http.Client {
+	if logger == nil || !debugEnabled(logger) {
+		return client
+	}
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = &debugTransport{next: next, logger: logger}
+	return &c
+}
@@ -0,0 +1,73 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestDebugLogging verifies --debug logs the redirect chain, retry
+// decisions and headers without leaking credentials, and that nothing is
+// logged without it.
+func TestDebugLogging(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/start":
+			http.Redirect(w, r, "/db?X-Amz-Signature=secret", http.StatusFound)
+		default:
+			attempts++
+			if attempts == 1 {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer srv.Close()
+
+	for _, debug := range []bool{true, false} {
+		attempts = 0
+		var out bytes.Buffer
+		logger, err := NewLogger(&Config{Debug: debug, Quiet: true}, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := NewHTTPClient(srv.Client(), 2, logger)
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", srv.URL+"/start?token=secret", nil)
+		req.Header.Set("X-API-Key", "secret")
+		resp, err := h.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		got := out.String()
+		if !debug {
+			if got != "" {
+				t.Errorf("logged without debug:\n%s", got)
+			}
+			continue
+		}
+		if strings.Contains(got, "secret") {
+			t.Errorf("credentials leaked:\n%s", got)
+		}
+		for _, want := range []string{
+			"[DEBUG] HTTP > GET " + srv.URL + "/start?REDACTED",
+			"X-Api-Key: REDACTED",
+			"HTTP < 302 Found",
+			"Location: /db?REDACTED",
+			"HTTP < 503 Service Unavailable",
+			"HTTP 503 is retryable",
+			"Attempt 2/2",
+			"HTTP < 200 OK",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("debug log lacks %q:\n%s", want, got)
+			}
+		}
+	}
+}
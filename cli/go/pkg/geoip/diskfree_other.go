@@ -0,0 +1,6 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package geoip
+
+// diskFree is not implemented on this platform; the space check is skipped.
+func diskFree(path string) (uint64, error) { return 0, errDiskFreeUnsupported }
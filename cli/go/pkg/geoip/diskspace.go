@@ -0,0 +1,106 @@
+package geoip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var errDiskFreeUnsupported = errors.New("free space cannot be determined on this platform")
+
+// ErrInsufficientSpace is returned (wrapped) by Update when the expected
+// download size does not fit in TargetDir.
+var ErrInsufficientSpace = errors.New("insufficient disk space")
+
+// checkDiskSpace sums the expected size of every download (see
+// expectedSizes) and fails before anything is written if TargetDir's
+// filesystem (which also holds the staging directory) cannot hold it plus a
+// margin. Databases whose size is unknown count as SpaceEstimate bytes.
+func (g *Updater) checkDiskSpace(sizes map[string]int64) error {
+	var total int64
+	var unknown int
+	for _, size := range sizes {
+		if size < 0 {
+			unknown++
+			size = g.config.SpaceEstimate
+		}
+		total += size
+	}
+	if unknown > 0 {
+		g.logger.Info("Size unknown for %d of %d databases, assuming %s each", unknown, len(sizes), formatBytes(g.config.SpaceEstimate))
+	}
+
+	return g.requireSpace("target directory", g.config.TargetDir, total)
+}
+
+// spaceMargin returns the headroom required on top of need: SpaceMargin
+// percent of it plus SpaceMarginBytes, so filesystem overhead and
+// decompression growth don't leave the disk completely full.
+func (g *Updater) spaceMargin(need int64) int64 {
+	return need*int64(g.config.SpaceMargin)/100 + g.config.SpaceMarginBytes
+}
+
+// requireSpace checks that dir has need bytes plus the safety margin free.
+func (g *Updater) requireSpace(what, dir string, need int64) error {
+	if need <= 0 || dir == "" {
+		return nil
+	}
+	margin := g.spaceMargin(need)
+	need += margin
+	free, err := diskFree(dir)
+	if err != nil {
+		g.logger.Info("Skipping disk space check for %s: %v", dir, err)
+		return nil
+	}
+	if uint64(need) > free {
+		return fmt.Errorf("%w in %s %s: need %s (including %s margin), %s available; free up space or use --skip-space-check",
+			ErrInsufficientSpace, what, dir, formatBytes(need), formatBytes(margin), formatBytes(int64(free)))
+	}
+	g.logger.Info("Disk space OK for %s: need %s, %s available", dir, formatBytes(need), formatBytes(int64(free)))
+	return nil
+}
+
+// announceDownloads logs how much an update is about to fetch.
+func (g *Updater) announceDownloads(sizes map[string]int64) {
+	var total int64
+	var unknown int
+	for _, size := range sizes {
+		if size < 0 {
+			unknown++
+			continue
+		}
+		total += size
+	}
+	switch {
+	case unknown == len(sizes):
+		g.logger.Info("About to download %d databases (sizes unknown)", len(sizes))
+	case unknown > 0:
+		g.logger.Info("About to download %d databases totaling at least %s (size unknown for %d)", len(sizes), formatBytes(total), unknown)
+	default:
+		g.logger.Info("About to download %d databases totaling %s", len(sizes), formatBytes(total))
+	}
+}
+
+// expectedSizes issues the HEAD requests for urls concurrently, at most
+// maxConcurrent at a time. Unknown sizes are -1.
+func (g *Updater) expectedSizes(ctx context.Context, urls map[string]string) map[string]int64 {
+	sizes := make(map[string]int64, len(urls))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, g.maxConcurrent(len(urls)))
+	for name, rawURL := range urls {
+		wg.Add(1)
+		go func(name, rawURL string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			size := g.headSize(ctx, name, rawURL)
+			<-semaphore
+			mu.Lock()
+			sizes[name] = size
+			mu.Unlock()
+		}(name, rawURL)
+	}
+	wg.Wait()
+	return sizes
+}
@@ -0,0 +1,161 @@
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestUpdateInsufficientSpace verifies Update refuses to start when the
+// announced sizes (or SpaceEstimate for unknown ones) exceed free space, and
+// that --skip-space-check style configs bypass the check.
+func TestUpdateInsufficientSpace(t *testing.T) {
+	var srvURL string
+	var gets int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/auth":
+			json.NewEncoder(w).Encode(map[string]string{
+				"huge.mmdb":    srvURL + "/huge",
+				"unknown.mmdb": srvURL + "/unknown",
+			})
+		case r.Method == http.MethodHead && r.URL.Path == "/huge":
+			w.Header().Set("Content-Length", "1152921504606846976") // 1 EiB
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		default:
+			gets++
+			io.WriteString(w, "database")
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	run := func(cfg *Config) error {
+		cfg.APIKey = "test"
+		cfg.APIEndpoints = []string{srv.URL + "/auth"}
+		cfg.TargetDir = t.TempDir()
+		cfg.Timeout = 10 * time.Second
+		cfg.MaxRetries = 1
+		updater, err := New(cfg, Options{HTTPClient: srv.Client(), LogOutput: io.Discard})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer updater.Close()
+		_, err = updater.Update(context.Background())
+		entries, _ := os.ReadDir(cfg.TargetDir)
+		if err != nil && len(entries) != 0 {
+			t.Errorf("files written despite failed space check: %v", entries)
+		}
+		return err
+	}
+
+	if _, err := diskFree(t.TempDir()); err != nil {
+		t.Skipf("free space unavailable: %v", err)
+	}
+	if err := run(&Config{}); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("announced size: err = %v, want ErrInsufficientSpace", err)
+	}
+	if gets != 0 {
+		t.Errorf("%d downloads started despite failed space check", gets)
+	}
+
+	if err := run(&Config{SkipSpaceCheck: true}); err != nil {
+		t.Errorf("SkipSpaceCheck: %v", err)
+	}
+}
+
+// TestAnnounceDownloads verifies the pre-flight logs the total announced
+// size, and that servers refusing HEAD only make the total a lower bound.
+func TestAnnounceDownloads(t *testing.T) {
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/auth":
+			json.NewEncoder(w).Encode(map[string]string{"a.mmdb": srvURL + "/a", "b.mmdb": srvURL + "/b"})
+		case r.Method == http.MethodHead && r.URL.Path == "/b":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		default:
+			io.WriteString(w, strings.Repeat("x", 2048))
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	rec := &recordingLogger{}
+	cfg := &Config{
+		APIEndpoints:   []string{srv.URL + "/auth"},
+		TargetDir:      t.TempDir(),
+		Timeout:        10 * time.Second,
+		MaxRetries:     1,
+		SkipSpaceCheck: true,
+	}
+	updater, err := New(cfg, Options{HTTPClient: srv.Client(), Logger: rec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updater.Close()
+	if _, err := updater.Update(context.Background()); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	want := "INFO About to download 2 databases totaling at least " + formatBytes(2048) + " (size unknown for 1)"
+	for _, line := range rec.lines {
+		if line == want {
+			return
+		}
+	}
+	t.Errorf("no %q in log:\n%s", want, strings.Join(rec.lines, "\n"))
+}
+
+// TestCheckDiskSpaceEstimate verifies databases without a Content-Length
+// count as SpaceEstimate bytes.
+func TestCheckDiskSpaceEstimate(t *testing.T) {
+	if _, err := diskFree(t.TempDir()); err != nil {
+		t.Skipf("free space unavailable: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden) // presigned for GET only
+	}))
+	defer srv.Close()
+
+	logger := &ConsoleLogger{quiet: true}
+	for estimate, wantErr := range map[int64]bool{0: false, 1 << 60: true} {
+		g := &Updater{
+			config:     &Config{TargetDir: t.TempDir(), MaxConcurrent: 1, SpaceEstimate: estimate},
+			httpClient: newHTTPClient(time.Second, 1, logger),
+			logger:     logger,
+			tempDir:    t.TempDir(),
+		}
+		err := g.checkDiskSpace(g.expectedSizes(context.Background(), map[string]string{"a.BIN": srv.URL}))
+		if (err != nil) != wantErr {
+			t.Errorf("estimate %d: err = %v", estimate, err)
+		}
+	}
+}
+
+// TestCheckDiskSpaceMargin verifies the configured margin is required on
+// top of the expected size.
+func TestCheckDiskSpaceMargin(t *testing.T) {
+	if _, err := diskFree(t.TempDir()); err != nil {
+		t.Skipf("free space unavailable: %v", err)
+	}
+	logger := &ConsoleLogger{quiet: true}
+	g := &Updater{config: &Config{SpaceMarginBytes: 1 << 60}, logger: logger}
+	if err := g.requireSpace("target directory", t.TempDir(), 1); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("byte margin: err = %v", err)
+	}
+	g.config = &Config{SpaceMargin: 10}
+	if got := g.spaceMargin(1000); got != 100 {
+		t.Errorf("10%% of 1000 = %d", got)
+	}
+	if err := g.requireSpace("target directory", t.TempDir(), 1000); err != nil {
+		t.Errorf("small download: %v", err)
+	}
+}
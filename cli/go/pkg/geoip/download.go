@@ -0,0 +1,1017 @@
+package geoip
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// downloadDatabase fetches url into the temp directory and moves it to
+// TargetDir. When checksum (SHA256 hex) is non-empty the temp file must match
+// it before the move, so a corrupt download never lands in TargetDir; a
+// mismatch discards the file and re-downloads up to MaxRetries times.
+// Gzip-compressed downloads are decompressed and stored without their
+// .gz suffix, and tar archives (MaxMind permalinks, IP2Location bundles) are
+// reduced to the .mmdb or .BIN they contain.
+func (g *Updater) downloadDatabase(ctx context.Context, name, url, checksum string) DownloadResult {
+	g.logger.Info("Downloading: %s", name)
+	parent := ctx
+	ctx, cancel := g.requestContext(ctx)
+	defer cancel()
+
+	tempFile := filepath.Join(g.tempDir, name)
+	asServed := stripArchiveSuffix(stripCompressionSuffix(name)) == name
+	targetFile := filepath.Join(g.config.TargetDir, g.storedName(name))
+
+	maxVerify := g.config.MaxRetries
+	if maxVerify < 1 {
+		maxVerify = 1
+	}
+
+	// Only revalidate against the cached validators while the file they
+	// describe is still in place; otherwise a 304 would leave nothing behind.
+	// A size mismatch means something else replaced the file since.
+	var cached *cacheMeta
+	if !g.config.Force {
+		if fi, err := os.Stat(targetFile); err == nil && fi.Size() > 0 {
+			cached = readCacheMeta(targetFile)
+			if cached != nil && cached.Size != 0 && cached.Size != fi.Size() {
+				g.logger.Info("%s: cached metadata does not match the existing file, ignoring it", name)
+				cached = nil
+			}
+			if cached != nil {
+				cached.Size = fi.Size()
+			}
+		}
+	}
+
+	if g.config.IfNewer && !g.config.Force {
+		if result, ok := g.notNewer(ctx, name, url, targetFile, cached); ok {
+			return result
+		}
+	}
+
+	// A local copy with the server's checksum is current however it got
+	// there. The checksum covers the bytes as served, so this only applies
+	// to databases stored without decompression or extraction.
+	if checksum != "" && !g.config.Force && !g.config.NoVerifyChecksum && asServed {
+		if fi, err := os.Stat(targetFile); err == nil && fi.Size() > 0 {
+			if local, err := fileSHA256(targetFile); err == nil && strings.EqualFold(local, checksum) {
+				g.logger.Info("%s: local copy matches the server's checksum", name)
+				return DownloadResult{Database: name, Size: fi.Size(), Unchanged: true, SHA256: local, Path: targetFile}
+			}
+		}
+	}
+
+	var size int64
+	var sum string
+	var meta *cacheMeta
+	for verifyAttempt := 1; ; verifyAttempt++ {
+		var err error
+		if g.config.ChunksPerFile > 1 {
+			meta, err = g.fetchChunked(ctx, name, url, tempFile, cached)
+		} else {
+			meta, err = g.fetchToFile(ctx, name, url, tempFile, cached)
+		}
+		if errors.Is(err, errNotModified) {
+			g.logger.Info("%s: not modified since last download", name)
+			path := targetFile
+			if stable, ok := g.stableTarget(targetFile); ok {
+				path = stable
+				if _, err := os.Lstat(stable); err != nil {
+					if err := linkLatest(stable, targetFile); err != nil {
+						return DownloadResult{Database: name, Error: fmt.Errorf("failed to update %s: %w", filepath.Base(stable), err)}
+					}
+				}
+			}
+			return DownloadResult{Database: name, Size: cached.Size, Unchanged: true, SHA256: cached.SHA256, Path: path}
+		}
+		if err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && parent.Err() == nil {
+				err = fmt.Errorf("download did not finish within %v: %w", g.config.Timeout, err)
+			}
+			return DownloadResult{Database: name, Error: err}
+		}
+		cached = nil // a retry after a bad checksum must fetch the body
+
+		// A gzip Content-Encoding, asked for or left by the transport,
+		// belongs to the transfer, not the artifact the checksum describes.
+		if meta.encoding == "gzip" || meta.encoding == "x-gzip" {
+			if err := g.decodeTransfer(name, tempFile); err != nil {
+				os.Remove(tempFile)
+				return DownloadResult{Database: name, Error: err}
+			}
+			meta.encoding = ""
+		}
+
+		fi, err := os.Stat(tempFile)
+		if err != nil || fi.Size() == 0 {
+			return DownloadResult{Database: name, Error: fmt.Errorf("downloaded file is empty")}
+		}
+		size = fi.Size()
+
+		// An expired presigned URL can yield an error document with a 200.
+		if err := sniffErrorBody(tempFile); err != nil {
+			os.Remove(tempFile)
+			return DownloadResult{Database: name, Error: err}
+		}
+
+		sum, err = g.verifyChecksum(name, tempFile, checksum)
+		if err == nil {
+			break
+		}
+		os.Remove(tempFile)
+		if verifyAttempt >= maxVerify {
+			return DownloadResult{Database: name, Error: fmt.Errorf("%w (after %d attempts)", err, verifyAttempt)}
+		}
+		g.logger.Warn("%s: %v - re-downloading (attempt %d/%d)", name, err, verifyAttempt+1, maxVerify)
+	}
+
+	// The checksum covers the bytes as served; decompress afterwards so
+	// validation and TargetDir see the database, not the compressed blob.
+	if layers := compressionLayers(meta.encoding, meta.contentType, name, urlPath(url), meta.filename); len(layers) > 0 {
+		if err := decompressFile(tempFile, layers); err != nil {
+			os.Remove(tempFile)
+			return DownloadResult{Database: name, Error: err}
+		}
+		fi, err := os.Stat(tempFile)
+		if err != nil || fi.Size() == 0 {
+			return DownloadResult{Database: name, Error: fmt.Errorf("decompressed file is empty")}
+		}
+		if fi.Size() != size {
+			g.logger.Info("%s: decompressed %d -> %d bytes", name, size, fi.Size())
+		}
+		size = fi.Size()
+		sum = ""
+		meta.contentType = ""
+	}
+
+	// Archives carry the database inside a dated directory; keep only it.
+	if isTarArchive(tempFile) {
+		entry, err := extractArchive(tempFile, filepath.Base(targetFile))
+		if err != nil {
+			os.Remove(tempFile)
+			return DownloadResult{Database: name, Error: err}
+		}
+		g.logger.Info("%s: extracted %s from archive", name, entry)
+		if !isDatabaseFile(targetFile) {
+			// The requested name was the archive itself; use the member's.
+			targetFile = filepath.Join(g.config.TargetDir, g.normalizeName(filepath.Base(entry)))
+		}
+		if fi, err := os.Stat(tempFile); err == nil {
+			size = fi.Size()
+		}
+		sum = ""
+		meta.contentType = ""
+	}
+	// The served checksum covered the compressed or archived bytes; report
+	// the digest of what is actually installed.
+	if sum == "" && !g.config.NoVerifyChecksum {
+		if digest, err := fileSHA256(tempFile); err == nil {
+			sum = digest
+		}
+	}
+
+	// Basic validation for MMDB files; deep validation rejects a database
+	// whose metadata or search tree is broken.
+	if strings.HasSuffix(targetFile, ".mmdb") {
+		if g.config.DeepValidate {
+			info, err := InspectMMDB(tempFile)
+			if err != nil {
+				os.Remove(tempFile)
+				return DownloadResult{Database: name, Error: fmt.Errorf("MMDB validation failed: %w", err)}
+			}
+			g.logger.Info("%s: %s, built %s, %d-bit records", name, info.DatabaseType, info.BuildEpoch.Format("2006-01-02"), info.RecordSize)
+		} else if err := ValidateMMDB(tempFile); err != nil {
+			g.logger.Warn("MMDB validation warning for %s: %v", name, err)
+		}
+	}
+	if strings.EqualFold(filepath.Ext(targetFile), ".bin") {
+		if info, err := InspectBIN(tempFile); err != nil {
+			g.logger.Warn("BIN validation warning for %s: %v", name, err)
+		} else {
+			g.logger.Info("%s: %s, built %s", name, info.Name(), info.Date.Format("2006-01-02"))
+		}
+	}
+
+	// Dated copies keep their own history, so they need no backup.
+	if g.config.Dated {
+		if err := g.placeDated(name, tempFile, targetFile, size); err != nil {
+			return DownloadResult{Database: name, Error: err}
+		}
+		return g.installed(name, targetFile, size, sum, meta)
+	}
+	// Provider-dated names are installed as served, with the undated name
+	// pointing at the newest.
+	if stable, ok := g.stableTarget(targetFile); ok {
+		if err := g.placeLinked(name, tempFile, targetFile, stable, size); err != nil {
+			return DownloadResult{Database: name, Error: err}
+		}
+		result := g.installed(name, targetFile, size, sum, meta)
+		result.Path = stable
+		return result
+	}
+
+	// Keep the previous database as <name>.bak so a bad replacement can be
+	// rolled back. Without Backup the copy only lasts until the new file
+	// has been verified in place.
+	backedUp, err := backupExisting(targetFile, g.backupCount())
+	if err != nil {
+		os.Remove(tempFile)
+		return DownloadResult{Database: name, Error: err}
+	}
+
+	// Flush the database to disk before it replaces the old one, then make
+	// the rename itself durable, so a crash leaves either the old file or
+	// the complete new one - never a torn or empty database.
+	if err := syncFile(tempFile); err != nil {
+		os.Remove(tempFile)
+		return g.rollback(name, targetFile, backedUp, fmt.Errorf("failed to sync file: %w", err))
+	}
+	if err := os.Rename(tempFile, targetFile); err != nil {
+		os.Remove(tempFile)
+		return g.rollback(name, targetFile, backedUp, fmt.Errorf("failed to move file: %w", err))
+	}
+	if err := syncDir(filepath.Dir(targetFile)); err != nil {
+		g.logger.Warn("%s: failed to sync %s: %v", name, filepath.Dir(targetFile), err)
+	}
+
+	// Check the installed file before letting the backup go.
+	if backedUp {
+		if err := g.verifyPlaced(targetFile, size); err != nil {
+			return g.rollback(name, targetFile, backedUp, err)
+		}
+		if !g.config.Backup {
+			discardBackup(targetFile)
+		}
+	}
+
+	return g.installed(name, targetFile, size, sum, meta)
+}
+
+// installed records the validators of a newly installed database and
+// returns its successful result.
+func (g *Updater) installed(name, targetFile string, size int64, sum string, meta *cacheMeta) DownloadResult {
+	meta.Size = size
+	meta.SHA256 = sum
+	if err := writeCacheMeta(targetFile, meta); err != nil {
+		g.logger.Warn("%s: failed to write cache metadata: %v", name, err)
+	}
+	return DownloadResult{Database: name, Size: size, SHA256: sum, Path: targetFile, contentType: meta.contentType}
+}
+
+// verifyPlaced re-checks the database moved into TargetDir: its size must
+// match the download and MMDB files must still validate.
+func (g *Updater) verifyPlaced(targetFile string, size int64) error {
+	fi, err := os.Stat(targetFile)
+	if err != nil {
+		return err
+	}
+	if fi.Size() != size {
+		return fmt.Errorf("installed file has %d bytes, expected %d", fi.Size(), size)
+	}
+	if strings.HasSuffix(targetFile, ".mmdb") {
+		if g.config.DeepValidate {
+			_, err = InspectMMDB(targetFile)
+		} else {
+			err = ValidateMMDB(targetFile)
+		}
+		if err != nil {
+			return fmt.Errorf("installed file failed validation: %w", err)
+		}
+	}
+	return nil
+}
+
+// backupCount is the number of backup slots in use: BackupCount when
+// backups are kept, otherwise the single transient <name>.bak.
+func (g *Updater) backupCount() int {
+	if g.config.Backup {
+		return g.config.BackupCount
+	}
+	return 1
+}
+
+// rollback restores the backup of targetFile, if one was made, and returns
+// the failed result for name.
+func (g *Updater) rollback(name, targetFile string, backedUp bool, cause error) DownloadResult {
+	if backedUp {
+		if err := restoreBackup(targetFile, g.backupCount()); err != nil {
+			g.logger.Error("%s: %v", name, err)
+		} else {
+			g.logger.Warn("%s: restored previous database from backup", name)
+		}
+	}
+	return DownloadResult{Database: name, Error: cause}
+}
+
+// notNewer implements Config.IfNewer: it reports whether the server's
+// Last-Modified for url, from a HEAD request, is no later than the
+// modification time of the installed targetFile, returning the result for
+// keeping it. Without a Last-Modified the database is downloaded.
+func (g *Updater) notNewer(ctx context.Context, name, url, targetFile string, cached *cacheMeta) (DownloadResult, bool) {
+	fi, err := os.Stat(targetFile)
+	if err != nil || fi.Size() == 0 {
+		return DownloadResult{}, false
+	}
+	_, header := g.headInfo(ctx, name, url)
+	modified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		g.logger.Info("%s: no Last-Modified from the server, downloading", name)
+		return DownloadResult{}, false
+	}
+	// Last-Modified has whole-second resolution.
+	if modified.After(fi.ModTime().Truncate(time.Second)) {
+		debugf(g.logger, "%s: server copy modified %s, after the local %s", name, modified.Format(time.RFC3339), fi.ModTime().Format(time.RFC3339))
+		return DownloadResult{}, false
+	}
+	g.logger.Info("%s: server copy (modified %s) is not newer than the local file, skipping", name, modified.Format(time.RFC3339))
+	result := DownloadResult{Database: name, Size: fi.Size(), NotNewer: true, Path: targetFile}
+	if cached != nil {
+		result.SHA256 = cached.SHA256
+	}
+	return result, true
+}
+
+// errNotModified is returned by fetchToFile when a conditional request was
+// answered with 304 Not Modified.
+var errNotModified = errors.New("not modified")
+
+// errUnexpectedNotModified is returned when a 304 answers a request that
+// carried no validators, so there is no cached file it could refer to.
+var errUnexpectedNotModified = errors.New("unexpected 304 Not Modified to a request without validators")
+
+// cacheMeta holds the HTTP validators of a downloaded database. It is stored
+// next to the database as "<name>.meta.json" so the next run can send a
+// conditional request instead of re-downloading an unchanged file.
+type cacheMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// Size of the file the validators describe, so a database replaced by
+	// another tool is not mistaken for the cached one.
+	Size int64 `json:"size,omitempty"`
+	// SHA256 of the installed file, reported for unchanged databases.
+	SHA256 string `json:"sha256,omitempty"`
+
+	encoding    string // Content-Encoding left undecoded by the transport, not persisted
+	contentType string // Content-Type, not persisted
+	filename    string // Content-Disposition filename, not persisted
+}
+
+func cacheMetaPath(targetFile string) string {
+	return targetFile + ".meta.json"
+}
+
+// readCacheMeta returns the validators stored for targetFile, or nil if there
+// are none (missing or unreadable sidecar).
+func readCacheMeta(targetFile string) *cacheMeta {
+	data, err := os.ReadFile(cacheMetaPath(targetFile))
+	if err != nil {
+		return nil
+	}
+	var meta cacheMeta
+	if err := json.Unmarshal(data, &meta); err != nil || (meta.ETag == "" && meta.LastModified == "") {
+		return nil
+	}
+	return &meta
+}
+
+// writeCacheMeta stores meta for targetFile. A nil or empty meta removes any
+// stale sidecar, since its validators no longer describe the file.
+func writeCacheMeta(targetFile string, meta *cacheMeta) error {
+	path := cacheMetaPath(targetFile)
+	if meta == nil || (meta.ETag == "" && meta.LastModified == "") {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	// Replace rather than rewrite: with AtomicDir the old sidecar is a hard
+	// link into the live directory.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// fetchToFile downloads url into tempFile from scratch. Bytes are written to
+// tempFile+".part" and renamed to tempFile only once the full length the
+// server announced has been received. When cached is non-nil the first
+// request is conditional and errNotModified is returned on a 304. The
+// validators of the downloaded body are returned for the next run.
+func (g *Updater) fetchToFile(ctx context.Context, name, url, tempFile string, cached *cacheMeta) (_ *cacheMeta, err error) {
+	partFile := tempFile + ".part"
+
+	// A cancelled run must not leave partial downloads behind.
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			os.Remove(partFile)
+			os.Remove(tempFile)
+		}
+	}()
+
+	// Resume on interruption/stall (HTTP Range) rather than restarting from
+	// byte 0, so large databases complete on flaky links. Retry while the
+	// transfer keeps making progress; give up only after a few consecutive
+	// no-progress attempts.
+	os.Remove(tempFile) // fresh start for this database
+	os.Remove(partFile)
+	const maxNoProgress = 3
+	const hardCap = 50
+	noProgress := 0
+	total := int64(-1) // full length announced by the server, -1 if unknown
+	meta := &cacheMeta{}
+	var lastErr error
+
+	for attempt := 1; ; attempt++ {
+		if attempt > hardCap {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", hardCap, lastErr)
+		}
+
+		var offset int64
+		if fi, statErr := os.Stat(partFile); statErr == nil {
+			offset = fi.Size()
+		}
+
+		reqCtx, cancel := context.WithCancel(ctx)
+		req, err := http.NewRequestWithContext(reqCtx, "GET", url, nil)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("User-Agent", g.userAgent())
+		if g.config.Compression == CompressionGzip {
+			// Set explicitly, the transport leaves the body encoded; it is
+			// decoded once complete, and resumed ranges continue the same
+			// encoded bytes.
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+		conditional := false
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			g.logger.Info("Resuming %s from %d bytes (attempt %d)", name, offset, attempt)
+		} else if cached != nil {
+			if cached.ETag != "" {
+				req.Header.Set("If-None-Match", cached.ETag)
+				conditional = true
+			}
+			if cached.LastModified != "" {
+				req.Header.Set("If-Modified-Since", cached.LastModified)
+				conditional = true
+			}
+		}
+
+		// doWithRetry handles transient/429 retries and fails fast on
+		// permanent errors (401/403/404, bad certificates).
+		resp, err := g.httpClient.doWithRetry(req)
+		if err != nil {
+			cancel()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if isPermanent(err) {
+				return nil, err
+			}
+			lastErr = err
+			noProgress++
+			if noProgress >= maxNoProgress {
+				return nil, err
+			}
+			if err := sleepContext(ctx, 5*time.Second); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Only a conditional request can be answered with "not modified";
+		// a 304 to anything else (a misbehaving proxy or CDN) has no file
+		// behind it.
+		if resp.StatusCode == http.StatusNotModified {
+			resp.Body.Close()
+			cancel()
+			if !conditional {
+				return nil, errUnexpectedNotModified
+			}
+			return nil, errNotModified
+		}
+
+		// 416 => the byte range is past EOF, i.e. we already have the whole
+		// file. Trust that only if it agrees with the length we expect.
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			resp.Body.Close()
+			cancel()
+			if _, _, size, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && size >= 0 {
+				total = size
+			}
+			if total < 0 || offset == total {
+				break
+			}
+			lastErr = fmt.Errorf("range not satisfiable at %d of %d bytes", offset, total)
+			g.logger.Warn("%s: %v - restarting", name, lastErr)
+			os.Remove(partFile)
+			continue
+		}
+
+		// 206 resumes (append) only when Content-Range starts exactly where
+		// the partial file ends; 200 means the server sent the whole body (no
+		// range support), so truncate and start the file fresh.
+		var out *os.File
+		if resp.StatusCode == http.StatusPartialContent {
+			start, _, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+			if !ok || start != offset {
+				resp.Body.Close()
+				cancel()
+				lastErr = fmt.Errorf("unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), offset)
+				g.logger.Warn("%s: %v - restarting", name, lastErr)
+				os.Remove(partFile)
+				continue
+			}
+			// Ranges of differently encoded bodies don't splice together.
+			if enc := strings.ToLower(resp.Header.Get("Content-Encoding")); enc != meta.encoding {
+				resp.Body.Close()
+				cancel()
+				lastErr = fmt.Errorf("resumed range has Content-Encoding %q, not %q", enc, meta.encoding)
+				g.logger.Warn("%s: %v - restarting", name, lastErr)
+				os.Remove(partFile)
+				continue
+			}
+			if size >= 0 {
+				total = size
+			}
+			out, err = os.OpenFile(partFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		} else {
+			if offset > 0 {
+				g.logger.Info("%s: server ignored range request - restarting from 0", name)
+			}
+			total = resp.ContentLength
+			out, err = os.Create(partFile)
+		}
+		if err != nil {
+			resp.Body.Close()
+			cancel()
+			return nil, fmt.Errorf("failed to open temp file: %w", err)
+		}
+		meta.ETag = resp.Header.Get("ETag")
+		meta.LastModified = resp.Header.Get("Last-Modified")
+		// Only present when the transport did not decode the body itself.
+		meta.encoding = strings.ToLower(resp.Header.Get("Content-Encoding"))
+		if resp.Uncompressed {
+			g.logger.Info("%s: gzip-encoded transfer, decoded by the transport", name)
+		}
+		meta.contentType = strings.ToLower(resp.Header.Get("Content-Type"))
+		if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+			meta.filename = filepath.Base(params["filename"])
+		}
+
+		// Copy through a stall guard: abort if no bytes arrive for the stall
+		// timeout (slow-but-progressing transfers are unaffected).
+		body := newIdleTimeoutReader(resp.Body, g.stallTimeout(), cancel)
+		barOffset := offset
+		if resp.StatusCode != http.StatusPartialContent {
+			barOffset = 0
+		}
+		bar := g.progress.add(name, total, barOffset)
+		limited := &rateLimitedReader{ctx: ctx, r: body, limiter: g.limiter}
+		_, copyErr := io.Copy(out, &progressReader{r: limited, bar: bar})
+		g.progress.remove(bar)
+		body.Stop()
+		out.Close()
+		resp.Body.Close()
+		cancel()
+
+		var cur int64
+		if fi, statErr := os.Stat(partFile); statErr == nil {
+			cur = fi.Size()
+		}
+		if copyErr == nil {
+			if total < 0 || cur == total {
+				break // read through to EOF with the full length => complete
+			}
+			if cur > total {
+				return nil, fmt.Errorf("received %d bytes, more than the expected %d", cur, total)
+			}
+			copyErr = fmt.Errorf("short body: %d of %d bytes", cur, total)
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = copyErr
+		if cur > offset {
+			noProgress = 0
+			g.logger.Warn("%s: transfer interrupted at %d bytes - resuming (%v)", name, cur, copyErr)
+		} else {
+			noProgress++
+			g.logger.Warn("%s: no progress (attempt %d/%d): %v", name, noProgress, maxNoProgress, copyErr)
+			if noProgress >= maxNoProgress {
+				return nil, fmt.Errorf("failed to download: %w", copyErr)
+			}
+			if err := sleepContext(ctx, 5*time.Second); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := os.Rename(partFile, tempFile); err != nil {
+		return nil, fmt.Errorf("failed to finalize download: %w", err)
+	}
+	return meta, nil
+}
+
+// parseContentRange parses a "bytes start-end/size" Content-Range header
+// (RFC 9110). Either side may be "*" for an unsatisfied range or an unknown
+// size; those fields are returned as -1.
+func parseContentRange(h string) (start, end, size int64, ok bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(h), "bytes ")
+	if !found {
+		return 0, 0, 0, false
+	}
+	rng, sz, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, 0, false
+	}
+
+	size = -1
+	if sz != "*" {
+		n, err := strconv.ParseInt(sz, 10, 64)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		size = n
+	}
+	if rng == "*" {
+		return -1, -1, size, true
+	}
+
+	first, last, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, 0, false
+	}
+	s, err1 := strconv.ParseInt(first, 10, 64)
+	e, err2 := strconv.ParseInt(last, 10, 64)
+	if err1 != nil || err2 != nil || s > e {
+		return 0, 0, 0, false
+	}
+	return s, e, size, true
+}
+
+// Compression formats recognised in downloads.
+const (
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressionSuffixes maps a file suffix to the compression it implies.
+var compressionSuffixes = map[string]string{
+	".gz":   compressionGzip,
+	".tgz":  compressionGzip,
+	".zst":  compressionZstd,
+	".zstd": compressionZstd,
+}
+
+// stripCompressionSuffix returns name without a trailing .gz/.zst suffix.
+func stripCompressionSuffix(name string) string {
+	ext := filepath.Ext(name)
+	if _, ok := compressionSuffixes[strings.ToLower(ext)]; ok {
+		return strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// stripArchiveSuffix returns name without a trailing .tar/.tgz suffix.
+func stripArchiveSuffix(name string) string {
+	ext := filepath.Ext(name)
+	if strings.EqualFold(ext, ".tar") || strings.EqualFold(ext, ".tgz") {
+		return strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// urlPath returns the path component of rawURL, or "" if it does not parse.
+func urlPath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+// compressionContentTypes maps a Content-Type to the compression it implies.
+var compressionContentTypes = map[string]string{
+	"application/gzip":   compressionGzip,
+	"application/x-gzip": compressionGzip,
+	"application/x-gtar": compressionGzip,
+	"application/zstd":   compressionZstd,
+}
+
+// compressionLayers returns the compression wrapping the downloaded bytes,
+// outermost first: a Content-Encoding the transport left undecoded, then the
+// .gz/.tgz/.zst suffix of the first of names (database name, URL path,
+// Content-Disposition filename) that has one, or failing that the
+// Content-Type. Both can apply at once (a .gz file served with
+// Content-Encoding: gzip).
+func compressionLayers(contentEncoding, contentType string, names ...string) []string {
+	var layers []string
+	switch contentEncoding {
+	case "gzip", "x-gzip":
+		layers = append(layers, compressionGzip)
+	case "zstd":
+		layers = append(layers, compressionZstd)
+	}
+
+	for _, name := range names {
+		if c, ok := compressionSuffixes[strings.ToLower(filepath.Ext(name))]; ok {
+			return append(layers, c)
+		}
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if c, ok := compressionContentTypes[mediaType]; ok {
+			layers = append(layers, c)
+		}
+	}
+	return layers
+}
+
+// decompressFile replaces path with its decompressed contents, peeling each
+// layer in order. A gzip layer whose magic bytes are absent is skipped, since
+// a CDN or the transport may already have decoded it. zstd is detected but
+// not supported by the standard library, so it is reported rather than
+// written out as a corrupt database.
+func decompressFile(path string, layers []string) error {
+	for _, layer := range layers {
+		head, err := readHead(path, len(zstdMagic))
+		if err != nil {
+			return err
+		}
+		switch {
+		case bytes.HasPrefix(head, zstdMagic):
+			return fmt.Errorf("zstd-compressed downloads are not supported; use an uncompressed or gzip URL")
+		case layer == compressionGzip && bytes.HasPrefix(head, gzipMagic):
+			if err := gunzipFile(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeTransfer removes the gzip Content-Encoding of the download at path,
+// logging the sizes before and after for comparing compression modes.
+func (g *Updater) decodeTransfer(name, path string) error {
+	before, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := gunzipFile(path); err != nil {
+		return fmt.Errorf("failed to decode gzip transfer: %w", err)
+	}
+	if after, err := os.Stat(path); err == nil {
+		g.logger.Info("%s: gzip transfer of %d bytes decoded to %d", name, before.Size(), after.Size())
+	}
+	return nil
+}
+
+// gunzipFile decompresses the gzip file at path in place. On error, including
+// a truncated stream, path is left untouched.
+func gunzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	zr, err := gzip.NewReader(bufio.NewReader(in))
+	if err != nil {
+		return fmt.Errorf("invalid gzip stream: %w", err)
+	}
+	defer zr.Close()
+
+	tmp := path + ".decompressed"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, zr)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("truncated gzip stream: %w", err)
+		}
+		return fmt.Errorf("failed to decompress gzip: %w", err)
+	}
+	in.Close()
+	return os.Rename(tmp, path)
+}
+
+// isTarArchive reports whether path holds a POSIX tar archive, recognised by
+// the "ustar" magic in the first header block.
+func isTarArchive(path string) bool {
+	head, err := readHead(path, 262)
+	if err != nil || len(head) < 262 {
+		return false
+	}
+	return bytes.Equal(head[257:262], []byte("ustar"))
+}
+
+// storedName returns the file name the database served as name is
+// installed under: without compression or archive suffixes, and lowercased
+// with NormalizeNames.
+func (g *Updater) storedName(name string) string {
+	return g.normalizeName(stripArchiveSuffix(stripCompressionSuffix(name)))
+}
+
+func (g *Updater) normalizeName(name string) string {
+	if g.config.NormalizeNames {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// isDatabaseFile reports whether name has a database extension (.mmdb for
+// MaxMind, .BIN for IP2Location).
+func isDatabaseFile(name string) bool {
+	ext := filepath.Ext(name)
+	return strings.EqualFold(ext, ".mmdb") || strings.EqualFold(ext, ".bin")
+}
+
+// extractArchive replaces the tar archive at path with the single database
+// file it contains and returns that member's name. MaxMind archives hold the
+// .mmdb in a dated directory next to COPYRIGHT/LICENSE files, IP2Location
+// bundles carry a .BIN next to a README; when several databases are present
+// the one named want wins, otherwise the first.
+func extractArchive(path, want string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var chosen string
+	var others []string // non-database members, for the error message
+	tmp := path + ".extracted"
+	tr := tar.NewReader(bufio.NewReader(f))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return "", fmt.Errorf("invalid tar archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if !isDatabaseFile(hdr.Name) {
+			others = append(others, hdr.Name)
+			continue
+		}
+		if chosen != "" && !strings.EqualFold(filepath.Base(hdr.Name), want) {
+			continue
+		}
+
+		out, err := os.Create(tmp)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(out, tr)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return "", fmt.Errorf("failed to extract %s: %w", hdr.Name, err)
+		}
+		chosen = hdr.Name
+		if strings.EqualFold(filepath.Base(hdr.Name), want) {
+			break
+		}
+	}
+
+	if chosen == "" {
+		if len(others) == 0 {
+			return "", fmt.Errorf("no .mmdb or .BIN database found in archive: it holds no files")
+		}
+		if len(others) > 5 {
+			others = append(others[:5], "...")
+		}
+		return "", fmt.Errorf("no .mmdb or .BIN database found in archive, only %s", strings.Join(others, ", "))
+	}
+	f.Close()
+	return chosen, os.Rename(tmp, path)
+}
+
+// readHead returns up to n bytes from the start of path.
+func readHead(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	m, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return buf[:m], nil
+}
+
+// verifyChecksum compares the SHA256 of path against the server-provided
+// checksum and returns the digest. It fails open when the server omitted a
+// checksum for this database, so older endpoints keep working.
+func (g *Updater) verifyChecksum(name, path, checksum string) (string, error) {
+	if g.config.NoVerifyChecksum {
+		return "", nil
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute checksum: %w", err)
+	}
+	g.logger.Info("%s: sha256 %s", name, sum)
+	if checksum != "" && !strings.EqualFold(sum, checksum) {
+		return "", fmt.Errorf("checksum mismatch: expected sha256 %s, got %s", checksum, sum)
+	}
+	return sum, nil
+}
+
+// fileSHA256 returns the lowercase hex SHA256 digest of the file at path.
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// stallTimeout returns how long a download may receive no bytes before it
+// is aborted and resumed.
+func (g *Updater) stallTimeout() time.Duration {
+	if g.config.StallTimeout > 0 {
+		return g.config.StallTimeout
+	}
+	return defaultStallTimeout
+}
+
+// syncFile flushes path's contents to stable storage.
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir flushes dir's entries so a rename into it survives a crash.
+// Windows cannot fsync a directory; NTFS journals the rename itself.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
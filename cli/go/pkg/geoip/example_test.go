@@ -0,0 +1,56 @@
+package geoip_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+// Example embeds an update in another program. The test server stands in
+// for the API endpoint and download host.
+func Example() {
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" {
+			json.NewEncoder(w).Encode(map[string]string{"GeoIP2-Country.db": srvURL + "/country"})
+			return
+		}
+		w.Write([]byte("database"))
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	dir, _ := os.MkdirTemp("", "geoip-example-")
+	defer os.RemoveAll(dir)
+
+	updater, err := geoip.New(&geoip.Config{
+		APIKey:         "example-key",
+		APIEndpoints:   []string{srv.URL + "/auth"},
+		TargetDir:      dir,
+		Databases:      []string{"all"},
+		MaxRetries:     3,
+		Timeout:        time.Minute,
+		MaxConcurrent:  2,
+		SkipSpaceCheck: true,
+	}, geoip.Options{HTTPClient: srv.Client()})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer updater.Close()
+
+	results, err := updater.Update(context.Background())
+	for _, r := range results {
+		fmt.Println(r.Database, r.Size, r.Error)
+	}
+	fmt.Println("error:", err)
+	// Output:
+	// GeoIP2-Country.db 8 <nil>
+	// error: <nil>
+}
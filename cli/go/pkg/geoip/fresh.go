@@ -0,0 +1,164 @@
+package geoip
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// selectionFile, kept in TargetDir, records which files the last fully
+// successful run installed for its database selection, so aliases such as
+// "city" or "maxmind/all" can be checked offline.
+const selectionFile = ".geoip-selection.json"
+
+type selectionRecord struct {
+	Selection []string `json:"selection"`
+	Files     []string `json:"files"`
+}
+
+// staleDatabase returns why an update is needed under OnlyIfStale, or ""
+// when every requested database in TargetDir was modified within MaxAge.
+// A missing file is always stale.
+func (g *Updater) staleDatabase(now time.Time) string {
+	files, why := g.expectedFiles()
+	if why != "" {
+		return why
+	}
+	for _, file := range files {
+		fi, err := os.Stat(filepath.Join(g.config.TargetDir, file))
+		if err != nil {
+			return file + " is missing"
+		}
+		if age := now.Sub(fi.ModTime()); age > g.config.MaxAge {
+			return fmt.Sprintf("%s is %s old", file, age.Round(time.Minute))
+		}
+	}
+	return ""
+}
+
+// expectedFiles returns the names in TargetDir of the requested databases,
+// or why they cannot be known. With "all" the server's list is not known
+// before authenticating, so the database files already in TargetDir stand
+// in for it (dated copies behind an undated name are skipped) and an empty
+// directory is a problem. A selection the last successful run recorded
+// uses its files; otherwise each selector is matched case-insensitively,
+// with or without its extension, against the database files present, and
+// a file name that matches none is expected under that name.
+func (g *Updater) expectedFiles() ([]string, string) {
+	all := len(g.config.Databases) == 0 || g.config.Databases[0] == "all"
+	entries, err := os.ReadDir(g.config.TargetDir)
+	if err != nil && (all || !os.IsNotExist(err)) {
+		return nil, fmt.Sprintf("cannot read %s: %v", g.config.TargetDir, err)
+	}
+	var present []string
+	for _, e := range entries {
+		if !e.IsDir() && isDatabaseFile(e.Name()) {
+			present = append(present, e.Name())
+		}
+	}
+
+	var files []string
+	if all {
+		for _, name := range present {
+			if stable, ok := stableName(name); ok {
+				if _, err := os.Stat(filepath.Join(g.config.TargetDir, stable)); err == nil {
+					continue
+				}
+			}
+			files = append(files, name)
+		}
+		if len(files) == 0 {
+			return nil, "no databases in " + g.config.TargetDir
+		}
+		return files, ""
+	}
+
+	if rec := readSelection(g.config.TargetDir); rec != nil && equalFoldSets(rec.Selection, g.config.Databases) && len(rec.Files) > 0 {
+		return rec.Files, ""
+	}
+	for _, selector := range g.config.Databases {
+		name := g.storedName(strings.TrimSpace(selector))
+		if file, ok := matchInstalled(present, name); ok {
+			files = append(files, file)
+		} else if isDatabaseFile(name) {
+			files = append(files, name)
+		} else {
+			return nil, fmt.Sprintf("no installed database matches %q; an update records it", selector)
+		}
+	}
+	return files, ""
+}
+
+// matchInstalled returns the file among present named name, ignoring case
+// and, when name has no database extension, the file's extension.
+func matchInstalled(present []string, name string) (string, bool) {
+	for _, file := range present {
+		if strings.EqualFold(file, name) || (!isDatabaseFile(name) && strings.EqualFold(strings.TrimSuffix(file, filepath.Ext(file)), name)) {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+// equalFoldSets reports whether a and b hold the same selectors, ignoring
+// case, surrounding space and order.
+func equalFoldSets(a, b []string) bool {
+	norm := func(list []string) []string {
+		out := make([]string, len(list))
+		for i, s := range list {
+			out[i] = strings.ToLower(strings.TrimSpace(s))
+		}
+		sort.Strings(out)
+		return out
+	}
+	return strings.Join(norm(a), "\n") == strings.Join(norm(b), "\n")
+}
+
+// readSelection returns the selection recorded in dir, or nil.
+func readSelection(dir string) *selectionRecord {
+	data, err := os.ReadFile(filepath.Join(dir, selectionFile))
+	if err != nil {
+		return nil
+	}
+	var rec selectionRecord
+	if json.Unmarshal(data, &rec) != nil {
+		return nil
+	}
+	return &rec
+}
+
+// recordSelection stores which files results installed for the configured
+// selection. It replaces the record rather than rewriting it, since with
+// AtomicDir the old one is a hard link into the live directory. Failures
+// only cost the offline checks their alias resolution, so they are logged.
+func (g *Updater) recordSelection(results []DownloadResult) {
+	if len(g.config.Databases) == 0 || g.config.Databases[0] == "all" {
+		return
+	}
+	rec := selectionRecord{Selection: g.config.Databases}
+	for _, r := range results {
+		if r.Error != nil {
+			continue
+		}
+		if r.Path != "" {
+			rec.Files = append(rec.Files, filepath.Base(r.Path))
+		} else {
+			rec.Files = append(rec.Files, g.storedName(r.Database))
+		}
+	}
+	sort.Strings(rec.Files)
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err == nil {
+		path := filepath.Join(g.config.TargetDir, selectionFile)
+		if err = os.WriteFile(path+".tmp", append(data, '\n'), 0644); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+	if err != nil {
+		g.logger.Warn("Failed to record the database selection: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestOnlyIfStale verifies Update skips authentication while every
+// requested database is younger than MaxAge, and runs when one is missing
+// or too old.
+func TestOnlyIfStale(t *testing.T) {
+	db := buildTestMMDB(t, 6, 24)
+	var srvURL string
+	var auths int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" {
+			auths++
+			json.NewEncoder(w).Encode(map[string]string{"a.mmdb": srvURL + "/a", "b.mmdb": srvURL + "/b"})
+			return
+		}
+		w.Write(db)
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	dir := t.TempDir()
+	run := func(databases ...string) {
+		t.Helper()
+		cfg := &Config{
+			APIEndpoints:   []string{srv.URL + "/auth"},
+			TargetDir:      dir,
+			Databases:      databases,
+			Timeout:        10 * time.Second,
+			MaxRetries:     1,
+			OnlyIfStale:    true,
+			MaxAge:         6 * time.Hour,
+			SkipSpaceCheck: true,
+		}
+		updater, err := New(cfg, Options{HTTPClient: srv.Client()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer updater.Close()
+		if _, err := updater.Update(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	os.WriteFile(filepath.Join(dir, "a.mmdb"), db, 0644)
+	run("a.mmdb")
+	if auths != 0 {
+		t.Errorf("fresh database: %d authentications, want 0", auths)
+	}
+	run("a.mmdb", "b.mmdb")
+	if auths != 1 {
+		t.Errorf("missing database: %d authentications, want 1", auths)
+	}
+
+	old := time.Now().Add(-7 * time.Hour)
+	os.Chtimes(filepath.Join(dir, "b.mmdb"), old, old)
+	run("all")
+	if auths != 2 {
+		t.Errorf("stale database with all: %d authentications, want 2", auths)
+	}
+	run("all")
+	if auths != 2 {
+		t.Errorf("fresh databases with all: %d authentications, want 2", auths)
+	}
+}
+
+// TestOnlyIfStaleAliases verifies selectors that are not file names (an
+// alias, an extensionless or differently cased name) resolve to the
+// installed files rather than always counting as missing.
+func TestOnlyIfStaleAliases(t *testing.T) {
+	db := buildTestMMDB(t, 6, 24)
+	var srvURL string
+	var auths int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" {
+			auths++
+			json.NewEncoder(w).Encode(map[string]string{"GeoIP2-City.mmdb": srvURL + "/city"})
+			return
+		}
+		w.Write(db)
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	dir := t.TempDir()
+	run := func(databases ...string) {
+		t.Helper()
+		cfg := &Config{
+			APIEndpoints:   []string{srv.URL + "/auth"},
+			TargetDir:      dir,
+			Databases:      databases,
+			Timeout:        10 * time.Second,
+			MaxRetries:     1,
+			OnlyIfStale:    true,
+			MaxAge:         6 * time.Hour,
+			SkipSpaceCheck: true,
+		}
+		updater, err := New(cfg, Options{HTTPClient: srv.Client()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer updater.Close()
+		if _, err := updater.Update(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("city")
+	run("City")
+	if auths != 1 {
+		t.Errorf("alias recorded by the last run: %d authentications, want 1", auths)
+	}
+
+	os.Remove(filepath.Join(dir, selectionFile))
+	run("GeoIP2-City")
+	run("geoip2-city.MMDB")
+	if auths != 1 {
+		t.Errorf("extensionless and differently cased names: %d authentications, want 1", auths)
+	}
+	run("country")
+	if auths != 2 {
+		t.Errorf("unresolvable alias: %d authentications, want 2", auths)
+	}
+}
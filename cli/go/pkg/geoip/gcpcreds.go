@@ -0,0 +1,270 @@
+package geoip
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcsScope is the OAuth scope requested for Cloud Storage uploads.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// gcpTokenTimeout bounds each token request to Google's OAuth endpoint.
+const gcpTokenTimeout = 30 * time.Second
+
+// Google endpoints, variables so tests can redirect them.
+var (
+	gceMetadataHost = "http://metadata.google.internal"
+	googleTokenURL  = "https://oauth2.googleapis.com/token"
+)
+
+// gcpCredentials hands out OAuth access tokens for Google Cloud, fetching
+// a new one shortly before the current one expires.
+type gcpCredentials struct {
+	source string // where they came from, for logging
+	fetch  func(ctx context.Context) (*gcpTokenResponse, error)
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// gcpTokenResponse is the JSON returned by Google's token endpoint and the
+// metadata server alike.
+type gcpTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// accessToken returns a token valid for at least another minute.
+func (c *gcpCredentials) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Until(c.expiry) > time.Minute {
+		return c.token, nil
+	}
+	tok, err := c.fetch(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", c.source, err)
+	}
+	if tok.AccessToken == "" {
+		return "", fmt.Errorf("%s: response has no access token", c.source)
+	}
+	c.token = tok.AccessToken
+	c.expiry = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	return c.token, nil
+}
+
+// gcpCredentialsFile is the subset of a service account key or a gcloud
+// application default credentials file that is used here.
+type gcpCredentialsFile struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// loadGCPCredentials resolves Application Default Credentials the way the
+// Google client libraries do, in order: keyFile when set, the file named
+// by GOOGLE_APPLICATION_CREDENTIALS, gcloud's application default
+// credentials file, and the GCE/GKE metadata server. Files may hold a
+// service account key or gcloud user credentials.
+func loadGCPCredentials(keyFile string) (*gcpCredentials, error) {
+	path, explicit := keyFile, keyFile != ""
+	if path == "" {
+		path, explicit = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), true
+	}
+	if path == "" {
+		path, explicit = gcloudCredentialsPath(), false
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return parseGCPCredentials(data, path)
+		}
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read Google credentials: %w", err)
+		}
+	}
+
+	host := gceMetadataHost
+	if env := os.Getenv("GCE_METADATA_HOST"); env != "" {
+		host = "http://" + env
+	}
+	client := &http.Client{Timeout: metadataTimeout}
+	return &gcpCredentials{
+		source: "GCE metadata server",
+		fetch: func(ctx context.Context) (*gcpTokenResponse, error) {
+			req, err := http.NewRequestWithContext(ctx, "GET", host+"/computeMetadata/v1/instance/service-accounts/default/token?scopes="+url.QueryEscape(gcsScope), nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Metadata-Flavor", "Google")
+			body, err := metadataGet(client, req)
+			if err != nil {
+				return nil, fmt.Errorf("no Google Cloud credentials found (credentials file or metadata server): %w", err)
+			}
+			var tok gcpTokenResponse
+			return &tok, json.Unmarshal(body, &tok)
+		},
+	}, nil
+}
+
+// gcloudCredentialsPath returns where "gcloud auth application-default
+// login" stores its credentials, or "" if it cannot be determined.
+func gcloudCredentialsPath() string {
+	dir := os.Getenv("CLOUDSDK_CONFIG")
+	if dir == "" {
+		if runtime.GOOS == "windows" {
+			if appData := os.Getenv("APPDATA"); appData != "" {
+				dir = filepath.Join(appData, "gcloud")
+			}
+		} else if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, ".config", "gcloud")
+		}
+	}
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "application_default_credentials.json")
+}
+
+// parseGCPCredentials builds credentials from a service account key or a
+// gcloud authorized_user file read from path.
+func parseGCPCredentials(data []byte, path string) (*gcpCredentials, error) {
+	var f gcpCredentialsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid Google credentials file %s: %w", path, err)
+	}
+	tokenURL := f.TokenURI
+	if tokenURL == "" {
+		tokenURL = googleTokenURL
+	}
+	client := &http.Client{Timeout: gcpTokenTimeout}
+
+	switch f.Type {
+	case "service_account":
+		key, err := parsePrivateKey(f.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Google credentials file %s: %w", path, err)
+		}
+		return &gcpCredentials{
+			source: fmt.Sprintf("service account %s (%s)", f.ClientEmail, path),
+			fetch: func(ctx context.Context) (*gcpTokenResponse, error) {
+				assertion, err := signJWT(key, f.PrivateKeyID, map[string]interface{}{
+					"iss":   f.ClientEmail,
+					"scope": gcsScope,
+					"aud":   tokenURL,
+					"iat":   time.Now().Unix(),
+					"exp":   time.Now().Add(time.Hour).Unix(),
+				})
+				if err != nil {
+					return nil, err
+				}
+				return postTokenForm(ctx, client, tokenURL, url.Values{
+					"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+					"assertion":  {assertion},
+				})
+			},
+		}, nil
+	case "authorized_user":
+		if f.RefreshToken == "" {
+			return nil, fmt.Errorf("invalid Google credentials file %s: no refresh_token", path)
+		}
+		return &gcpCredentials{
+			source: "gcloud user credentials (" + path + ")",
+			fetch: func(ctx context.Context) (*gcpTokenResponse, error) {
+				return postTokenForm(ctx, client, tokenURL, url.Values{
+					"grant_type":    {"refresh_token"},
+					"client_id":     {f.ClientID},
+					"client_secret": {f.ClientSecret},
+					"refresh_token": {f.RefreshToken},
+				})
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported Google credentials type %q in %s: use a service account key or gcloud application default credentials", f.Type, path)
+	}
+}
+
+// parsePrivateKey decodes a PEM RSA private key in PKCS#8 (as in service
+// account keys) or PKCS#1 form.
+func parsePrivateKey(pemKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("private_key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("private_key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private_key is not an RSA key")
+	}
+	return key, nil
+}
+
+// signJWT returns an RS256-signed JSON Web Token carrying claims.
+func signJWT(key *rsa.PrivateKey, keyID string, claims map[string]interface{}) (string, error) {
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	if keyID != "" {
+		header["kid"] = keyID
+	}
+	var parts []string
+	for _, v := range []interface{}{header, claims} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, base64.RawURLEncoding.EncodeToString(data))
+	}
+	signingInput := strings.Join(parts, ".")
+	hash := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// postTokenForm exchanges form for an access token at tokenURL.
+func postTokenForm(ctx context.Context, client *http.Client, tokenURL string, form url.Values) (*gcpTokenResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := metadataGet(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	var tok gcpTokenResponse
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	return &tok, nil
+}
@@ -0,0 +1,172 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// gcsEndpoint serves the Cloud Storage JSON API.
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// gcsUploader copies installed databases to Config.GCSBucket under
+// Config.GCSPrefix through the Cloud Storage JSON API.
+type gcsUploader struct {
+	bucket   string
+	prefix   string
+	keyFile  string
+	endpoint string // gcsEndpoint, or a test server
+	client   *HTTPClient
+	logger   Logger
+
+	credsOnce sync.Once
+	creds     *gcpCredentials
+	credsErr  error
+}
+
+func newGCSUploader(config *Config, client *HTTPClient, logger Logger) *gcsUploader {
+	return &gcsUploader{
+		bucket:   config.GCSBucket,
+		prefix:   strings.Trim(config.GCSPrefix, "/"),
+		keyFile:  config.GCSCredentialsFile,
+		endpoint: gcsEndpoint,
+		client:   client,
+		logger:   logger,
+	}
+}
+
+// key returns the object name for a database file name.
+func (u *gcsUploader) key(name string) string {
+	if u.prefix == "" {
+		return name
+	}
+	return u.prefix + "/" + name
+}
+
+// upload copies the installed database at path, downloaded from sourceURL,
+// to Cloud Storage unless the object's MD5 already matches the file. The
+// object carries the file's SHA256 and the (redacted) source URL as custom
+// metadata.
+func (u *gcsUploader) upload(ctx context.Context, path, contentType, sourceURL string) error {
+	u.credsOnce.Do(func() {
+		u.creds, u.credsErr = loadGCPCredentials(u.keyFile)
+		if u.credsErr == nil {
+			u.logger.Info("Using Google Cloud credentials from %s", u.creds.source)
+		}
+	})
+	if u.credsErr != nil {
+		return u.credsErr
+	}
+	token, err := u.creds.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	md5Sum, sha256Sum, size, err := fileDigests(path)
+	if err != nil {
+		return err
+	}
+	key := u.key(filepath.Base(path))
+	dest := fmt.Sprintf("gs://%s/%s", u.bucket, key)
+	md5Hash := base64.StdEncoding.EncodeToString(md5Sum)
+
+	if remote, err := u.objectMD5(ctx, token, key); err == nil && remote == md5Hash {
+		u.logger.Info("%s already up to date (MD5 match), not uploading", dest)
+		return nil
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	metadata := map[string]string{"sha256": hex.EncodeToString(sha256Sum)}
+	if sourceURL != "" {
+		metadata["source_url"] = redactURL(sourceURL)
+	}
+	// GCS rejects the upload if the content does not match md5Hash.
+	meta, err := json.Marshal(map[string]interface{}{
+		"name":        key,
+		"contentType": contentType,
+		"md5Hash":     md5Hash,
+		"metadata":    metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	// A multipart/related body streamed from the file: the metadata part,
+	// then the media part.
+	var rnd [12]byte
+	rand.Read(rnd[:])
+	boundary := "geoip-" + hex.EncodeToString(rnd[:])
+	head := []byte("--" + boundary + "\r\nContent-Type: application/json; charset=UTF-8\r\n\r\n" + string(meta) +
+		"\r\n--" + boundary + "\r\nContent-Type: " + contentType + "\r\n\r\n")
+	tail := []byte("\r\n--" + boundary + "--\r\n")
+
+	uploadURL := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=multipart", u.endpoint, url.PathEscape(u.bucket))
+	req, err := http.NewRequestWithContext(ctx, "POST", uploadURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head), f, bytes.NewReader(tail)), f}, nil
+	}
+	if req.Body, err = req.GetBody(); err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(head)) + size + int64(len(tail))
+	req.Header.Set("Content-Type", "multipart/related; boundary="+boundary)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := u.client.doWithRetry(req)
+	if err != nil {
+		return fmt.Errorf("upload to %s failed: %w", dest, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	u.logger.Info("Uploaded %s to %s (%d bytes)", filepath.Base(path), dest, size)
+	return nil
+}
+
+// objectMD5 returns the base64 MD5 Cloud Storage stores for the object
+// key, or an error if it does not exist or cannot be read.
+func (u *gcsUploader) objectMD5(ctx context.Context, token, key string) (string, error) {
+	objectURL := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?fields=md5Hash", u.endpoint, url.PathEscape(u.bucket), url.PathEscape(key))
+	req, err := http.NewRequestWithContext(ctx, "GET", objectURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := u.client.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	var object struct {
+		MD5Hash string `json:"md5Hash"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&object); err != nil {
+		return "", err
+	}
+	return object.MD5Hash, nil
+}
@@ -0,0 +1,172 @@
+package geoip
+
+import (
+	"context"
+	"crypto"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestGCSUpload verifies a service account token exchange, an upload
+// carrying the MD5, SHA256 and redacted source URL, and that an object
+// whose MD5 matches is not uploaded again.
+func TestGCSUpload(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	var uploads int
+	var metadata map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/token" {
+			r.ParseForm()
+			parts := strings.Split(r.PostForm.Get("assertion"), ".")
+			sig, _ := base64.RawURLEncoding.DecodeString(parts[len(parts)-1])
+			hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			if len(parts) != 3 || rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], sig) != nil {
+				http.Error(w, "bad assertion", http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "tok", "expires_in": 3600})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "GET" && strings.HasPrefix(r.URL.EscapedPath(), "/storage/v1/b/geo-bucket/o/"):
+			body, ok := objects[r.URL.Path[len("/storage/v1/b/geo-bucket/o/"):]]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			sum := md5.Sum(body)
+			json.NewEncoder(w).Encode(map[string]string{"md5Hash": base64.StdEncoding.EncodeToString(sum[:])})
+		case r.Method == "POST" && r.URL.Path == "/upload/storage/v1/b/geo-bucket/o":
+			_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			part, _ := mr.NextPart()
+			var meta map[string]interface{}
+			json.NewDecoder(part).Decode(&meta)
+			part, _ = mr.NextPart()
+			body, _ := io.ReadAll(part)
+			sum := md5.Sum(body)
+			if meta["md5Hash"] != base64.StdEncoding.EncodeToString(sum[:]) {
+				http.Error(w, "md5 mismatch", http.StatusBadRequest)
+				return
+			}
+			objects[meta["name"].(string)] = body
+			metadata = meta["metadata"].(map[string]interface{})
+			uploads++
+			w.Write([]byte("{}"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "sa.json")
+	sa, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "geoip@example.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    srv.URL + "/token",
+	})
+	os.WriteFile(keyFile, sa, 0600)
+	path := filepath.Join(dir, "GeoIP2-City.mmdb")
+	os.WriteFile(path, []byte("database"), 0644)
+
+	logger := &ConsoleLogger{quiet: true}
+	u := newGCSUploader(&Config{GCSBucket: "geo-bucket", GCSPrefix: "/geoip/", GCSCredentialsFile: keyFile}, NewHTTPClient(srv.Client(), 1, logger), logger)
+	u.endpoint = srv.URL
+	for i := 0; i < 2; i++ {
+		if err := u.upload(context.Background(), path, "", "https://cdn.example.com/city.mmdb?X-Amz-Signature=secret"); err != nil {
+			t.Fatalf("upload %d: %v", i+1, err)
+		}
+	}
+	if string(objects["geoip/GeoIP2-City.mmdb"]) != "database" {
+		t.Errorf("objects = %v", objects)
+	}
+	if uploads != 1 {
+		t.Errorf("%d uploads, want 1 (second upload should match the MD5)", uploads)
+	}
+	sum := sha256.Sum256([]byte("database"))
+	if metadata["sha256"] != fmt.Sprintf("%x", sum) || metadata["source_url"] != "https://cdn.example.com/city.mmdb?REDACTED" {
+		t.Errorf("metadata = %v", metadata)
+	}
+}
+
+// TestLoadGCPCredentials verifies gcloud user credentials and the
+// metadata server fallback.
+func TestLoadGCPCredentials(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			r.ParseForm()
+			if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "refresh" {
+				http.Error(w, "bad grant", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token":"user-token","expires_in":3600}`))
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				http.Error(w, "missing header", http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"access_token":"gce-token","expires_in":3600}`))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	adc := filepath.Join(dir, "application_default_credentials.json")
+	os.WriteFile(adc, []byte(`{"type":"authorized_user","client_id":"id","client_secret":"s","refresh_token":"refresh","token_uri":"`+srv.URL+`/token"}`), 0600)
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("CLOUDSDK_CONFIG", dir)
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(srv.URL, "http://"))
+
+	creds, err := loadGCPCredentials("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok, err := creds.accessToken(context.Background()); err != nil || tok != "user-token" {
+		t.Errorf("gcloud credentials: %q, %v", tok, err)
+	}
+
+	os.Remove(adc)
+	if creds, err = loadGCPCredentials(""); err != nil {
+		t.Fatal(err)
+	}
+	if tok, err := creds.accessToken(context.Background()); err != nil || tok != "gce-token" {
+		t.Errorf("metadata server: %q, %v", tok, err)
+	}
+
+	if _, err := loadGCPCredentials(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing key file accepted")
+	}
+}
@@ -0,0 +1,49 @@
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Health checks, without contacting the server, that TargetDir holds the
+// requested databases (with "all", whatever databases are there, at least
+// one; aliases resolve as for OnlyIfStale), that each passes the
+// validation a download gets and, when MaxAge is set, that none was
+// modified longer ago than MaxAge. It returns how many databases were
+// checked and the first problem found.
+func (g *Updater) Health() (int, error) {
+	files, why := g.expectedFiles()
+	if why != "" {
+		return 0, errors.New(why)
+	}
+	now := time.Now()
+	for _, file := range files {
+		path := filepath.Join(g.config.TargetDir, file)
+		fi, err := os.Stat(path)
+		if err != nil {
+			return len(files), fmt.Errorf("%s is missing", file)
+		}
+		if fi.IsDir() || fi.Size() == 0 {
+			return len(files), fmt.Errorf("%s is empty", file)
+		}
+		switch ext := filepath.Ext(file); {
+		case ext == ".mmdb" && g.config.DeepValidate:
+			_, err = InspectMMDB(path)
+		case ext == ".mmdb":
+			err = ValidateMMDB(path)
+		case strings.EqualFold(ext, ".bin"):
+			_, err = InspectBIN(path)
+		}
+		if err != nil {
+			return len(files), fmt.Errorf("%s failed validation: %v", file, err)
+		}
+		if age := now.Sub(fi.ModTime()); g.config.MaxAge > 0 && age > g.config.MaxAge {
+			return len(files), fmt.Errorf("%s is %s old, more than %s", file, age.Round(time.Minute), g.config.MaxAge)
+		}
+	}
+	return len(files), nil
+}
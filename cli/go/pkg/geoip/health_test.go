@@ -0,0 +1,87 @@
+package geoip
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHealth verifies Health reports missing, invalid and outdated
+// databases from the local files alone.
+func TestHealth(t *testing.T) {
+	dir := t.TempDir()
+	check := func(databases ...string) (int, error) {
+		t.Helper()
+		cfg := &Config{TargetDir: dir, Databases: databases, MaxAge: 24 * time.Hour}
+		updater, err := New(cfg, Options{Logger: &ConsoleLogger{quiet: true}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer updater.Close()
+		return updater.Health()
+	}
+
+	if _, err := check("all"); err == nil || !strings.Contains(err.Error(), "no databases") {
+		t.Errorf("empty directory: err = %v", err)
+	}
+	os.WriteFile(filepath.Join(dir, "a.mmdb"), buildTestMMDB(t, 6, 24), 0644)
+	if n, err := check("all"); n != 1 || err != nil {
+		t.Errorf("healthy: %d checked, err = %v", n, err)
+	}
+	if _, err := check("a.mmdb", "b.mmdb"); err == nil || err.Error() != "b.mmdb is missing" {
+		t.Errorf("missing database: err = %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "b.mmdb"), []byte("not a database"), 0644)
+	if _, err := check("a.mmdb", "b.mmdb"); err == nil || !strings.Contains(err.Error(), "b.mmdb failed validation") {
+		t.Errorf("invalid database: err = %v", err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(filepath.Join(dir, "a.mmdb"), old, old)
+	if _, err := check("a.mmdb"); err == nil || !strings.Contains(err.Error(), "a.mmdb is 48h0m0s old") {
+		t.Errorf("outdated database: err = %v", err)
+	}
+}
+
+// TestHealthAliases verifies alias selectors resolve through the selection
+// the last successful run recorded, and extensionless names by matching
+// the installed files.
+func TestHealthAliases(t *testing.T) {
+	dir := t.TempDir()
+	db := buildTestMMDB(t, 6, 24)
+	os.WriteFile(filepath.Join(dir, "GeoIP2-City.mmdb"), db, 0644)
+	os.WriteFile(filepath.Join(dir, "GeoIP2-Country.mmdb"), db, 0644)
+	check := func(databases ...string) (int, error) {
+		t.Helper()
+		cfg := &Config{TargetDir: dir, Databases: databases, MaxAge: 24 * time.Hour}
+		updater, err := New(cfg, Options{Logger: &ConsoleLogger{quiet: true}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer updater.Close()
+		return updater.Health()
+	}
+
+	if _, err := check("city", "country"); err == nil || !strings.Contains(err.Error(), `"city"`) {
+		t.Errorf("unrecorded alias: err = %v", err)
+	}
+	if n, err := check("geoip2-city", "GeoIP2-Country"); n != 2 || err != nil {
+		t.Errorf("extensionless names: %d checked, err = %v", n, err)
+	}
+
+	g := &Updater{config: &Config{TargetDir: dir, Databases: []string{"city", "country"}}, logger: &ConsoleLogger{quiet: true}}
+	g.recordSelection([]DownloadResult{
+		{Database: "GeoIP2-City.mmdb", Path: filepath.Join(dir, "GeoIP2-City.mmdb")},
+		{Database: "GeoIP2-Country.mmdb", Unchanged: true, Path: filepath.Join(dir, "GeoIP2-Country.mmdb")},
+	})
+	if n, err := check("country", "city"); n != 2 || err != nil {
+		t.Errorf("recorded aliases: %d checked, err = %v", n, err)
+	}
+	os.Remove(filepath.Join(dir, "GeoIP2-Country.mmdb"))
+	if _, err := check("city", "country"); err == nil || err.Error() != "GeoIP2-Country.mmdb is missing" {
+		t.Errorf("recorded file removed: err = %v", err)
+	}
+}
@@ -0,0 +1,542 @@
+package geoip
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultStallTimeout aborts a download whose body read stalls for this long
+// when Config.StallTimeout is zero. This is a stall timeout, not an absolute
+// deadline, so a slow-but-progressing download of a large database is not
+// killed mid-transfer.
+const defaultStallTimeout = 120 * time.Second
+
+// idleTimeoutReader wraps a response body and cancels the request (via cancel)
+// if no data is read for idle. The timer is reset on every read that returns
+// bytes, so only a genuine stall trips it.
+type idleTimeoutReader struct {
+	rc    io.Reader
+	timer *time.Timer
+	idle  time.Duration
+}
+
+func newIdleTimeoutReader(rc io.Reader, idle time.Duration, cancel context.CancelFunc) *idleTimeoutReader {
+	return &idleTimeoutReader{rc: rc, idle: idle, timer: time.AfterFunc(idle, cancel)}
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.idle)
+	}
+	return n, err
+}
+
+func (r *idleTimeoutReader) Stop() { r.timer.Stop() }
+
+// HTTPClient wraps http.Client with retry logic
+type HTTPClient struct {
+	client     *http.Client
+	maxRetries int
+	logger     Logger
+	backoff    Backoff
+	// jitter picks the actual wait below a backoff cap; nil means uniformly
+	// random ("full jitter").
+	jitter func(time.Duration) time.Duration
+	// rng, when set by SetJitterSeed, replaces the per-process random
+	// source of the default jitter.
+	rngMu sync.Mutex
+	rng   *rand.Rand
+	// retryOn lists the HTTP statuses worth retrying; nil means 408, 429
+	// and 5xx.
+	retryOn map[int]bool
+	// accepted lists statuses returned as successes besides 200, 206, 304
+	// and 416; see SetAccepted.
+	accepted map[int]bool
+	// attemptTimeout, when positive, bounds each attempt including the
+	// read of its response body.
+	attemptTimeout time.Duration
+	retries        atomic.Int64 // attempts after the first, for Updater.Retries
+}
+
+// Backoff shapes the wait between retries: the cap grows from Base by
+// Multiplier per attempt up to Max, and each wait is a random duration
+// between zero and the cap so that clients rate limited together don't
+// retry in lockstep. Zero fields take the defaults 1s, 2 and 60s.
+type Backoff struct {
+	Base       time.Duration
+	Multiplier float64
+	Max        time.Duration
+	// MaxElapsed, when positive, stops retrying once the time since the
+	// first attempt plus the next wait would exceed it, whatever the
+	// attempt count.
+	MaxElapsed time.Duration
+}
+
+// cap returns the backoff cap before retry number retry (1 = first retry).
+func (b Backoff) cap(retry int) time.Duration {
+	base, mult, max := b.Base, b.Multiplier, b.Max
+	if base <= 0 {
+		base = time.Second
+	}
+	if mult < 1 {
+		mult = 2
+	}
+	if max <= 0 {
+		max = 60 * time.Second
+	}
+	d := float64(base)
+	for i := 1; i < retry && d < float64(max); i++ {
+		d *= mult
+	}
+	return time.Duration(math.Min(d, float64(max)))
+}
+
+// SetRetryOn replaces the HTTP status codes that are retried (by default
+// 408, 429 and every 5xx). An empty list restores the default. 401 and 403
+// always fail at once.
+func (h *HTTPClient) SetRetryOn(codes []int) {
+	if len(codes) == 0 {
+		h.retryOn = nil
+		return
+	}
+	h.retryOn = make(map[int]bool, len(codes))
+	for _, code := range codes {
+		h.retryOn[code] = true
+	}
+}
+
+// SetAccepted makes the client return responses with the given statuses
+// as successes, e.g. the 201, 202 or 204 a webhook receiver acknowledges
+// with. Downloads and API requests keep the default, under which those
+// would be empty bodies.
+func (h *HTTPClient) SetAccepted(codes []int) {
+	h.accepted = make(map[int]bool, len(codes))
+	for _, code := range codes {
+		h.accepted[code] = true
+	}
+}
+
+// retryStatus reports whether an HTTP status is worth another attempt.
+func (h *HTTPClient) retryStatus(code int) bool {
+	if h.retryOn != nil {
+		return h.retryOn[code]
+	}
+	return isRetryable(&http.Response{StatusCode: code}, nil)
+}
+
+// retriedStatuses describes the retried HTTP statuses for error messages.
+func (h *HTTPClient) retriedStatuses() string {
+	if h.retryOn == nil {
+		return "408, 429 and 5xx"
+	}
+	codes := make([]int, 0, len(h.retryOn))
+	for code := range h.retryOn {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = strconv.Itoa(code)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// SetBackoff replaces the client's retry backoff.
+func (h *HTTPClient) SetBackoff(b Backoff) { h.backoff = b }
+
+// SetAttemptTimeout bounds every attempt, from sending the request to
+// closing its response body, so each retry gets a fresh budget. Zero
+// removes the limit; the request's own context still applies.
+func (h *HTTPClient) SetAttemptTimeout(d time.Duration) { h.attemptTimeout = d }
+
+// attemptRequest returns req bound by the attempt timeout, and the cancel
+// function that releases it.
+func (h *HTTPClient) attemptRequest(req *http.Request) (*http.Request, context.CancelFunc) {
+	if h.attemptTimeout <= 0 {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), h.attemptTimeout)
+	return req.WithContext(ctx), cancel
+}
+
+// cancelOnClose ends an attempt's context once its response body is
+// closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// SetJitterSeed makes the random retry waits a reproducible sequence
+// derived from seed. Zero restores the default: math/rand's global source,
+// seeded differently by every process so a fleet doesn't share waits.
+func (h *HTTPClient) SetJitterSeed(seed int64) {
+	h.rngMu.Lock()
+	defer h.rngMu.Unlock()
+	if seed == 0 {
+		h.rng = nil
+		return
+	}
+	h.rng = rand.New(rand.NewSource(seed))
+}
+
+func (h *HTTPClient) retryDelay(retry int) time.Duration {
+	limit := h.backoff.cap(retry)
+	if h.jitter != nil {
+		return h.jitter(limit)
+	}
+	h.rngMu.Lock()
+	defer h.rngMu.Unlock()
+	if h.rng != nil {
+		return time.Duration(h.rng.Int63n(int64(limit) + 1))
+	}
+	return time.Duration(rand.Int63n(int64(limit) + 1))
+}
+
+// NewHTTPClient wraps client with retry logic. A nil client gets the default
+// transport built by newHTTPClient.
+func NewHTTPClient(client *http.Client, maxRetries int, logger Logger) *HTTPClient {
+	if client == nil {
+		return newHTTPClient(0, maxRetries, logger)
+	}
+	if maxRetries < 1 {
+		maxRetries = 1
+	}
+	return &HTTPClient{client: withDebug(client, logger), maxRetries: maxRetries, logger: logger}
+}
+
+// NewConfiguredClient returns the client New builds when Options.HTTPClient
+// is nil: config's TLS settings, proxy, connect and response header
+// timeouts and compression mode. Requests made outside an Updater, such as
+// database discovery, use it to reach the API the same way.
+func NewConfiguredClient(config *Config) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := proxyFunc(config)
+	if err != nil {
+		return nil, err
+	}
+	timeouts := transportTimeouts{connect: config.ConnectTimeout, responseHeader: config.ResponseHeaderTimeout}
+	return newHTTPClientTLS(timeouts, config.MaxRetries, tlsConfig, proxy, config.Compression == CompressionOff, nil).client, nil
+}
+
+func newHTTPClient(timeout time.Duration, maxRetries int, logger Logger) *HTTPClient {
+	return newHTTPClientTLS(transportTimeouts{total: timeout}, maxRetries, &tls.Config{MinVersion: tls.VersionTLS12}, nil, false, logger)
+}
+
+// Compression modes for Config.Compression.
+const (
+	CompressionAuto = "auto" // the transport negotiates gzip and decodes it (the default)
+	CompressionOff  = "off"  // no Accept-Encoding: bodies arrive as stored
+	CompressionGzip = "gzip" // Accept-Encoding: gzip on every download, decoded after it
+)
+
+// transportTimeouts bound the phases of a request made by the default
+// transport. Zero connect and responseHeader mean 30s; zero total means no
+// overall limit beyond the request's context.
+type transportTimeouts struct {
+	total          time.Duration
+	connect        time.Duration // TCP connect, and separately the TLS handshake
+	responseHeader time.Duration // from request written to response headers
+}
+
+const (
+	defaultConnectTimeout        = 30 * time.Second
+	defaultResponseHeaderTimeout = 30 * time.Second
+)
+
+// newHTTPClientTLS is newHTTPClient with custom timeouts, TLS
+// configuration and proxy selection; a nil proxy uses the environment.
+// With noCompression the transport never asks for gzip on its own.
+func newHTTPClientTLS(timeouts transportTimeouts, maxRetries int, tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error), noCompression bool, logger Logger) *HTTPClient {
+	if maxRetries < 1 {
+		maxRetries = 1
+	}
+	connect := timeouts.connect
+	if connect <= 0 {
+		connect = defaultConnectTimeout
+	}
+	responseHeader := timeouts.responseHeader
+	if responseHeader <= 0 {
+		responseHeader = defaultResponseHeaderTimeout
+	}
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+	return &HTTPClient{
+		client: withDebug(&http.Client{
+			// Connect, TLS and response headers are bounded by the
+			// transport so a dead host fails fast; the body is guarded by
+			// the stall timeout and, for downloads, the per-database
+			// deadline (Config.Timeout) carried by the request context.
+			Timeout: timeouts.total,
+			Transport: &http.Transport{
+				Proxy:                 proxy,
+				TLSClientConfig:       tlsConfig,
+				DialContext:           (&net.Dialer{Timeout: connect}).DialContext,
+				TLSHandshakeTimeout:   connect,
+				ResponseHeaderTimeout: responseHeader,
+				MaxIdleConns:          100,
+				IdleConnTimeout:       90 * time.Second,
+				DisableCompression:    noCompression,
+			},
+		}, logger),
+		maxRetries: maxRetries,
+		logger:     logger,
+	}
+}
+
+// isRetryable reports whether a request that ended in resp or err is worth
+// another attempt. Transport failures (DNS, refused or reset connections,
+// timeouts) and 408/429/5xx responses are transient; other 4xx responses,
+// TLS certificate errors and cancellation are permanent.
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return false
+		}
+		var verifyErr *tls.CertificateVerificationError
+		var unknownAuthority x509.UnknownAuthorityError
+		var invalidCert x509.CertificateInvalidError
+		var hostnameErr x509.HostnameError
+		if errors.As(err, &verifyErr) || errors.As(err, &unknownAuthority) ||
+			errors.As(err, &invalidCert) || errors.As(err, &hostnameErr) {
+			return false
+		}
+		return true
+	}
+	if resp == nil {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return resp.StatusCode >= 500
+}
+
+// throttledKey carries the callback told about every 429 response of the
+// requests made with a context; see withThrottled.
+type throttledKey struct{}
+
+// withThrottled returns a copy of ctx whose requests report each 429 to
+// fn, so concurrent Update runs sharing one HTTPClient each adapt their own
+// concurrency.
+func withThrottled(ctx context.Context, fn func()) context.Context {
+	return context.WithValue(ctx, throttledKey{}, fn)
+}
+
+func throttledFrom(ctx context.Context) func() {
+	fn, _ := ctx.Value(throttledKey{}).(func())
+	return fn
+}
+
+// rejectedError is a 401 or 403: the server refused the credentials, which
+// retrying, or another endpoint of the same service, won't change.
+type rejectedError struct{ msg string }
+
+func (e *rejectedError) Error() string { return e.msg }
+
+// IsRejected reports whether err is a 401 or 403 from the server, so
+// callers failing over across mirrors can stop at the first refusal.
+func IsRejected(err error) bool {
+	var r *rejectedError
+	return errors.As(err, &r)
+}
+
+// permanentError marks a failure doWithRetry did not retry, so callers with
+// their own retry loop (fetchToFile) give up too.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// isPermanent reports whether err came from a failure isRetryable rejected.
+func isPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// doWithRetry sends req, retrying failures that isRetryable considers
+// transient and returning permanent ones after the first attempt. The
+// request's context bounds the whole exchange: cancelling it aborts both an
+// in-flight attempt and the wait between attempts, and its error is
+// returned as is.
+func (h *HTTPClient) doWithRetry(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	var lastErr error
+	var retryAfter time.Duration // server-requested wait, used instead of the backoff
+	begin := time.Now()
+
+	for attempt := 0; attempt < h.maxRetries; attempt++ {
+		if attempt > 0 {
+			delay := retryAfter
+			if delay <= 0 {
+				delay = h.retryDelay(attempt)
+				debugf(h.logger, "Backoff before attempt %d: %v of at most %v", attempt+1, delay, h.backoff.cap(attempt))
+			}
+			retryAfter = 0
+			// Don't start a wait the retry budget can't cover. The budget is
+			// spent for any caller retrying on top, so this is permanent.
+			if budget := h.backoff.MaxElapsed; budget > 0 && time.Since(begin)+delay > budget {
+				debugf(h.logger, "Retry budget of %v exhausted after %v", budget, time.Since(begin).Round(time.Millisecond))
+				return nil, &permanentError{fmt.Errorf("gave up after %d attempts: retry budget of %v exhausted: %w", attempt, budget, lastErr)}
+			}
+			h.logger.Info("Retrying in %v... (attempt %d/%d)", delay.Round(time.Millisecond), attempt+1, h.maxRetries)
+			if err := sleepContext(ctx, delay); err != nil {
+				return nil, err
+			}
+			h.retries.Add(1)
+			// The previous attempt consumed the body; start a fresh copy.
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, &permanentError{err}
+				}
+				req.Body = body
+			}
+		}
+
+		debugf(h.logger, "Attempt %d/%d: %s %s", attempt+1, h.maxRetries, req.Method, redactURL(req.URL.String()))
+		start := time.Now()
+		attemptReq, cancelAttempt := h.attemptRequest(req)
+		resp, err := h.client.Do(attemptReq)
+		if err != nil {
+			cancelAttempt()
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			if h.attemptTimeout > 0 && errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("attempt timed out after %v: %w", h.attemptTimeout, err)
+			}
+			if !isRetryable(nil, err) {
+				debugf(h.logger, "Attempt %d failed after %v, not retrying (permanent): %v", attempt+1, time.Since(start).Round(time.Millisecond), err)
+				return nil, &permanentError{err}
+			}
+			lastErr = err
+			h.logger.Warn("Request failed: %v", err)
+			debugf(h.logger, "Attempt %d failed after %v, retryable", attempt+1, time.Since(start).Round(time.Millisecond))
+			continue
+		}
+		resp.Body = cancelOnClose{resp.Body, cancelAttempt}
+		debugf(h.logger, "Attempt %d: HTTP %d after %v", attempt+1, resp.StatusCode, time.Since(start).Round(time.Millisecond))
+
+		// Check status code
+		if h.accepted[resp.StatusCode] {
+			return resp, nil
+		}
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+			// 200 full, 206 resumed range, 416 range-not-satisfiable (already complete)
+			return resp, nil
+		case http.StatusNotModified:
+			// Conditional request matched the cached ETag/Last-Modified.
+			return resp, nil
+		case http.StatusTooManyRequests:
+			resp.Body.Close()
+			if throttled := throttledFrom(ctx); throttled != nil {
+				throttled()
+			}
+			if header := resp.Header.Get("Retry-After"); header != "" {
+				if d, ok := parseRetryAfter(header, time.Now()); ok {
+					retryAfter = d
+					debugf(h.logger, "Retry-After %q: next attempt in %v", header, retryAfter)
+				} else {
+					debugf(h.logger, "Ignoring unparseable Retry-After %q", header)
+				}
+			}
+			lastErr = fmt.Errorf("rate limited")
+			if !h.retryStatus(resp.StatusCode) {
+				debugf(h.logger, "HTTP 429 is not in the retried statuses, not retrying")
+				return nil, &permanentError{lastErr}
+			}
+			h.logger.Warn("Rate limited (429)")
+		case http.StatusUnauthorized:
+			resp.Body.Close()
+			return nil, &permanentError{&rejectedError{"authentication failed (401) - check your API key"}}
+		case http.StatusForbidden:
+			resp.Body.Close()
+			return nil, &permanentError{&rejectedError{"access forbidden (403) - check your permissions"}}
+		default:
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			lastErr = fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+			if !h.retryStatus(resp.StatusCode) {
+				debugf(h.logger, "HTTP %d is permanent, not retrying", resp.StatusCode)
+				return nil, &permanentError{lastErr}
+			}
+			h.logger.Warn("HTTP error %d", resp.StatusCode)
+			debugf(h.logger, "HTTP %d is retryable", resp.StatusCode)
+		}
+	}
+
+	return nil, fmt.Errorf("failed after %d attempts (retried on network errors and HTTP %s): %w", h.maxRetries, h.retriedStatuses(), lastErr)
+}
+
+// Do sends req with the client's retry policy: transient failures are
+// retried with exponential backoff, permanent ones returned at once. A
+// request with a body must be replayable (GetBody set, as it is by
+// http.NewRequest for in-memory bodies).
+func (h *HTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return h.doWithRetry(req)
+}
+
+// maxRetryAfter caps the wait a server can request with Retry-After.
+const maxRetryAfter = 10 * time.Minute
+
+// parseRetryAfter interprets a Retry-After value, either delay-seconds or an
+// HTTP-date, as a wait from now, clamped to [0, maxRetryAfter]. ok is false
+// when the value is neither.
+func parseRetryAfter(value string, now time.Time) (d time.Duration, ok bool) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		d = t.Sub(now)
+	} else {
+		return 0, false
+	}
+	if d < 0 {
+		d = 0
+	}
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d, true
+}
+
+// sleepContext waits for d or until ctx is done, returning ctx.Err() in the
+// latter case.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,364 @@
+package geoip
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	urlErr := func(err error) error { return &url.Error{Op: "Get", URL: "https://example.com", Err: err} }
+	errCases := map[string]struct {
+		err  error
+		want bool
+	}{
+		"dns":         {urlErr(&net.DNSError{Err: "no such host", Name: "example.com"}), true},
+		"reset":       {urlErr(&net.OpError{Op: "read", Err: syscall.ECONNRESET}), true},
+		"refused":     {urlErr(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), true},
+		"unknown CA":  {urlErr(x509.UnknownAuthorityError{}), false},
+		"hostname":    {urlErr(x509.HostnameError{Host: "example.com"}), false},
+		"cancelled":   {urlErr(context.Canceled), false},
+		"wrapped TLS": {fmt.Errorf("attempt: %w", urlErr(x509.CertificateInvalidError{})), false},
+	}
+	for name, c := range errCases {
+		if got := isRetryable(nil, c.err); got != c.want {
+			t.Errorf("%s: isRetryable = %v, want %v", name, got, c.want)
+		}
+	}
+
+	for status, want := range map[int]bool{
+		400: false, 404: false, 410: false, 408: true, 429: true,
+		500: true, 502: true, 503: true, 504: true,
+	} {
+		if got := isRetryable(&http.Response{StatusCode: status}, nil); got != want {
+			t.Errorf("HTTP %d: isRetryable = %v, want %v", status, got, want)
+		}
+	}
+}
+
+// TestDoWithRetryPermanent verifies permanent failures use a single attempt
+// and transient ones use them all.
+func TestDoWithRetryPermanent(t *testing.T) {
+	var hits int32
+	status := http.StatusNotFound
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	h := NewHTTPClient(srv.Client(), 2, &ConsoleLogger{quiet: true})
+	get := func(target string) error {
+		req, _ := http.NewRequest("GET", target, nil)
+		_, err := h.doWithRetry(req)
+		return err
+	}
+
+	if err := get(srv.URL); !isPermanent(err) || atomic.LoadInt32(&hits) != 1 {
+		t.Errorf("404: err = %v after %d attempts, want one permanent failure", err, hits)
+	}
+
+	atomic.StoreInt32(&hits, 0)
+	status = http.StatusServiceUnavailable
+	if err := get(srv.URL); err == nil || isPermanent(err) || atomic.LoadInt32(&hits) != 2 {
+		t.Errorf("503: err = %v after %d attempts, want 2 transient failures", err, hits)
+	}
+
+	// A 204 is no download; only a client told to accept it returns it.
+	atomic.StoreInt32(&hits, 0)
+	status = http.StatusNoContent
+	if err := get(srv.URL); !isPermanent(err) || atomic.LoadInt32(&hits) != 1 {
+		t.Errorf("204: err = %v after %d attempts, want one permanent failure", err, hits)
+	}
+	h.SetAccepted([]int{http.StatusNoContent})
+	if err := get(srv.URL); err != nil {
+		t.Errorf("accepted 204: err = %v", err)
+	}
+
+	// The default transport does not trust httptest's certificate.
+	tlsSrv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tlsSrv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+	h = NewHTTPClient(&http.Client{Timeout: 5 * time.Second}, 3, &ConsoleLogger{quiet: true})
+	begin := time.Now()
+	if err := get(tlsSrv.URL); !isPermanent(err) {
+		t.Errorf("bad certificate: err = %v, want permanent", err)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("bad certificate retried for %v", elapsed)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Base: 100 * time.Millisecond, Multiplier: 3, Max: time.Second}
+	for retry, want := range map[int]time.Duration{
+		1: 100 * time.Millisecond, 2: 300 * time.Millisecond, 3: 900 * time.Millisecond, 4: time.Second, 50: time.Second,
+	} {
+		if got := b.cap(retry); got != want {
+			t.Errorf("cap(%d) = %v, want %v", retry, got, want)
+		}
+	}
+	if got := (Backoff{}).cap(3); got != 4*time.Second {
+		t.Errorf("default cap(3) = %v, want 4s", got)
+	}
+
+	h := &HTTPClient{backoff: b}
+	for i := 0; i < 100; i++ {
+		if d := h.retryDelay(2); d < 0 || d > 300*time.Millisecond {
+			t.Fatalf("retryDelay(2) = %v, want within [0, 300ms]", d)
+		}
+	}
+
+	// The same seed gives the same waits; another seed does not.
+	waits := func(seed int64) []time.Duration {
+		h := &HTTPClient{backoff: b}
+		h.SetJitterSeed(seed)
+		var d []time.Duration
+		for retry := 1; retry <= 8; retry++ {
+			d = append(d, h.retryDelay(retry))
+		}
+		return d
+	}
+	if x, y := waits(42), waits(42); !reflect.DeepEqual(x, y) {
+		t.Errorf("seed 42 gave %v then %v", x, y)
+	}
+	if x, y := waits(42), waits(43); reflect.DeepEqual(x, y) {
+		t.Errorf("seeds 42 and 43 both gave %v", x)
+	}
+}
+
+// TestRetryAfterOverridesBackoff verifies a 429's Retry-After is waited out
+// instead of the jittered backoff.
+func TestRetryAfterOverridesBackoff(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	h := NewHTTPClient(srv.Client(), 2, &ConsoleLogger{quiet: true})
+	var jittered int32
+	h.jitter = func(limit time.Duration) time.Duration { atomic.AddInt32(&jittered, 1); return 0 }
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	begin := time.Now()
+	resp, err := h.doWithRetry(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(begin); elapsed < time.Second {
+		t.Errorf("retried after %v, want Retry-After's 1s", elapsed)
+	}
+	if jittered != 0 {
+		t.Error("backoff used despite Retry-After")
+	}
+}
+
+// TestThrottledPerContext verifies a 429 is reported to the callback of the
+// request's own context, so Update runs sharing a client don't see each
+// other's throttling.
+func TestThrottledPerContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("throttle") != "" {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	h := NewHTTPClient(srv.Client(), 2, &ConsoleLogger{quiet: true})
+	h.jitter = func(time.Duration) time.Duration { return 0 }
+	var a, b int32
+	ctxA := withThrottled(context.Background(), func() { atomic.AddInt32(&a, 1) })
+	ctxB := withThrottled(context.Background(), func() { atomic.AddInt32(&b, 1) })
+
+	req, _ := http.NewRequestWithContext(ctxA, "GET", srv.URL+"?throttle=1", nil)
+	if _, err := h.doWithRetry(req); err == nil {
+		t.Fatal("expected the 429s to exhaust the retries")
+	}
+	req, _ = http.NewRequestWithContext(ctxB, "GET", srv.URL, nil)
+	resp, err := h.doWithRetry(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if a != 2 || b != 0 {
+		t.Errorf("throttled callbacks: a=%d b=%d, want 2 and 0", a, b)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"30", 30 * time.Second, true},
+		{" 5 ", 5 * time.Second, true},
+		{"Fri, 01 Mar 2024 12:00:45 GMT", 45 * time.Second, true},
+		{"Friday, 01-Mar-24 12:02:00 GMT", 2 * time.Minute, true},
+		{"Fri, 01 Mar 2024 11:00:00 GMT", 0, true},
+		{"Sat, 02 Mar 2024 12:00:00 GMT", maxRetryAfter, true},
+		{"86400", maxRetryAfter, true},
+		{"soon", 0, false},
+	}
+	for _, c := range cases {
+		got, ok := parseRetryAfter(c.value, now)
+		if got != c.want || ok != c.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", c.value, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+// TestRetryOn verifies SetRetryOn replaces the retried statuses, 429
+// included, and that exhausted retries name them.
+func TestRetryOn(t *testing.T) {
+	var hits int32
+	status := http.StatusBadGateway
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	h := NewHTTPClient(srv.Client(), 2, &ConsoleLogger{quiet: true})
+	h.jitter = func(time.Duration) time.Duration { return 0 }
+	get := func() error {
+		atomic.StoreInt32(&hits, 0)
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		_, err := h.doWithRetry(req)
+		return err
+	}
+
+	if err := get(); err == nil || !strings.Contains(err.Error(), "HTTP 408, 429 and 5xx") || hits != 2 {
+		t.Errorf("default 502: err = %v after %d attempts", err, hits)
+	}
+
+	h.SetRetryOn([]int{503, 409})
+	if err := get(); !isPermanent(err) || hits != 1 {
+		t.Errorf("502 not listed: err = %v after %d attempts, want one permanent failure", err, hits)
+	}
+	status = http.StatusTooManyRequests
+	if err := get(); !isPermanent(err) || hits != 1 {
+		t.Errorf("429 not listed: err = %v after %d attempts, want one permanent failure", err, hits)
+	}
+	status = http.StatusConflict
+	if err := get(); err == nil || !strings.Contains(err.Error(), "HTTP 409, 503") || hits != 2 {
+		t.Errorf("409 listed: err = %v after %d attempts", err, hits)
+	}
+}
+
+// TestRetryMaxElapsed verifies the retry budget ends retries before
+// MaxRetries does.
+func TestRetryMaxElapsed(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	h := NewHTTPClient(srv.Client(), 10, &ConsoleLogger{quiet: true})
+	h.SetBackoff(Backoff{MaxElapsed: 500 * time.Millisecond})
+	h.jitter = func(time.Duration) time.Duration { return 200 * time.Millisecond }
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	begin := time.Now()
+	_, err := h.doWithRetry(req)
+	if err == nil || !strings.Contains(err.Error(), "retry budget of 500ms exhausted") || !isPermanent(err) {
+		t.Errorf("err = %v, want a permanent budget error", err)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("retried for %v", elapsed)
+	}
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Errorf("%d attempts, want 3", n)
+	}
+}
+
+// TestRetrySleepCancelled verifies cancelling the request's context ends a
+// long backoff wait at once with the context's error.
+func TestRetrySleepCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	h := NewHTTPClient(srv.Client(), 3, &ConsoleLogger{quiet: true})
+	h.jitter = func(time.Duration) time.Duration { return time.Minute }
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+
+	begin := time.Now()
+	_, err := h.doWithRetry(req)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Errorf("returned after %v, want promptly after cancellation", elapsed)
+	}
+}
+
+// TestAttemptTimeout verifies each attempt gets its own deadline: a hung
+// first attempt times out and the retry succeeds with its body readable,
+// while attempts that all hang fail with a timeout.
+func TestAttemptTimeout(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) != 2 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	h := NewHTTPClient(srv.Client(), 2, &ConsoleLogger{quiet: true})
+	h.jitter = func(time.Duration) time.Duration { return 0 }
+	h.SetAttemptTimeout(200 * time.Millisecond)
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	resp, err := h.doWithRetry(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "ok" || hits != 2 {
+		t.Errorf("body %q, err %v after %d attempts", body, err, hits)
+	}
+
+	req, _ = http.NewRequest("GET", srv.URL, nil)
+	if _, err := h.doWithRetry(req); err == nil || !strings.Contains(err.Error(), "attempt timed out after 200ms") {
+		t.Errorf("err = %v, want an attempt timeout", err)
+	}
+
+	// Without an attempt timeout, a deadline from elsewhere is reported
+	// as it is.
+	client := srv.Client()
+	client.Timeout = 200 * time.Millisecond
+	h = NewHTTPClient(client, 1, &ConsoleLogger{quiet: true})
+	req, _ = http.NewRequest("GET", srv.URL, nil)
+	if _, err := h.doWithRetry(req); err == nil || strings.Contains(err.Error(), "attempt timed out") {
+		t.Errorf("err = %v, want the transport's own timeout", err)
+	}
+}
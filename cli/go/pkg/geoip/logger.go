@@ -0,0 +1,278 @@
+package geoip
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger receives the updater's log output. Messages are printf-style
+// formats. ConsoleLogger, returned by NewLogger, is the default; callers
+// with their own logging can pass any implementation in Options.Logger,
+// such as NewSlogLogger. A Logger that also has a Debug method with the
+// same signature receives the wire-level detail of Config.Debug.
+type Logger interface {
+	Info(format string, args ...interface{})
+	Warn(format string, args ...interface{})
+	Error(format string, args ...interface{})
+	Success(format string, args ...interface{})
+}
+
+// debugLogger is implemented by Loggers that accept DEBUG messages.
+type debugLogger interface {
+	Debug(format string, args ...interface{})
+}
+
+// fieldLogger is implemented by Loggers that keep structured fields
+// (progress counters, HTTP status and timings) alongside the message.
+type fieldLogger interface {
+	logFields(level, message string, fields map[string]interface{})
+}
+
+// debugf logs at DEBUG level if l supports it.
+func debugf(l Logger, format string, args ...interface{}) {
+	if d, ok := l.(debugLogger); ok {
+		d.Debug(format, args...)
+	}
+}
+
+// logFields logs message at level with fields where l keeps them, and as a
+// plain message at the nearest level otherwise.
+func logFields(l Logger, level, message string, fields map[string]interface{}) {
+	if f, ok := l.(fieldLogger); ok {
+		f.logFields(level, message, fields)
+		return
+	}
+	switch level {
+	case "DEBUG":
+		debugf(l, "%s", message)
+	case "WARN":
+		l.Warn("%s", message)
+	case "ERROR":
+		l.Error("%s", message)
+	case "SUCCESS":
+		l.Success("%s", message)
+	default:
+		l.Info("%s", message)
+	}
+}
+
+// debugEnabled reports whether l wants DEBUG messages: any Logger with a
+// Debug method, unless it reports otherwise through debugOn (ConsoleLogger
+// without Config.Debug, a slog handler above Debug level).
+func debugEnabled(l Logger) bool {
+	if e, ok := l.(interface{ debugOn() bool }); ok {
+		return e.debugOn()
+	}
+	_, ok := l.(debugLogger)
+	return ok
+}
+
+// ConsoleLogger is the default Logger: colored level tags on the console,
+// or plain lines to a writer, optionally mirrored to a log file, as text
+// or JSON.
+type ConsoleLogger struct {
+	quiet    bool
+	verbose  bool
+	debug    bool // log DEBUG lines, regardless of quiet and verbose
+	json     bool // one JSON object per line instead of text
+	colorOut bool // color console lines written to stdout
+	colorErr bool // color console lines written to stderr
+	file     *os.File
+	out      io.Writer // plain-text destination; nil means the console
+	mu       sync.Mutex
+	progress *progress // active progress bars, cleared around each line
+}
+
+// NewLogger creates a ConsoleLogger honouring config's Quiet, Verbose, LogFile and
+// LogFormat settings. Lines are written uncolored to out, or to the colored
+// console (os.Stdout/os.Stderr) when out is nil; see Config.Color for when
+// the console is colored. With LogFormat "json" every line, console and file
+// alike, is a JSON object instead.
+func NewLogger(config *Config, out io.Writer) (*ConsoleLogger, error) {
+	l := &ConsoleLogger{
+		quiet:    config.Quiet,
+		verbose:  config.Verbose,
+		debug:    config.Debug,
+		json:     config.LogFormat == "json",
+		out:      out,
+		colorOut: useColor(config.Color, os.Stdout),
+		colorErr: useColor(config.Color, os.Stderr),
+	}
+
+	if config.LogFile != "" {
+		// Create log directory if needed
+		logDir := filepath.Dir(config.LogFile)
+		if err := os.MkdirAll(logDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create log directory: %w", err)
+		}
+
+		// Open log file
+		file, err := os.OpenFile(config.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		l.file = file
+	}
+
+	return l, nil
+}
+
+// useColor decides whether console output to f is colored: always or never
+// when mode says so, otherwise only when f is a terminal and NO_COLOR is
+// unset (https://no-color.org).
+func useColor(mode string, f *os.File) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isTerminal(f)
+}
+
+// levelColors are the ANSI SGR codes of the colored console level tags.
+var levelColors = map[string]string{
+	"ERROR":   "0;31",
+	"WARN":    "1;33",
+	"SUCCESS": "0;32",
+	"INFO":    "0;34",
+	"DEBUG":   "0;36",
+}
+
+// levelTag renders "[LEVEL]", wrapped in the level's color if color is set.
+func levelTag(level string, color bool) string {
+	if code, ok := levelColors[level]; ok && color {
+		return "\033[" + code + "m[" + level + "]\033[0m"
+	}
+	return "[" + level + "]"
+}
+
+// jsonLogLine renders an entry for LogFormat "json": timestamp, level and
+// message, followed by fields.
+func jsonLogLine(now time.Time, level, message string, fields map[string]interface{}) string {
+	entry := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["timestamp"] = now.Format("2006-01-02T15:04:05.000Z07:00")
+	entry["level"] = strings.ToLower(level)
+	entry["message"] = message
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"timestamp": entry["timestamp"].(string), "level": "error", "message": "unencodable log entry: " + message})
+	}
+	return string(data)
+}
+
+// log is the single entry point for every line: level filtering, the log
+// file, progress-bar interplay and both text and JSON formatting. fields
+// carry structured data for the JSON format; the text format relies on
+// message alone.
+func (l *ConsoleLogger) log(level, message string, fields map[string]interface{}) {
+	if level == "DEBUG" && !l.debug {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	timestamp := now.Format("2006-01-02 15:04:05")
+	var jsonLine string
+	if l.json {
+		jsonLine = jsonLogLine(now, level, message, fields)
+	}
+
+	// Write to file if configured
+	if l.file != nil {
+		if l.json {
+			fmt.Fprintln(l.file, jsonLine)
+		} else {
+			fmt.Fprintf(l.file, "[%s] [%s] %s\n", timestamp, level, message)
+		}
+	}
+
+	// Keep progress bars below log output: erase them, print, redraw.
+	l.progress.clearLocked()
+	defer l.progress.redrawLocked()
+
+	if l.out != nil {
+		if level == "ERROR" || level == "DEBUG" || (!l.quiet && (level != "INFO" || l.verbose)) {
+			if l.json {
+				fmt.Fprintln(l.out, jsonLine)
+			} else {
+				fmt.Fprintf(l.out, "[%s] %s\n", level, message)
+			}
+		}
+		return
+	}
+
+	// Write to console based on level and settings
+	if l.json {
+		switch {
+		case level == "ERROR" || level == "DEBUG" || (level == "WARN" && !l.quiet):
+			fmt.Fprintln(os.Stderr, jsonLine)
+		case !l.quiet && (level != "INFO" || l.verbose):
+			fmt.Println(jsonLine)
+		}
+		return
+	}
+	if level == "DEBUG" {
+		fmt.Fprintf(os.Stderr, "%s %s\n", levelTag(level, l.colorErr), message)
+	} else if !l.quiet {
+		switch level {
+		case "ERROR", "WARN":
+			fmt.Fprintf(os.Stderr, "%s %s\n", levelTag(level, l.colorErr), message)
+		case "INFO":
+			if l.verbose {
+				fmt.Printf("%s %s\n", levelTag(level, l.colorOut), message)
+			}
+		default:
+			fmt.Printf("%s %s\n", levelTag(level, l.colorOut), message)
+		}
+	} else if level == "ERROR" {
+		// Always output errors
+		fmt.Fprintf(os.Stderr, "[%s] %s\n", timestamp, message)
+	}
+}
+
+func (l *ConsoleLogger) debugOn() bool { return l.debug }
+
+func (l *ConsoleLogger) logFields(level, message string, fields map[string]interface{}) {
+	l.log(level, message, fields)
+}
+
+func (l *ConsoleLogger) Info(format string, args ...interface{}) {
+	l.log("INFO", fmt.Sprintf(format, args...), nil)
+}
+
+func (l *ConsoleLogger) Warn(format string, args ...interface{}) {
+	l.log("WARN", fmt.Sprintf(format, args...), nil)
+}
+
+func (l *ConsoleLogger) Error(format string, args ...interface{}) {
+	l.log("ERROR", fmt.Sprintf(format, args...), nil)
+}
+
+func (l *ConsoleLogger) Success(format string, args ...interface{}) {
+	l.log("SUCCESS", fmt.Sprintf(format, args...), nil)
+}
+
+// Debug logs wire-level detail, shown only with Config.Debug.
+func (l *ConsoleLogger) Debug(format string, args ...interface{}) {
+	l.log("DEBUG", fmt.Sprintf(format, args...), nil)
+}
+
+func (l *ConsoleLogger) Close() {
+	if l.file != nil {
+		l.file.Close()
+	}
+}
@@ -0,0 +1,92 @@
+package geoip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoggerJSON verifies LogFormat "json" writes one object per line to
+// both the console writer and the log file, with structured fields.
+func TestLoggerJSON(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "update.log")
+	var out bytes.Buffer
+	l, err := NewLogger(&Config{LogFormat: "json", LogFile: logFile, Verbose: true}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("Downloading: %s", "a.mmdb")
+	l.Error("boom")
+	l.log("PROGRESS", "a.mmdb 50%", map[string]interface{}{"database": "a.mmdb", "bytes": 512})
+	l.Close()
+
+	file, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dest, data := range map[string][]byte{"console": out.Bytes(), "file": file} {
+		var entries []map[string]interface{}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var e map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				t.Fatalf("%s: line %q is not JSON: %v", dest, scanner.Text(), err)
+			}
+			entries = append(entries, e)
+		}
+		if len(entries) != 3 {
+			t.Fatalf("%s: %d entries, want 3:\n%s", dest, len(entries), data)
+		}
+		if entries[0]["level"] != "info" || entries[0]["message"] != "Downloading: a.mmdb" || entries[0]["timestamp"] == nil {
+			t.Errorf("%s: entry 0 = %v", dest, entries[0])
+		}
+		if entries[1]["level"] != "error" {
+			t.Errorf("%s: entry 1 = %v", dest, entries[1])
+		}
+		if entries[2]["database"] != "a.mmdb" || entries[2]["bytes"] != float64(512) {
+			t.Errorf("%s: entry 2 = %v", dest, entries[2])
+		}
+	}
+}
+
+func TestLoggerText(t *testing.T) {
+	var out bytes.Buffer
+	l, err := NewLogger(&Config{}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("hidden without --verbose")
+	l.Warn("disk %s", "low")
+	if got := out.String(); got != "[WARN] disk low\n" || strings.Contains(got, "{") {
+		t.Errorf("output = %q", got)
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	// Test output is never a terminal, so auto means no color.
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	t.Setenv("NO_COLOR", "")
+	if useColor("auto", f) || useColor("", f) || useColor("never", f) || !useColor("always", f) {
+		t.Error("modes not honoured for a regular file")
+	}
+	t.Setenv("NO_COLOR", "1")
+	if !useColor("always", f) {
+		t.Error("--color=always must override NO_COLOR")
+	}
+
+	if got := levelTag("WARN", true); got != "\033[1;33m[WARN]\033[0m" {
+		t.Errorf("colored tag = %q", got)
+	}
+	if got := levelTag("WARN", false); got != "[WARN]" {
+		t.Errorf("plain tag = %q", got)
+	}
+}
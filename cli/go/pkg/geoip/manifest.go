@@ -0,0 +1,162 @@
+package geoip
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// manifestName returns the checksum manifest file name for algo, as used by
+// the coreutils tools: SHA256SUMS or SHA512SUMS.
+func manifestName(algo string) (string, error) {
+	switch algo {
+	case "", "sha256":
+		return "SHA256SUMS", nil
+	case "sha512":
+		return "SHA512SUMS", nil
+	}
+	return "", fmt.Errorf("unsupported manifest algorithm %q: use sha256 or sha512", algo)
+}
+
+// writeManifest writes a "<hash>  <file>" line for every installed database
+// in results to the manifest in TargetDir, checkable with "sha256sum -c"
+// (or sha512sum) from that directory. Downloaded and unchanged files are
+// both listed, so the manifest always describes the whole directory.
+func (g *Updater) writeManifest(results []DownloadResult) error {
+	name, err := manifestName(g.config.ManifestAlgo)
+	if err != nil {
+		return err
+	}
+
+	// results are sorted by database, which keeps the manifest stable.
+	var lines []string
+	for _, r := range results {
+		if r.Error != nil || r.Path == "" {
+			continue
+		}
+		sum := r.SHA256
+		if name != "SHA256SUMS" || sum == "" {
+			if sum, err = fileDigest(r.Path, name); err != nil {
+				return fmt.Errorf("failed to hash %s: %w", r.Path, err)
+			}
+		}
+		rel, err := filepath.Rel(g.config.TargetDir, r.Path)
+		if err != nil {
+			rel = filepath.Base(r.Path)
+		}
+		lines = append(lines, strings.ToLower(sum)+"  "+filepath.ToSlash(rel)+"\n")
+	}
+
+	// Replace rather than rewrite: with AtomicDir the previous manifest is
+	// a hard link into the live directory.
+	path := filepath.Join(g.config.TargetDir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "")), 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	g.logger.Info("Wrote %s for %d databases", path, len(lines))
+	return nil
+}
+
+// fileDigest hashes path with the algorithm of the manifest name.
+func fileDigest(path, manifest string) (string, error) {
+	var h hash.Hash = sha256.New()
+	if manifest == "SHA512SUMS" {
+		h = sha512.New()
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// jsonManifest is the file written to Config.ManifestJSON.
+type jsonManifest struct {
+	GeneratedAt string              `json:"generated_at"` // RFC3339
+	TargetDir   string              `json:"target_dir"`
+	Databases   []jsonManifestEntry `json:"databases"`
+}
+
+type jsonManifestEntry struct {
+	Name        string `json:"name"`
+	File        string `json:"file"` // relative to target_dir
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	SourceURL   string `json:"source_url,omitempty"` // query string redacted
+	Status      string `json:"status"`               // "downloaded" or "unchanged"
+	InstalledAt string `json:"installed_at"`         // RFC3339, the file's mtime
+}
+
+// writeManifestJSON atomically replaces Config.ManifestJSON with a record of
+// every database installed in TargetDir.
+func (g *Updater) writeManifestJSON(results []DownloadResult) error {
+	m := jsonManifest{
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		TargetDir:   g.config.TargetDir,
+		Databases:   []jsonManifestEntry{},
+	}
+	for _, r := range results {
+		if r.Error != nil || r.Path == "" {
+			continue
+		}
+		fi, err := os.Stat(r.Path)
+		if err != nil {
+			return err
+		}
+		sum := r.SHA256
+		if sum == "" {
+			if sum, err = fileSHA256(r.Path); err != nil {
+				return fmt.Errorf("failed to hash %s: %w", r.Path, err)
+			}
+		}
+		rel, err := filepath.Rel(g.config.TargetDir, r.Path)
+		if err != nil {
+			rel = filepath.Base(r.Path)
+		}
+		e := jsonManifestEntry{
+			Name: r.Database, File: filepath.ToSlash(rel), Size: fi.Size(), SHA256: strings.ToLower(sum),
+			Status: "downloaded", InstalledAt: fi.ModTime().UTC().Format(time.RFC3339),
+		}
+		if r.url != "" {
+			e.SourceURL = redactURL(r.url)
+		}
+		if r.Unchanged || r.NotNewer {
+			e.Status = "unchanged"
+		}
+		m.Databases = append(m.Databases, e)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := g.config.ManifestJSON + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, g.config.ManifestJSON); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	g.logger.Info("Wrote manifest %s for %d databases", g.config.ManifestJSON, len(m.Databases))
+	return nil
+}
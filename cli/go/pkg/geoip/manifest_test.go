@@ -0,0 +1,105 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteManifest(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.mmdb"), []byte("aaa"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.BIN"), []byte("bbb"), 0644)
+	results := []DownloadResult{
+		{Database: "a.mmdb", Path: filepath.Join(dir, "a.mmdb"), SHA256: "9834876DCFB05CB167A5C24953EBA58C4AC89B1ADF57F28F2F9D09AF107EE8F0"},
+		{Database: "b.BIN.zip", Path: filepath.Join(dir, "b.BIN"), Unchanged: true},
+		{Database: "c.mmdb", Error: errors.New("boom")},
+	}
+
+	g := &Updater{config: &Config{TargetDir: dir}, logger: &ConsoleLogger{quiet: true}}
+	if err := g.writeManifest(results); err != nil {
+		t.Fatal(err)
+	}
+	want := "9834876dcfb05cb167a5c24953eba58c4ac89b1adf57f28f2f9d09af107ee8f0  a.mmdb\n" +
+		"3e744b9dc39389baf0c5a0660589b8402f3dbb49b89b3e75f2c9355852a3c677  b.BIN\n"
+	if data, _ := os.ReadFile(filepath.Join(dir, "SHA256SUMS")); string(data) != want {
+		t.Errorf("SHA256SUMS:\n%s\nwant:\n%s", data, want)
+	}
+
+	g.config.ManifestAlgo = "sha512"
+	if err := g.writeManifest(results[:1]); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha512.Sum512([]byte("aaa"))
+	if data, _ := os.ReadFile(filepath.Join(dir, "SHA512SUMS")); string(data) != hex.EncodeToString(sum[:])+"  a.mmdb\n" {
+		t.Errorf("SHA512SUMS = %q", data)
+	}
+}
+
+// TestManifestJSON verifies the JSON manifest records each installed
+// database without the URL's query string, and lands in the swapped-in
+// directory with AtomicDir.
+func TestManifestJSON(t *testing.T) {
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" {
+			json.NewEncoder(w).Encode(map[string]string{"a.db": srvURL + "/a.db?X-Amz-Signature=secret"})
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "a.db", time.Time{}, bytes.NewReader([]byte("aaa")))
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	target := filepath.Join(t.TempDir(), "geoip")
+	manifest := filepath.Join(target, "manifest.json")
+	cfg := &Config{
+		APIEndpoints: []string{srv.URL + "/auth"}, TargetDir: target, Timeout: 5 * time.Second,
+		MaxRetries: 1, SkipSpaceCheck: true, ManifestJSON: manifest,
+	}
+	for _, atomic := range []bool{false, true} {
+		cfg.AtomicDir = atomic
+		updater, err := New(cfg, Options{HTTPClient: srv.Client()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := updater.Update(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		updater.Close()
+
+		data, err := os.ReadFile(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m jsonManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatal(err)
+		}
+		want := "downloaded"
+		if atomic {
+			want = "unchanged"
+		}
+		if len(m.Databases) != 1 {
+			t.Fatalf("atomic %v: manifest %s", atomic, data)
+		}
+		e := m.Databases[0]
+		if e.File != "a.db" || e.Size != 3 || e.SHA256 != "9834876dcfb05cb167a5c24953eba58c4ac89b1adf57f28f2f9d09af107ee8f0" || e.Status != want {
+			t.Errorf("atomic %v: entry %+v", atomic, e)
+		}
+		if strings.Contains(e.SourceURL, "secret") || !strings.HasPrefix(e.SourceURL, srv.URL+"/a.db") {
+			t.Errorf("source_url = %q", e.SourceURL)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// mmdbMetadataMarker precedes the metadata map at the end of every MaxMind DB.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdbMetadataMaxSize bounds how far from the end of the file the metadata
+// marker is searched for, as in the MaxMind DB specification.
+const mmdbMetadataMaxSize = 128 * 1024
+
+// mmdbDataSeparator is the size of the zero-filled gap between the search
+// tree and the data section.
+const mmdbDataSeparator = 16
+
+// mmdbSampleIP is looked up by InspectMMDB to prove the search tree resolves.
+var mmdbSampleIP = net.ParseIP("8.8.8.8")
+
+// MMDBInfo describes a MaxMind DB, as reported by InspectMMDB.
+type MMDBInfo struct {
+	DatabaseType string
+	Description  string
+	Languages    []string
+	BuildEpoch   time.Time
+	IPVersion    int
+	RecordSize   int
+	NodeCount    int
+	// SampleFound reports whether the sample lookup (8.8.8.8) hit a record.
+	SampleFound bool
+}
+
+// ReadMMDBMetadata decodes the metadata section of the MaxMind DB at path
+// without touching the search tree or data section.
+func ReadMMDBMetadata(path string) (*MMDBInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, _, err := readMMDBMetadata(f)
+	return info, err
+}
+
+// InspectMMDB opens the MaxMind DB at path, decodes its metadata, checks that
+// the search tree and data section fit the file, and looks up a sample
+// address to confirm the tree leads to a decodable record. Unlike
+// ValidateMMDB it catches truncated or corrupt files that still carry the
+// metadata marker.
+func InspectMMDB(path string) (*MMDBInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, metaStart, err := readMMDBMetadata(f)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeBytes := int64(info.RecordSize) / 4
+	treeSize := nodeBytes * int64(info.NodeCount)
+	dataStart := treeSize + mmdbDataSeparator
+	dataEnd := metaStart - int64(len(mmdbMetadataMarker))
+	if dataStart > dataEnd {
+		return nil, fmt.Errorf("search tree (%d nodes) exceeds the data before the metadata at %d", info.NodeCount, metaStart)
+	}
+	sep := make([]byte, mmdbDataSeparator)
+	if _, err := f.ReadAt(sep, treeSize); err != nil {
+		return nil, fmt.Errorf("reading data section separator: %w", err)
+	}
+	if !bytes.Equal(sep, make([]byte, mmdbDataSeparator)) {
+		return nil, fmt.Errorf("corrupt search tree: data section separator is not zeroed")
+	}
+
+	t := &mmdbTree{r: f, nodeCount: info.NodeCount, recordSize: info.RecordSize}
+	record, err := t.lookup(mmdbSampleIP, info.IPVersion)
+	if err != nil {
+		return nil, fmt.Errorf("sample lookup of %s failed: %w", mmdbSampleIP, err)
+	}
+	if record > info.NodeCount {
+		offset := int64(record-info.NodeCount) - mmdbDataSeparator
+		data := &mmdbDecoder{r: f, base: dataStart, end: dataEnd}
+		if _, _, err := data.decode(offset, 0); err != nil {
+			return nil, fmt.Errorf("sample lookup of %s: invalid record: %w", mmdbSampleIP, err)
+		}
+		info.SampleFound = true
+	}
+	return info, nil
+}
+
+// readMMDBMetadata decodes and sanity-checks the metadata map of f and
+// returns it with the offset at which the metadata section starts.
+func readMMDBMetadata(f *os.File) (*MMDBInfo, int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := fi.Size()
+
+	// The marker is located in the tail; the metadata map follows it.
+	tailSize := int64(mmdbMetadataMaxSize)
+	if tailSize > size {
+		tailSize = size
+	}
+	tail := make([]byte, tailSize)
+	if _, err := f.ReadAt(tail, size-tailSize); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	idx := bytes.LastIndex(tail, mmdbMetadataMarker)
+	if idx < 0 {
+		return nil, 0, fmt.Errorf("MaxMind metadata marker not found")
+	}
+	metaStart := size - tailSize + int64(idx+len(mmdbMetadataMarker))
+
+	md := &mmdbDecoder{r: f, base: metaStart, end: size}
+	value, _, err := md.decode(0, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid metadata: %w", err)
+	}
+	meta, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid metadata: not a map")
+	}
+
+	info := &MMDBInfo{
+		DatabaseType: mmdbStringValue(meta["database_type"]),
+		IPVersion:    int(mmdbUintValue(meta["ip_version"])),
+		RecordSize:   int(mmdbUintValue(meta["record_size"])),
+		NodeCount:    int(mmdbUintValue(meta["node_count"])),
+		BuildEpoch:   time.Unix(int64(mmdbUintValue(meta["build_epoch"])), 0).UTC(),
+	}
+	if langs, ok := meta["languages"].([]interface{}); ok {
+		for _, l := range langs {
+			info.Languages = append(info.Languages, mmdbStringValue(l))
+		}
+	}
+	if desc, ok := meta["description"].(map[string]interface{}); ok {
+		info.Description = mmdbStringValue(desc["en"])
+	}
+
+	if major := mmdbUintValue(meta["binary_format_major_version"]); major != 2 {
+		return nil, 0, fmt.Errorf("unsupported binary format version %d", major)
+	}
+	switch info.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, 0, fmt.Errorf("invalid record size %d", info.RecordSize)
+	}
+	if info.IPVersion != 4 && info.IPVersion != 6 {
+		return nil, 0, fmt.Errorf("invalid IP version %d", info.IPVersion)
+	}
+	if info.NodeCount == 0 {
+		return nil, 0, fmt.Errorf("empty search tree")
+	}
+	return info, metaStart, nil
+}
+
+// mmdbTree walks the binary search tree at the start of the file.
+type mmdbTree struct {
+	r          io.ReaderAt
+	nodeCount  int
+	recordSize int
+}
+
+// lookup returns the record value the tree resolves ip to: nodeCount means
+// "not found", anything above is a data section pointer.
+func (t *mmdbTree) lookup(ip net.IP, ipVersion int) (int, error) {
+	bits := ip.To16()
+	bitCount := 128
+	node := 0
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+		bitCount = 32
+		if ipVersion == 6 {
+			// IPv4 lives under ::/96 in an IPv6 tree.
+			for i := 0; i < 96 && node < t.nodeCount; i++ {
+				left, _, err := t.readNode(node)
+				if err != nil {
+					return 0, err
+				}
+				node = left
+			}
+		}
+	}
+
+	for i := 0; i < bitCount && node < t.nodeCount; i++ {
+		left, right, err := t.readNode(node)
+		if err != nil {
+			return 0, err
+		}
+		if bits[i/8]&(0x80>>(i%8)) == 0 {
+			node = left
+		} else {
+			node = right
+		}
+	}
+	if node < t.nodeCount {
+		return 0, fmt.Errorf("search tree is deeper than the address")
+	}
+	return node, nil
+}
+
+func (t *mmdbTree) readNode(node int) (left, right int, err error) {
+	size := t.recordSize / 4
+	b := make([]byte, size)
+	if _, err := t.r.ReadAt(b, int64(node)*int64(size)); err != nil {
+		return 0, 0, fmt.Errorf("reading node %d: %w", node, err)
+	}
+	switch t.recordSize {
+	case 24:
+		left = int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+		right = int(b[3])<<16 | int(b[4])<<8 | int(b[5])
+	case 28:
+		left = int(b[3]&0xf0)<<20 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+		right = int(b[3]&0x0f)<<24 | int(b[4])<<16 | int(b[5])<<8 | int(b[6])
+	case 32:
+		left = int(binary.BigEndian.Uint32(b[0:4]))
+		right = int(binary.BigEndian.Uint32(b[4:8]))
+	}
+	return left, right, nil
+}
+
+// MaxMind DB data section types.
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+// mmdbMaxDepth stops decoding of maliciously nested data.
+const mmdbMaxDepth = 64
+
+var errMMDBBounds = errors.New("value extends past the end of its section")
+
+// mmdbDecoder decodes values from a data or metadata section spanning
+// [base, end) of r. Offsets and pointers are relative to base.
+type mmdbDecoder struct {
+	r    io.ReaderAt
+	base int64
+	end  int64
+}
+
+func (d *mmdbDecoder) read(offset, n int64) ([]byte, error) {
+	if offset < 0 || n < 0 || d.base+offset+n > d.end {
+		return nil, errMMDBBounds
+	}
+	b := make([]byte, n)
+	if _, err := d.r.ReadAt(b, d.base+offset); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// decode returns the value at offset and the offset just past it.
+func (d *mmdbDecoder) decode(offset int64, depth int) (interface{}, int64, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, fmt.Errorf("data nested too deeply")
+	}
+	b, err := d.read(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	offset++
+	typ := int(ctrl >> 5)
+
+	if typ == mmdbPointer {
+		ss := int64(ctrl>>3) & 0x3
+		b, err := d.read(offset, ss+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		var ptr int64
+		switch ss {
+		case 0:
+			ptr = int64(ctrl&0x7)<<8 | int64(b[0])
+		case 1:
+			ptr = (int64(ctrl&0x7)<<16 | int64(b[0])<<8 | int64(b[1])) + 2048
+		case 2:
+			ptr = (int64(ctrl&0x7)<<24 | int64(b[0])<<16 | int64(b[1])<<8 | int64(b[2])) + 526336
+		case 3:
+			ptr = int64(binary.BigEndian.Uint32(b))
+		}
+		value, _, err := d.decode(ptr, depth+1)
+		return value, offset + ss + 1, err
+	}
+
+	if typ == mmdbExtended {
+		b, err := d.read(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + int(b[0])
+		offset++
+		if typ < mmdbInt32 || typ > mmdbFloat {
+			return nil, 0, fmt.Errorf("invalid extended type %d", typ)
+		}
+	}
+
+	size := int64(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		b, err := d.read(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + int64(b[0])
+		case 2:
+			size = 285 + (int64(b[0])<<8 | int64(b[1]))
+		case 3:
+			size = 65821 + (int64(b[0])<<16 | int64(b[1])<<8 | int64(b[2]))
+		}
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]interface{})
+		for i := int64(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key is not a string")
+			}
+			value, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case mmdbArray:
+		var a []interface{}
+		for i := int64(0); i < size; i++ {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	case mmdbContainer, mmdbEndMarker:
+		return nil, offset, nil
+	}
+
+	b, err = d.read(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += size
+	switch typ {
+	case mmdbString:
+		return string(b), offset, nil
+	case mmdbBytes:
+		return b, offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("invalid integer size %d", size)
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case mmdbInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("invalid int32 size %d", size)
+		}
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int32(v), offset, nil
+	case mmdbUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("invalid uint128 size %d", size)
+		}
+		return new(big.Int).SetBytes(b), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unknown type %d", typ)
+}
+
+func mmdbStringValue(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func mmdbUintValue(v interface{}) uint64 {
+	u, _ := v.(uint64)
+	return u
+}
@@ -0,0 +1,266 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// mmdbEncode writes v in the MaxMind DB data format. Only the types the
+// tests need are supported.
+func mmdbEncode(buf *bytes.Buffer, v interface{}) {
+	ctrl := func(typ, size int) {
+		extended := typ > 7
+		t := typ
+		if extended {
+			t = 0
+		}
+		switch {
+		case size < 29:
+			buf.WriteByte(byte(t<<5 | size))
+		default:
+			buf.WriteByte(byte(t<<5 | 29))
+		}
+		if extended {
+			buf.WriteByte(byte(typ - 7))
+		}
+		if size >= 29 {
+			buf.WriteByte(byte(size - 29))
+		}
+	}
+	uintBytes := func(u uint64) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, u)
+		return bytes.TrimLeft(b, "\x00")
+	}
+
+	switch v := v.(type) {
+	case string:
+		ctrl(mmdbString, len(v))
+		buf.WriteString(v)
+	case uint16:
+		b := uintBytes(uint64(v))
+		ctrl(mmdbUint16, len(b))
+		buf.Write(b)
+	case uint32:
+		b := uintBytes(uint64(v))
+		ctrl(mmdbUint32, len(b))
+		buf.Write(b)
+	case uint64:
+		b := uintBytes(v)
+		ctrl(mmdbUint64, len(b))
+		buf.Write(b)
+	case []interface{}:
+		ctrl(mmdbArray, len(v))
+		for _, e := range v {
+			mmdbEncode(buf, e)
+		}
+	case map[string]interface{}:
+		ctrl(mmdbMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			mmdbEncode(buf, k)
+			mmdbEncode(buf, v[k])
+		}
+	default:
+		panic("mmdbEncode: unsupported type")
+	}
+}
+
+// buildTestMMDB returns a minimal but well-formed MaxMind DB whose tree maps
+// 8.0.0.0/8 to a single {"country": "US"} record.
+func buildTestMMDB(t *testing.T, ipVersion, recordSize int) []byte {
+	t.Helper()
+
+	// Path to 8.0.0.0/8, preceded by ::/96 in an IPv6 tree.
+	var path []int
+	if ipVersion == 6 {
+		path = make([]int, 96)
+	}
+	for i := 7; i >= 0; i-- {
+		path = append(path, (8>>i)&1)
+	}
+	nodeCount := len(path)
+	dataRecord := nodeCount + mmdbDataSeparator // data offset 0
+
+	var tree bytes.Buffer
+	for i, bit := range path {
+		next := nodeCount // not found
+		match := i + 1
+		if i == len(path)-1 {
+			match = dataRecord
+		}
+		left, right := next, match
+		if bit == 0 {
+			left, right = match, next
+		}
+		switch recordSize {
+		case 24:
+			tree.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			tree.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte((left>>24)<<4 | (right>>24)&0x0f), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 32:
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint32(b[0:], uint32(left))
+			binary.BigEndian.PutUint32(b[4:], uint32(right))
+			tree.Write(b)
+		}
+	}
+
+	var db bytes.Buffer
+	db.Write(tree.Bytes())
+	db.Write(make([]byte, mmdbDataSeparator))
+	mmdbEncode(&db, map[string]interface{}{"country": "US"})
+	db.Write(mmdbMetadataMarker)
+	mmdbEncode(&db, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"database_type":               "GeoIP2-Country",
+		"description":                 map[string]interface{}{"en": "Test database"},
+		"ip_version":                  uint16(ipVersion),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	})
+	return db.Bytes()
+}
+
+func TestInspectMMDB(t *testing.T) {
+	dir := t.TempDir()
+	for _, ipVersion := range []int{4, 6} {
+		for _, recordSize := range []int{24, 28, 32} {
+			path := filepath.Join(dir, "test.mmdb")
+			if err := os.WriteFile(path, buildTestMMDB(t, ipVersion, recordSize), 0644); err != nil {
+				t.Fatal(err)
+			}
+			info, err := InspectMMDB(path)
+			if err != nil {
+				t.Fatalf("v%d/%d: %v", ipVersion, recordSize, err)
+			}
+			if info.DatabaseType != "GeoIP2-Country" || info.RecordSize != recordSize || info.IPVersion != ipVersion {
+				t.Errorf("v%d/%d: info = %+v", ipVersion, recordSize, info)
+			}
+			if !info.BuildEpoch.Equal(time.Unix(1700000000, 0)) {
+				t.Errorf("BuildEpoch = %v", info.BuildEpoch)
+			}
+			if !info.SampleFound {
+				t.Errorf("v%d/%d: sample lookup did not find 8.8.8.8", ipVersion, recordSize)
+			}
+			if info.Description != "Test database" || len(info.Languages) != 1 {
+				t.Errorf("description/languages = %q/%v", info.Description, info.Languages)
+			}
+		}
+	}
+}
+
+// TestInspectMMDBCorrupt verifies files that still carry the metadata marker
+// but are truncated or have a damaged tree are rejected, while the shallow
+// ValidateMMDB check and ReadMMDBMetadata let them through.
+func TestInspectMMDBCorrupt(t *testing.T) {
+	good := buildTestMMDB(t, 4, 24)
+	marker := bytes.Index(good, mmdbMetadataMarker)
+
+	truncatedTree := append(append([]byte{}, good[:12]...), good[marker:]...)
+	badPointer := append([]byte{}, good...)
+	badPointer[7*6] = 0xff // node 7, left record (bit 0 of 8) -> far past the data section
+	dirtySeparator := append([]byte{}, good...)
+	dirtySeparator[8*6] = 1
+
+	cases := map[string][]byte{
+		"truncated-tree":  truncatedTree,
+		"bad-pointer":     badPointer,
+		"dirty-separator": dirtySeparator,
+		"no-marker":       good[:marker],
+		"bad-metadata":    append(append([]byte{}, good[:marker+len(mmdbMetadataMarker)]...), 0xe9),
+	}
+	dir := t.TempDir()
+	for name, data := range cases {
+		path := filepath.Join(dir, name+".mmdb")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := InspectMMDB(path); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+		if name == "bad-pointer" || name == "dirty-separator" {
+			if err := ValidateMMDB(path); err != nil {
+				t.Errorf("%s: shallow validation should pass: %v", name, err)
+			}
+			if info, err := ReadMMDBMetadata(path); err != nil || info.DatabaseType != "GeoIP2-Country" {
+				t.Errorf("%s: metadata should still be readable: %v", name, err)
+			}
+		}
+	}
+}
+
+// TestDownloadDatabaseDeepValidate verifies --deep-validate keeps a corrupt
+// database that still carries the marker out of TargetDir.
+func TestDownloadDatabaseDeepValidate(t *testing.T) {
+	good := buildTestMMDB(t, 6, 28)
+	corrupt := append([]byte("garbage"), good[bytes.Index(good, mmdbMetadataMarker):]...)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/corrupt" {
+			w.Write(corrupt)
+			return
+		}
+		w.Write(good)
+	}))
+	defer srv.Close()
+
+	for _, path := range []string{"/good", "/corrupt"} {
+		logger := &ConsoleLogger{quiet: true}
+		cfg := &Config{TargetDir: t.TempDir(), Timeout: 10 * time.Second, MaxRetries: 1, DeepValidate: true}
+		g := &Updater{
+			config:     cfg,
+			httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+			logger:     logger,
+			tempDir:    t.TempDir(),
+		}
+		res := g.downloadDatabase(context.Background(), "GeoIP2-Country.mmdb", srv.URL+path, "")
+		_, statErr := os.Stat(filepath.Join(cfg.TargetDir, "GeoIP2-Country.mmdb"))
+		if path == "/good" && (res.Error != nil || statErr != nil) {
+			t.Errorf("good database rejected: %v / %v", res.Error, statErr)
+		}
+		if path == "/corrupt" && (res.Error == nil || statErr == nil) {
+			t.Errorf("corrupt database accepted: %v / %v", res.Error, statErr)
+		}
+	}
+}
+
+// TestValidateMMDB verifies the shallow check looks for the metadata marker
+// at the end of the file: a complete database passes, one truncated before
+// its metadata fails, and so does a file merely starting with "MMDB".
+func TestValidateMMDB(t *testing.T) {
+	good := buildTestMMDB(t, 6, 28)
+	marker := bytes.Index(good, mmdbMetadataMarker)
+	dir := t.TempDir()
+	for name, c := range map[string]struct {
+		data  []byte
+		valid bool
+	}{
+		"good":      {good, true},
+		"truncated": {good[:marker+5], false},
+		"mmdb-text": {append([]byte("MMDB"), make([]byte, 4096)...), false},
+	} {
+		path := filepath.Join(dir, name+".mmdb")
+		if err := os.WriteFile(path, c.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ValidateMMDB(path); (err == nil) != c.valid {
+			t.Errorf("%s: ValidateMMDB = %v, want valid %v", name, err, c.valid)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package geoip
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Progress reporting. On a terminal every active download gets one bar on
+// stderr that is redrawn in place; otherwise a PROGRESS log line is emitted
+// periodically.
+// Bars share the Logger's mutex so log output never lands in the middle of a
+// redraw: the Logger clears the bars, prints, and redraws them.
+const (
+	progressRedrawInterval = 200 * time.Millisecond
+	progressLogInterval    = 15 * time.Second
+)
+
+// progressBar tracks one in-flight transfer.
+type progressBar struct {
+	name   string
+	total  int64 // full size in bytes, -1 if unknown
+	offset int64 // bytes already on disk when the transfer (re)started
+	done   int64 // bytes on disk so far, updated atomically
+	start  time.Time
+}
+
+const progressBarWidth = 24
+
+func (b *progressBar) String() string {
+	return b.line(false)
+}
+
+// line renders the bar's status. With graphic set, a "[=====>    ]" bar is
+// included when the total size is known.
+func (b *progressBar) line(graphic bool) string {
+	done := atomic.LoadInt64(&b.done)
+	rate := float64(0)
+	if elapsed := time.Since(b.start).Seconds(); elapsed > 0 {
+		rate = float64(done-b.offset) / elapsed
+	}
+	name := truncateName(b.name, 40)
+	if b.total <= 0 {
+		return fmt.Sprintf("%-40s %s  %s/s", name, formatBytes(done), formatBytes(int64(rate)))
+	}
+
+	pct := float64(done) * 100 / float64(b.total)
+	if pct > 100 {
+		pct = 100
+	}
+	if !graphic {
+		return fmt.Sprintf("%-40s %5.1f%%  %s / %s  %s/s", name, pct,
+			formatBytes(done), formatBytes(b.total), formatBytes(int64(rate)))
+	}
+	filled := int(pct / 100 * progressBarWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	return fmt.Sprintf("%-40s [%s] %5.1f%%  %s / %s  %s/s", name, bar, pct,
+		formatBytes(done), formatBytes(b.total), formatBytes(int64(rate)))
+}
+
+// fields returns the bar's status as structured log fields.
+func (b *progressBar) fields() map[string]interface{} {
+	done := atomic.LoadInt64(&b.done)
+	f := map[string]interface{}{"database": b.name, "bytes": done}
+	if elapsed := time.Since(b.start).Seconds(); elapsed > 0 {
+		f["bytes_per_second"] = int64(float64(done-b.offset) / elapsed)
+	}
+	if b.total > 0 {
+		f["total_bytes"] = b.total
+	}
+	return f
+}
+
+// progressReader counts bytes read into bar.
+type progressReader struct {
+	r   io.Reader
+	bar *progressBar
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.r.Read(buf)
+	if p.bar != nil {
+		atomic.AddInt64(&p.bar.done, int64(n))
+	}
+	return n, err
+}
+
+// progress renders the set of active progressBars. A nil *progress is valid
+// and reports nothing (used for --quiet).
+type progress struct {
+	logger *ConsoleLogger
+	tty    bool
+	bars   []*progressBar // guarded by logger.mu
+	drawn  int            // lines currently on screen, guarded by logger.mu
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func newProgress(logger *ConsoleLogger, tty bool) *progress {
+	p := &progress{logger: logger, tty: tty, stop: make(chan struct{}), done: make(chan struct{})}
+	logger.mu.Lock()
+	logger.progress = p
+	logger.mu.Unlock()
+	go p.run()
+	return p
+}
+
+// Stop halts rendering and removes the bars from the screen.
+func (p *progress) Stop() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+
+	p.logger.mu.Lock()
+	defer p.logger.mu.Unlock()
+	p.clearLocked()
+	p.logger.progress = nil
+}
+
+func (p *progress) add(name string, total, offset int64) *progressBar {
+	if p == nil {
+		return nil
+	}
+	b := &progressBar{name: name, total: total, offset: offset, done: offset, start: time.Now()}
+	p.logger.mu.Lock()
+	p.bars = append(p.bars, b)
+	p.logger.mu.Unlock()
+	return b
+}
+
+func (p *progress) remove(b *progressBar) {
+	if p == nil || b == nil {
+		return
+	}
+	p.logger.mu.Lock()
+	defer p.logger.mu.Unlock()
+	p.clearLocked()
+	for i, bar := range p.bars {
+		if bar == b {
+			p.bars = append(p.bars[:i], p.bars[i+1:]...)
+			break
+		}
+	}
+	p.redrawLocked()
+}
+
+func (p *progress) run() {
+	defer close(p.done)
+
+	interval := progressLogInterval
+	if p.tty {
+		interval = progressRedrawInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if p.tty {
+			p.logger.mu.Lock()
+			p.clearLocked()
+			p.redrawLocked()
+			p.logger.mu.Unlock()
+			continue
+		}
+
+		p.logger.mu.Lock()
+		bars := append([]*progressBar(nil), p.bars...)
+		p.logger.mu.Unlock()
+		for _, b := range bars {
+			p.logger.log("PROGRESS", b.String(), b.fields())
+		}
+	}
+}
+
+// clearLocked erases the bars drawn by the last redraw. Caller holds
+// logger.mu.
+func (p *progress) clearLocked() {
+	if p == nil || !p.tty || p.drawn == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\033[%dA\033[J", p.drawn)
+	p.drawn = 0
+}
+
+// redrawLocked draws one line per active bar below the cursor. Caller holds
+// logger.mu and has cleared the previous frame.
+func (p *progress) redrawLocked() {
+	if p == nil || !p.tty {
+		return
+	}
+	for _, b := range p.bars {
+		fmt.Fprintf(os.Stderr, "\033[K%s\n", b.line(true))
+	}
+	p.drawn = len(p.bars)
+}
+
+// isTerminal reports whether f is attached to a terminal (character device).
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// formatBytes renders n using binary units ("12.3 MB").
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func truncateName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	return name[:max-3] + "..."
+}
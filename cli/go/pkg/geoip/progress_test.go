@@ -0,0 +1,67 @@
+package geoip
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFormatBytes(t *testing.T) {
+	cases := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{115 * 1024 * 1024, "115.0 MB"},
+		{3 * 1024 * 1024 * 1024, "3.0 GB"},
+	}
+	for _, c := range cases {
+		if got := formatBytes(c.in); got != c.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+// TestProgressReaderCounts verifies the counting reader starts from the
+// resume offset and that a nil bar (quiet mode) is a pass-through.
+func TestProgressReaderCounts(t *testing.T) {
+	bar := &progressBar{name: "db", total: 110, offset: 100, done: 100}
+	n, err := io.Copy(io.Discard, &progressReader{r: strings.NewReader("0123456789"), bar: bar})
+	if err != nil || n != 10 {
+		t.Fatalf("copy: n=%d err=%v", n, err)
+	}
+	if bar.done != 110 {
+		t.Fatalf("done = %d, want 110", bar.done)
+	}
+	if s := bar.String(); !strings.Contains(s, "100.0%") {
+		t.Fatalf("String() = %q, want 100.0%%", s)
+	}
+
+	var p *progress
+	if b := p.add("db", 10, 0); b != nil {
+		t.Fatal("nil progress returned a bar")
+	}
+	if _, err := io.Copy(io.Discard, &progressReader{r: strings.NewReader("x")}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestProgressBarGraphic checks the rendered bar at the edges and that an
+// unknown total falls back to a byte count without a bar.
+func TestProgressBarGraphic(t *testing.T) {
+	half := &progressBar{name: "db", total: 200, done: 100}
+	if s := half.line(true); !strings.Contains(s, "[============>           ]") || !strings.Contains(s, "50.0%") {
+		t.Errorf("half line = %q", s)
+	}
+	full := &progressBar{name: "db", total: 200, done: 200}
+	if s := full.line(true); !strings.Contains(s, "["+strings.Repeat("=", progressBarWidth)+"]") {
+		t.Errorf("full line = %q", s)
+	}
+	unknown := &progressBar{name: "db", total: -1, done: 2048}
+	if s := unknown.line(true); strings.Contains(s, "[") || !strings.Contains(s, "2.0 KB") {
+		t.Errorf("unknown-size line = %q", s)
+	}
+}
@@ -0,0 +1,105 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// proxyFunc is the http.Transport.Proxy for config: Config.Proxy when set,
+// otherwise the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment, with hosts
+// matching Config.NoProxy always reached directly.
+func proxyFunc(config *Config) (func(*http.Request) (*url.URL, error), error) {
+	proxy := http.ProxyFromEnvironment
+	if config.Proxy != "" {
+		proxyURL, err := parseProxyURL(config.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	if len(config.NoProxy) == 0 {
+		return proxy, nil
+	}
+	noProxy := config.NoProxy
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(noProxy, req.URL.Host) {
+			return nil, nil
+		}
+		return proxy(req)
+	}, nil
+}
+
+// parseProxyURL validates a --proxy value: an http, https, socks5 or
+// socks5h URL with a host and optional user:password.
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: want scheme://[user:password@]host:port", redactProxy(raw))
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %q: use http, https or socks5", u.Scheme)
+}
+
+// redactProxy hides the password of a proxy URL for logging.
+func redactProxy(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "(unparseable)"
+	}
+	return u.Redacted()
+}
+
+// bypassProxy reports whether hostport matches an entry of noProxy, which
+// follows the NO_PROXY conventions: "*" matches everything, a domain
+// matches itself and its subdomains (a leading dot is optional), an IP
+// address or CIDR range matches addresses in it, and an entry with a port
+// only matches that port.
+func bypassProxy(noProxy []string, hostport string) bool {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	ip := net.ParseIP(host)
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		entryHost, entryPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			entryHost, entryPort = entry, ""
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		entryHost = strings.Trim(entryHost, "[]")
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		domain := strings.TrimPrefix(entryHost, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
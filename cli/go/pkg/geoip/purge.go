@@ -0,0 +1,53 @@
+package geoip
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// purgeUnknown applies Config.PurgeUnknown after a successful run: it
+// removes the database files in TargetDir that are not part of names, the
+// set the server resolved for this run. Only top-level files with a
+// database extension are considered; dated copies of a wanted database,
+// the versions directory and everything else are left alone.
+func (g *Updater) purgeUnknown(names []string, results []DownloadResult) {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[g.storedName(name)] = true
+	}
+	installed := make(map[string]bool, len(results))
+	for _, r := range results {
+		if r.Path != "" {
+			wanted[filepath.Base(r.Path)] = true
+			installed[r.Database] = true
+		}
+	}
+	// An archive names its database only once extracted; if one was not
+	// downloaded this run its file cannot be told from an unknown one.
+	for _, name := range names {
+		if !installed[name] && !isDatabaseFile(g.storedName(name)) {
+			g.logger.Warn("Not purging unknown databases: cannot tell which file %s installs", name)
+			return
+		}
+	}
+
+	entries, err := os.ReadDir(g.config.TargetDir)
+	if err != nil {
+		g.logger.Warn("Failed to purge unknown databases: %v", err)
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !isDatabaseFile(name) || wanted[name] {
+			continue
+		}
+		if stable, ok := stableName(name); ok && wanted[stable] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(g.config.TargetDir, name)); err != nil {
+			g.logger.Warn("Failed to purge %s: %v", name, err)
+			continue
+		}
+		g.logger.Info("Purged unknown database: %s", name)
+	}
+}
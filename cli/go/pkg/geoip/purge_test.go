@@ -0,0 +1,71 @@
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// TestPurgeUnknown verifies PurgeUnknown removes only database files the
+// server did not list, keeping dated copies, other files and versions/.
+func TestPurgeUnknown(t *testing.T) {
+	db := buildTestMMDB(t, 6, 24)
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" {
+			json.NewEncoder(w).Encode(map[string]string{"a.mmdb": srvURL + "/a"})
+			return
+		}
+		w.Write(db)
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	dir := t.TempDir()
+	for _, name := range []string{"a_20240101.mmdb", "old.mmdb", "OLD.BIN", "notes.txt", "old.mmdb.bak"} {
+		os.WriteFile(filepath.Join(dir, name), db, 0644)
+	}
+	os.MkdirAll(filepath.Join(dir, versionsDir), 0755)
+	os.WriteFile(filepath.Join(dir, versionsDir, "old.20240101T000000Z.mmdb"), db, 0644)
+
+	cfg := &Config{
+		APIEndpoints:   []string{srv.URL + "/auth"},
+		TargetDir:      dir,
+		Timeout:        10 * time.Second,
+		MaxRetries:     1,
+		PurgeUnknown:   true,
+		SkipSpaceCheck: true,
+	}
+	updater, err := New(cfg, Options{HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updater.Close()
+	if _, err := updater.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".json" {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	want := []string{"a.mmdb", "a_20240101.mmdb", "notes.txt", "old.mmdb.bak", versionsDir}
+	if len(names) != len(want) {
+		t.Fatalf("left %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("left %v, want %v", names, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package geoip
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by every download of an Updater, so
+// Config.MaxRate caps aggregate throughput however many files are in flight.
+// A nil *rateLimiter is valid and never waits.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSecond)
+	return &rateLimiter{rate: rate, burst: rate, tokens: rate, last: time.Now()}
+}
+
+// chunk is the most a single Read should take at once, keeping individual
+// waits short (about a tenth of a second) so the limit is applied smoothly.
+func (l *rateLimiter) chunk() int {
+	c := int(l.rate / 10)
+	if c < 512 {
+		c = 512
+	}
+	return c
+}
+
+// wait takes n bytes worth of tokens, sleeping until the bucket has paid
+// them back if it went into debt.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	return sleepContext(ctx, delay)
+}
+
+// rateLimitedReader paces reads from r through limiter.
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (r *rateLimitedReader) Read(buf []byte) (int, error) {
+	if r.limiter == nil {
+		return r.r.Read(buf)
+	}
+	if c := r.limiter.chunk(); len(buf) > c {
+		buf = buf[:c]
+	}
+	n, err := r.r.Read(buf)
+	if werr := r.limiter.wait(r.ctx, n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
@@ -0,0 +1,70 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestMaxRateAggregate verifies the rate limit applies to the sum of all
+// concurrent downloads, not to each one.
+func TestMaxRateAggregate(t *testing.T) {
+	const rate = 64 * 1024
+	body := bytes.Repeat([]byte("x"), rate)
+
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" {
+			json.NewEncoder(w).Encode(map[string]string{
+				"a.BIN": srvURL + "/a",
+				"b.BIN": srvURL + "/b",
+			})
+			return
+		}
+		w.Write(body)
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	cfg := &Config{
+		APIEndpoints:  []string{srv.URL + "/auth"},
+		TargetDir:     t.TempDir(),
+		Timeout:       time.Minute,
+		MaxRetries:    1,
+		MaxConcurrent: 2,
+		MaxRate:       rate,
+	}
+	updater, err := New(cfg, Options{HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updater.Close()
+
+	// Two files of one second's worth each: the first second is covered by
+	// the bucket's burst, the second has to be waited for.
+	start := time.Now()
+	if _, err := updater.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if elapsed < 800*time.Millisecond {
+		t.Errorf("2 x %d bytes at %d B/s took only %v", rate, rate, elapsed)
+	}
+	if elapsed > 5*time.Second {
+		t.Errorf("throttled download took %v", elapsed)
+	}
+}
+
+func TestRateLimiterNil(t *testing.T) {
+	var l *rateLimiter
+	if err := l.wait(context.Background(), 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	if newRateLimiter(0) != nil {
+		t.Fatal("zero rate should mean unlimited")
+	}
+}
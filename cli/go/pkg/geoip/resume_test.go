@@ -0,0 +1,296 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestDownloadDatabaseResume verifies that downloadDatabase resumes a partial
+// download (HTTP Range / 206) instead of restarting, and completes to the full
+// content. The first request is answered with a short body to simulate an
+// interruption; the second (a Range request) serves the remainder.
+func TestDownloadDatabaseResume(t *testing.T) {
+	const total = 5 * 1024 * 1024
+	full := make([]byte, total)
+	for i := range full {
+		full[i] = byte((i * 7) % 251)
+	}
+
+	var reqs int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&reqs, 1)
+		rng := r.Header.Get("Range")
+		if rng == "" {
+			// First attempt: declare the full length but send only 1MB, then
+			// return so the connection closes -> client gets an unexpected EOF.
+			w.Header().Set("Content-Length", strconv.Itoa(total))
+			w.WriteHeader(http.StatusOK)
+			w.Write(full[:1024*1024])
+			return
+		}
+		var start int
+		fmt.Sscanf(rng, "bytes=%d-", &start)
+		if start >= total {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, total-1, total))
+		w.Header().Set("Content-Length", strconv.Itoa(total-start))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(full[start:])
+	}))
+	defer srv.Close()
+
+	logger := &ConsoleLogger{quiet: true}
+	cfg := &Config{TargetDir: t.TempDir(), Timeout: 60 * time.Second, MaxRetries: 3}
+	g := &Updater{
+		config:     cfg,
+		httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+		logger:     logger,
+		tempDir:    t.TempDir(),
+	}
+
+	res := g.downloadDatabase(context.Background(), "test.bin", srv.URL, "")
+	if res.Error != nil {
+		t.Fatalf("downloadDatabase error: %v (after %d requests)", res.Error, atomic.LoadInt32(&reqs))
+	}
+	got, err := os.ReadFile(filepath.Join(cfg.TargetDir, "test.bin"))
+	if err != nil {
+		t.Fatalf("read result: %v", err)
+	}
+	if len(got) != total {
+		t.Fatalf("final size %d != %d", len(got), total)
+	}
+	if !bytes.Equal(got, full) {
+		t.Fatal("content mismatch after resume")
+	}
+	if n := atomic.LoadInt32(&reqs); n < 2 {
+		t.Fatalf("expected >=2 requests (interrupt + resume), got %d", n)
+	}
+	t.Logf("resumed and completed: %d bytes across %d requests", len(got), atomic.LoadInt32(&reqs))
+}
+
+// TestParseContentRange covers the Content-Range forms a resuming download
+// has to understand, including unknown sizes and unsatisfied ranges.
+func TestParseContentRange(t *testing.T) {
+	cases := []struct {
+		in               string
+		start, end, size int64
+		ok               bool
+	}{
+		{"bytes 100-199/1000", 100, 199, 1000, true},
+		{"bytes 0-0/1", 0, 0, 1, true},
+		{"bytes 100-199/*", 100, 199, -1, true},
+		{"bytes */1000", -1, -1, 1000, true},
+		{"bytes 200-100/1000", 0, 0, 0, false},
+		{"items 0-1/2", 0, 0, 0, false},
+		{"bytes 0-1", 0, 0, 0, false},
+		{"", 0, 0, 0, false},
+	}
+	for _, c := range cases {
+		start, end, size, ok := parseContentRange(c.in)
+		if ok != c.ok || (ok && (start != c.start || end != c.end || size != c.size)) {
+			t.Errorf("parseContentRange(%q) = %d, %d, %d, %v; want %d, %d, %d, %v",
+				c.in, start, end, size, ok, c.start, c.end, c.size, c.ok)
+		}
+	}
+}
+
+// TestDownloadDatabaseBadContentRange verifies that a 206 whose Content-Range
+// does not start at the partial file's size is not appended (which would
+// corrupt the file); the download restarts and still completes correctly.
+func TestDownloadDatabaseBadContentRange(t *testing.T) {
+	const total = 64 * 1024
+	full := make([]byte, total)
+	for i := range full {
+		full[i] = byte((i * 13) % 251)
+	}
+
+	var reqs int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&reqs, 1)
+		if r.Header.Get("Range") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(total))
+			w.WriteHeader(http.StatusOK)
+			if n == 1 {
+				w.Write(full[:1000]) // interrupted
+				return
+			}
+			w.Write(full)
+			return
+		}
+		// Misbehaving server: always answers from byte 10.
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 10-%d/%d", total-1, total))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(full[10:])
+	}))
+	defer srv.Close()
+
+	logger := &ConsoleLogger{quiet: true}
+	cfg := &Config{TargetDir: t.TempDir(), Timeout: 10 * time.Second, MaxRetries: 1}
+	g := &Updater{
+		config:     cfg,
+		httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+		logger:     logger,
+		tempDir:    t.TempDir(),
+	}
+
+	res := g.downloadDatabase(context.Background(), "test.bin", srv.URL, "")
+	if res.Error != nil {
+		t.Fatalf("downloadDatabase error: %v", res.Error)
+	}
+	got, err := os.ReadFile(filepath.Join(cfg.TargetDir, "test.bin"))
+	if err != nil {
+		t.Fatalf("read result: %v", err)
+	}
+	if !bytes.Equal(got, full) {
+		t.Fatalf("content mismatch: got %d bytes", len(got))
+	}
+}
+
+// TestDownloadDatabaseRangeIgnored verifies the fallback for servers without
+// range support: a 200 in reply to a Range request must truncate the .part
+// file and restart rather than append the full body after the partial one.
+func TestDownloadDatabaseRangeIgnored(t *testing.T) {
+	const total = 256 * 1024
+	full := make([]byte, total)
+	for i := range full {
+		full[i] = byte((i * 31) % 251)
+	}
+
+	var reqs, ranged int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&reqs, 1)
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&ranged, 1)
+		}
+		// Never honours Range; the first response is cut short.
+		w.Header().Set("Content-Length", strconv.Itoa(total))
+		w.WriteHeader(http.StatusOK)
+		if n == 1 {
+			w.Write(full[:total/4])
+			return
+		}
+		w.Write(full)
+	}))
+	defer srv.Close()
+
+	logger := &ConsoleLogger{quiet: true}
+	cfg := &Config{TargetDir: t.TempDir(), Timeout: 10 * time.Second, MaxRetries: 1}
+	g := &Updater{
+		config:     cfg,
+		httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+		logger:     logger,
+		tempDir:    t.TempDir(),
+	}
+
+	res := g.downloadDatabase(context.Background(), "test.bin", srv.URL, "")
+	if res.Error != nil {
+		t.Fatalf("downloadDatabase error: %v", res.Error)
+	}
+	if atomic.LoadInt32(&ranged) == 0 {
+		t.Fatal("expected the retry to attempt a Range request")
+	}
+	got, err := os.ReadFile(filepath.Join(cfg.TargetDir, "test.bin"))
+	if err != nil {
+		t.Fatalf("read result: %v", err)
+	}
+	if !bytes.Equal(got, full) {
+		t.Fatalf("content mismatch: got %d bytes, want %d", len(got), total)
+	}
+	if _, err := os.Stat(filepath.Join(g.tempDir, "test.bin.part")); !os.IsNotExist(err) {
+		t.Fatalf(".part file left behind: %v", err)
+	}
+}
+
+// TestDownloadDatabaseStallTimeout verifies a transfer that stops sending
+// data is aborted after StallTimeout and resumed, well within Timeout.
+func TestDownloadDatabaseStallTimeout(t *testing.T) {
+	full := bytes.Repeat([]byte("geoip"), 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(full)))
+			w.Write(full[:100])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done() // hang until the client gives up
+			return
+		}
+		var start int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(full)-1, len(full)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(full[start:])
+	}))
+	defer srv.Close()
+
+	logger := &ConsoleLogger{quiet: true}
+	cfg := &Config{TargetDir: t.TempDir(), Timeout: time.Minute, StallTimeout: 200 * time.Millisecond, MaxRetries: 1}
+	g := &Updater{
+		config:     cfg,
+		httpClient: newHTTPClient(cfg.Timeout, cfg.MaxRetries, logger),
+		logger:     logger,
+		tempDir:    t.TempDir(),
+	}
+
+	begin := time.Now()
+	res := g.downloadDatabase(context.Background(), "stall.bin", srv.URL, "")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if elapsed := time.Since(begin); elapsed > 10*time.Second {
+		t.Errorf("stalled download took %v to resume", elapsed)
+	}
+	if res.Size != int64(len(full)) {
+		t.Errorf("size = %d, want %d", res.Size, len(full))
+	}
+}
+
+// TestDownloadDatabaseDeadline verifies Config.Timeout bounds a whole
+// download, even one that keeps making progress, and that the transport
+// has no overall timeout of its own.
+func TestDownloadDatabaseDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000000")
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(20 * time.Millisecond):
+				w.Write([]byte("geoip"))
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	defer srv.Close()
+
+	logger := &ConsoleLogger{quiet: true}
+	cfg := &Config{TargetDir: t.TempDir(), Timeout: 300 * time.Millisecond, StallTimeout: time.Minute, MaxRetries: 1}
+	g := &Updater{
+		config:     cfg,
+		httpClient: newHTTPClientTLS(transportTimeouts{}, cfg.MaxRetries, nil, nil, false, logger),
+		logger:     logger,
+		tempDir:    t.TempDir(),
+	}
+	if g.httpClient.client.Timeout != 0 {
+		t.Errorf("client timeout = %v, want none", g.httpClient.client.Timeout)
+	}
+
+	begin := time.Now()
+	res := g.downloadDatabase(context.Background(), "slow.bin", srv.URL, "")
+	if res.Error == nil || !strings.Contains(res.Error.Error(), "did not finish within 300ms") {
+		t.Fatalf("error = %v", res.Error)
+	}
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Errorf("deadline took %v to fire", elapsed)
+	}
+}
@@ -0,0 +1,274 @@
+package geoip
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3Uploader copies installed databases to Config.S3Bucket under
+// Config.S3Prefix, signing requests with AWS Signature Version 4.
+type s3Uploader struct {
+	bucket   string
+	prefix   string
+	region   string
+	endpoint string // base URL for path-style requests; empty means AWS
+	client   *HTTPClient
+	logger   Logger
+
+	credsOnce sync.Once
+	creds     *awsCredentials
+	credsErr  error
+	now       func() time.Time // for tests
+}
+
+func newS3Uploader(config *Config, client *HTTPClient, logger Logger) *s3Uploader {
+	region := config.S3Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &s3Uploader{
+		bucket:   config.S3Bucket,
+		prefix:   strings.Trim(config.S3Prefix, "/"),
+		region:   region,
+		endpoint: config.S3Endpoint,
+		client:   client,
+		logger:   logger,
+		now:      time.Now,
+	}
+}
+
+// key returns the object key for a database file name.
+func (u *s3Uploader) key(name string) string {
+	if u.prefix == "" {
+		return name
+	}
+	return u.prefix + "/" + name
+}
+
+// objectURL returns the URL of key: virtual-hosted style on AWS (path
+// style for bucket names with dots, which break the wildcard certificate),
+// path style on a custom endpoint.
+func (u *s3Uploader) objectURL(key string) string {
+	path := "/" + s3EscapePath(key)
+	switch {
+	case u.endpoint != "":
+		return strings.TrimRight(u.endpoint, "/") + "/" + u.bucket + path
+	case strings.Contains(u.bucket, "."):
+		return fmt.Sprintf("https://s3.%s.amazonaws.com/%s%s", u.region, u.bucket, path)
+	default:
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com%s", u.bucket, u.region, path)
+	}
+}
+
+// upload copies the installed database at path to S3 unless the object's
+// ETag already matches the file's MD5.
+func (u *s3Uploader) upload(ctx context.Context, path, contentType string) error {
+	u.credsOnce.Do(func() {
+		u.creds, u.credsErr = loadAWSCredentials(ctx)
+		if u.credsErr == nil {
+			u.logger.Info("Using AWS credentials from %s", u.creds.source)
+		}
+	})
+	if u.credsErr != nil {
+		return u.credsErr
+	}
+
+	md5Sum, sha256Sum, size, err := fileDigests(path)
+	if err != nil {
+		return err
+	}
+	key := u.key(filepath.Base(path))
+	dest := fmt.Sprintf("s3://%s/%s", u.bucket, key)
+	objectURL := u.objectURL(key)
+
+	// A single-part upload's ETag is the MD5 of its content.
+	if etag, err := u.headETag(ctx, objectURL); err == nil && strings.EqualFold(etag, hex.EncodeToString(md5Sum)) {
+		u.logger.Info("%s already up to date (ETag match), not uploading", dest)
+		return nil
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", objectURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.GetBody = func() (io.ReadCloser, error) { return os.Open(path) }
+	if req.Body, err = req.GetBody(); err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Sum))
+	u.sign(req, hex.EncodeToString(sha256Sum))
+
+	resp, err := u.client.doWithRetry(req)
+	if err != nil {
+		return fmt.Errorf("upload to %s failed: %w", dest, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	u.logger.Info("Uploaded %s to %s (%d bytes)", filepath.Base(path), dest, size)
+	return nil
+}
+
+// headETag returns the unquoted ETag of the object at objectURL.
+func (u *s3Uploader) headETag(ctx context.Context, objectURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", objectURL, nil)
+	if err != nil {
+		return "", err
+	}
+	u.sign(req, emptySHA256)
+	resp, err := u.client.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return strings.Trim(resp.Header.Get("ETag"), `"`), nil
+}
+
+// fileDigests returns the MD5 and SHA256 of the file at path, and its size,
+// in a single read.
+func fileDigests(path string) (md5Sum, sha256Sum []byte, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer f.Close()
+	m, s := md5.New(), sha256.New()
+	if size, err = io.Copy(io.MultiWriter(m, s), f); err != nil {
+		return nil, nil, 0, err
+	}
+	return m.Sum(nil), s.Sum(nil), size, nil
+}
+
+// emptySHA256 is the hex SHA256 of an empty payload.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds AWS Signature Version 4 headers for the S3 service to req,
+// whose payload has the hex SHA256 payloadHash. Host, Content-Type,
+// Content-MD5, Range and x-amz-* headers are signed.
+func (u *s3Uploader) sign(req *http.Request, payloadHash string) {
+	signV4(req, u.creds, u.region, "s3", payloadHash, u.now().UTC())
+}
+
+func signV4(req *http.Request, creds *awsCredentials, region, service, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || lower == "content-md5" || lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath URI-encodes each segment of an object path as SigV4 expects:
+// everything but unreserved characters, keeping the slashes.
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = awsEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), values[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes all but the RFC 3986 unreserved characters.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// databasesListing is the part of the /databases discovery response that
+// carries per-database update times. Endpoints that predate last_updated
+// simply leave it empty.
+type databasesListing struct {
+	Providers map[string]struct {
+		Databases []struct {
+			Name        string `json:"name"`
+			LastUpdated string `json:"last_updated"`
+		} `json:"databases"`
+	} `json:"providers"`
+}
+
+// serverUpdateTimes fetches the /databases listing next to the /auth
+// endpoint and returns the last_updated time of each database, keyed by
+// lower-cased name. A missing or unreadable listing yields an empty map.
+func (g *Updater) serverUpdateTimes(ctx context.Context, authEndpoint string) map[string]time.Time {
+	times := map[string]time.Time{}
+	listURL := strings.Replace(authEndpoint, "/auth", "/databases", 1)
+	if listURL == authEndpoint {
+		return times
+	}
+	ctx, cancel := g.requestContext(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", listURL, nil)
+	if err != nil {
+		return times
+	}
+	req.Header.Set("User-Agent", g.userAgent())
+	if err := g.auth.Authorize(ctx, req); err != nil {
+		g.logger.Info("Database listing unavailable: %v", err)
+		return times
+	}
+	resp, err := g.httpClient.client.Do(req)
+	if err != nil {
+		g.logger.Info("Database listing unavailable: %v", err)
+		return times
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		g.logger.Info("Database listing unavailable (HTTP %d)", resp.StatusCode)
+		return times
+	}
+
+	var listing databasesListing
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		g.logger.Info("Database listing unreadable: %v", err)
+		return times
+	}
+	for _, provider := range listing.Providers {
+		for _, db := range provider.Databases {
+			if db.LastUpdated == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, db.LastUpdated)
+			if err != nil {
+				g.logger.Info("%s: ignoring last_updated %q: %v", db.Name, db.LastUpdated, err)
+				continue
+			}
+			times[strings.ToLower(db.Name)] = t
+		}
+	}
+	return times
+}
+
+// filterSince drops from urls the databases not updated after Since: by the
+// server's last_updated time when the listing provides one, otherwise when
+// the local copy in TargetDir was written after Since. It returns the
+// databases to download and the names skipped.
+func (g *Updater) filterSince(ctx context.Context, auth *authResponse) (map[string]string, []string) {
+	if g.config.Since.IsZero() {
+		return auth.URLs, nil
+	}
+	serverTimes := g.serverUpdateTimes(ctx, auth.endpoint)
+
+	keep := make(map[string]string, len(auth.URLs))
+	var skipped []string
+	for name, rawURL := range auth.URLs {
+		base := stripArchiveSuffix(stripCompressionSuffix(name))
+		updated, ok := serverTimes[strings.ToLower(name)]
+		if !ok {
+			updated, ok = serverTimes[strings.ToLower(base)]
+		}
+		if ok {
+			if !updated.After(g.config.Since) {
+				g.logger.Info("%s: not updated since %s (server: %s), skipping", name, g.config.Since.Format(time.RFC3339), updated.Format(time.RFC3339))
+				skipped = append(skipped, name)
+				continue
+			}
+		} else if fi, err := os.Stat(filepath.Join(g.config.TargetDir, base)); err == nil && fi.ModTime().After(g.config.Since) {
+			g.logger.Info("%s: local copy written %s, after %s, skipping", name, fi.ModTime().Format(time.RFC3339), g.config.Since.Format(time.RFC3339))
+			skipped = append(skipped, name)
+			continue
+		}
+		keep[name] = rawURL
+	}
+	if len(skipped) > 0 {
+		g.logger.Info("Skipping %d of %d databases not updated since %s", len(skipped), len(auth.URLs), g.config.Since.Format(time.RFC3339))
+	}
+	return keep, skipped
+}
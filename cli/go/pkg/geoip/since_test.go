@@ -0,0 +1,79 @@
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestUpdateSince verifies databases are skipped by server last_updated
+// time, falling back to the local mtime when the listing has none.
+func TestUpdateSince(t *testing.T) {
+	since := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	var srvURL string
+	fetched := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			urls := map[string]string{}
+			for _, name := range []string{"old.mmdb", "new.mmdb", "fresh-local.mmdb", "unknown.mmdb"} {
+				urls[name] = srvURL + "/db/" + name
+			}
+			json.NewEncoder(w).Encode(urls)
+		case "/databases":
+			io.WriteString(w, `{"providers": {"maxmind": {"databases": [
+				{"name": "old.mmdb", "last_updated": "2024-05-01T00:00:00Z"},
+				{"name": "New.mmdb", "last_updated": "2024-06-15T00:00:00Z"},
+				{"name": "fresh-local.mmdb"}
+			]}}}`)
+		default:
+			if r.Method == http.MethodGet {
+				fetched[filepath.Base(r.URL.Path)] = true
+			}
+			io.WriteString(w, "database")
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	cfg := &Config{
+		APIEndpoints:   []string{srv.URL + "/auth"},
+		TargetDir:      t.TempDir(),
+		Timeout:        10 * time.Second,
+		MaxRetries:     1,
+		MaxConcurrent:  1,
+		Since:          since,
+		SkipSpaceCheck: true,
+	}
+	if err := os.WriteFile(filepath.Join(cfg.TargetDir, "fresh-local.mmdb"), []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	updater, err := New(cfg, Options{HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updater.Close()
+
+	results, err := updater.Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped := map[string]bool{}
+	for _, r := range results {
+		if r.Skipped {
+			skipped[r.Database] = true
+		}
+	}
+	if len(results) != 4 || !skipped["old.mmdb"] || !skipped["fresh-local.mmdb"] || len(skipped) != 2 {
+		t.Errorf("results = %+v", results)
+	}
+	if !fetched["new.mmdb"] || !fetched["unknown.mmdb"] || fetched["old.mmdb"] || fetched["fresh-local.mmdb"] {
+		t.Errorf("fetched = %v", fetched)
+	}
+}
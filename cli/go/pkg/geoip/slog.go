@@ -0,0 +1,68 @@
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+)
+
+// slogLogger adapts a *slog.Logger to Logger.
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger returns a Logger writing to l. SUCCESS messages are logged
+// at Info level with success=true, DEBUG messages at Debug level, and
+// structured fields become attributes.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{l: l}
+}
+
+func (s *slogLogger) Info(format string, args ...interface{}) {
+	s.logFields("INFO", fmt.Sprintf(format, args...), nil)
+}
+
+func (s *slogLogger) Warn(format string, args ...interface{}) {
+	s.logFields("WARN", fmt.Sprintf(format, args...), nil)
+}
+
+func (s *slogLogger) Error(format string, args ...interface{}) {
+	s.logFields("ERROR", fmt.Sprintf(format, args...), nil)
+}
+
+func (s *slogLogger) Success(format string, args ...interface{}) {
+	s.logFields("SUCCESS", fmt.Sprintf(format, args...), nil)
+}
+
+func (s *slogLogger) Debug(format string, args ...interface{}) {
+	s.logFields("DEBUG", fmt.Sprintf(format, args...), nil)
+}
+
+func (s *slogLogger) debugOn() bool {
+	return s.l.Enabled(context.Background(), slog.LevelDebug)
+}
+
+func (s *slogLogger) logFields(level, message string, fields map[string]interface{}) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, 0, len(fields)+1)
+	for _, k := range keys {
+		attrs = append(attrs, slog.Any(k, fields[k]))
+	}
+	var lvl slog.Level
+	switch level {
+	case "DEBUG":
+		lvl = slog.LevelDebug
+	case "WARN":
+		lvl = slog.LevelWarn
+	case "ERROR":
+		lvl = slog.LevelError
+	case "SUCCESS":
+		attrs = append(attrs, slog.Bool("success", true))
+	}
+	s.l.LogAttrs(context.Background(), lvl, message, attrs...)
+}
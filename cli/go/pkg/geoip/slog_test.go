@@ -0,0 +1,99 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingLogger implements only the four required levels.
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (r *recordingLogger) add(level, format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = append(r.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (r *recordingLogger) Info(format string, args ...interface{})  { r.add("INFO", format, args...) }
+func (r *recordingLogger) Warn(format string, args ...interface{})  { r.add("WARN", format, args...) }
+func (r *recordingLogger) Error(format string, args ...interface{}) { r.add("ERROR", format, args...) }
+func (r *recordingLogger) Success(format string, args ...interface{}) {
+	r.add("SUCCESS", format, args...)
+}
+
+// TestCustomLoggers verifies Options.Logger replaces the console logger:
+// a plain Logger gets the run's messages, and the slog adapter emits clean
+// structured records including the --debug wire detail when the handler
+// is at Debug level.
+func TestCustomLoggers(t *testing.T) {
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" {
+			json.NewEncoder(w).Encode(map[string]string{"a.db": srvURL + "/a"})
+			return
+		}
+		w.Write([]byte("data"))
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	run := func(logger Logger) {
+		cfg := &Config{
+			APIEndpoints:   []string{srv.URL + "/auth"},
+			TargetDir:      t.TempDir(),
+			Timeout:        10 * time.Second,
+			MaxRetries:     1,
+			MaxConcurrent:  1,
+			SkipSpaceCheck: true,
+		}
+		updater, err := New(cfg, Options{HTTPClient: srv.Client(), Logger: logger, Progress: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer updater.Close()
+		if _, err := updater.Update(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := &recordingLogger{}
+	run(rec)
+	if got := strings.Join(rec.lines, "\n"); !strings.Contains(got, "SUCCESS Successfully downloaded: a.db (4 bytes)") {
+		t.Errorf("custom logger missed the result:\n%s", got)
+	}
+
+	var buf bytes.Buffer
+	run(NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	out := buf.String()
+	if strings.Contains(out, "\033[") {
+		t.Errorf("slog output contains ANSI codes:\n%s", out)
+	}
+	var sawSuccess, sawWire bool
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var rec map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("not JSON: %q", line)
+		}
+		if rec["success"] == true && rec["level"] == "INFO" {
+			sawSuccess = true
+		}
+		if rec["level"] == "DEBUG" && rec["method"] == "GET" && rec["status"] != nil {
+			sawWire = true
+		}
+	}
+	if !sawSuccess || !sawWire {
+		t.Errorf("success record %v, wire debug record %v:\n%s", sawSuccess, sawWire, out)
+	}
+}
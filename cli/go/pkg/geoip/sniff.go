@@ -0,0 +1,81 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// errorBodyPrefixes start the documents servers send in place of a file: an
+// S3/CDN XML error (often with status 200 once a presigned URL expires), an
+// HTML error page, or a JSON API error. No database format begins with them.
+var errorBodyPrefixes = []string{"<?xml", "<error>", "<html", "<!doctype html", "{"}
+
+// errorBodySniffSize is how much of a download is examined, enough for a
+// whole error document.
+const errorBodySniffSize = 4096
+
+// sniffErrorBody fails when the downloaded file at path is an error
+// document rather than a database, quoting the server's message.
+func sniffErrorBody(path string) error {
+	head, err := readHead(path, errorBodySniffSize)
+	if err != nil {
+		return err
+	}
+	text := bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")))
+	lower := strings.ToLower(string(text[:min(len(text), 16)]))
+	for _, prefix := range errorBodyPrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return fmt.Errorf("server sent an error document instead of the database (expired download URL?): %s", errorBodyMessage(text))
+		}
+	}
+	return nil
+}
+
+var (
+	xmlMessage = regexp.MustCompile(`(?is)<(Code|Message)>\s*(.*?)\s*</(?:Code|Message)>`)
+	htmlTitle  = regexp.MustCompile(`(?is)<title>\s*(.*?)\s*</title>`)
+	markup     = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// errorBodyMessage extracts the useful part of an error document: the
+// Code and Message of an S3-style XML error, the message or error of a
+// JSON body, the title of an HTML page, or else its text.
+func errorBodyMessage(body []byte) string {
+	if body[0] == '{' {
+		var v struct {
+			Message string          `json:"message"`
+			Error   json.RawMessage `json:"error"`
+		}
+		if json.Unmarshal(body, &v) == nil {
+			var errText string
+			if json.Unmarshal(v.Error, &errText) != nil {
+				errText = string(v.Error)
+			}
+			if msg := strings.TrimSpace(v.Message + " " + errText); msg != "" {
+				return truncateText(msg, 200)
+			}
+		}
+	}
+	if m := xmlMessage.FindAllSubmatch(body, -1); len(m) > 0 {
+		parts := make([]string, len(m))
+		for i, sub := range m {
+			parts[i] = string(sub[2])
+		}
+		return truncateText(strings.Join(parts, ": "), 200)
+	}
+	if m := htmlTitle.FindSubmatch(body); m != nil {
+		return truncateText(string(m[1]), 200)
+	}
+	return truncateText(strings.Join(strings.Fields(markup.ReplaceAllString(string(body), " ")), " "), 200)
+}
+
+// truncateText shortens s to at most n bytes, marking the cut.
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
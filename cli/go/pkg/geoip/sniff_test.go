@@ -0,0 +1,74 @@
+package geoip
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSniffErrorBody(t *testing.T) {
+	cases := map[string]string{
+		"s3":      `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Request has expired</Message></Error>`,
+		"bare":    "\n<Error><Code>NoSuchKey</Code></Error>",
+		"html":    "<!DOCTYPE html><html><head><title>403 Forbidden</title></head><body>nope</body></html>",
+		"json":    `{"error": "token expired"}`,
+		"message": `{"message": "Forbidden"}`,
+		"bom":     "\xef\xbb\xbf<html><body>Gone</body></html>",
+	}
+	wants := map[string]string{
+		"s3":      "AccessDenied: Request has expired",
+		"bare":    "NoSuchKey",
+		"html":    "403 Forbidden",
+		"json":    "token expired",
+		"message": "Forbidden",
+		"bom":     "Gone",
+	}
+	dir := t.TempDir()
+	for name, body := range cases {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(body), 0644)
+		err := sniffErrorBody(path)
+		if err == nil || !strings.HasSuffix(err.Error(), ": "+wants[name]) {
+			t.Errorf("%s: err = %v, want message %q", name, err, wants[name])
+		}
+	}
+
+	for name, body := range map[string]string{
+		"mmdb": "\x00\x01binary\xab\xcd\xef\xabMaxMind.com",
+		"gzip": "\x1f\x8b\x08\x00",
+		"csv":  `"1.0.0.0","1.0.0.255","AU"`,
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(body), 0644)
+		if err := sniffErrorBody(path); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// TestDownloadRejectsErrorBody verifies an XML error served with 200 fails
+// the download and never replaces the installed database.
+func TestDownloadRejectsErrorBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<?xml version="1.0"?><Error><Code>AccessDenied</Code><Message>Request has expired</Message></Error>`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.BIN"), []byte("old"), 0644)
+	cfg := &Config{TargetDir: dir, MaxRetries: 1, Timeout: 5 * time.Second}
+	g := &Updater{config: cfg, httpClient: newHTTPClient(cfg.Timeout, 1, &ConsoleLogger{quiet: true}), logger: &ConsoleLogger{quiet: true}, tempDir: t.TempDir()}
+
+	result := g.downloadDatabase(context.Background(), "a.BIN", srv.URL+"/a.BIN", "")
+	if result.Error == nil || !strings.Contains(result.Error.Error(), "Request has expired") {
+		t.Errorf("err = %v, want the server's message", result.Error)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.BIN")); string(data) != "old" {
+		t.Errorf("a.BIN replaced with %q", data)
+	}
+}
@@ -0,0 +1,78 @@
+package geoip
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// hasTLSOptions reports whether config customises TLS, which requires the
+// transport built by New.
+func hasTLSOptions(config *Config) bool {
+	return config.CACert != "" || config.CAOnly || config.ClientCert != "" || config.ClientKey != "" ||
+		config.InsecureSkipVerify || (config.TLSMinVersion != "" && config.TLSMinVersion != "1.2")
+}
+
+// newTLSConfig builds the client TLS configuration for config: TLS 1.2 (or
+// TLSMinVersion) or later, the system roots plus CACert (CACert alone with
+// CAOnly), and the ClientCert/ClientKey pair for mutual TLS.
+func newTLSConfig(config *Config) (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(config.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         minVersion,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CAOnly && config.CACert == "" {
+		return nil, errors.New("CAOnly requires CACert")
+	}
+	if config.CACert != "" {
+		pem, err := os.ReadFile(config.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		// Keep the system roots: presigned download URLs usually point at
+		// public storage even when the API itself is private. CAOnly is for
+		// air-gapped mirrors that serve the downloads themselves.
+		var pool *x509.CertPool
+		if !config.CAOnly {
+			pool, err = x509.SystemCertPool()
+		}
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", config.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		return nil, errors.New("a client certificate and its key must be given together")
+	}
+	if config.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// parseTLSVersion maps a TLSMinVersion value to its crypto/tls constant;
+// empty means TLS 1.2.
+func parseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported minimum TLS version %q: use 1.2 or 1.3", v)
+}
@@ -0,0 +1,179 @@
+package geoip
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePEM writes a PEM block of typ to dir/name and returns its path.
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newClientCert issues a self-signed client certificate and writes it and
+// its key to dir.
+func newClientCert(t *testing.T, dir string) (cert *x509.Certificate, certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "geoip-client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, writePEM(t, dir, "client.pem", "CERTIFICATE", der), writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+// TestTLSOptions verifies a private CA, a client certificate for mutual
+// TLS and InsecureSkipVerify each let the default transport, and the one
+// NewConfiguredClient returns, reach a server it otherwise rejects.
+func TestTLSOptions(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, certFile, keyFile := newClientCert(t, dir)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := writePEM(t, dir, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+
+	get := func(cfg *Config) error {
+		cfg.MaxRetries = 1
+		cfg.Timeout = 5 * time.Second
+		g, err := New(cfg, Options{})
+		if err != nil {
+			return err
+		}
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", srv.URL, nil)
+		resp, err := g.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if err := get(&Config{}); err == nil {
+		t.Error("untrusted server accepted without CACert")
+	}
+	if err := get(&Config{CACert: caFile}); err == nil {
+		t.Error("server requiring a client certificate accepted without one")
+	}
+	if err := get(&Config{CACert: caFile, ClientCert: certFile, ClientKey: keyFile}); err != nil {
+		t.Errorf("CACert + client certificate: %v", err)
+	}
+	if err := get(&Config{InsecureSkipVerify: true, ClientCert: certFile, ClientKey: keyFile}); err != nil {
+		t.Errorf("InsecureSkipVerify: %v", err)
+	}
+
+	client, err := NewConfiguredClient(&Config{CACert: caFile, ClientCert: certFile, ClientKey: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Get(srv.URL); err != nil {
+		t.Errorf("NewConfiguredClient: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+
+	if err := get(&Config{CACert: caFile, CAOnly: true, ClientCert: certFile, ClientKey: keyFile}); err != nil {
+		t.Errorf("CAOnly: %v", err)
+	}
+	tlsConfig, err := newTLSConfig(&Config{CACert: caFile, CAOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	only := x509.NewCertPool()
+	only.AddCert(srv.Certificate())
+	if !tlsConfig.RootCAs.Equal(only) {
+		t.Error("CAOnly kept roots besides CACert")
+	}
+	if _, err := New(&Config{CAOnly: true}, Options{}); err == nil {
+		t.Error("CAOnly without CACert accepted")
+	}
+
+	if _, err := New(&Config{ClientCert: certFile}, Options{}); err == nil || !strings.Contains(err.Error(), "together") {
+		t.Errorf("certificate without key: %v", err)
+	}
+	if _, err := New(&Config{CACert: keyFile}, Options{}); err == nil {
+		t.Error("CA file without certificates accepted")
+	}
+	if _, err := New(&Config{CACert: caFile}, Options{HTTPClient: srv.Client()}); err == nil {
+		t.Error("TLS options combined with Options.HTTPClient accepted")
+	}
+}
+
+// TestTLSMinVersion verifies TLSMinVersion sets the client's floor and
+// rejects unsupported versions.
+func TestTLSMinVersion(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(version string) error {
+		g, err := New(&Config{TLSMinVersion: version, InsecureSkipVerify: true, MaxRetries: 1, Timeout: 5 * time.Second}, Options{})
+		if err != nil {
+			return err
+		}
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		resp, err := g.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if err := get("1.2"); err != nil {
+		t.Errorf("1.2 against a TLS 1.2 server: %v", err)
+	}
+	if err := get("1.3"); err == nil {
+		t.Error("1.3 accepted a TLS 1.2 server")
+	}
+	if err := get("1.1"); err == nil || !strings.Contains(err.Error(), "unsupported minimum TLS version") {
+		t.Errorf("1.1: %v", err)
+	}
+}
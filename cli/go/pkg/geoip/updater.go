@@ -0,0 +1,680 @@
+// Package geoip downloads GeoIP databases (MaxMind MMDB and IP2Location BIN)
+// from a GeoIP API endpoint into a local directory. It is the engine behind
+// the geoip-update CLI and can be embedded in other Go programs:
+//
+//	updater, err := geoip.New(&geoip.Config{
+//		APIKey:       key,
+//		APIEndpoints: []string{"https://geoipdb.net/auth"},
+//		TargetDir:    "/var/lib/geoip",
+//		Databases:    []string{"all"},
+//	}, geoip.Options{LogOutput: os.Stderr})
+//	if err != nil {
+//		return err
+//	}
+//	defer updater.Close()
+//	results, err := updater.Update(ctx)
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DownloadResult represents the result of a database download
+type DownloadResult struct {
+	Database string
+	Size     int64
+	Error    error
+	// Unchanged is set when the server answered 304 Not Modified and the
+	// existing file in TargetDir was kept.
+	Unchanged bool
+	// Skipped is set when Config.Since excluded the database and no
+	// request was made for it.
+	Skipped bool
+	// NotNewer is set when Config.IfNewer kept the existing file because
+	// the server's copy was last modified before it was written.
+	NotNewer bool
+	// Aborted is set when FailFast cancelled the download after another
+	// database failed; Error holds the cancellation.
+	Aborted bool
+	// Duration is how long the download (or revalidation) took.
+	Duration time.Duration
+	// SHA256 is the hex digest of the installed database, when known: it
+	// is not computed with NoVerifyChecksum.
+	SHA256 string
+	// Path is where the database is installed, for downloaded and
+	// unchanged databases.
+	Path string
+
+	contentType string // as served, when the file was stored unchanged
+	url         string // where it was downloaded from, for the JSON manifest
+}
+
+// Options customises how an Updater talks to the network and reports.
+type Options struct {
+	// HTTPClient is used for every request. When nil a client is created
+	// from Config's TLS options, ConnectTimeout and ResponseHeaderTimeout.
+	HTTPClient *http.Client
+	// Logger receives log output. When nil, a ConsoleLogger writes plain
+	// lines to LogOutput, and nothing is logged if that is nil too.
+	Logger Logger
+	// LogOutput is the destination for the default Logger.
+	LogOutput io.Writer
+	// Progress enables per-download progress with the default
+	// ConsoleLogger: bars on a terminal, periodic log lines otherwise.
+	Progress bool
+	// OnPhase, when set, is called as Update enters each phase
+	// (PhaseAuthenticating, PhaseDownloading) and again as each download
+	// finishes, with the number of databases still to download. It may be
+	// called from several goroutines at once.
+	OnPhase func(phase string, remaining int)
+}
+
+// Update phases reported to Options.OnPhase.
+const (
+	PhaseAuthenticating = "authenticating"
+	PhaseDownloading    = "downloading"
+)
+
+// Updater handles the database update process
+type Updater struct {
+	config       *Config
+	httpClient   *HTTPClient
+	auth         Authenticator
+	logger       Logger
+	tempDir      string // staging directory inside TargetDir, set during Update
+	progress     *progress
+	showProgress bool
+	limiter      *rateLimiter // shared by all downloads, nil when unlimited
+	s3           *s3Uploader  // nil unless Config.S3Bucket is set
+	gcs          *gcsUploader // nil unless Config.GCSBucket is set
+	onPhase      func(phase string, remaining int)
+}
+
+// New creates an Updater for config. Call Close when done to remove any
+// temporary files an interrupted Update left behind.
+func New(config *Config, opts Options) (*Updater, error) {
+	logger := opts.Logger
+	if logger == nil {
+		out := opts.LogOutput
+		if out == nil {
+			out = io.Discard
+		}
+		var err error
+		if logger, err = NewLogger(config, out); err != nil {
+			return nil, err
+		}
+	}
+
+	switch config.Compression {
+	case "", CompressionAuto, CompressionOff, CompressionGzip:
+	default:
+		return nil, fmt.Errorf("unknown compression mode %q: use %s, %s or %s", config.Compression, CompressionAuto, CompressionOff, CompressionGzip)
+	}
+
+	var httpClient *HTTPClient
+	if opts.HTTPClient != nil {
+		if config.Compression == CompressionOff {
+			return nil, errors.New("Compression off cannot be combined with Options.HTTPClient; set DisableCompression on its transport instead")
+		}
+		if hasTLSOptions(config) {
+			return nil, errors.New("TLSMinVersion, CACert, CAOnly, ClientCert, ClientKey and InsecureSkipVerify cannot be combined with Options.HTTPClient; configure its transport instead")
+		}
+		if config.Proxy != "" || len(config.NoProxy) > 0 {
+			return nil, errors.New("Proxy and NoProxy cannot be combined with Options.HTTPClient; configure its transport instead")
+		}
+		httpClient = NewHTTPClient(opts.HTTPClient, config.MaxRetries, logger)
+	} else {
+		client, err := NewConfiguredClient(config)
+		if err != nil {
+			return nil, err
+		}
+		if config.InsecureSkipVerify {
+			logger.Warn("TLS certificate verification is DISABLED: any server can impersonate the API and download hosts. Use only for testing.")
+		}
+		if config.Proxy != "" {
+			logger.Info("Using proxy %s", redactProxy(config.Proxy))
+		}
+		httpClient = NewHTTPClient(client, config.MaxRetries, logger)
+	}
+
+	httpClient.SetBackoff(Backoff{Base: config.RetryBaseDelay, Multiplier: config.RetryMultiplier, Max: config.RetryMaxDelay, MaxElapsed: config.RetryMaxElapsed})
+	httpClient.SetRetryOn(config.RetryOn)
+	httpClient.SetAttemptTimeout(config.RequestTimeout)
+	httpClient.SetJitterSeed(config.RetryJitterSeed)
+	auth, err := NewAuthenticator(config, httpClient.client)
+	if err != nil {
+		return nil, err
+	}
+	if config.Manifest {
+		if _, err := manifestName(config.ManifestAlgo); err != nil {
+			return nil, err
+		}
+	}
+
+	g := &Updater{
+		config:       config,
+		httpClient:   httpClient,
+		auth:         auth,
+		logger:       logger,
+		showProgress: opts.Progress,
+		limiter:      newRateLimiter(config.MaxRate),
+		onPhase:      opts.OnPhase,
+	}
+	if config.S3Bucket != "" {
+		g.s3 = newS3Uploader(config, httpClient, logger)
+	}
+	if config.GCSBucket != "" {
+		g.gcs = newGCSUploader(config, httpClient, logger)
+	}
+	return g, nil
+}
+
+// authResponse is the decoded /auth response. The endpoint returns a flat
+// object of database name -> download URL; newer endpoints may also include a
+// "checksums" object mapping database name -> SHA256 hex digest.
+type authResponse struct {
+	URLs      map[string]string
+	Checksums map[string]string
+
+	endpoint string // the endpoint that answered
+}
+
+func (a *authResponse) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	a.URLs = make(map[string]string, len(raw))
+	for name, value := range raw {
+		if name == "checksums" {
+			if err := json.Unmarshal(value, &a.Checksums); err != nil {
+				return fmt.Errorf("invalid checksums: %w", err)
+			}
+			continue
+		}
+		var url string
+		if err := json.Unmarshal(value, &url); err != nil {
+			return fmt.Errorf("invalid URL for %s: %w", name, err)
+		}
+		a.URLs[name] = url
+	}
+	return nil
+}
+
+// DefaultUserAgent returns a User-Agent identifying this client, its
+// version (when not empty) and platform, and with withHost the machine:
+// "GeoIP-Update-Go/1.0.0 (linux/amd64; host=web-3)". API operators can
+// then tell which fleet or host is behind a rate limit.
+func DefaultUserAgent(version string, withHost bool) string {
+	ua := "GeoIP-Update-Go"
+	if version != "" {
+		ua += "/" + version
+	}
+	ua += " (" + runtime.GOOS + "/" + runtime.GOARCH
+	if hostname, err := os.Hostname(); withHost && err == nil && hostname != "" {
+		ua += "; host=" + hostname
+	}
+	return ua + ")"
+}
+
+// configUserAgent returns config.UserAgent, or the library default.
+func configUserAgent(config *Config) string {
+	if config.UserAgent != "" {
+		return config.UserAgent
+	}
+	return DefaultUserAgent("", false)
+}
+
+// userAgent returns the User-Agent sent with every request.
+func (g *Updater) userAgent() string {
+	return configUserAgent(g.config)
+}
+
+func (g *Updater) authenticate(ctx context.Context) (*authResponse, error) {
+	if len(g.config.APIEndpoints) == 0 {
+		return nil, fmt.Errorf("no API endpoint configured")
+	}
+
+	// Prepare request body
+	body := map[string]interface{}{
+		"databases": "all",
+	}
+	if len(g.config.Databases) > 0 && g.config.Databases[0] != "all" {
+		body["databases"] = g.config.Databases
+	}
+
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Fall back to the next endpoint only once the current one has used up
+	// its retries, and not when it rejected the credentials.
+	var lastErr error
+	for i, endpoint := range g.config.APIEndpoints {
+		if i > 0 {
+			g.logger.Warn("Endpoint %s failed: %v; trying fallback %s", g.config.APIEndpoints[i-1], lastErr, endpoint)
+		}
+		auth, err := g.authenticateWith(ctx, endpoint, jsonBody)
+		if err == nil {
+			if i > 0 {
+				g.logger.Warn("Download URLs served by fallback endpoint %s", endpoint)
+			} else {
+				g.logger.Info("Download URLs served by %s", endpoint)
+			}
+			return auth, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		// Mirrors share the key: one that rejects it speaks for all.
+		if IsRejected(err) {
+			if i > 0 {
+				return nil, fmt.Errorf("endpoint %s: %w", endpoint, err)
+			}
+			return nil, err
+		}
+		lastErr = err
+	}
+	if len(g.config.APIEndpoints) == 1 {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("all %d API endpoints failed, last (%s): %w",
+		len(g.config.APIEndpoints), g.config.APIEndpoints[len(g.config.APIEndpoints)-1], lastErr)
+}
+
+// authenticateWith posts the authentication request to a single endpoint.
+func (g *Updater) authenticateWith(ctx context.Context, endpoint string, jsonBody []byte) (*authResponse, error) {
+	g.logger.Info("Authenticating with API endpoint %s", endpoint)
+	ctx, cancel := g.requestContext(ctx)
+	defer cancel()
+
+	// Create request
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", g.userAgent())
+	if err := g.auth.Authorize(ctx, req); err != nil {
+		return nil, err
+	}
+
+	// Make request
+	resp, err := g.httpClient.doWithRetry(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Parse response
+	var auth authResponse
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	auth.endpoint = endpoint
+	g.logger.Info("Received URLs for %d databases", len(auth.URLs))
+	if len(auth.Checksums) > 0 {
+		g.logger.Info("Received checksums for %d databases", len(auth.Checksums))
+	}
+	return &auth, nil
+}
+
+// requestContext bounds a single API request, or a whole download, by
+// Config.Timeout.
+func (g *Updater) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if g.config.Timeout > 0 {
+		return context.WithTimeout(ctx, g.config.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// PlannedDownload describes a database that Update would fetch.
+type PlannedDownload struct {
+	Database string
+	URL      string // possibly presigned; treat as a secret
+	Host     string
+	Size     int64 // Content-Length from a HEAD request, -1 if unknown
+}
+
+// RedactedURL returns URL with any query string (where presigned URLs keep
+// their signature and credentials) and user info replaced, safe to log.
+func (p PlannedDownload) RedactedURL() string {
+	return redactURL(p.URL)
+}
+
+// redactURL replaces the user info and query string of rawURL, which may
+// carry credentials, with "REDACTED".
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "REDACTED"
+	}
+	u.Fragment = ""
+	return u.String()
+}
+
+// Plan authenticates and resolves the databases Update would download,
+// without downloading anything or touching TargetDir. Sizes come from a
+// single HEAD request per URL; servers that reject HEAD (e.g. URLs presigned
+// for GET only) report -1.
+func (g *Updater) Plan(ctx context.Context) ([]PlannedDownload, error) {
+	auth, err := g.authenticate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication failed: %w", err)
+	}
+
+	urls, _ := g.filterSince(ctx, auth)
+	plan := make([]PlannedDownload, 0, len(urls))
+	for name, rawURL := range urls {
+		p := PlannedDownload{Database: name, URL: rawURL, Size: -1}
+		if u, err := url.Parse(rawURL); err == nil {
+			p.Host = u.Host
+		}
+		p.Size = g.headSize(ctx, name, rawURL)
+		plan = append(plan, p)
+	}
+	sort.Slice(plan, func(i, j int) bool { return plan[i].Database < plan[j].Database })
+	return plan, ctx.Err()
+}
+
+// headSize returns the Content-Length announced for rawURL by a HEAD
+// request, or -1 if it cannot be determined.
+func (g *Updater) headSize(ctx context.Context, name, rawURL string) int64 {
+	size, _ := g.headInfo(ctx, name, rawURL)
+	return size
+}
+
+// Update authenticates, downloads every resolved database into TargetDir and
+// returns one result per database. The error is non-nil if authentication
+// failed or any download failed; results are returned in either case.
+// Cancelling ctx aborts queued and in-flight downloads, removes their partial
+// files and makes Update return an error wrapping ctx.Err(). With AtomicDir
+// the directory is only replaced, as a whole, when every database succeeded.
+// With OnlyIfStale it returns no results, without contacting the API, while
+// every database is younger than MaxAge.
+func (g *Updater) Update(ctx context.Context) ([]DownloadResult, error) {
+	if g.config.OnlyIfStale && g.config.MaxAge > 0 {
+		why := g.staleDatabase(time.Now())
+		if why == "" {
+			g.logger.Info("All databases fresh (modified within %s), skipping update", g.config.MaxAge)
+			return nil, nil
+		}
+		g.logger.Info("Update needed: %s", why)
+	}
+	if g.config.AtomicDir {
+		return g.updateAtomic(ctx)
+	}
+	return g.update(ctx)
+}
+
+// update is Update in place: each database replaces its file in TargetDir
+// as soon as it validates.
+func (g *Updater) update(ctx context.Context) ([]DownloadResult, error) {
+	g.logger.Info("Starting GeoIP database update")
+	g.logger.Info("Target directory: %s", g.config.TargetDir)
+
+	// Ensure target directory exists
+	if err := os.MkdirAll(g.config.TargetDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create target directory: %w", err)
+	}
+
+	// Stage downloads in a hidden directory inside TargetDir so moving a
+	// finished database into place is a same-filesystem, atomic rename.
+	if g.tempDir == "" {
+		tempDir, err := os.MkdirTemp(g.config.TargetDir, ".geoip-update-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp directory: %w", err)
+		}
+		g.tempDir = tempDir
+		defer g.Close()
+	}
+
+	// Get download URLs
+	g.phase(PhaseAuthenticating, 0)
+	auth, err := g.authenticate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication failed: %w", err)
+	}
+	if len(auth.URLs) == 0 {
+		g.logger.Warn("No databases to download")
+		return nil, nil
+	}
+	urls, skipped := g.filterSince(ctx, auth)
+
+	// One HEAD per database up front: announce the total, then fail before
+	// writing anything rather than filling the disk mid-copy.
+	if len(urls) > 0 {
+		sizes := g.expectedSizes(ctx, urls)
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("update cancelled: %w", err)
+		}
+		g.announceDownloads(sizes)
+		if !g.config.SkipSpaceCheck {
+			if err := g.checkDiskSpace(sizes); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Per-download progress: stacked in-place bars on stderr when attached
+	// to a terminal, periodic log lines when output is redirected or logged
+	// as JSON, nothing in quiet mode.
+	if console, ok := g.logger.(*ConsoleLogger); ok && g.showProgress && !g.config.Quiet {
+		tty := !console.json && isTerminal(os.Stderr) && isTerminal(os.Stdout)
+		g.progress = newProgress(console, tty)
+		defer func() {
+			g.progress.Stop()
+			g.progress = nil
+		}()
+	}
+
+	// Download databases concurrently. With FailFast the first failure
+	// cancels the downloads still queued or in flight. Their 429s reach
+	// this run's slots through the context.
+	slots := newSlots(g.maxConcurrent(len(urls)), g.config.ConcurrencyAuto, g.logger)
+	dlCtx, abort := context.WithCancel(withThrottled(ctx, slots.rateLimited))
+	defer abort()
+	results := make(chan DownloadResult, len(urls))
+	if g.config.ConcurrencyAuto {
+		g.logger.Info("Concurrent downloads: %d (auto: %d CPUs, %d databases; adapts to rate limiting)", slots.limit, runtime.NumCPU(), len(urls))
+	} else {
+		g.logger.Info("Concurrent downloads: %d", slots.limit)
+	}
+	switch g.config.Compression {
+	case CompressionOff:
+		g.logger.Info("Compression: off (downloads requested unencoded)")
+	case CompressionGzip:
+		g.logger.Info("Compression: gzip (requested on every single-stream download)")
+	default:
+		g.logger.Info("Compression: auto (gzip negotiated and decoded by the transport)")
+	}
+	var wg sync.WaitGroup
+	var successCount, unchangedCount, notNewerCount, failCount, abortedCount int32
+	remaining := int32(len(urls))
+	g.phase(PhaseDownloading, len(urls))
+
+	for name, url := range urls {
+		wg.Add(1)
+		go func(name, url string) {
+			defer wg.Done()
+
+			// Wait for a slot, unless the run is cancelled while queued
+			var result DownloadResult
+			if err := slots.acquire(dlCtx); err != nil {
+				result = DownloadResult{Database: name, Error: err}
+			} else {
+				started := time.Now()
+				result = g.downloadDatabase(dlCtx, name, url, auth.Checksums[name])
+				result.url = url
+				if g.s3 != nil && result.Error == nil {
+					if err := g.s3.upload(dlCtx, result.Path, result.contentType); err != nil {
+						result.Error = fmt.Errorf("S3 upload failed: %w", err)
+					}
+				}
+				if g.gcs != nil && result.Error == nil {
+					if err := g.gcs.upload(dlCtx, result.Path, result.contentType, url); err != nil {
+						result.Error = fmt.Errorf("GCS upload failed: %w", err)
+					}
+				}
+				result.Duration = time.Since(started)
+				slots.release()
+			}
+			cancelled := dlCtx.Err() != nil && errors.Is(result.Error, dlCtx.Err())
+			result.Aborted = cancelled && ctx.Err() == nil
+			results <- result
+			g.phase(PhaseDownloading, int(atomic.AddInt32(&remaining, -1)))
+
+			if result.Aborted {
+				atomic.AddInt32(&abortedCount, 1)
+				g.logger.Warn("Aborted after an earlier failure: %s", result.Database)
+			} else if cancelled {
+				atomic.AddInt32(&failCount, 1)
+				g.logger.Warn("Cancelled: %s", result.Database)
+			} else if result.Error != nil {
+				atomic.AddInt32(&failCount, 1)
+				g.logger.Error("Failed to download %s: %v", result.Database, result.Error)
+				if g.config.FailFast {
+					abort()
+				}
+			} else if result.Unchanged {
+				atomic.AddInt32(&unchangedCount, 1)
+				g.logger.Success("Up to date: %s (%d bytes)", result.Database, result.Size)
+			} else if result.NotNewer {
+				atomic.AddInt32(&notNewerCount, 1)
+				g.logger.Success("Not newer than local copy: %s (%d bytes)", result.Database, result.Size)
+			} else {
+				atomic.AddInt32(&successCount, 1)
+				g.logger.Success("Successfully downloaded: %s (%d bytes)", result.Database, result.Size)
+			}
+		}(name, url)
+	}
+
+	// Wait for all downloads
+	wg.Wait()
+	close(results)
+
+	collected := make([]DownloadResult, 0, len(urls)+len(skipped))
+	for result := range results {
+		collected = append(collected, result)
+	}
+	for _, name := range skipped {
+		collected = append(collected, DownloadResult{Database: name, Skipped: true})
+	}
+	sort.Slice(collected, func(i, j int) bool { return collected[i].Database < collected[j].Database })
+
+	if (g.config.Dated || g.config.StableNames) && g.config.Keep > 0 {
+		g.pruneVersions(collected)
+	}
+	if g.config.KeepVersions > 0 {
+		g.storeVersions(collected)
+	}
+
+	// Summary
+	total := len(collected)
+	success := int(atomic.LoadInt32(&successCount))
+	unchanged := int(atomic.LoadInt32(&unchangedCount))
+	notNewer := int(atomic.LoadInt32(&notNewerCount))
+	failed := int(atomic.LoadInt32(&failCount))
+	aborted := int(atomic.LoadInt32(&abortedCount))
+
+	summary := fmt.Sprintf("Download summary: %d successful, %d up to date", success, unchanged)
+	if notNewer > 0 {
+		summary += fmt.Sprintf(", %d not newer", notNewer)
+	}
+	if len(skipped) > 0 {
+		summary += fmt.Sprintf(", %d skipped", len(skipped))
+	}
+	if aborted > 0 {
+		summary += fmt.Sprintf(", %d aborted", aborted)
+	}
+	g.logger.Info("%s, %d failed out of %d", summary, failed, total)
+
+	if g.config.Manifest && ctx.Err() == nil {
+		if err := g.writeManifest(collected); err != nil {
+			return collected, fmt.Errorf("failed to write checksum manifest: %w", err)
+		}
+	}
+	if g.config.ManifestJSON != "" && ctx.Err() == nil && failed == 0 {
+		if err := g.writeManifestJSON(collected); err != nil {
+			return collected, fmt.Errorf("failed to write %s: %w", g.config.ManifestJSON, err)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return collected, fmt.Errorf("update cancelled: %w", err)
+	}
+	if failed > 0 {
+		return collected, downloadError(collected, aborted)
+	}
+
+	g.recordSelection(collected)
+	if g.config.PurgeUnknown {
+		names := make([]string, 0, len(auth.URLs))
+		for name := range auth.URLs {
+			names = append(names, name)
+		}
+		g.purgeUnknown(names, collected)
+	}
+	return collected, nil
+}
+
+// downloadError names each failed database with its error, so the one
+// line a cron mail shows says which database broke and why.
+func downloadError(results []DownloadResult, aborted int) error {
+	var failures []string
+	for _, r := range results {
+		if r.Error != nil && !r.Aborted {
+			failures = append(failures, fmt.Sprintf("%s: %v", r.Database, r.Error))
+		}
+	}
+	msg := fmt.Sprintf("failed to download %d databases (%s)", len(failures), strings.Join(failures, "; "))
+	if aborted > 0 {
+		msg += fmt.Sprintf(", %d more aborted", aborted)
+	}
+	return errors.New(msg)
+}
+
+// Retries returns how many HTTP requests the Updater has retried since New.
+func (g *Updater) Retries() int64 {
+	return g.httpClient.retries.Load()
+}
+
+// phase reports progress to Options.OnPhase, if set.
+func (g *Updater) phase(phase string, remaining int) {
+	if g.onPhase != nil {
+		g.onPhase(phase, remaining)
+	}
+}
+
+// Close removes the Updater's temporary files.
+func (g *Updater) Close() {
+	if g.tempDir != "" {
+		g.logger.Info("Cleaning up temporary files")
+		os.RemoveAll(g.tempDir)
+		g.tempDir = ""
+	}
+}
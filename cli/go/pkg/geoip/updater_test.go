@@ -0,0 +1,395 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestUpdate drives the public API end to end: New with an injected
+// http.Client and log writer, then Update returning one result per database.
+func TestUpdate(t *testing.T) {
+	var srvURL string
+	var gotUA string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			gotUA = r.Header.Get("User-Agent")
+			json.NewEncoder(w).Encode(map[string]string{
+				"b.mmdb": srvURL + "/b",
+				"a.mmdb": srvURL + "/a",
+			})
+		case "/a", "/b":
+			if ua := r.Header.Get("User-Agent"); ua != "embedder/1.0" {
+				t.Errorf("download User-Agent = %q", ua)
+			}
+			w.Write([]byte("database " + r.URL.Path))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	var logs bytes.Buffer
+	cfg := &Config{
+		APIKey:        "test",
+		APIEndpoints:  []string{srv.URL + "/auth"},
+		TargetDir:     t.TempDir(),
+		Timeout:       10 * time.Second,
+		MaxRetries:    1,
+		MaxConcurrent: 2,
+		UserAgent:     "embedder/1.0",
+	}
+	updater, err := New(cfg, Options{HTTPClient: srv.Client(), LogOutput: &logs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updater.Close()
+
+	results, err := updater.Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	for i, want := range []string{"a.mmdb", "b.mmdb"} {
+		if results[i].Database != want {
+			t.Errorf("results[%d] = %s, want %s", i, results[i].Database, want)
+		}
+	}
+	if results[0].Error != nil || results[0].Size != int64(len("database /a")) {
+		t.Errorf("a.mmdb: size %d, err %v", results[0].Size, results[0].Error)
+	}
+	entries, _ := os.ReadDir(cfg.TargetDir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".geoip-update-") {
+			t.Errorf("staging directory %s left in TargetDir", e.Name())
+		}
+	}
+	if gotUA != "embedder/1.0" {
+		t.Errorf("User-Agent = %q", gotUA)
+	}
+	if !strings.Contains(logs.String(), "[SUCCESS] Successfully downloaded: a.mmdb") {
+		t.Errorf("expected plain success lines in log output, got:\n%s", logs.String())
+	}
+}
+
+// TestUpdateCancel verifies that cancelling the context aborts an in-flight
+// download promptly, reports context.Canceled and leaves no partial files.
+func TestUpdateCancel(t *testing.T) {
+	var srvURL string
+	started := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" {
+			json.NewEncoder(w).Encode(map[string]string{"slow.mmdb": srvURL + "/slow"})
+			return
+		}
+		if r.Method == http.MethodHead { // disk space check
+			return
+		}
+		w.Header().Set("Content-Length", "1000")
+		w.Write(bytes.Repeat([]byte("x"), 100))
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	cfg := &Config{
+		APIEndpoints:  []string{srv.URL + "/auth"},
+		TargetDir:     t.TempDir(),
+		Timeout:       time.Minute,
+		MaxRetries:    3,
+		MaxConcurrent: 1,
+	}
+	updater, err := New(cfg, Options{HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updater.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	begin := time.Now()
+	results, err := updater.Update(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Errorf("Update took %v after cancellation", elapsed)
+	}
+	if len(results) != 1 || !errors.Is(results[0].Error, context.Canceled) {
+		t.Errorf("results = %+v", results)
+	}
+	if entries, _ := os.ReadDir(updater.tempDir); len(entries) != 0 {
+		t.Errorf("partial files left behind: %v", entries)
+	}
+	if entries, _ := os.ReadDir(cfg.TargetDir); len(entries) != 0 {
+		t.Errorf("TargetDir not empty: %v", entries)
+	}
+}
+
+// TestUpdateFailFast verifies the final error names the failed database
+// and its error, and that FailFast aborts a download still in flight
+// while the default lets it finish.
+func TestUpdateFailFast(t *testing.T) {
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/auth":
+			json.NewEncoder(w).Encode(map[string]string{"bad.mmdb": srvURL + "/bad", "slow.mmdb": srvURL + "/slow"})
+		case r.Method == http.MethodHead:
+		case r.URL.Path == "/bad":
+			http.NotFound(w, r)
+		default:
+			select {
+			case <-time.After(500 * time.Millisecond):
+				w.Write([]byte("slow database"))
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	run := func(failFast bool) ([]DownloadResult, error) {
+		cfg := &Config{
+			APIEndpoints:  []string{srv.URL + "/auth"},
+			TargetDir:     t.TempDir(),
+			Timeout:       10 * time.Second,
+			MaxRetries:    1,
+			MaxConcurrent: 2,
+			FailFast:      failFast,
+		}
+		updater, err := New(cfg, Options{HTTPClient: srv.Client()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer updater.Close()
+		return updater.Update(context.Background())
+	}
+
+	results, err := run(false)
+	if err == nil || !strings.Contains(err.Error(), "failed to download 1 databases (bad.mmdb: ") {
+		t.Errorf("default: err = %v", err)
+	}
+	if len(results) != 2 || results[1].Error != nil {
+		t.Errorf("default: slow.mmdb did not finish: %+v", results)
+	}
+
+	results, err = run(true)
+	if err == nil || !strings.Contains(err.Error(), "bad.mmdb: ") || !strings.Contains(err.Error(), "1 more aborted") {
+		t.Errorf("FailFast: err = %v", err)
+	}
+	if len(results) != 2 || !results[1].Aborted {
+		t.Errorf("FailFast: slow.mmdb not aborted: %+v", results)
+	}
+}
+
+func TestDefaultUserAgent(t *testing.T) {
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	if got, want := DefaultUserAgent("1.2.3", false), "GeoIP-Update-Go/1.2.3 ("+platform+")"; got != want {
+		t.Errorf("DefaultUserAgent = %q, want %q", got, want)
+	}
+	hostname, _ := os.Hostname()
+	if got := DefaultUserAgent("", true); hostname != "" && got != "GeoIP-Update-Go ("+platform+"; host="+hostname+")" {
+		t.Errorf("with hostname = %q", got)
+	}
+}
+
+// TestUpdateNormalizeNames verifies NormalizeNames lowercases the
+// installed file while the server's name is what gets requested.
+func TestUpdateNormalizeNames(t *testing.T) {
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" {
+			json.NewEncoder(w).Encode(map[string]string{"IP2PROXY-PX2.BIN": srvURL + "/IP2PROXY-PX2.BIN"})
+			return
+		}
+		w.Write([]byte("database"))
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	cfg := &Config{
+		APIEndpoints:   []string{srv.URL + "/auth"},
+		TargetDir:      t.TempDir(),
+		Timeout:        10 * time.Second,
+		MaxRetries:     1,
+		NormalizeNames: true,
+		SkipSpaceCheck: true,
+	}
+	updater, err := New(cfg, Options{HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updater.Close()
+	results, err := updater.Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(cfg.TargetDir, "ip2proxy-px2.bin")
+	if len(results) != 1 || results[0].Database != "IP2PROXY-PX2.BIN" || results[0].Path != want {
+		t.Fatalf("results = %+v", results)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestPlan verifies a dry-run plan reports hosts and HEAD sizes, tolerates
+// servers that reject HEAD, and never creates TargetDir.
+func TestPlan(t *testing.T) {
+	var srvURL string
+	var gets int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/auth":
+			json.NewEncoder(w).Encode(map[string]string{
+				"a.mmdb": srvURL + "/a?X-Amz-Signature=secret",
+				"b.BIN":  srvURL + "/get-only",
+			})
+		case r.Method == http.MethodGet:
+			atomic.AddInt32(&gets, 1)
+		case r.URL.Path == "/a":
+			w.Header().Set("Content-Length", "12345")
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	cfg := &Config{
+		APIEndpoints: []string{srv.URL + "/auth"},
+		TargetDir:    filepath.Join(t.TempDir(), "geoip"),
+		Timeout:      10 * time.Second,
+		MaxRetries:   1,
+	}
+	updater, err := New(cfg, Options{HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updater.Close()
+
+	plan, err := updater.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := strings.TrimPrefix(srv.URL, "http://")
+	want := []PlannedDownload{
+		{Database: "a.mmdb", URL: srv.URL + "/a?X-Amz-Signature=secret", Host: host, Size: 12345},
+		{Database: "b.BIN", URL: srv.URL + "/get-only", Host: host, Size: -1},
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("plan = %+v, want %+v", plan, want)
+	}
+	if got := plan[0].RedactedURL(); got != srv.URL+"/a?REDACTED" {
+		t.Errorf("RedactedURL = %q", got)
+	}
+	if got := plan[1].RedactedURL(); got != srv.URL+"/get-only" {
+		t.Errorf("RedactedURL without query = %q", got)
+	}
+	if n := atomic.LoadInt32(&gets); n != 0 {
+		t.Errorf("%d GET requests during a dry run", n)
+	}
+	if _, err := os.Stat(cfg.TargetDir); !os.IsNotExist(err) {
+		t.Errorf("TargetDir was created: %v", err)
+	}
+}
+
+// TestAuthenticateFailover verifies a failing endpoint hands over to the
+// next one and the log names the endpoint that served the URLs.
+func TestAuthenticateFailover(t *testing.T) {
+	var primaryHits int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryHits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"a.mmdb": "https://example.com/a"})
+	}))
+	defer fallback.Close()
+
+	var logs bytes.Buffer
+	cfg := &Config{
+		APIEndpoints: []string{primary.URL + "/auth", fallback.URL + "/auth"},
+		TargetDir:    t.TempDir(),
+		Timeout:      10 * time.Second,
+		MaxRetries:   1,
+	}
+	updater, err := New(cfg, Options{LogOutput: &logs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updater.Close()
+
+	auth, err := updater.authenticate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auth.URLs) != 1 || atomic.LoadInt32(&primaryHits) != 1 {
+		t.Errorf("URLs = %v, primary hit %d times", auth.URLs, primaryHits)
+	}
+	if !strings.Contains(logs.String(), "served by fallback endpoint "+fallback.URL) {
+		t.Errorf("log does not name the serving endpoint:\n%s", logs.String())
+	}
+
+	cfg.APIEndpoints = []string{primary.URL + "/auth", primary.URL + "/other"}
+	if _, err := updater.authenticate(context.Background()); err == nil || !strings.Contains(err.Error(), "all 2 API endpoints failed") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// TestAuthenticateRejectedNoFailover verifies a 401 ends authentication at
+// once instead of trying the same key on the next endpoint.
+func TestAuthenticateRejectedNoFailover(t *testing.T) {
+	var fallbackHits int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer primary.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fallbackHits, 1)
+		json.NewEncoder(w).Encode(map[string]string{"a.mmdb": "https://example.com/a"})
+	}))
+	defer fallback.Close()
+
+	cfg := &Config{
+		APIEndpoints: []string{primary.URL + "/auth", fallback.URL + "/auth"},
+		TargetDir:    t.TempDir(),
+		Timeout:      10 * time.Second,
+		MaxRetries:   3,
+	}
+	updater, err := New(cfg, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updater.Close()
+
+	if _, err := updater.authenticate(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("err = %v, want the 401", err)
+	}
+	if n := atomic.LoadInt32(&fallbackHits); n != 0 {
+		t.Errorf("fallback endpoint hit %d times after a 401", n)
+	}
+}
@@ -0,0 +1,52 @@
+package geoip
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ValidateMMDB checks that path looks like a MaxMind DB by finding the
+// metadata marker near the end of the file.
+func ValidateMMDB(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Get file size
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := stat.Size()
+
+	// MMDB files have metadata at the end with marker \xab\xcd\xef followed by MaxMind.com
+	// Read the last 100KB to find the metadata section
+	readSize := int64(100000)
+	if size < readSize {
+		readSize = size
+	}
+
+	// Seek to the position to start reading
+	_, err = file.Seek(size-readSize, 0)
+	if err != nil {
+		return err
+	}
+
+	// Read the last portion of the file
+	buf := make([]byte, readSize)
+	n, err := file.Read(buf)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	// Look for the MMDB metadata marker
+	if !bytes.Contains(buf[:n], mmdbMetadataMarker) {
+		return fmt.Errorf("missing MaxMind metadata marker")
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Verification statuses reported by Verify.
+const (
+	VerifyCurrent = "current" // the local file matches the server's version
+	VerifyStale   = "stale"   // the server has a different version
+	VerifyMissing = "missing" // no local file
+	VerifyUnknown = "unknown" // the server gave nothing to compare against
+)
+
+// VerifyResult compares one local database with the server's version.
+type VerifyResult struct {
+	Database string
+	Path     string
+	Status   string // one of the Verify* constants
+	// Method is how the status was decided ("sha256", "etag" or "size"),
+	// or why it could not be.
+	Method     string
+	LocalSize  int64 // -1 when missing
+	RemoteSize int64 // -1 when the server did not announce it
+}
+
+// Verify authenticates and reports, for every database the server offers,
+// whether the file in TargetDir is current, stale or missing, without
+// downloading anything. It compares, in order of preference, the server's
+// SHA256 checksum, the ETag recorded by the last download and the size
+// announced by a HEAD request. The checksum and size describe the bytes as
+// served, so they are only used for databases stored as downloaded (not
+// decompressed or extracted).
+func (g *Updater) Verify(ctx context.Context) ([]VerifyResult, error) {
+	auth, err := g.authenticate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication failed: %w", err)
+	}
+
+	results := make([]VerifyResult, 0, len(auth.URLs))
+	for name, rawURL := range auth.URLs {
+		if ctx.Err() != nil {
+			break
+		}
+		results = append(results, g.verifyDatabase(ctx, name, rawURL, auth.Checksums[name]))
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Database < results[j].Database })
+	return results, ctx.Err()
+}
+
+func (g *Updater) verifyDatabase(ctx context.Context, name, rawURL, checksum string) VerifyResult {
+	r := VerifyResult{Database: name, Path: filepath.Join(g.config.TargetDir, g.storedName(name)), LocalSize: -1, RemoteSize: -1}
+	asServed := stripArchiveSuffix(stripCompressionSuffix(name)) == name
+
+	fi, err := os.Stat(r.Path)
+	if err != nil {
+		r.Status, r.Method = VerifyMissing, "no local file"
+		r.RemoteSize, _ = g.headInfo(ctx, name, rawURL)
+		return r
+	}
+	r.LocalSize = fi.Size()
+
+	if checksum != "" && asServed {
+		r.Method = "sha256"
+		r.Status = VerifyStale
+		if sum, err := fileSHA256(r.Path); err == nil && strings.EqualFold(sum, checksum) {
+			r.Status = VerifyCurrent
+		}
+		r.RemoteSize, _ = g.headInfo(ctx, name, rawURL)
+		return r
+	}
+
+	var header http.Header
+	r.RemoteSize, header = g.headInfo(ctx, name, rawURL)
+	etag := header.Get("ETag")
+	if meta := readCacheMeta(r.Path); etag != "" && meta != nil && meta.ETag != "" && (meta.Size == 0 || meta.Size == r.LocalSize) {
+		r.Method = "etag"
+		r.Status = VerifyStale
+		if meta.ETag == etag {
+			r.Status = VerifyCurrent
+		}
+		return r
+	}
+	if asServed && r.RemoteSize >= 0 {
+		r.Method = "size"
+		r.Status = VerifyStale
+		if r.RemoteSize == r.LocalSize {
+			r.Status = VerifyCurrent
+		}
+		return r
+	}
+	r.Status, r.Method = VerifyUnknown, "no checksum, ETag or comparable size from the server"
+	return r
+}
+
+// headInfo returns the Content-Length (-1 if unknown) and headers announced
+// for rawURL by a HEAD request; the headers are nil if it failed.
+func (g *Updater) headInfo(ctx context.Context, name, rawURL string) (int64, http.Header) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", rawURL, nil)
+	if err != nil {
+		return -1, nil
+	}
+	req.Header.Set("User-Agent", g.userAgent())
+	resp, err := g.httpClient.client.Do(req)
+	if err != nil {
+		g.logger.Info("%s: HEAD request failed: %v", name, err)
+		return -1, nil
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented, http.StatusForbidden:
+		// Presigned GET URLs and some mirrors refuse HEAD; the size is
+		// simply unknown.
+		debugf(g.logger, "%s: HEAD not supported (HTTP %d)", name, resp.StatusCode)
+		return -1, nil
+	default:
+		g.logger.Info("%s: HEAD request returned %d", name, resp.StatusCode)
+		return -1, nil
+	}
+	return resp.ContentLength, resp.Header
+}
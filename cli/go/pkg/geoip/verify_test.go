@@ -0,0 +1,88 @@
+package geoip
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestVerify covers each way of deciding drift: checksum, recorded ETag
+// and announced size, plus missing files and nothing to compare.
+func TestVerify(t *testing.T) {
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	var srvURL string
+	var downloads int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			resp := map[string]interface{}{
+				"checksums": map[string]string{"sum-ok.db": sum("same"), "sum-old.db": sum("new")},
+			}
+			for _, name := range []string{"sum-ok.db", "sum-old.db", "missing.db", "etag.db.gz", "size.db", "opaque.db.gz"} {
+				resp[name] = srvURL + "/" + name
+			}
+			json.NewEncoder(w).Encode(resp)
+			return
+		case "/etag.db.gz":
+			w.Header().Set("ETag", `"v2"`)
+		case "/size.db":
+			w.Header().Set("Content-Length", "4")
+		case "/opaque.db.gz":
+			w.Header().Set("Content-Length", "10")
+		}
+		if r.Method != "HEAD" {
+			downloads++
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"sum-ok.db": "same", "sum-old.db": "old", "etag.db": "data", "size.db": "1234", "opaque.db": "data",
+	} {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+	writeCacheMeta(filepath.Join(dir, "etag.db"), &cacheMeta{ETag: `"v1"`, Size: 4})
+
+	cfg := &Config{APIEndpoints: []string{srv.URL + "/auth"}, TargetDir: dir, Timeout: 5 * time.Second, MaxRetries: 1}
+	g, err := New(cfg, Options{HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := g.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][2]string{
+		"sum-ok.db":    {VerifyCurrent, "sha256"},
+		"sum-old.db":   {VerifyStale, "sha256"},
+		"missing.db":   {VerifyMissing, "no local file"},
+		"etag.db.gz":   {VerifyStale, "etag"},
+		"size.db":      {VerifyCurrent, "size"},
+		"opaque.db.gz": {VerifyUnknown, ""},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("%d results, want %d", len(results), len(want))
+	}
+	for _, r := range results {
+		w := want[r.Database]
+		if r.Status != w[0] || (w[1] != "" && r.Method != w[1]) {
+			t.Errorf("%s: %s (%s), want %s (%s)", r.Database, r.Status, r.Method, w[0], w[1])
+		}
+	}
+	if downloads != 0 {
+		t.Errorf("Verify made %d GET requests", downloads)
+	}
+}
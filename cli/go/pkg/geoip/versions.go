@@ -0,0 +1,155 @@
+package geoip
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionsDir is the subdirectory of TargetDir that holds KeepVersions
+// history.
+const versionsDir = "versions"
+
+// versionLayout is the timestamp in a stored version's name.
+const versionLayout = "20060102T150405Z"
+
+// versionName returns the name of a stored version of the database file
+// base taken at t: "GeoIP2-City.mmdb" becomes
+// "GeoIP2-City.20240115T030000Z.mmdb". Versions stored in the same second
+// get a "-<n>" suffix on the timestamp from n = 2.
+func versionName(base string, t time.Time, n int) string {
+	ext := filepath.Ext(base)
+	stamp := t.UTC().Format(versionLayout)
+	if n > 1 {
+		stamp += "-" + strconv.Itoa(n)
+	}
+	return strings.TrimSuffix(base, ext) + "." + stamp + ext
+}
+
+// parseVersionName reports whether name is a stored version of the
+// database file base, and if so when it was taken and its collision
+// counter (1 when it has none).
+func parseVersionName(name, base string) (time.Time, int, bool) {
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "."
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) || len(name) < len(prefix)+len(ext) {
+		return time.Time{}, 0, false
+	}
+	stamp := name[len(prefix) : len(name)-len(ext)]
+	n := 1
+	if i := strings.IndexByte(stamp, '-'); i >= 0 {
+		var err error
+		if n, err = strconv.Atoi(stamp[i+1:]); err != nil || n < 2 {
+			return time.Time{}, 0, false
+		}
+		stamp = stamp[:i]
+	}
+	t, err := time.Parse(versionLayout, stamp)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return t, n, true
+}
+
+// storeVersion adds the database at path to the versions directory beside
+// it as a hard link (a copy where links are unsupported) and returns the
+// stored path. An existing version is never overwritten.
+func storeVersion(path string, now time.Time) (string, error) {
+	src, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(filepath.Dir(path), versionsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	for n := 1; ; n++ {
+		stored := filepath.Join(dir, versionName(filepath.Base(path), now, n))
+		if _, err := os.Lstat(stored); err == nil {
+			continue
+		}
+		err := os.Link(src, stored)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			if err := copyFile(src, stored); err != nil {
+				os.Remove(stored)
+				return "", err
+			}
+		}
+		return stored, nil
+	}
+}
+
+// pruneStoredVersions removes all but the newest keep stored versions of
+// the database file at path and returns the removed paths. Only names
+// that parse as versions of exactly that file are considered, so
+// databases whose names share a prefix never touch each other's history.
+func pruneStoredVersions(path string, keep int) ([]string, error) {
+	dir := filepath.Join(filepath.Dir(path), versionsDir)
+	base := filepath.Base(path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type version struct {
+		path string
+		t    time.Time
+		n    int
+	}
+	var versions []version
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if t, n, ok := parseVersionName(e.Name(), base); ok {
+			versions = append(versions, version{filepath.Join(dir, e.Name()), t, n})
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if !versions[i].t.Equal(versions[j].t) {
+			return versions[i].t.After(versions[j].t)
+		}
+		return versions[i].n > versions[j].n
+	})
+
+	var removed []string
+	for i := keep; i < len(versions); i++ {
+		if err := os.Remove(versions[i].path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, versions[i].path)
+	}
+	return removed, nil
+}
+
+// storeVersions applies Config.KeepVersions to every database installed by
+// this run: each is stored under versions/ and the history beyond the
+// newest KeepVersions is removed.
+func (g *Updater) storeVersions(results []DownloadResult) {
+	now := time.Now()
+	for _, r := range results {
+		if r.Error != nil || r.Path == "" || r.Unchanged || r.NotNewer || r.Skipped {
+			continue
+		}
+		stored, err := storeVersion(r.Path, now)
+		if err != nil {
+			g.logger.Warn("%s: failed to store version: %v", r.Database, err)
+			continue
+		}
+		debugf(g.logger, "%s: stored %s", r.Database, filepath.Join(versionsDir, filepath.Base(stored)))
+		removed, err := pruneStoredVersions(r.Path, g.config.KeepVersions)
+		for _, path := range removed {
+			g.logger.Info("Pruned old version: %s", filepath.Join(versionsDir, filepath.Base(path)))
+		}
+		if err != nil {
+			g.logger.Warn("%s: failed to prune stored versions: %v", r.Database, err)
+		}
+	}
+}
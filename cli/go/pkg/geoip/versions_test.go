@@ -0,0 +1,73 @@
+package geoip
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVersionName(t *testing.T) {
+	at := time.Date(2024, 1, 15, 3, 0, 0, 0, time.UTC)
+	if got := versionName("GeoIP2-City.mmdb", at, 1); got != "GeoIP2-City.20240115T030000Z.mmdb" {
+		t.Errorf("versionName = %q", got)
+	}
+	for name, want := range map[string]bool{
+		"GeoIP2-City.20240115T030000Z.mmdb":      true,
+		"GeoIP2-City.20240115T030000Z-2.mmdb":    true,
+		"GeoIP2-City.20240115T030000Z-1.mmdb":    false,
+		"GeoIP2-City.20240115T030000Z-x.mmdb":    false,
+		"GeoIP2-City.mmdb":                       false,
+		"GeoIP2-City-Lite.20240115T030000Z.mmdb": false,
+		"GeoIP2-City.20240115T030000Z.BIN":       false,
+	} {
+		if _, _, ok := parseVersionName(name, "GeoIP2-City.mmdb"); ok != want {
+			t.Errorf("parseVersionName(%q) = %v, want %v", name, ok, want)
+		}
+	}
+}
+
+// TestStoreVersions verifies stored versions never overwrite each other,
+// even within the same second, and that pruning keeps the newest N of one
+// database without touching a database whose name shares its prefix.
+func TestStoreVersions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.db")
+	other := filepath.Join(dir, "a-lite.db")
+	at := time.Date(2024, 1, 15, 3, 0, 0, 0, time.UTC)
+
+	var stored []string
+	for i, content := range []string{"v1", "v2", "v3"} {
+		os.WriteFile(path+".tmp", []byte(content), 0644)
+		os.Rename(path+".tmp", path)
+		s, err := storeVersion(path, at.Add(time.Duration(i/2)*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored = append(stored, s)
+	}
+	os.WriteFile(other, []byte("lite"), 0644)
+	otherStored, err := storeVersion(other, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored[0] == stored[1] {
+		t.Fatalf("same-second versions collide: %s", stored[0])
+	}
+	if data, _ := os.ReadFile(stored[0]); string(data) != "v1" {
+		t.Errorf("first version holds %q", data)
+	}
+
+	removed, err := pruneStoredVersions(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != stored[0] {
+		t.Errorf("removed %v, want [%s]", removed, stored[0])
+	}
+	for _, s := range append(stored[1:], otherStored) {
+		if _, err := os.Stat(s); err != nil {
+			t.Errorf("%s pruned: %v", filepath.Base(s), err)
+		}
+	}
+}
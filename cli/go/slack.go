@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+// Slack attachment colors for a clean and a failed run.
+const (
+	slackColorSuccess = "#2eb886"
+	slackColorFailure = "#a30200"
+)
+
+// notifyMaxListed caps how many databases a Slack or Teams message lists
+// under one heading.
+const notifyMaxListed = 10
+
+// slackMessage is an incoming-webhook message: a fallback text plus one
+// colored attachment holding Block Kit blocks.
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func slackMarkdown(text string) slackText {
+	return slackText{Type: "mrkdwn", Text: text}
+}
+
+// sendSlack posts the run summary to a Slack incoming webhook.
+func sendSlack(url string, s runSummary, maxRetries int, logger geoip.Logger) error {
+	hostname, _ := os.Hostname()
+	return postJSON(url, slackPayload(s, hostname), maxRetries, logger)
+}
+
+// slackPayload formats the run summary s from hostname as a Slack message:
+// green when everything succeeded, red with the failing databases and their
+// errors otherwise, listing the databases updated either way.
+func slackPayload(s runSummary, hostname string) slackMessage {
+	var downloaded int64
+	for _, d := range s.Databases {
+		if d.Status == "downloaded" {
+			downloaded += d.Size
+		}
+	}
+
+	title, ok := notifyTitle(s)
+	color := slackColorSuccess
+	if !ok {
+		color = slackColorFailure
+	}
+	fallback, headline := title, "*"+title+"*"
+	if hostname != "" {
+		fallback += " on " + hostname
+		headline += " on `" + hostname + "`"
+	}
+
+	blocks := []slackBlock{
+		{Type: "section", Text: ptrMarkdown(headline)},
+		{Type: "section", Fields: []slackText{
+			slackMarkdown(fmt.Sprintf("*Downloaded*\n%d", s.Counts.Downloaded)),
+			slackMarkdown(fmt.Sprintf("*Up to date*\n%d", s.Counts.Unchanged)),
+			slackMarkdown(fmt.Sprintf("*Failed*\n%d", s.Counts.Failed)),
+			slackMarkdown(fmt.Sprintf("*Skipped*\n%d", s.Counts.Skipped)),
+			slackMarkdown("*Bytes downloaded*\n" + humanBytes(downloaded)),
+			slackMarkdown("*Duration*\n" + time.Duration(s.Duration*float64(time.Second)).Round(time.Second).String()),
+		}},
+	}
+
+	var updated, failures []string
+	for _, d := range s.Databases {
+		switch d.Status {
+		case "downloaded":
+			updated = append(updated, fmt.Sprintf("• `%s` (%s)", d.Name, humanBytes(d.Size)))
+		case "failed":
+			failures = append(failures, fmt.Sprintf("• `%s`: %s", d.Name, truncateText(d.Error, 200)))
+		}
+	}
+	if len(updated) > 0 {
+		blocks = append(blocks, slackBlock{Type: "section", Text: ptrMarkdown("*Updated databases*\n" + strings.Join(capList(updated), "\n"))})
+	}
+	failures = capList(failures)
+	if len(failures) > 0 {
+		blocks = append(blocks, slackBlock{Type: "section", Text: ptrMarkdown("*Failed databases*\n" + strings.Join(failures, "\n"))})
+	} else if s.Error != "" {
+		blocks = append(blocks, slackBlock{Type: "section", Text: ptrMarkdown("*Error*\n" + truncateText(s.Error, 500))})
+	}
+
+	return slackMessage{Text: fallback, Attachments: []slackAttachment{{Color: color, Blocks: blocks}}}
+}
+
+// notifyTitle returns the headline for the run summary s and whether the
+// run succeeded.
+func notifyTitle(s runSummary) (string, bool) {
+	switch s.Status {
+	case "failed":
+		return "GeoIP update failed", false
+	case "interrupted":
+		return "GeoIP update interrupted", false
+	}
+	return "GeoIP update succeeded", true
+}
+
+// capList keeps the first notifyMaxListed lines, noting how many were cut.
+func capList(lines []string) []string {
+	if len(lines) <= notifyMaxListed {
+		return lines
+	}
+	return append(lines[:notifyMaxListed:notifyMaxListed], fmt.Sprintf("… and %d more", len(lines)-notifyMaxListed))
+}
+
+func ptrMarkdown(text string) *slackText {
+	t := slackMarkdown(text)
+	return &t
+}
+
+// humanBytes renders n using binary units ("12.3 MB").
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// truncateText shortens s to at most max runes, marking the cut.
+func truncateText(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-1]) + "…"
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+func TestSlackPayload(t *testing.T) {
+	config := &geoip.Config{TargetDir: "/data"}
+	ok := newRunSummary(config, time.Now(), 42*time.Second, []geoip.DownloadResult{
+		{Database: "a.mmdb", Size: 3 << 20},
+		{Database: "b.mmdb", Size: 1 << 20, Unchanged: true},
+	}, nil, false)
+	msg := slackPayload(ok, "geo1")
+	if len(msg.Attachments) != 1 || msg.Attachments[0].Color != slackColorSuccess {
+		t.Fatalf("success message = %+v", msg)
+	}
+	text := messageText(t, msg)
+	for _, want := range []string{"succeeded", "`geo1`", "3.0 MB", "42s", "`a.mmdb` (3.0 MB)"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("success message lacks %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "Failed databases") {
+		t.Errorf("success message lists failures:\n%s", text)
+	}
+
+	failed := newRunSummary(config, time.Now(), time.Second, []geoip.DownloadResult{
+		{Database: "a.mmdb", Size: 10},
+		{Database: "c.mmdb", Error: errors.New("HTTP 404: not found")},
+	}, errors.New("1 download failed"), false)
+	msg = slackPayload(failed, "geo1")
+	if msg.Attachments[0].Color != slackColorFailure || !strings.Contains(msg.Text, "failed") {
+		t.Fatalf("failure message = %+v", msg)
+	}
+	if text := messageText(t, msg); !strings.Contains(text, "`c.mmdb`: HTTP 404: not found") {
+		t.Errorf("failure message lacks the failing database:\n%s", text)
+	}
+
+	// A run that failed before downloading anything reports its error.
+	authFailed := newRunSummary(config, time.Now(), time.Second, nil, errors.New("authentication failed"), false)
+	if text := messageText(t, slackPayload(authFailed, "")); !strings.Contains(text, "authentication failed") {
+		t.Errorf("auth failure message lacks the error:\n%s", text)
+	}
+}
+
+// messageText returns the text of all blocks in msg, checking the message
+// encodes to valid JSON.
+func messageText(t *testing.T, msg slackMessage) string {
+	t.Helper()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, a := range msg.Attachments {
+		for _, b := range a.Blocks {
+			if b.Text != nil {
+				texts = append(texts, b.Text.Text)
+			}
+			for _, f := range b.Fields {
+				texts = append(texts, f.Text)
+			}
+		}
+	}
+	if !json.Valid(data) {
+		t.Fatalf("invalid JSON: %s", data)
+	}
+	return strings.Join(texts, "\n")
+}
+
+func TestTeamsPayload(t *testing.T) {
+	config := &geoip.Config{TargetDir: "/data"}
+	failed := newRunSummary(config, time.Now(), time.Second, []geoip.DownloadResult{
+		{Database: "a.mmdb", Size: 2 << 20},
+		{Database: "c.mmdb", Error: errors.New("HTTP 404: not found")},
+	}, errors.New("1 download failed"), false)
+	card := teamsPayload(failed, "geo1")
+	if card.Type != "MessageCard" || card.ThemeColor != strings.TrimPrefix(slackColorFailure, "#") {
+		t.Fatalf("card = %+v", card)
+	}
+	data, err := json.Marshal(card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"@type":"MessageCard"`, "GeoIP update failed on geo1", "`a.mmdb` (2.0 MB)", "`c.mmdb`: HTTP 404: not found"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("card lacks %q:\n%s", want, data)
+		}
+	}
+
+	ok := newRunSummary(config, time.Now(), time.Second, nil, nil, false)
+	if card := teamsPayload(ok, ""); card.ThemeColor != strings.TrimPrefix(slackColorSuccess, "#") || card.Summary != "GeoIP update succeeded" {
+		t.Errorf("success card = %+v", card)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+// Phases written to --status-file besides the library's
+// geoip.PhaseAuthenticating and geoip.PhaseDownloading.
+const (
+	phaseStarting = "starting"
+	phaseIdle     = "idle"
+)
+
+// statusDoc is the JSON document kept in --status-file.
+type statusDoc struct {
+	PID                int        `json:"pid"`
+	Phase              string     `json:"phase"`
+	DatabasesRemaining int        `json:"databases_remaining"`
+	LastSuccess        *time.Time `json:"last_success,omitempty"`
+	LastError          string     `json:"last_error,omitempty"`
+	LastErrorTime      *time.Time `json:"last_error_time,omitempty"`
+	NextRun            *time.Time `json:"next_run,omitempty"`
+	Updated            time.Time  `json:"updated"`
+}
+
+// statusFile keeps --status-file current for external watchdogs. A nil
+// *statusFile (no --status-file) ignores every call.
+type statusFile struct {
+	path   string
+	logger geoip.Logger
+
+	mu  sync.Mutex
+	doc statusDoc
+}
+
+// newStatusFile returns the status file at path, carrying the last success
+// and error over from an existing document so one-shot runs keep history;
+// it returns nil when path is empty.
+func newStatusFile(path string, logger geoip.Logger) *statusFile {
+	if path == "" {
+		return nil
+	}
+	s := &statusFile{path: path, logger: logger}
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &s.doc)
+	}
+	s.doc.PID = os.Getpid()
+	s.doc.NextRun = nil
+	return s
+}
+
+// phase records the current phase and the databases still to download.
+func (s *statusFile) phase(phase string, remaining int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Downloads finishing together may report out of order; the count
+	// only ever goes down within a run.
+	if phase == geoip.PhaseDownloading && s.doc.Phase == phase && remaining > s.doc.DatabasesRemaining {
+		return
+	}
+	s.doc.Phase, s.doc.DatabasesRemaining = phase, remaining
+	s.doc.NextRun = nil
+	s.write()
+}
+
+// finished records the outcome of a run and goes idle.
+func (s *statusFile) finished(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	if err != nil {
+		s.doc.LastError, s.doc.LastErrorTime = err.Error(), &now
+	} else {
+		s.doc.LastSuccess = &now
+	}
+	s.doc.Phase, s.doc.DatabasesRemaining = phaseIdle, 0
+	s.write()
+}
+
+// idle records that the daemon waits for its next run at next.
+func (s *statusFile) idle(next time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.doc.Phase, s.doc.DatabasesRemaining = phaseIdle, 0
+	s.setNext(next)
+	s.write()
+}
+
+func (s *statusFile) setNext(next time.Time) {
+	s.doc.NextRun = nil
+	if !next.IsZero() {
+		next = next.UTC()
+		s.doc.NextRun = &next
+	}
+}
+
+// write replaces the file atomically so a watchdog never reads a partial
+// document. Failures are logged, not fatal: the status file is advisory.
+func (s *statusFile) write() {
+	s.doc.Updated = time.Now().UTC()
+	data, err := json.MarshalIndent(s.doc, "", "  ")
+	if err != nil {
+		s.logger.Warn("Failed to write status file: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".geoip-status-*")
+	if err != nil {
+		s.logger.Warn("Failed to write status file: %v", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		s.logger.Warn("Failed to write status file: %v", err)
+	}
+}
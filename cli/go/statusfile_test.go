@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+// TestStatusFile verifies the status document follows a run's phases,
+// keeps the remaining count from going back up, and carries the last
+// success over to the next process.
+func TestStatusFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	logger := &geoip.ConsoleLogger{}
+	read := func() statusDoc {
+		t.Helper()
+		var doc statusDoc
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			t.Fatalf("%v in %s", err, data)
+		}
+		return doc
+	}
+
+	s := newStatusFile(path, logger)
+	s.phase(geoip.PhaseDownloading, 3)
+	s.phase(geoip.PhaseDownloading, 1)
+	s.phase(geoip.PhaseDownloading, 2)
+	if doc := read(); doc.Phase != geoip.PhaseDownloading || doc.DatabasesRemaining != 1 || doc.PID != os.Getpid() {
+		t.Errorf("downloading: %+v", doc)
+	}
+	s.finished(nil)
+	s.idle(time.Now().Add(time.Hour))
+	doc := read()
+	if doc.Phase != phaseIdle || doc.LastSuccess == nil || doc.NextRun == nil || doc.LastError != "" {
+		t.Errorf("idle: %+v", doc)
+	}
+
+	s = newStatusFile(path, logger)
+	s.finished(errors.New("authentication failed"))
+	doc = read()
+	if doc.LastSuccess == nil || doc.LastError != "authentication failed" || doc.NextRun != nil {
+		t.Errorf("next process: %+v", doc)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("temp files left behind: %v", entries)
+	}
+
+	var none *statusFile
+	none.phase(phaseStarting, 0) // no --status-file: a no-op
+}
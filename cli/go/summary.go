@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+// summarySchemaVersion is bumped whenever a field of runSummary is renamed,
+// removed or changes meaning; new fields may be added without a bump.
+const summarySchemaVersion = 1
+
+// runSummary is the document --output json writes to stdout after an
+// update run. Its layout is a stable interface for CI and scripts.
+type runSummary struct {
+	SchemaVersion int     `json:"schema_version"`
+	Version       string  `json:"version"`
+	Status        string  `json:"status"` // "success", "failed" or "interrupted"
+	Error         string  `json:"error,omitempty"`
+	StartedAt     string  `json:"started_at"` // RFC3339
+	Duration      float64 `json:"duration_seconds"`
+	TargetDir     string  `json:"target_dir"`
+	Counts        struct {
+		Total      int `json:"total"`
+		Downloaded int `json:"downloaded"`
+		Unchanged  int `json:"unchanged"`
+		NotNewer   int `json:"not_newer"`
+		Skipped    int `json:"skipped"`
+		Failed     int `json:"failed"`
+		Aborted    int `json:"aborted"`
+	} `json:"counts"`
+	Databases []databaseSummary `json:"databases"`
+}
+
+// databaseSummary is one database's entry in runSummary.
+type databaseSummary struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"` // "downloaded", "unchanged", "not_newer", "skipped", "failed" or "aborted"
+	Size     int64   `json:"size"`
+	Duration float64 `json:"duration_seconds"`
+	SHA256   string  `json:"sha256,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// newRunSummary builds the summary of a run that started at started and
+// returned results and err; interrupted reports a cancelled run.
+func newRunSummary(config *geoip.Config, started time.Time, duration time.Duration, results []geoip.DownloadResult, err error, interrupted bool) runSummary {
+	s := runSummary{
+		SchemaVersion: summarySchemaVersion,
+		Version:       version,
+		Status:        "success",
+		StartedAt:     started.UTC().Format(time.RFC3339),
+		Duration:      duration.Seconds(),
+		TargetDir:     config.TargetDir,
+		Databases:     make([]databaseSummary, 0, len(results)),
+	}
+	if err != nil {
+		s.Status = "failed"
+		if interrupted {
+			s.Status = "interrupted"
+		}
+		s.Error = err.Error()
+	}
+
+	for _, r := range results {
+		d := databaseSummary{Name: r.Database, Size: r.Size, Duration: r.Duration.Seconds(), SHA256: r.SHA256}
+		switch {
+		case r.Aborted:
+			d.Status = "aborted"
+			s.Counts.Aborted++
+		case r.Error != nil:
+			d.Status = "failed"
+			d.Error = r.Error.Error()
+			s.Counts.Failed++
+		case r.Skipped:
+			d.Status = "skipped"
+			s.Counts.Skipped++
+		case r.Unchanged:
+			d.Status = "unchanged"
+			s.Counts.Unchanged++
+		case r.NotNewer:
+			d.Status = "not_newer"
+			s.Counts.NotNewer++
+		default:
+			d.Status = "downloaded"
+			s.Counts.Downloaded++
+		}
+		s.Databases = append(s.Databases, d)
+	}
+	s.Counts.Total = len(s.Databases)
+	return s
+}
+
+func writeRunSummary(w io.Writer, s runSummary) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
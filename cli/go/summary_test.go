@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+func TestRunSummary(t *testing.T) {
+	config := &geoip.Config{TargetDir: "/data"}
+	started := time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC)
+	results := []geoip.DownloadResult{
+		{Database: "a.mmdb", Size: 10, Duration: 1500 * time.Millisecond, SHA256: "abc"},
+		{Database: "b.mmdb", Size: 20, Unchanged: true},
+		{Database: "c.mmdb", Skipped: true},
+		{Database: "d.mmdb", Error: errors.New("HTTP 404")},
+	}
+	s := newRunSummary(config, started, 2*time.Second, results, errors.New("1 download failed"), false)
+
+	var buf bytes.Buffer
+	if err := writeRunSummary(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if got["schema_version"] != float64(summarySchemaVersion) || got["status"] != "failed" || got["started_at"] != "2024-06-01T03:00:00Z" {
+		t.Errorf("header = %v", got)
+	}
+	counts := got["counts"].(map[string]interface{})
+	for key, want := range map[string]float64{"total": 4, "downloaded": 1, "unchanged": 1, "skipped": 1, "failed": 1} {
+		if counts[key] != want {
+			t.Errorf("counts.%s = %v, want %v", key, counts[key], want)
+		}
+	}
+
+	dbs := got["databases"].([]interface{})
+	first := dbs[0].(map[string]interface{})
+	if first["status"] != "downloaded" || first["sha256"] != "abc" || first["duration_seconds"] != 1.5 {
+		t.Errorf("databases[0] = %v", first)
+	}
+	if last := dbs[3].(map[string]interface{}); last["status"] != "failed" || last["error"] != "HTTP 404" {
+		t.Errorf("databases[3] = %v", last)
+	}
+
+	if s := newRunSummary(config, started, 0, nil, errors.New("context canceled"), true); s.Status != "interrupted" || s.Databases == nil {
+		t.Errorf("interrupted summary = %+v", s)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+// teamsCard is a legacy actionable MessageCard, the format Microsoft Teams
+// incoming webhooks and Power Automate "post to a channel" flows accept.
+type teamsCard struct {
+	Type       string         `json:"@type"`
+	Context    string         `json:"@context"`
+	ThemeColor string         `json:"themeColor"`
+	Summary    string         `json:"summary"`
+	Title      string         `json:"title"`
+	Sections   []teamsSection `json:"sections"`
+}
+
+type teamsSection struct {
+	ActivityTitle string      `json:"activityTitle,omitempty"`
+	Facts         []teamsFact `json:"facts,omitempty"`
+	Text          string      `json:"text,omitempty"`
+}
+
+type teamsFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// sendTeams posts the run summary to a Microsoft Teams incoming webhook.
+func sendTeams(url string, s runSummary, maxRetries int, logger geoip.Logger) error {
+	hostname, _ := os.Hostname()
+	return postJSON(url, teamsPayload(s, hostname), maxRetries, logger)
+}
+
+// teamsPayload formats the run summary s from hostname as a MessageCard
+// with the same content and colors as slackPayload.
+func teamsPayload(s runSummary, hostname string) teamsCard {
+	var downloaded int64
+	for _, d := range s.Databases {
+		if d.Status == "downloaded" {
+			downloaded += d.Size
+		}
+	}
+
+	title, ok := notifyTitle(s)
+	color := slackColorSuccess
+	if !ok {
+		color = slackColorFailure
+	}
+	summary := title
+	if hostname != "" {
+		summary += " on " + hostname
+	}
+
+	sections := []teamsSection{{
+		ActivityTitle: summary,
+		Facts: []teamsFact{
+			{"Downloaded", fmt.Sprint(s.Counts.Downloaded)},
+			{"Up to date", fmt.Sprint(s.Counts.Unchanged)},
+			{"Failed", fmt.Sprint(s.Counts.Failed)},
+			{"Skipped", fmt.Sprint(s.Counts.Skipped)},
+			{"Bytes downloaded", humanBytes(downloaded)},
+			{"Duration", time.Duration(s.Duration * float64(time.Second)).Round(time.Second).String()},
+		},
+	}}
+
+	// Teams renders the text as Markdown; a blank line keeps list items
+	// on separate lines.
+	var updated, failures []string
+	for _, d := range s.Databases {
+		switch d.Status {
+		case "downloaded":
+			updated = append(updated, fmt.Sprintf("- `%s` (%s)", d.Name, humanBytes(d.Size)))
+		case "failed":
+			failures = append(failures, fmt.Sprintf("- `%s`: %s", d.Name, truncateText(d.Error, 200)))
+		}
+	}
+	if len(updated) > 0 {
+		sections = append(sections, teamsSection{ActivityTitle: "Updated databases", Text: strings.Join(capList(updated), "\n\n")})
+	}
+	if len(failures) > 0 {
+		sections = append(sections, teamsSection{ActivityTitle: "Failed databases", Text: strings.Join(capList(failures), "\n\n")})
+	} else if s.Error != "" {
+		sections = append(sections, teamsSection{ActivityTitle: "Error", Text: truncateText(s.Error, 500)})
+	}
+
+	return teamsCard{
+		Type:       "MessageCard",
+		Context:    "https://schema.org/extensions",
+		ThemeColor: strings.TrimPrefix(color, "#"),
+		Summary:    summary,
+		Title:      title,
+		Sections:   sections,
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+// webhookTimeout bounds the whole webhook delivery, retries included, so a
+// slow receiver never holds up exit for long.
+const webhookTimeout = 10 * time.Second
+
+// webhookPayload is the body POSTed to --webhook-url: the --output json
+// summary plus where and when the run happened.
+type webhookPayload struct {
+	runSummary
+	Hostname  string `json:"hostname"`
+	Timestamp string `json:"timestamp"` // RFC3339, when the run finished
+}
+
+// shouldNotify reports whether a run with summary s is sent under the
+// --webhook-on filter on ("always", "success" or "failure").
+func shouldNotify(on string, s runSummary) bool {
+	switch on {
+	case "success":
+		return s.Status == "success"
+	case "failure":
+		return s.Status != "success"
+	default:
+		return true
+	}
+}
+
+// sendWebhook POSTs the run summary to url.
+func sendWebhook(url string, s runSummary, maxRetries int, logger geoip.Logger) error {
+	hostname, _ := os.Hostname()
+	return postJSON(url, webhookPayload{
+		runSummary: s,
+		Hostname:   hostname,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+	}, maxRetries, logger)
+}
+
+// postJSON POSTs payload as JSON to url with the retrying client. It gives
+// up after webhookTimeout and is independent of the run's context, so an
+// interrupted run is still reported.
+func postJSON(url string, payload interface{}, maxRetries int, logger geoip.Logger) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return postBody(url, "application/json", body, maxRetries, logger)
+}
+
+// postBody POSTs body to url with the retrying client, bounded by
+// webhookTimeout like postJSON.
+func postBody(url, contentType string, body []byte, maxRetries int, logger geoip.Logger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "GeoIP-Update-Go/"+version)
+
+	client := geoip.NewHTTPClient(&http.Client{Timeout: webhookTimeout}, maxRetries, logger)
+	// Receivers acknowledge with any 2xx, not just 200.
+	client.SetAccepted([]int{http.StatusCreated, http.StatusAccepted, http.StatusNoContent})
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
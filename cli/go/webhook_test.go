@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ytzcom/geoip/cli/go/pkg/geoip"
+)
+
+// TestSendWebhook verifies the payload and that a retried POST resends the
+// full body.
+func TestSendWebhook(t *testing.T) {
+	var attempts int
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := io.ReadAll(r.Body)
+		if attempts == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("attempt %d: invalid body %q: %v", attempts, body, err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	logger, _ := geoip.NewLogger(&geoip.Config{Quiet: true}, io.Discard)
+	results := []geoip.DownloadResult{{Database: "a.mmdb", Error: io.ErrUnexpectedEOF}}
+	s := newRunSummary(&geoip.Config{TargetDir: "/data"}, time.Now(), time.Second, results, io.ErrUnexpectedEOF, false)
+	if err := sendWebhook(srv.URL, s, 2, logger); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("attempts = %d, want 2", attempts)
+	}
+	if got["status"] != "failed" || got["hostname"] == nil || got["timestamp"] == nil || got["schema_version"] != float64(summarySchemaVersion) {
+		t.Errorf("payload = %v", got)
+	}
+}
+
+func TestShouldNotify(t *testing.T) {
+	ok := runSummary{Status: "success"}
+	failed := runSummary{Status: "failed"}
+	interrupted := runSummary{Status: "interrupted"}
+	tests := []struct {
+		on   string
+		s    runSummary
+		want bool
+	}{
+		{"always", ok, true},
+		{"always", failed, true},
+		{"failure", ok, false},
+		{"failure", failed, true},
+		{"failure", interrupted, true},
+		{"success", ok, true},
+		{"success", failed, false},
+	}
+	for _, tt := range tests {
+		if got := shouldNotify(tt.on, tt.s); got != tt.want {
+			t.Errorf("shouldNotify(%q, %s) = %v, want %v", tt.on, tt.s.Status, got, tt.want)
+		}
+	}
+}